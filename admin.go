@@ -0,0 +1,186 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// AdminHandler returns an http.Handler serving the administrative API
+// of the peer. It is not registered by Handler() and should only be
+// exposed to operators, for example:
+//
+//	http.Handle("/admin/", http.StripPrefix("/admin", peer.AdminHandler()))
+//
+// or by enabling the RouteAdmin route of the peer's Mux.
+//
+// The API serves:
+//
+//	GET /stats       the peer's Stats as JSON
+//	POST /stats/reset
+//	                 resets the peer's Stats (see Peer.ResetStats)
+//	GET /recordings  the exchanges recorded by the peer's Recorder as JSON
+//	GET /buffers     the utilization of the peer's BufferPool as JSON
+//	GET /keys        the keys of the peer's cache as JSON, if it lists them
+//	                 (see hashkey.Cache)
+//	GET /epoch       the epoch of the peer's cache as JSON, if it has one
+//	POST /epoch      bumps the epoch, invalidating the peer's cache
+//	POST /purge      purges the responses matching the pattern given by the
+//	                 pattern query parameter (see Peer.Purge), or carrying
+//	                 the tag given by the tag query parameter from the whole
+//	                 pool (see Client.PurgeTag), or marks them stale with
+//	                 soft=1 (see Peer.SoftPurge)
+//	GET /promote     whether the peer is a standby as JSON, if it is one
+//	POST /promote    promotes the standby peer (see WithStandby)
+//	GET /offline     whether the peer is in the offline mode as JSON
+//	POST /offline    switches the offline mode on, or off with enabled=0
+//	                 (see Peer.SetOffline)
+//	GET /probe       what the peer knows about the URL given by the url query
+//	                 parameter as JSON (see Peer.Probe)
+//	GET /ring        the ownership of the keyspace by the peers of the pool
+//	                 as JSON (see Client.RingLayout)
+//	GET /ring.html   the same, drawn as a ring
+func (p *Peer) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, p.Stats().String())
+	})
+	mux.HandleFunc("/stats/reset", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if err := p.ResetStats(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/purge", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		pattern, tag := r.URL.Query().Get("pattern"), r.URL.Query().Get("tag")
+		soft, _ := strconv.ParseBool(r.URL.Query().Get("soft"))
+		switch {
+		case tag != "":
+			if err := p.PurgeTag(r.Context(), tag, soft); err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+		case pattern == "":
+			http.Error(w, "missing pattern or tag", http.StatusBadRequest)
+			return
+		case soft:
+			p.SoftPurge(pattern)
+		default:
+			p.Purge(pattern)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/offline", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			enabled, err := strconv.ParseBool(r.URL.Query().Get("enabled"))
+			if err != nil {
+				enabled = true
+			}
+			p.SetOffline(enabled)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"offline": p.Offline()})
+	})
+	mux.HandleFunc("/probe", func(w http.ResponseWriter, r *http.Request) {
+		probe, err := p.Probe(r.URL.Query().Get("url"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(probe)
+	})
+	mux.HandleFunc("/ring", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p.RingLayout())
+	})
+	mux.HandleFunc("/ring.html", func(w http.ResponseWriter, r *http.Request) {
+		serveRingHTML(w, p.RingLayout())
+	})
+	if p.recorder != nil {
+		mux.Handle("/recordings", p.recorder)
+	}
+	if buffers, ok := p.buffers.(*BufferPool); ok {
+		mux.HandleFunc("/buffers", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(buffers.Stats())
+		})
+	}
+	if keys, ok := p.cache.(keyLister); ok {
+		mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(keys.Keys())
+		})
+	}
+	if p.standby != nil {
+		mux.HandleFunc("/promote", func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+			case http.MethodPost:
+				p.Promote()
+			default:
+				w.Header().Set("Allow", "GET, POST")
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]bool{"standby": p.Standby()})
+		})
+	}
+	if p.epoch != nil {
+		mux.HandleFunc("/epoch", func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+			case http.MethodPost:
+				p.BumpEpoch()
+			default:
+				w.Header().Set("Allow", "GET, POST")
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]int64{"epoch": p.Epoch()})
+		})
+	}
+	return mux
+}
+
+// keyLister is a cache listing its keys, like hashkey.Cache.
+type keyLister interface {
+	Keys() []string
+}
@@ -0,0 +1,66 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/forwardcache/hashkey"
+)
+
+func TestAdminHandler(t *testing.T) {
+	testCases := []struct {
+		path   string
+		peer   *Peer
+		status int
+	}{
+		{"/stats", NewPeer("http://self.com:3000"), http.StatusOK},
+		{"/probe?url=http://example.com/a", NewPeer("http://self.com:3000"), http.StatusOK},
+		{"/probe", NewPeer("http://self.com:3000"), http.StatusBadRequest},
+		{"/ring", NewPeer("http://self.com:3000"), http.StatusOK},
+		{"/recordings", NewPeer("http://self.com:3000"), http.StatusNotFound},
+		{"/recordings", NewPeer("http://self.com:3000", WithRecorder(NewRecorder(10))), http.StatusOK},
+		{"/buffers", NewPeer("http://self.com:3000"), http.StatusOK},
+		{"/buffers", NewPeer("http://self.com:3000", WithBufferPool(nil)), http.StatusNotFound},
+		{"/keys", NewPeer("http://self.com:3000"), http.StatusNotFound},
+		{"/epoch", NewPeer("http://self.com:3000"), http.StatusNotFound},
+		{"/epoch", NewPeer("http://self.com:3000", WithCacheEpoch(0)), http.StatusOK},
+		{"/keys", NewPeer("http://self.com:3000", WithCache(hashkey.New(httpcache.NewMemoryCache()))), http.StatusOK},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", tC.path, nil)
+			tC.peer.AdminHandler().ServeHTTP(rr, req)
+
+			if rr.Code != tC.status {
+				t.Fatalf("admin handler sent wrong status: got %d, want %d", rr.Code, tC.status)
+			}
+
+			if rr.Code == http.StatusOK {
+				var v interface{}
+				if err := json.Unmarshal(rr.Body.Bytes(), &v); err != nil {
+					t.Errorf("admin handler returned invalid JSON: %q", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"mime"
+	"net/http"
+
+	"github.com/mikegleasonjr/forwardcache/tinylfu"
+)
+
+// AdmissionPolicy decides which of the responses a peer may store, as a
+// shared cache, are actually stored, see WithAdmission.
+type AdmissionPolicy interface {
+	// ShouldCache reports whether the response to req is stored. It is
+	// called before the body of the response is read.
+	ShouldCache(req *http.Request, res *http.Response) bool
+}
+
+// AdmissionFunc is a function used as an AdmissionPolicy.
+type AdmissionFunc func(req *http.Request, res *http.Response) bool
+
+// ShouldCache returns f(req, res).
+func (f AdmissionFunc) ShouldCache(req *http.Request, res *http.Response) bool {
+	return f(req, res)
+}
+
+// AdmitAll admits the responses admitted by all the policies.
+func AdmitAll(policies ...AdmissionPolicy) AdmissionPolicy {
+	return AdmissionFunc(func(req *http.Request, res *http.Response) bool {
+		for _, p := range policies {
+			if !p.ShouldCache(req, res) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// AdmitMaxSize admits the responses of at most size bytes. The
+// responses of unknown length are admitted.
+func AdmitMaxSize(size int64) AdmissionPolicy {
+	return AdmissionFunc(func(req *http.Request, res *http.Response) bool {
+		return res.ContentLength <= size
+	})
+}
+
+// AdmitContentTypes admits the responses whose media type matches one
+// of the patterns, like "image/*" or "application/json". Stars match
+// any sequence of characters.
+func AdmitContentTypes(patterns ...string) AdmissionPolicy {
+	return AdmissionFunc(func(req *http.Request, res *http.Response) bool {
+		media, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+		if err != nil {
+			return false
+		}
+		for _, pattern := range patterns {
+			if match(pattern, media) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// AdmitStatuses admits the responses with one of the status codes.
+func AdmitStatuses(codes ...int) AdmissionPolicy {
+	admitted := make(map[int]bool, len(codes))
+	for _, code := range codes {
+		admitted[code] = true
+	}
+	return AdmissionFunc(func(req *http.Request, res *http.Response) bool {
+		return admitted[res.StatusCode]
+	})
+}
+
+// AdmitFrequent admits the responses to URLs fetched at least n times
+// recently, counted with a TinyLFU sketch (see tinylfu.Sketch), so that
+// the URLs requested once don't flush the popular ones. n is at most 15.
+func AdmitFrequent(n int) AdmissionPolicy {
+	sketch := tinylfu.NewSketch(1 << 16)
+	return AdmissionFunc(func(req *http.Request, res *http.Response) bool {
+		return sketch.Increment(req.URL.String()) >= n
+	})
+}
+
+// WithAdmission makes the peer only store the responses admitted by the
+// policy, among the ones it may store. The responses not admitted are
+// counted in Stats.NotAdmitted.
+// Defaults to admitting all of them.
+func WithAdmission(policy AdmissionPolicy) func(*Peer) {
+	return func(p *Peer) {
+		p.admission = policy
+	}
+}
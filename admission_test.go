@@ -0,0 +1,90 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestAdmissionPolicies(t *testing.T) {
+	testCases := []struct {
+		desc   string
+		policy AdmissionPolicy
+		status int
+		header http.Header
+		size   int64
+		want   bool
+	}{
+		{"max size", AdmitMaxSize(10), 200, nil, 10, true},
+		{"max size exceeded", AdmitMaxSize(10), 200, nil, 11, false},
+		{"max size unknown", AdmitMaxSize(10), 200, nil, -1, true},
+		{"content type", AdmitContentTypes("image/*", "text/css"), 200, http.Header{"Content-Type": {"image/png"}}, 10, true},
+		{"content type parameters", AdmitContentTypes("image/*", "text/css"), 200, http.Header{"Content-Type": {"text/css; charset=utf-8"}}, 10, true},
+		{"content type mismatch", AdmitContentTypes("image/*", "text/css"), 200, http.Header{"Content-Type": {"text/html"}}, 10, false},
+		{"content type missing", AdmitContentTypes("image/*", "text/css"), 200, nil, 10, false},
+		{"status", AdmitStatuses(200, 301), 301, nil, 10, true},
+		{"status mismatch", AdmitStatuses(200, 301), 404, nil, 10, false},
+		{"all", AdmitAll(AdmitMaxSize(10), AdmitStatuses(200)), 200, nil, 10, true},
+		{"all mismatch", AdmitAll(AdmitMaxSize(10), AdmitStatuses(200)), 200, nil, 11, false},
+	}
+	for _, tC := range testCases {
+		req, _ := http.NewRequest("GET", "http://some.url/res.js", nil)
+		res := &http.Response{StatusCode: tC.status, Header: tC.header, ContentLength: tC.size}
+		if got := tC.policy.ShouldCache(req, res); got != tC.want {
+			t.Errorf("unexpected admission for %s: got %v, want %v", tC.desc, got, tC.want)
+		}
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("Cache-Control", "max-age=60")
+		return res, nil
+	})
+
+	stats := newStats()
+	var policy AdmissionPolicy = AdmitFrequent(2)
+	transport := &cacheTransport{
+		cache:       httpcache.NewMemoryCache(),
+		credentials: new(credentials),
+		admission:   &policy,
+		stats:       stats,
+		transport:   origin,
+	}
+
+	for i, want := range []string{"", "", "1"} {
+		req, _ := http.NewRequest("GET", "http://some.url/res.js", nil)
+		res, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if got := res.Header.Get(httpcache.XFromCache); got != want {
+			t.Errorf("unexpected %s of request %d: got %q, want %q", httpcache.XFromCache, i, got, want)
+		}
+	}
+
+	if got, want := stats.NotAdmitted.Get(), int64(1); got != want {
+		t.Errorf("unexpected responses not admitted: got %d, want %d", got, want)
+	}
+}
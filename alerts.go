@@ -0,0 +1,106 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"io"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// OriginAlert describes an origin request that exceeded the latency
+// or the size threshold of a peer.
+type OriginAlert struct {
+	URL     *url.URL
+	Latency time.Duration // time to get the response headers
+	Size    int64         // size of the response body, as known when the alert is raised
+	Slow    bool          // the latency threshold was exceeded
+	Large   bool          // the size threshold was exceeded
+}
+
+// thresholds flag misbehaving origins.
+type thresholds struct {
+	latency  time.Duration
+	size     int64
+	alert    func(OriginAlert)
+	redactor *Redactor
+}
+
+func (t *thresholds) raise(stats *Stats, origin *Counters, a OriginAlert) {
+	if a.Slow {
+		stats.SlowOrigins.Add(1)
+		origin.SlowOrigins.Add(1)
+	}
+	if a.Large {
+		stats.LargeResponses.Add(1)
+		origin.LargeResponses.Add(1)
+	}
+	if t.alert != nil {
+		a.URL = t.redactor.URL(a.URL)
+		t.alert(a)
+	}
+}
+
+// largeBody raises an alert when more than the size threshold is read
+// from a body of unknown length.
+type largeBody struct {
+	io.ReadCloser
+	read  int64
+	once  sync.Once
+	raise func(size int64)
+	limit int64
+}
+
+func (b *largeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.read > b.limit {
+		b.once.Do(func() { b.raise(b.read) })
+	}
+	return n, err
+}
+
+// WithSlowOriginThreshold flags origin requests taking longer than d
+// to return their response headers. Flagged requests are counted in
+// Stats.SlowOrigins and reported to the alert handler.
+// Defaults to 0 (disabled).
+func WithSlowOriginThreshold(d time.Duration) func(*Peer) {
+	return func(p *Peer) {
+		p.thresholds.latency = d
+	}
+}
+
+// WithLargeResponseThreshold flags origin responses larger than n bytes.
+// Flagged responses are counted in Stats.LargeResponses and reported to
+// the alert handler. Defaults to 0 (disabled).
+func WithLargeResponseThreshold(n int64) func(*Peer) {
+	return func(p *Peer) {
+		p.thresholds.size = n
+	}
+}
+
+// WithOriginAlerts lets you configure a function called each time an
+// origin request exceeds one of the thresholds. The function is called
+// synchronously and must be safe for concurrent use. The secrets of the
+// URLs are redacted, see WithRedactor.
+// Defaults to nil.
+func WithOriginAlerts(f func(OriginAlert)) func(*Peer) {
+	return func(p *Peer) {
+		p.thresholds.alert = f
+	}
+}
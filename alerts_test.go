@@ -0,0 +1,100 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestOriginAlerts(t *testing.T) {
+	origin := newRoundTripperMock().
+		add("GET", "http://cdn.com/fast.js", func(*http.Request) (*http.Response, error) {
+			return okResponse(), nil
+		}).
+		add("GET", "http://cdn.com/slow.js", func(*http.Request) (*http.Response, error) {
+			time.Sleep(20 * time.Millisecond)
+			return okResponse(), nil
+		}).
+		add("GET", "http://cdn.com/large.js", func(*http.Request) (*http.Response, error) {
+			res := okResponse()
+			res.Body = ioutil.NopCloser(strings.NewReader("0123456789"))
+			res.ContentLength = 10
+			return res, nil
+		}).
+		add("GET", "http://cdn.com/chunked.js", func(*http.Request) (*http.Response, error) {
+			res := okResponse()
+			res.Body = ioutil.NopCloser(strings.NewReader("0123456789"))
+			res.ContentLength = -1
+			return res, nil
+		})
+
+	var mu sync.Mutex
+	alerts := map[string]OriginAlert{}
+
+	proxy := newProxy("/p", httpcache.NewMemoryCache(), origin, nil)
+	proxy.thresholds = thresholds{
+		latency: 10 * time.Millisecond,
+		size:    5,
+		alert: func(a OriginAlert) {
+			mu.Lock()
+			alerts[a.URL.String()] = a
+			mu.Unlock()
+		},
+	}
+
+	testCases := []struct {
+		url   string
+		alert bool
+		slow  bool
+		large bool
+	}{
+		{"http://cdn.com/fast.js", false, false, false},
+		{"http://cdn.com/slow.js", true, true, false},
+		{"http://cdn.com/large.js", true, false, true},
+		{"http://cdn.com/chunked.js", true, false, true},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.url, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/p?q="+url.QueryEscape(tC.url), nil)
+			proxy.ServeHTTP(httptest.NewRecorder(), req)
+
+			a, ok := alerts[tC.url]
+			if ok != tC.alert {
+				t.Fatalf("unexpected alert: got %v, want %v", ok, tC.alert)
+			}
+			if a.Slow != tC.slow || a.Large != tC.large {
+				t.Errorf("unexpected alert: got slow=%v large=%v, want slow=%v large=%v", a.Slow, a.Large, tC.slow, tC.large)
+			}
+		})
+	}
+
+	if got, want := proxy.stats.SlowOrigins.Get(), int64(1); got != want {
+		t.Errorf("unexpected number of slow origins: got %d, want %d", got, want)
+	}
+	if got, want := proxy.stats.Origin("cdn.com").LargeResponses.Get(), int64(2); got != want {
+		t.Errorf("unexpected number of large responses: got %d, want %d", got, want)
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// immutableTTL is how long the immutable responses stay fresh, like the
+// blobs of the registries or the released versions of the packages.
+const immutableTTL = 365 * 24 * time.Hour
+
+// ArtifactClass is the class of the URLs of an artifact repository,
+// see ArtifactRepositories.
+type ArtifactClass int
+
+const (
+	// OtherArtifact is the class of the URLs cached like any other.
+	OtherArtifact ArtifactClass = iota
+	// ImmutableArtifact is the class of the URLs of released versions,
+	// which never change.
+	ImmutableArtifact
+	// IndexArtifact is the class of the URLs of the indexes and metadata
+	// listing the versions, which change when versions are released.
+	IndexArtifact
+)
+
+// An ArtifactClassifier classifies the URLs of an artifact repository.
+type ArtifactClassifier func(u *url.URL) ArtifactClass
+
+// GoModules classifies the URLs of the Go module proxies (GOPROXY) and
+// checksum databases (GOSUMDB): the versions' .info, .mod and .zip
+// files and the checksum lookups and tiles are immutable, while the
+// version lists and the latest versions are indexes.
+func GoModules() ArtifactClassifier {
+	return func(u *url.URL) ArtifactClass {
+		p := u.Path
+		switch {
+		case strings.HasSuffix(p, "/@v/list"), strings.HasSuffix(p, "/@latest"), p == "/latest":
+			return IndexArtifact
+		case strings.Contains(p, "/@v/") && (strings.HasSuffix(p, ".info") || strings.HasSuffix(p, ".mod") || strings.HasSuffix(p, ".zip")):
+			return ImmutableArtifact
+		case strings.HasPrefix(p, "/lookup/"), strings.HasPrefix(p, "/tile/"):
+			return ImmutableArtifact
+		}
+		return OtherArtifact
+	}
+}
+
+// NPM classifies the URLs of the npm registries: the tarballs are
+// immutable, while the package documents, like /react or /@babel/core,
+// are indexes.
+func NPM() ArtifactClassifier {
+	return func(u *url.URL) ArtifactClass {
+		p := strings.Trim(u.Path, "/")
+		if p == "" || strings.HasPrefix(p, "-/") {
+			return OtherArtifact
+		}
+		if strings.Contains(p, "/-/") && strings.HasSuffix(p, ".tgz") {
+			return ImmutableArtifact
+		}
+		parts := strings.Split(p, "/")
+		if len(parts) == 1 || len(parts) == 2 && strings.HasPrefix(parts[0], "@") {
+			return IndexArtifact
+		}
+		return OtherArtifact
+	}
+}
+
+// PyPI classifies the URLs of the Python package indexes: the
+// distributions under /packages/ are immutable, while the simple
+// indexes and the JSON documents of the projects are indexes.
+func PyPI() ArtifactClassifier {
+	return func(u *url.URL) ArtifactClass {
+		p := u.Path
+		switch {
+		case strings.HasPrefix(p, "/packages/"):
+			return ImmutableArtifact
+		case strings.HasPrefix(p, "/simple/"), strings.HasPrefix(p, "/pypi/") && strings.HasSuffix(p, "/json"):
+			return IndexArtifact
+		}
+		return OtherArtifact
+	}
+}
+
+// Apt classifies the URLs of the Debian package archives: the packages
+// and sources in the pool and the index files by hash are immutable,
+// while the other files of the distributions, like Release and
+// Packages.gz, are indexes.
+func Apt() ArtifactClassifier {
+	return func(u *url.URL) ArtifactClass {
+		p := u.Path
+		switch {
+		case strings.Contains(p, "/by-hash/"):
+			return ImmutableArtifact
+		case strings.Contains(p, "/pool/"):
+			switch ext := path.Ext(p); {
+			case ext == ".deb", ext == ".udeb", ext == ".dsc", strings.Contains(p, ".tar."):
+				return ImmutableArtifact
+			}
+		case strings.Contains(p, "/dists/"):
+			return IndexArtifact
+		}
+		return OtherArtifact
+	}
+}
+
+// artifactClassifiers classifies URLs with the first classifier knowing
+// their class.
+type artifactClassifiers []ArtifactClassifier
+
+func (c artifactClassifiers) classify(u *url.URL) ArtifactClass {
+	for _, classify := range c {
+		if class := classify(u); class != OtherArtifact {
+			return class
+		}
+	}
+	return OtherArtifact
+}
+
+// Freshness keeps the successful responses of the immutable artifacts
+// fresh for a year.
+func (c artifactClassifiers) Freshness(req *http.Request, res *http.Response, lifetime time.Duration) time.Duration {
+	if res.StatusCode != http.StatusOK || c.classify(req.URL) != ImmutableArtifact {
+		return lifetime
+	}
+	return immutableTTL
+}
+
+// ArtifactRepositories bundles the options caching artifact repositories,
+// like Go module proxies, npm registries, Python package indexes or
+// Debian archives, whose URLs are classified by the classifiers, all of
+// GoModules, NPM, PyPI and Apt if none is given:
+//
+//   - the released versions, immutable, stay fresh for a year
+//     (WithFreshness)
+//   - the indexes are micro-cached for indexTTL, a single request per
+//     index reaching the repository at a time (WithMicroCache, whose TTL
+//     it sets)
+//
+// The classifiers classify the URLs of any host, classifiers of a single
+// host can be given to restrict them, like:
+//
+//	forwardcache.ArtifactRepositories(time.Minute, func(u *url.URL) forwardcache.ArtifactClass {
+//		if u.Host != "registry.npmjs.org" {
+//			return forwardcache.OtherArtifact
+//		}
+//		return forwardcache.NPM()(u)
+//	})
+func ArtifactRepositories(indexTTL time.Duration, classifiers ...ArtifactClassifier) func(*Peer) {
+	if len(classifiers) == 0 {
+		classifiers = []ArtifactClassifier{GoModules(), NPM(), PyPI(), Apt()}
+	}
+	c := artifactClassifiers(classifiers)
+	return func(p *Peer) {
+		WithFreshness(c)(p)
+		p.microTTL = indexTTL
+		p.microMatchers = append(p.microMatchers, func(u *url.URL) bool {
+			return c.classify(u) == IndexArtifact
+		})
+	}
+}
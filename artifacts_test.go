@@ -0,0 +1,113 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArtifactClassifiers(t *testing.T) {
+	testCases := []struct {
+		classifier ArtifactClassifier
+		url        string
+		class      ArtifactClass
+	}{
+		{GoModules(), "https://proxy.golang.org/github.com/pkg/errors/@v/v0.9.1.zip", ImmutableArtifact},
+		{GoModules(), "https://proxy.golang.org/github.com/pkg/errors/@v/v0.9.1.mod", ImmutableArtifact},
+		{GoModules(), "https://proxy.golang.org/github.com/pkg/errors/@v/list", IndexArtifact},
+		{GoModules(), "https://proxy.golang.org/github.com/pkg/errors/@latest", IndexArtifact},
+		{GoModules(), "https://sum.golang.org/lookup/github.com/pkg/errors@v0.9.1", ImmutableArtifact},
+		{GoModules(), "https://sum.golang.org/latest", IndexArtifact},
+		{GoModules(), "https://proxy.golang.org/", OtherArtifact},
+		{NPM(), "https://registry.npmjs.org/react", IndexArtifact},
+		{NPM(), "https://registry.npmjs.org/@babel/core", IndexArtifact},
+		{NPM(), "https://registry.npmjs.org/react/-/react-18.2.0.tgz", ImmutableArtifact},
+		{NPM(), "https://registry.npmjs.org/@babel/core/-/core-7.22.0.tgz", ImmutableArtifact},
+		{NPM(), "https://registry.npmjs.org/-/v1/search", OtherArtifact},
+		{NPM(), "https://registry.npmjs.org/react/18.2.0", OtherArtifact},
+		{PyPI(), "https://files.pythonhosted.org/packages/ab/cd/requests-2.31.0-py3-none-any.whl", ImmutableArtifact},
+		{PyPI(), "https://pypi.org/simple/requests/", IndexArtifact},
+		{PyPI(), "https://pypi.org/pypi/requests/json", IndexArtifact},
+		{PyPI(), "https://pypi.org/project/requests/", OtherArtifact},
+		{Apt(), "http://deb.debian.org/debian/pool/main/c/curl/curl_7.88.1-10_amd64.deb", ImmutableArtifact},
+		{Apt(), "http://deb.debian.org/debian/pool/main/c/curl/curl_7.88.1.orig.tar.gz", ImmutableArtifact},
+		{Apt(), "http://deb.debian.org/debian/dists/bookworm/InRelease", IndexArtifact},
+		{Apt(), "http://deb.debian.org/debian/dists/bookworm/main/binary-amd64/Packages.xz", IndexArtifact},
+		{Apt(), "http://deb.debian.org/debian/dists/bookworm/main/binary-amd64/by-hash/SHA256/2cf24d", ImmutableArtifact},
+		{Apt(), "http://deb.debian.org/debian/pool/main/c/curl/", OtherArtifact},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.url, func(t *testing.T) {
+			u, _ := url.Parse(tC.url)
+			if got := tC.classifier(u); got != tC.class {
+				t.Errorf("unexpected class: got %d, want %d", got, tC.class)
+			}
+		})
+	}
+}
+
+func TestArtifactRepositories(t *testing.T) {
+	fetches := make(map[string]int)
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		fetches[req.URL.Path]++
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{"Cache-Control": {"no-cache"}},
+			ContentLength: 2,
+			Body:          ioutil.NopCloser(strings.NewReader("OK")),
+		}, nil
+	})
+	peer := NewPeer("http://self.com:3000", WithPeerTransport(origin), ArtifactRepositories(time.Minute, GoModules()))
+
+	urls := []string{
+		"https://proxy.golang.org/github.com/pkg/errors/@v/v0.9.1.zip",
+		"https://proxy.golang.org/github.com/pkg/errors/@v/list",
+		"https://proxy.golang.org/github.com/pkg/errors/@v/list",
+		"https://proxy.golang.org/github.com/pkg/errors/@v/v0.9.1.zip",
+		"https://proxy.golang.org/",
+		"https://proxy.golang.org/",
+	}
+	for _, u := range urls {
+		rr := httptest.NewRecorder()
+		peer.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape(u), nil))
+		if got := rr.Body.String(); got != "OK" {
+			t.Fatalf("unexpected body of %s: got %q, want %q", u, got, "OK")
+		}
+	}
+	want := map[string]int{
+		"/github.com/pkg/errors/@v/v0.9.1.zip": 1,
+		"/github.com/pkg/errors/@v/list":       1,
+		"/":                                    2,
+	}
+	for path, n := range want {
+		if fetches[path] != n {
+			t.Errorf("unexpected fetches of %s: got %d, want %d", path, fetches[path], n)
+		}
+	}
+	if !peer.handler.micro.matches(&url.URL{Scheme: "https", Host: "proxy.golang.org", Path: "/m/@latest"}) {
+		t.Errorf("unexpected index not micro-cached")
+	}
+	if peer.handler.micro.matches(&url.URL{Scheme: "https", Host: "proxy.golang.org", Path: "/m/@v/v1.0.0.zip"}) {
+		t.Errorf("unexpected immutable artifact micro-cached")
+	}
+}
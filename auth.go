@@ -0,0 +1,246 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"time"
+
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+// KeyCounters are statistics about the requests of an authenticated
+// client, see WithAPIKey.
+type KeyCounters struct {
+	Requests    AtomicInt // requests made by the client
+	RateLimited AtomicInt // requests rejected because of the client's rate limit
+}
+
+// KeyCountersSnapshot is a point in time copy of KeyCounters.
+type KeyCountersSnapshot struct {
+	Requests    int64 `json:"requests"`
+	RateLimited int64 `json:"rateLimited"`
+}
+
+// Snapshot returns a copy of the counters.
+func (c *KeyCounters) Snapshot() KeyCountersSnapshot {
+	return KeyCountersSnapshot{
+		Requests:    c.Requests.Get(),
+		RateLimited: c.RateLimited.Get(),
+	}
+}
+
+// identity is a client allowed to use a peer.
+type identity struct {
+	name    string
+	limiter *tokenBucket // nil if unlimited
+}
+
+func newIdentity(name string, rate float64, burst int) *identity {
+	id := &identity{name: name}
+	if rate > 0 {
+		id.limiter = newTokenBucket(rate, burst)
+	}
+	return id
+}
+
+// clientAuth authenticates the clients of a peer by API key or by the
+// common name of their verified TLS certificate, and the other peers
+// of the pool by the signature of their requests.
+type clientAuth struct {
+	keys     map[[sha256.Size]byte]*identity // by hashed key, so lookups don't leak the keys' timing
+	certs    map[string]*identity            // by common name
+	secret   []byte                          // the cluster secret
+	required bool                            // to reject the unsigned requests of unknown clients
+	purges   bool                            // to accept the tag purges without a cluster secret
+	hot      bool                            // to serve the hot URLs without a cluster secret
+}
+
+func (a *clientAuth) enabled() bool {
+	return a.keys != nil || a.certs != nil
+}
+
+func (a *clientAuth) identify(req *http.Request) *identity {
+	if key := req.Header.Get(protocol.APIKeyHeader); key != "" {
+		if id, ok := a.keys[sha256.Sum256([]byte(key))]; ok {
+			return id
+		}
+	}
+	if req.TLS != nil && len(req.TLS.VerifiedChains) > 0 && len(req.TLS.VerifiedChains[0]) > 0 {
+		if id, ok := a.certs[req.TLS.VerifiedChains[0][0].Subject.CommonName]; ok {
+			return id
+		}
+	}
+	return nil
+}
+
+// authenticate checks that a request is made by a known client within
+// its rate limit, replying with an error otherwise. Signatures are
+// verified against query, the parameters served, and signed reports
+// whether the request was signed with the cluster secret.
+func (p *proxy) authenticate(w http.ResponseWriter, req *http.Request, query peerQuery) (signed, ok bool) {
+	if req.Header.Get(protocol.SignatureHeader) != "" && p.auth.secret != nil {
+		if protocol.VerifyRequest(req, p.auth.secret, query.signed(req.Method), time.Now()) {
+			return true, true
+		}
+		p.stats.Unauthorized.Add(1)
+		status := http.StatusUnauthorized
+		if p.auth.required {
+			status = http.StatusForbidden
+		}
+		http.Error(w, http.StatusText(status), status)
+		return false, false
+	}
+
+	if !p.auth.enabled() {
+		if p.auth.required && p.auth.secret != nil {
+			p.stats.Unauthorized.Add(1)
+			http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+			return false, false
+		}
+		return false, true
+	}
+
+	id := p.auth.identify(req)
+	if id == nil {
+		p.stats.Unauthorized.Add(1)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return false, false
+	}
+
+	counters := p.stats.Key(id.name)
+	counters.Requests.Add(1)
+	if id.limiter != nil && !id.limiter.allow() {
+		counters.RateLimited.Add(1)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return false, false
+	}
+	return false, true
+}
+
+// refuseUnsigned refuses a request of the pool that must be signed with
+// the cluster secret, unless allowed unsigned, and reports whether it
+// did. Without a cluster secret, the request can't be signed at all.
+func (p *proxy) refuseUnsigned(w http.ResponseWriter, signed, allowed bool) bool {
+	if signed || allowed {
+		return false
+	}
+	p.stats.Unauthorized.Add(1)
+	status := http.StatusForbidden
+	if p.auth.secret != nil {
+		status = http.StatusUnauthorized
+	}
+	http.Error(w, http.StatusText(status), status)
+	return true
+}
+
+// WithAPIKey requires clients to send an API key in the
+// protocol.APIKeyHeader header, and allows the given key. The name
+// identifies the client in Stats.Keys. Its requests are limited to
+// rate per second, with bursts of burst requests, unless rate is 0.
+// When the peers of a pool require API keys, they must authenticate
+// to each other too, see WithClusterSecret.
+// Defaults to allowing all clients.
+func WithAPIKey(name, key string, rate float64, burst int) func(*Peer) {
+	return func(p *Peer) {
+		if p.auth.keys == nil {
+			p.auth.keys = make(map[[sha256.Size]byte]*identity)
+		}
+		p.auth.keys[sha256.Sum256([]byte(key))] = newIdentity(name, rate, burst)
+	}
+}
+
+// WithClientCertificate requires clients to authenticate, and allows
+// the clients presenting a verified TLS certificate with the given
+// common name, which identifies them in Stats.Keys. The server must be
+// configured to verify client certificates. Rate limits are as with
+// WithAPIKey.
+func WithClientCertificate(commonName string, rate float64, burst int) func(*Peer) {
+	return func(p *Peer) {
+		if p.auth.certs == nil {
+			p.auth.certs = make(map[string]*identity)
+		}
+		p.auth.certs[commonName] = newIdentity(commonName, rate, burst)
+	}
+}
+
+// WithClientAPIKey sends the given API key to the peers, see WithAPIKey.
+func WithClientAPIKey(key string) func(*Client) {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithClientSecret signs the requests sent to the peers with the
+// cluster secret of the pool, for the clients of the peers requiring
+// signed requests, see WithRequiredSignatures.
+func WithClientSecret(secret []byte) func(*Client) {
+	return func(c *Client) {
+		c.secret = secret
+	}
+}
+
+// WithRequiredSignatures makes the peer reject the requests not signed
+// with its cluster secret (see WithClusterSecret) with 403 Forbidden,
+// as well as the requests with an invalid signature, or signed for
+// another method or more than protocol.MaxSkew ago, so that only the
+// other peers and the clients having the secret (see WithClientSecret)
+// can use it, rather than anyone reaching it. The clients authenticated
+// by WithAPIKey or WithClientCertificate are still allowed without
+// signing their requests. It has no effect without a cluster secret.
+// Defaults to allowing the unsigned requests.
+func WithRequiredSignatures() func(*Peer) {
+	return func(p *Peer) {
+		p.auth.required = true
+	}
+}
+
+// WithClusterSecret authenticates the peers of a pool to each other,
+// independently of how their clients authenticate. The requests a peer
+// forwards to the others are signed with the secret (see protocol.SignRequest),
+// and signed requests are allowed without client credentials. Requests
+// with an invalid signature are rejected. All the peers of a pool must
+// share the same secret.
+// Defaults to nil (requests are not signed).
+func WithClusterSecret(secret []byte) func(*Peer) {
+	return func(p *Peer) {
+		p.auth.secret = secret
+	}
+}
+
+// WithUnsignedTagPurges accepts the tag purges of the pool (see
+// Client.PurgeTag) on a peer without a cluster secret, from anyone
+// who can reach it. Only use it on a trusted network.
+// Defaults to false (tag purges are refused without a cluster secret).
+func WithUnsignedTagPurges() func(*Peer) {
+	return func(p *Peer) {
+		p.auth.purges = true
+	}
+}
+
+// WithUnsignedHotList serves the URLs the peer served the most (see
+// protocol.HotURL) on a peer without a cluster secret, to anyone who
+// can reach it, for Peer.Bootstrap and WithStandby. The URLs may carry
+// the tokens of signed URLs: only use it on a trusted network.
+// Defaults to false (the hot URLs are refused without a cluster secret).
+func WithUnsignedHotList() func(*Peer) {
+	return func(p *Peer) {
+		p.auth.hot = true
+	}
+}
@@ -0,0 +1,298 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+func TestAPIKeys(t *testing.T) {
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("X-Got-Key", req.Header.Get(protocol.APIKeyHeader))
+		return res, nil
+	})
+	peer := NewPeer("http://self.com:3000",
+		WithPeerTransport(origin),
+		WithAPIKey("team-a", "key-a", 0, 0),
+		WithAPIKey("team-b", "key-b", 0.001, 1),
+		WithClientCertificate("team-c", 0, 0),
+	)
+
+	testCases := []struct {
+		name string
+		key  string
+		cn   string
+		want int
+	}{
+		{"no key", "", "", http.StatusUnauthorized},
+		{"unknown key", "key-z", "", http.StatusUnauthorized},
+		{"key", "key-a", "", http.StatusOK},
+		{"limited key", "key-b", "", http.StatusOK},
+		{"limited key exceeded", "key-b", "", http.StatusTooManyRequests},
+		{"certificate", "", "team-c", http.StatusOK},
+		{"unknown certificate", "", "team-z", http.StatusUnauthorized},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape("http://cdn.com/jquery.js?"+tC.name), nil)
+			if tC.key != "" {
+				req.Header.Set(protocol.APIKeyHeader, tC.key)
+			}
+			if tC.cn != "" {
+				cert := &x509.Certificate{Subject: pkix.Name{CommonName: tC.cn}}
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+			}
+			peer.Handler().ServeHTTP(rr, req)
+
+			if rr.Code != tC.want {
+				t.Errorf("unexpected status: got %d, want %d", rr.Code, tC.want)
+			}
+			if got := rr.Header().Get("X-Got-Key"); got != "" {
+				t.Errorf("unexpected key forwarded to origin: got %q", got)
+			}
+		})
+	}
+
+	snap := peer.Stats().Snapshot()
+	if snap.Unauthorized != 3 {
+		t.Errorf("unexpected unauthorized: got %d, want %d", snap.Unauthorized, 3)
+	}
+	if got, want := snap.Keys["team-b"], (KeyCountersSnapshot{Requests: 2, RateLimited: 1}); got != want {
+		t.Errorf("unexpected team-b counters: got %+v, want %+v", got, want)
+	}
+}
+
+func TestClientAPIKey(t *testing.T) {
+	var got string
+	client := NewClient(
+		WithPool("http://peer.com:3000"),
+		WithClientAPIKey("key-a"),
+		WithClientTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			got = req.Header.Get(protocol.APIKeyHeader)
+			return okResponse(), nil
+		})),
+	)
+
+	req, _ := http.NewRequest("GET", "http://cdn.com/jquery.js", nil)
+	client.RoundTrip(req)
+	if got != "key-a" {
+		t.Errorf("unexpected key: got %q, want %q", got, "key-a")
+	}
+	if req.Header.Get(protocol.APIKeyHeader) != "" {
+		t.Errorf("unexpected modified request")
+	}
+}
+
+func TestClusterSecret(t *testing.T) {
+	hash := newHashMock().
+		with("http://a.com:3000", 0).
+		with("http://b.com:3000", 1).
+		with("http://cdn.com/jquery.js", 1)
+
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("X-Got-Signature", req.Header.Get(protocol.SignatureHeader))
+		return res, nil
+	})
+	secret := []byte("cluster secret")
+
+	b := NewPeer("http://b.com:3000",
+		WithPeerTransport(origin),
+		WithAPIKey("team-a", "key-a", 0, 0),
+		WithClusterSecret(secret),
+	)
+	toB := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		rr := httptest.NewRecorder()
+		b.Handler().ServeHTTP(rr, req)
+		return rr.Result(), nil
+	})
+
+	testCases := []struct {
+		name   string
+		secret []byte
+		want   int
+	}{
+		{"same secret", secret, http.StatusOK},
+		{"other secret", []byte("other"), http.StatusUnauthorized},
+		{"no secret", nil, http.StatusUnauthorized},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			a := NewPeer("http://a.com:3000",
+				WithClusterSecret(tC.secret),
+				WithClient(NewClient(
+					WithPool("http://a.com:3000", "http://b.com:3000"),
+					WithHashFn(hash.fn),
+					WithClientTransport(toB),
+				)),
+			)
+
+			req, _ := http.NewRequest("GET", "http://cdn.com/jquery.js", nil)
+			res, err := a.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res.StatusCode != tC.want {
+				t.Errorf("unexpected status: got %d, want %d", res.StatusCode, tC.want)
+			}
+			if got := res.Header.Get("X-Got-Signature"); got != "" {
+				t.Errorf("unexpected signature forwarded to origin: got %q", got)
+			}
+		})
+	}
+}
+
+func TestRequiredSignatures(t *testing.T) {
+	secret := []byte("cluster secret")
+	peer := NewPeer("http://peer.com:3000",
+		WithPeerTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return okResponse(), nil
+		})),
+		WithClusterSecret(secret),
+		WithRequiredSignatures(),
+	)
+	toPeer := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		rr := httptest.NewRecorder()
+		peer.Handler().ServeHTTP(rr, req)
+		return rr.Result(), nil
+	})
+
+	testCases := []struct {
+		name   string
+		secret []byte
+		want   int
+	}{
+		{"signed", secret, http.StatusOK},
+		{"invalid signature", []byte("other"), http.StatusForbidden},
+		{"unsigned", nil, http.StatusForbidden},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			client := NewClient(
+				WithPool("http://peer.com:3000"),
+				WithClientSecret(tC.secret),
+				WithClientTransport(toPeer),
+			)
+
+			req, _ := http.NewRequest("GET", "http://cdn.com/jquery.js", nil)
+			res, err := client.RoundTrip(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if res.StatusCode != tC.want {
+				t.Errorf("unexpected status: got %d, want %d", res.StatusCode, tC.want)
+			}
+		})
+	}
+}
+
+func TestSignedQueryAmbiguous(t *testing.T) {
+	secret := []byte("cluster secret")
+	var fetched []string
+	peer := NewPeer("http://peer.com:3000",
+		WithPeerTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			fetched = append(fetched, req.URL.String())
+			return okResponse(), nil
+		})),
+		WithClusterSecret(secret),
+		WithRequiredSignatures(),
+	)
+
+	signed := "http://cdn.com/jquery.js"
+	testCases := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{"signed", "q=" + url.QueryEscape(signed), http.StatusOK},
+		{"duplicated", "q=http://evil.internal/x;y&q=" + url.QueryEscape(signed), http.StatusBadRequest},
+		{"duplicated without separator", "q=" + url.QueryEscape("http://evil.internal/x") + "&q=" + url.QueryEscape(signed), http.StatusBadRequest},
+	}
+	for _, tC := range testCases {
+		req := httptest.NewRequest("GET", "http://peer.com:3000/proxy?"+tC.query, nil)
+		protocol.SignRequest(req, secret, signed, time.Now())
+		rr := httptest.NewRecorder()
+		peer.Handler().ServeHTTP(rr, req)
+		if rr.Code != tC.want {
+			t.Errorf("unexpected status for %s: got %d, want %d", tC.name, rr.Code, tC.want)
+		}
+	}
+	if len(fetched) != 1 || fetched[0] != signed {
+		t.Errorf("unexpected origin fetches: got %q, want %q", fetched, []string{signed})
+	}
+}
+
+func TestSignedRequestReplay(t *testing.T) {
+	secret := []byte("cluster secret")
+	peer := NewPeer("http://peer.com:3000",
+		WithPeerTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return okResponse(), nil
+		})),
+		WithClusterSecret(secret),
+		WithRequiredSignatures(),
+	)
+
+	signed := "http://cdn.com/jquery.js"
+	query := "/proxy?q=" + url.QueryEscape(signed)
+	testCases := []struct {
+		name   string
+		method string
+		sign   func(req *http.Request)
+		want   int
+	}{
+		{"signed", "GET", func(req *http.Request) {
+			protocol.SignRequest(req, secret, signed, time.Now())
+		}, http.StatusOK},
+		{"replayed", "GET", func(req *http.Request) {
+			protocol.SignRequest(req, secret, signed, time.Now().Add(-2*protocol.MaxSkew))
+		}, http.StatusForbidden},
+		{"tampered timestamp", "GET", func(req *http.Request) {
+			protocol.SignRequest(req, secret, signed, time.Now().Add(-2*protocol.MaxSkew))
+			req.Header.Set(protocol.TimestampHeader, strconv.FormatInt(time.Now().Unix(), 10))
+		}, http.StatusForbidden},
+		{"tampered method", "DELETE", func(req *http.Request) {
+			req.Method = "GET"
+			protocol.SignRequest(req, secret, signed, time.Now())
+			req.Method = "DELETE"
+		}, http.StatusForbidden},
+		{"tampered resource", "GET", func(req *http.Request) {
+			protocol.SignRequest(req, secret, "http://cdn.com/other.js", time.Now())
+		}, http.StatusForbidden},
+		{"unsigned", "GET", func(req *http.Request) {}, http.StatusForbidden},
+	}
+	for _, tC := range testCases {
+		req := httptest.NewRequest(tC.method, query, nil)
+		tC.sign(req)
+		rr := httptest.NewRecorder()
+		peer.Handler().ServeHTTP(rr, req)
+		if rr.Code != tC.want {
+			t.Errorf("unexpected status for %s: got %d, want %d", tC.name, rr.Code, tC.want)
+		}
+	}
+}
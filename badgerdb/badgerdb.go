@@ -0,0 +1,255 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package badgerdb provides a cache storing responses in a Badger
+// database, for single node persistent caches larger than memory.
+package badgerdb
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	badger "github.com/dgraph-io/badger/v4"
+)
+
+// valueLogFileSize is the size of the value log files, preallocated by
+// Badger. Smaller files than the default 1GB are garbage collected
+// sooner, giving back the space of the expired and deleted responses.
+const valueLogFileSize = 64 << 20
+
+// Cache is an httpcache.Cache storing responses in a Badger database.
+// Errors of the database are treated as misses.
+// It is safe for concurrent access.
+type Cache struct {
+	db           *badger.DB
+	dir          string
+	ttl          time.Duration
+	maxSize      int64
+	gcInterval   time.Duration
+	discardRatio float64
+	mu           sync.Mutex
+	size         int64 // of the live responses, estimated, see WithMaxSize
+	stats        Stats
+	done         chan struct{}
+	close        sync.Once
+	wg           sync.WaitGroup
+}
+
+// errDenied aborts the transactions storing responses over the size cap.
+var errDenied = errors.New("badgerdb: size cap reached")
+
+// Stats are statistics about the cache.
+type Stats struct {
+	Denied int64 // responses not stored because of the size cap
+	GCs    int64 // value log files rewritten by the garbage collection
+}
+
+// New opens or creates the database in dir and starts its value log
+// garbage collection.
+func New(dir string, options ...func(*Cache)) (*Cache, error) {
+	c := &Cache{
+		dir:          dir,
+		gcInterval:   5 * time.Minute,
+		discardRatio: 0.5,
+		done:         make(chan struct{}),
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	opts := badger.DefaultOptions(dir).
+		WithLoggingLevel(badger.WARNING).
+		WithValueLogFileSize(valueLogFileSize)
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	c.db = db
+	c.measure()
+
+	if c.gcInterval > 0 {
+		c.wg.Add(1)
+		go c.collect()
+	}
+	return c, nil
+}
+
+// WithTTL makes the responses expire from the database ttl after they
+// were stored, whatever their freshness. Defaults to never.
+func WithTTL(ttl time.Duration) func(*Cache) {
+	return func(c *Cache) {
+		c.ttl = ttl
+	}
+}
+
+// WithMaxSize caps the size of the live responses, keys included:
+// responses that would take it over max bytes are not stored, counted
+// in Stats.Denied, until others are replaced, deleted or expire. The
+// cap is a hard stop, nothing is evicted to make room. The size is
+// tracked as responses are stored, replaced and deleted, and measured
+// again when the database is opened and garbage collected, to account
+// for the expired responses. The files of the database are larger by
+// the space of the responses not garbage collected yet.
+// Defaults to unlimited.
+func WithMaxSize(max int64) func(*Cache) {
+	return func(c *Cache) {
+		c.maxSize = max
+	}
+}
+
+// WithGC specifies how often the value log is garbage collected and
+// the fraction of a value log file that must be discardable for it to
+// be rewritten (see badger.DB.RunValueLogGC). An interval of 0 disables
+// the garbage collection. Defaults to every 5 minutes and 0.5.
+func WithGC(interval time.Duration, discardRatio float64) func(*Cache) {
+	return func(c *Cache) {
+		c.gcInterval = interval
+		c.discardRatio = discardRatio
+	}
+}
+
+// Get returns the response stored for key.
+func (c *Cache) Get(key string) (resp []byte, ok bool) {
+	err := c.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		resp, err = item.ValueCopy(nil)
+		return err
+	})
+	return resp, err == nil
+}
+
+// Set stores a response for key.
+func (c *Cache) Set(key string, resp []byte) {
+	var delta int64
+	err := c.db.Update(func(txn *badger.Txn) error {
+		delta = int64(len(key)+len(resp)) - c.sizeOf(txn, key)
+		if !c.grow(delta) {
+			return errDenied
+		}
+		e := badger.NewEntry([]byte(key), resp)
+		if c.ttl > 0 {
+			e = e.WithTTL(c.ttl)
+		}
+		return txn.SetEntry(e)
+	})
+	if err != nil && err != errDenied {
+		c.grow(-delta)
+	}
+}
+
+// Delete removes the response stored for key.
+func (c *Cache) Delete(key string) {
+	var size int64
+	err := c.db.Update(func(txn *badger.Txn) error {
+		size = c.sizeOf(txn, key)
+		return txn.Delete([]byte(key))
+	})
+	if err == nil {
+		c.grow(-size)
+	}
+}
+
+// sizeOf returns the size of the response stored for key, key
+// included, or 0 if there is none.
+func (c *Cache) sizeOf(txn *badger.Txn, key string) int64 {
+	item, err := txn.Get([]byte(key))
+	if err != nil {
+		return 0
+	}
+	return int64(len(key)) + item.ValueSize()
+}
+
+// grow adds delta to the size of the live responses, unless it would
+// take it over the size cap.
+func (c *Cache) grow(delta int64) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.maxSize > 0 && delta > 0 && c.size+delta > c.maxSize {
+		c.stats.Denied++
+		return false
+	}
+	c.size += delta
+	return true
+}
+
+// Stats returns the statistics of the cache.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
+
+// Close stops the garbage collection and closes the database.
+func (c *Cache) Close() error {
+	var err error
+	c.close.Do(func() {
+		close(c.done)
+		c.wg.Wait()
+		err = c.db.Close()
+	})
+	return err
+}
+
+// collect garbage collects the value log periodically, rewriting files
+// until none is worth it as recommended by Badger.
+func (c *Cache) collect() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(c.gcInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-c.done:
+			return
+		}
+
+		for c.db.RunValueLogGC(c.discardRatio) == nil {
+			c.mu.Lock()
+			c.stats.GCs++
+			c.mu.Unlock()
+		}
+		c.measure()
+	}
+}
+
+// measure sets the size of the live responses, the expired and
+// deleted ones being skipped by Badger's iterators.
+func (c *Cache) measure() {
+	var size int64
+	c.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			size += int64(len(item.Key())) + item.ValueSize()
+		}
+		return nil
+	})
+
+	c.mu.Lock()
+	c.size = size
+	c.mu.Unlock()
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package badgerdb
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "badgerdb")
+	defer os.RemoveAll(dir)
+
+	cache, err := New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("key1", []byte("value1"))
+	if val, ok := cache.Get("key1"); !ok || string(val) != "value1" {
+		t.Errorf("unexpected value: got %q, want %q", val, "value1")
+	}
+	cache.Delete("key1")
+	if _, ok := cache.Get("key1"); ok {
+		t.Errorf("unexpected key '%s' in cache", "key1")
+	}
+
+	// responses survive a restart
+	cache.Set("key2", []byte("value2"))
+	cache.Close()
+	cache, err = New(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if val, ok := cache.Get("key2"); !ok || string(val) != "value2" {
+		t.Errorf("unexpected value: got %q, want %q", val, "value2")
+	}
+}
+
+func TestTTL(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "badgerdb")
+	defer os.RemoveAll(dir)
+
+	cache, err := New(dir, WithTTL(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Set("key", []byte("value"))
+	if _, ok := cache.Get("key"); !ok {
+		t.Errorf("expected key '%s' to be found in cache", "key")
+	}
+	time.Sleep(1100 * time.Millisecond)
+	if _, ok := cache.Get("key"); ok {
+		t.Errorf("unexpected expired key '%s' in cache", "key")
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "badgerdb")
+	defer os.RemoveAll(dir)
+
+	cache, err := New(dir, WithMaxSize(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	cache.Set("key", []byte("value"))
+	if _, ok := cache.Get("key"); ok {
+		t.Errorf("unexpected key '%s' in cache", "key")
+	}
+	if got, want := cache.Stats().Denied, int64(1); got != want {
+		t.Errorf("unexpected denied count: got %d, want %d", got, want)
+	}
+}
+
+func TestMaxSizeReplaced(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "badgerdb")
+	defer os.RemoveAll(dir)
+
+	cache, err := New(dir, WithMaxSize(10), WithGC(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+
+	// the size of the responses replaced and deleted is given back
+	for _, value := range []string{"value1", "value2"} {
+		cache.Set("key", []byte(value))
+		if got, ok := cache.Get("key"); !ok || string(got) != value {
+			t.Errorf("unexpected value: got %q, want %q", got, value)
+		}
+	}
+	cache.Delete("key")
+	cache.Set("other", []byte("value"))
+	if _, ok := cache.Get("other"); !ok {
+		t.Errorf("expected key '%s' to be found in cache", "other")
+	}
+	if got := cache.Stats().Denied; got != 0 {
+		t.Errorf("unexpected denied count: got %d, want %d", got, 0)
+	}
+
+	// the size of the responses stored is measured when reopened
+	cache.Close()
+	if cache, err = New(dir, WithMaxSize(10), WithGC(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("key", []byte("value"))
+	if got := cache.Stats().Denied; got != 1 {
+		t.Errorf("unexpected denied count: got %d, want %d", got, 1)
+	}
+	if err := cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Close(); err != nil {
+		t.Errorf("unexpected error closing twice: %v", err)
+	}
+}
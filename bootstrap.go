@@ -0,0 +1,230 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+// Bootstrap warms the cache of a cold peer, like one added to the pool
+// to scale it up, with the responses of its siblings: it asks each peer
+// of the pool for the n URLs it served the most (see protocol.HotURL)
+// and fetches the ones it now owns from the cache of that peer, without
+// reaching the origins, with the bulk priority and at most concurrency
+// at a time. The stored responses keep their age. The siblings only
+// list their URLs to the peers sharing their cluster secret (see
+// WithClusterSecret), or to anyone with WithUnsignedHotList.
+//
+// The health route of the peer's Mux replies 503 Service Unavailable
+// while the peer bootstraps, so that it takes full traffic once its
+// cache is warm. Bootstrap should be called once the pool is set. It
+// stops when ctx is done, and returns the number of responses stored and
+// the first error encountered, if any, the siblings failing being
+// skipped.
+func (p *Peer) Bootstrap(ctx context.Context, n, concurrency int) (int, error) {
+	p.bootstrapping.Add(1)
+	defer p.bootstrapping.Add(-1)
+
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	ctx = WithPriority(ctx, PriorityBulk)
+
+	p.Client.mu.RLock()
+	peers := p.Client.peers
+	p.Client.mu.RUnlock()
+
+	cache := &countingCache{Cache: &sizeStatsCache{p.purges.cache(), p.handler.stats}}
+	var first error
+	for _, peer := range peers {
+		if peer == p.self {
+			continue
+		}
+		urls, err := p.hotURLs(ctx, peer, n)
+		if err == nil {
+			owned := urls[:0]
+			for _, u := range urls {
+				if p.Owner(u) == p.self {
+					owned = append(owned, u)
+				}
+			}
+			err = p.fetchSibling(ctx, peer, owned, cache, concurrency)
+		}
+		if err != nil && first == nil {
+			first = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return int(cache.sets.Get()), first
+}
+
+// hotURLs lists the n URLs a sibling served the most.
+func (p *Peer) hotURLs(ctx context.Context, peer string, n int) ([]string, error) {
+	u, err := protocol.HotURL(peer, p.Client.path, n)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if p.Client.apiKey != "" {
+		req.Header.Set(protocol.APIKeyHeader, p.Client.apiKey)
+	}
+	if p.Client.secret != nil {
+		protocol.SignRequest(req, p.Client.secret, strconv.Itoa(n), time.Now())
+	}
+
+	res, err := p.Client.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("forwardcache: listing the hot URLs of %s: %s", peer, res.Status)
+	}
+
+	var urls []string
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		if u := scanner.Text(); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls, scanner.Err()
+}
+
+// fetchSibling stores in cache the responses to urls stored by a
+// sibling, through the freshness policies of the peer.
+func (p *Peer) fetchSibling(ctx context.Context, peer string, urls []string, cache httpcache.Cache, concurrency int) error {
+	var transport http.RoundTripper = &siblingTransport{p.Client, peer}
+	transport = &heuristicTransport{&p.handler.heuristic, transport}
+	transport = &freshnessTransport{[]FreshnessPolicy{(*minTTL)(&p.handler.minTTL), &p.handler.micro}, &p.handler.freshness, transport}
+	transport = &cacheTransport{
+		cache:       cache,
+		credentials: &p.handler.credentials,
+		admission:   &p.handler.admission,
+		stats:       p.handler.stats,
+		transport:   transport,
+	}
+
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+		sem   = make(chan struct{}, concurrency)
+	)
+	for _, u := range urls {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			once.Do(func() { first = ctx.Err() })
+			return first
+		}
+
+		wg.Add(1)
+		go func(u string) {
+			defer func() { <-sem; wg.Done() }()
+			if err := fetchDiscard(ctx, transport, u); err != nil {
+				once.Do(func() { first = err })
+			}
+		}(u)
+	}
+
+	wg.Wait()
+	return first
+}
+
+// fetchDiscard fetches u with transport and discards the response.
+func fetchDiscard(ctx context.Context, transport http.RoundTripper, u string) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(ioutil.Discard, res.Body)
+	return err
+}
+
+// siblingTransport fetches the responses stored by a sibling peer,
+// without letting it reach the origins.
+type siblingTransport struct {
+	client *Client
+	peer   string
+}
+
+func (t *siblingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	cpy := clone(req)
+	cpy.Header.Set("Cache-Control", "only-if-cached")
+	res, err := t.client.roundTripTo(t.peer, req.URL.String(), cpy, t.client.stats.RingEpoch.Get())
+	if err != nil {
+		return nil, err
+	}
+	res.Header.Del(httpcache.XFromCache)
+	return res, nil
+}
+
+// countingCache counts the responses stored in a cache.
+type countingCache struct {
+	httpcache.Cache
+	sets AtomicInt
+}
+
+func (c *countingCache) Set(key string, resp []byte) {
+	c.sets.Add(1)
+	c.Cache.Set(key, resp)
+}
+
+// serveHot lists the n URLs the peer served the most, see
+// protocol.HotURL. They must be signed with the cluster secret, unless
+// the peer serves them unsigned without one.
+func (p *proxy) serveHot(w http.ResponseWriter, hot string) {
+	n, err := strconv.Atoi(hot)
+	if err != nil || n < 1 {
+		http.Error(w, "invalid hot URLs count", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	bw := bufio.NewWriter(w)
+	for _, u := range p.stats.urls.hottest(n) {
+		bw.WriteString(u)
+		bw.WriteByte('\n')
+	}
+	bw.Flush()
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+func TestBootstrap(t *testing.T) {
+	secret := WithClusterSecret([]byte("s3cr3t"))
+	sibling := NewPeer("http://sibling.invalid", secret, WithPeerTransport(roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return okResponse(), nil
+	})))
+	server := httptest.NewServer(sibling.Handler())
+	defer server.Close()
+
+	var urls []string
+	for i := 0; i < 20; i++ {
+		u := fmt.Sprintf("http://cdn.com/%d.js", i)
+		urls = append(urls, u)
+		for j := 0; j <= i%3; j++ {
+			req, _ := http.NewRequest("GET", "/proxy?q="+url.QueryEscape(u), nil)
+			sibling.Handler().ServeHTTP(httptest.NewRecorder(), req)
+		}
+	}
+
+	if got, want := sibling.Stats().urls.hottest(1), []string{"http://cdn.com/17.js"}; len(got) != 1 || got[0] != want[0] {
+		t.Errorf("unexpected hottest URLs: got %q, want %q", got, want)
+	}
+
+	peer := NewPeer("http://self.invalid", secret, WithPeerTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		t.Errorf("unexpected origin request for %s", req.URL)
+		return nil, errors.New("unexpected origin request")
+	})))
+	peer.SetPool(server.URL, "http://self.invalid")
+
+	var owned []string
+	for _, u := range urls {
+		if peer.Owner(u) == "http://self.invalid" {
+			owned = append(owned, u)
+		}
+	}
+	if len(owned) == 0 || len(owned) == len(urls) {
+		t.Fatalf("unexpected owned URLs: got %d of %d", len(owned), len(urls))
+	}
+
+	stored, err := peer.Bootstrap(context.Background(), 100, 4)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored != len(owned) {
+		t.Errorf("unexpected stored responses: got %d, want %d", stored, len(owned))
+	}
+	for _, u := range owned {
+		probe, _ := peer.Probe(u)
+		if !probe.Cached || !probe.Fresh {
+			t.Errorf("unexpected probe of %s: got cached %v, fresh %v", u, probe.Cached, probe.Fresh)
+		}
+	}
+}
+
+func TestServeHot(t *testing.T) {
+	secret := []byte("s3cr3t")
+	unsigned := NewPeer("http://self.com:3000")
+	allowed := NewPeer("http://self.com:3000", WithUnsignedHotList())
+	signed := NewPeer("http://self.com:3000", WithClusterSecret(secret))
+
+	testCases := []struct {
+		peer   *Peer
+		query  string
+		sign   bool
+		status int
+	}{
+		{allowed, "hot=10", false, http.StatusOK},
+		{allowed, "hot=0", false, http.StatusBadRequest},
+		{allowed, "hot=x", false, http.StatusBadRequest},
+		{unsigned, "hot=10", false, http.StatusForbidden},
+		{signed, "hot=10", false, http.StatusUnauthorized},
+		{signed, "hot=10", true, http.StatusOK},
+	}
+	for i, tC := range testCases {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/proxy?"+tC.query, nil)
+		if tC.sign {
+			protocol.SignRequest(req, secret, req.URL.Query().Get(protocol.HotParam), time.Now())
+		}
+		tC.peer.Handler().ServeHTTP(rr, req)
+		if rr.Code != tC.status {
+			t.Errorf("%d: unexpected status of %q: got %d, want %d", i, tC.query, rr.Code, tC.status)
+		}
+	}
+}
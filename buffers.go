@@ -1,32 +1,122 @@
 package forwardcache
 
 import (
+	"sort"
 	"sync"
 )
 
+// defaultBufferSize is the size of the buffers handed out without size hint.
+const defaultBufferSize = 32 * 1024
+
 // BufferPool uses sync.Pool for getting and returning temporary byte slices.
+// It hands out buffers from one or more size classes.
 type BufferPool struct {
-	p *sync.Pool
+	classes []*bufferClass // sorted by size
+	def     *bufferClass   // used without size hint
+}
+
+type bufferClass struct {
+	size   int
+	pool   sync.Pool
+	gets   AtomicInt
+	allocs AtomicInt
+	inUse  AtomicInt
 }
 
 // NewBufferPool creates a new BufferPool.
 func NewBufferPool(bufSize int) *BufferPool {
-	return &BufferPool{
-		&sync.Pool{
-			New: func() interface{} { return make([]byte, bufSize) },
-		},
+	return NewTieredBufferPool(bufSize)
+}
+
+// NewTieredBufferPool creates a BufferPool with a size class per size.
+// Buffers are taken from the smallest class fitting the size hint given
+// to GetSized, so small objects don't hold large buffers and large ones
+// are copied in fewer chunks. Get uses the smallest class of at least
+// 32k, or the largest class.
+func NewTieredBufferPool(sizes ...int) *BufferPool {
+	sort.Ints(sizes)
+
+	p := &BufferPool{}
+	for _, size := range sizes {
+		c := &bufferClass{size: size}
+		c.pool.New = func() interface{} {
+			c.allocs.Add(1)
+			return make([]byte, c.size)
+		}
+		p.classes = append(p.classes, c)
+		if p.def == nil && size >= defaultBufferSize {
+			p.def = c
+		}
 	}
+	if p.def == nil {
+		p.def = p.classes[len(p.classes)-1]
+	}
+	return p
 }
 
-// DefaultBufferPool is a pool which produces 32k buffers.
-var DefaultBufferPool = NewBufferPool(32 * 1024)
+// DefaultBufferPool is a pool which produces 4k, 32k and 256k buffers.
+var DefaultBufferPool = NewTieredBufferPool(4*1024, 32*1024, 256*1024)
 
 // Get gets a buffer from the pool.
 func (p *BufferPool) Get() []byte {
-	return p.p.Get().([]byte)
+	return p.def.get()
+}
+
+// GetSized gets a buffer to copy size bytes from the pool, size
+// being -1 if unknown.
+func (p *BufferPool) GetSized(size int64) []byte {
+	if size < 0 {
+		return p.Get()
+	}
+	for _, c := range p.classes {
+		if int64(c.size) >= size {
+			return c.get()
+		}
+	}
+	return p.classes[len(p.classes)-1].get()
 }
 
-// Put puts back a buffer to the pool.
+// Put puts back a buffer to the pool. Buffers not handed out by the
+// pool are dropped.
 func (p *BufferPool) Put(b []byte) {
-	p.p.Put(b)
+	for _, c := range p.classes {
+		if cap(b) == c.size {
+			c.inUse.Add(-1)
+			c.pool.Put(b[:c.size])
+			return
+		}
+	}
+}
+
+func (c *bufferClass) get() []byte {
+	c.gets.Add(1)
+	c.inUse.Add(1)
+	return c.pool.Get().([]byte)
+}
+
+// BufferClassStats are statistics about a size class of a BufferPool.
+type BufferClassStats struct {
+	Size   int   `json:"size"`
+	Gets   int64 `json:"gets"`   // buffers handed out
+	Allocs int64 `json:"allocs"` // buffers allocated because none could be reused
+	InUse  int64 `json:"inUse"`  // buffers not put back yet
+}
+
+// Stats returns statistics about the utilization of each size class.
+func (p *BufferPool) Stats() []BufferClassStats {
+	stats := make([]BufferClassStats, len(p.classes))
+	for i, c := range p.classes {
+		stats[i] = BufferClassStats{
+			Size:   c.size,
+			Gets:   c.gets.Get(),
+			Allocs: c.allocs.Get(),
+			InUse:  c.inUse.Get(),
+		}
+	}
+	return stats
+}
+
+// sizedBufferPool is a buffer pool taking size hints, like BufferPool.
+type sizedBufferPool interface {
+	GetSized(size int64) []byte
 }
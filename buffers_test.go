@@ -0,0 +1,63 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import "testing"
+
+func TestTieredBufferPool(t *testing.T) {
+	pool := NewTieredBufferPool(256, 16, 64)
+
+	testCases := []struct {
+		size int64
+		want int
+	}{
+		{-1, 256},
+		{0, 16},
+		{16, 16},
+		{17, 64},
+		{200, 256},
+		{1000, 256},
+	}
+	for _, tC := range testCases {
+		b := pool.GetSized(tC.size)
+		if len(b) != tC.want {
+			t.Errorf("unexpected buffer size for %d: got %d, want %d", tC.size, len(b), tC.want)
+		}
+		pool.Put(b[:1])
+	}
+	pool.Put(make([]byte, 10)) // dropped
+
+	want := []BufferClassStats{
+		{Size: 16, Gets: 2, Allocs: 1, InUse: 0},
+		{Size: 64, Gets: 1, Allocs: 1, InUse: 0},
+		{Size: 256, Gets: 3, Allocs: 1, InUse: 0},
+	}
+	got := pool.Stats()
+	for i := range want {
+		// sync.Pool may drop buffers, allocating more than once
+		if got[i].Size != want[i].Size || got[i].Gets != want[i].Gets || got[i].InUse != want[i].InUse || got[i].Allocs < 1 {
+			t.Errorf("unexpected stats: got %+v, want %+v", got[i], want[i])
+		}
+	}
+
+	if got := len(NewBufferPool(1024).Get()); got != 1024 {
+		t.Errorf("unexpected buffer size: got %d, want %d", got, 1024)
+	}
+	if got := len(DefaultBufferPool.Get()); got != 32*1024 {
+		t.Errorf("unexpected default buffer size: got %d, want %d", got, 32*1024)
+	}
+}
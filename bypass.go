@@ -0,0 +1,191 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// bypass are the rules of a Client sending requests directly to the
+// origins instead of through a peer.
+type bypass struct {
+	patterns []string
+	private  bool
+	methods  map[string]bool // routed through the pool, nil for GET and HEAD
+	reject   bool            // whether the other methods are rejected
+	direct   http.RoundTripper
+}
+
+// MethodPolicy is what a Client does with the requests whose method is
+// not routed through the pool, see WithMethods.
+type MethodPolicy int
+
+const (
+	// DirectOtherMethods sends them directly to the origins.
+	DirectOtherMethods MethodPolicy = iota
+	// RejectOtherMethods fails them.
+	RejectOtherMethods
+)
+
+var errMethodNotRouted = errors.New("method not routed through the pool")
+
+// routed reports whether requests of a method are routed through the pool.
+func (b *bypass) routed(method string) bool {
+	if b.methods == nil {
+		return method == http.MethodGet || method == http.MethodHead
+	}
+	return b.methods[method]
+}
+
+// roundTrip makes a request bypassing the pool.
+func (b *bypass) roundTrip(req *http.Request, stats *ClientStats) (*http.Response, error) {
+	if b.reject && !b.routed(req.Method) {
+		return nil, errMethodNotRouted
+	}
+	stats.Bypassed.Add(1)
+	return b.transport().RoundTrip(req)
+}
+
+// matches reports whether a request bypasses the pool.
+func (b *bypass) matches(req *http.Request) bool {
+	if !b.routed(req.Method) {
+		return true
+	}
+	u := req.URL
+	host := strings.ToLower(u.Hostname())
+	if b.private && privateHost(host) {
+		return true
+	}
+	for _, pattern := range b.patterns {
+		if matchURL(pattern, host, u.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchURL reports whether a URL, of lowercase host, matches a pattern
+// of host (without slash), of path and query (starting with a slash)
+// or of the whole URL.
+func matchURL(pattern, host, rawurl string) bool {
+	if !strings.Contains(pattern, "/") {
+		return match(strings.ToLower(pattern), host)
+	}
+	return matchKey(pattern, rawurl)
+}
+
+// transport returns the transport of the requests bypassing the pool.
+func (b *bypass) transport() http.RoundTripper {
+	if b.direct == nil {
+		return http.DefaultTransport
+	}
+	return b.direct
+}
+
+// privateHost reports whether a host is local or private: localhost or
+// a loopback, link-local, private (RFC 1918, RFC 4193) or unspecified
+// address. Names other than localhost are not resolved.
+func privateHost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && (ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified())
+}
+
+// WithBypass sends the requests matching one of the patterns directly to
+// the origins, using the transport set by WithDirectTransport, so that
+// internal service calls don't detour through the pool. Patterns without
+// slash match the host, like "*.svc.cluster.local", patterns starting
+// with a slash match the path and query, like "/api/*", and the other
+// ones match the whole URL, like "http://intranet/*". Stars match any
+// sequence of characters.
+func WithBypass(patterns ...string) func(*Client) {
+	return func(c *Client) {
+		c.bypass.patterns = append(c.bypass.patterns, patterns...)
+	}
+}
+
+// originPool routes the requests matching a pattern through a pool,
+// see WithOriginPool.
+type originPool struct {
+	pattern string
+	pool    *Client
+}
+
+// originPool returns the pool of the first origin pool matching a
+// request, nil if none does.
+func (c *Client) originPool(req *http.Request) *Client {
+	if len(c.pools) == 0 {
+		return nil
+	}
+	u := req.URL
+	host := strings.ToLower(u.Hostname())
+	for _, p := range c.pools {
+		if matchURL(p.pattern, host, u.String()) {
+			return p.pool
+		}
+	}
+	return nil
+}
+
+// WithOriginPool routes the requests matching the pattern through
+// another pool, for example large artifacts through a pool of peers
+// with disks and small objects through the client's pool of peers
+// caching in memory. Patterns are matched like with WithBypass, the
+// first origin pool matching a request being used. The requests
+// bypassing the pool are not routed through origin pools.
+func WithOriginPool(pattern string, pool *Client) func(*Client) {
+	return func(c *Client) {
+		c.pools = append(c.pools, originPool{pattern, pool})
+	}
+}
+
+// WithBypassPrivate sends the requests for localhost and for loopback,
+// link-local and private addresses, like the RFC 1918 ones, directly to
+// the origins. Host names are not resolved.
+func WithBypassPrivate() func(*Client) {
+	return func(c *Client) {
+		c.bypass.private = true
+	}
+}
+
+// WithMethods specifies the methods of the requests routed through the
+// pool, the requests of the other methods being either sent directly to
+// the origins, using the transport set by WithDirectTransport, or
+// rejected. Defaults to GET and HEAD, the other methods going directly
+// to the origins.
+func WithMethods(policy MethodPolicy, methods ...string) func(*Client) {
+	return func(c *Client) {
+		c.bypass.methods = make(map[string]bool, len(methods))
+		for _, method := range methods {
+			c.bypass.methods[strings.ToUpper(method)] = true
+		}
+		c.bypass.reject = policy == RejectOtherMethods
+	}
+}
+
+// WithDirectTransport lets you configure the transport of the requests
+// bypassing the pool. Defaults to http.DefaultTransport.
+func WithDirectTransport(t http.RoundTripper) func(*Client) {
+	return func(c *Client) {
+		c.bypass.direct = t
+	}
+}
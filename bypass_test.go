@@ -0,0 +1,165 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBypass(t *testing.T) {
+	direct := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("X-Source", "direct")
+		return res, nil
+	})
+	pool := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("X-Source", "pool")
+		return res, nil
+	})
+
+	client := NewClient(
+		WithPool("http://a.com:3000"),
+		WithClientTransport(pool),
+		WithDirectTransport(direct),
+		WithBypass("*.svc.cluster.local", "/api/*", "http://intranet/*"),
+		WithBypassPrivate(),
+	)
+
+	testCases := []struct {
+		url  string
+		want string
+	}{
+		{"http://some.url/res.js", "pool"},
+		{"http://users.svc.cluster.local/me", "direct"},
+		{"http://USERS.SVC.cluster.local:8080/me", "direct"},
+		{"http://some.url/api/users?id=1", "direct"},
+		{"http://intranet/index.html", "direct"},
+		{"https://intranet/index.html", "pool"},
+		{"http://localhost:8080/", "direct"},
+		{"http://app.localhost/", "direct"},
+		{"http://127.0.0.1/", "direct"},
+		{"http://10.1.2.3/", "direct"},
+		{"http://172.16.0.1/", "direct"},
+		{"http://192.168.1.1/", "direct"},
+		{"http://[::1]:8080/", "direct"},
+		{"http://[fd00::1]/", "direct"},
+		{"http://169.254.169.254/", "direct"},
+		{"http://8.8.8.8/", "pool"},
+		{"http://172.32.0.1/", "pool"},
+	}
+	for _, tC := range testCases {
+		req, _ := http.NewRequest("GET", tC.url, nil)
+		res, err := client.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("unexpected error: got %q, want <nil>", err)
+		}
+		if got := res.Header.Get("X-Source"); got != tC.want {
+			t.Errorf("unexpected transport of %q: got %q, want %q", tC.url, got, tC.want)
+		}
+	}
+
+	if got, want := client.ClientStats().Bypassed.Get(), int64(13); got != want {
+		t.Errorf("unexpected bypassed requests: got %d, want %d", got, want)
+	}
+}
+
+func TestMethods(t *testing.T) {
+	direct := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("X-Source", "direct")
+		return res, nil
+	})
+	pool := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("X-Source", "pool")
+		return res, nil
+	})
+
+	testCases := []struct {
+		method  string
+		options []func(*Client)
+		want    string
+	}{
+		{"GET", nil, "pool"},
+		{"HEAD", nil, "pool"},
+		{"POST", nil, "direct"},
+		{"OPTIONS", []func(*Client){WithMethods(DirectOtherMethods, "get", "options")}, "pool"},
+		{"HEAD", []func(*Client){WithMethods(DirectOtherMethods, "GET")}, "direct"},
+		{"POST", []func(*Client){WithMethods(RejectOtherMethods, "GET", "HEAD")}, ""},
+	}
+	for _, tC := range testCases {
+		client := NewClient(append([]func(*Client){
+			WithPool("http://a.com:3000"),
+			WithClientTransport(pool),
+			WithDirectTransport(direct),
+		}, tC.options...)...)
+
+		req, _ := http.NewRequest(tC.method, "http://some.url/res.js", nil)
+		res, err := client.RoundTrip(req)
+		if tC.want == "" {
+			if err != errMethodNotRouted {
+				t.Errorf("unexpected error of %s: got %v, want %v", tC.method, err, errMethodNotRouted)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: got %q, want <nil>", err)
+		}
+		if got := res.Header.Get("X-Source"); got != tC.want {
+			t.Errorf("unexpected transport of %s: got %q, want %q", tC.method, got, tC.want)
+		}
+	}
+}
+
+func TestOriginPool(t *testing.T) {
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("X-Source", req.URL.Host)
+		return res, nil
+	})
+	disks := NewClient(WithPool("http://disk.com:3000"), WithClientTransport(transport))
+	client := NewClient(
+		WithPool("http://memory.com:3000"),
+		WithClientTransport(transport),
+		WithOriginPool("artifacts.example.com", disks),
+		WithOriginPool("/*.iso", disks),
+	)
+
+	testCases := []struct {
+		url  string
+		want string
+	}{
+		{"http://ARTIFACTS.example.com/app.tar.gz", "disk.com:3000"},
+		{"http://cdn.com/debian.iso", "disk.com:3000"},
+		{"http://cdn.com/jquery.js", "memory.com:3000"},
+	}
+	for _, tC := range testCases {
+		req, _ := http.NewRequest("GET", tC.url, nil)
+		res, err := client.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Header.Get("X-Source"); got != tC.want {
+			t.Errorf("unexpected pool of %q: got %q, want %q", tC.url, got, tC.want)
+		}
+	}
+	if got := client.ClientStats().Delegated.Get(); got != 2 {
+		t.Errorf("unexpected delegated requests: got %d, want %d", got, 2)
+	}
+}
@@ -0,0 +1,663 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+// variedPrefix prefixes the headers holding the values of the request
+// headers a stored response varies on, like httpcache does.
+const variedPrefix = "X-Varied-"
+
+// cacheableStatuses are the statuses of the responses stored without
+// explicit freshness (RFC 7231, 6.1). Partial responses are never
+// stored, range requests being served only from the chunks of the
+// responses stored in chunks.
+var cacheableStatuses = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// safeMethods are the methods not invalidating the stored responses
+// (RFC 7231, 4.2.1).
+var safeMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodOptions: true, http.MethodTrace: true,
+}
+
+// cacheTransport is the HTTP cache of a peer, shared by its clients
+// (RFC 7234). The responses are stored in an httpcache.Cache, dumped
+// like httpcache does, once their bodies were streamed to the client.
+// The responses served from the cache are marked with
+// httpcache.XFromCache.
+type cacheTransport struct {
+	cache       httpcache.Cache
+	credentials *credentials     // the responses to the peer's own credentials are not private
+	admission   *AdmissionPolicy // nil policy to admit all the responses
+	offline     *AtomicInt       // non-zero to serve the stored responses however stale
+	vary        *varyPolicy      // nil to allow any Vary header
+	transforms  *[]Transform     // nil to store the responses as is
+	checksums   *[]Checksum      // nil to store the bodies unverified
+	chunks      *chunking        // nil to store the bodies whole
+	maxObject   *int64           // nil or 0 to store the bodies whatever their size
+	stats       *Stats
+	transport   http.RoundTripper
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		explain(req, "bypass: %s requests are not cached", req.Method)
+		res, err := t.transport.RoundTrip(req)
+		if err == nil && !safeMethods[req.Method] && res.StatusCode < http.StatusBadRequest {
+			t.invalidate(req, res)
+		}
+		return res, err
+	}
+	if _, ok := req.Header["Range"]; ok {
+		if res := t.serveRange(req, requestDirectives(req.Header), time.Now()); res != nil {
+			return res, nil
+		}
+		explain(req, "bypass: range requests are not cached")
+		return t.transport.RoundTrip(req)
+	}
+
+	now := time.Now()
+	reqCC := requestDirectives(req.Header)
+	s := t.lookup(req, req.URL.String(), now)
+	if s == nil {
+		explain(req, "miss: no stored response matching the request")
+		if reqCC.has("only-if-cached") {
+			explain(req, "miss: only-if-cached requested")
+			return gatewayTimeout(req), nil
+		}
+		if s := t.lookupDigest(req, reqCC, now); s != nil {
+			explain(req, "hit: stored for another URL with the same digest %s", s.digest)
+			return s.serve(req), nil
+		}
+		if res, err := t.resume(req, reqCC); res != nil || err != nil {
+			return res, err
+		}
+		res, err := t.transport.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		return t.store(req, reqCC, res, false)
+	}
+
+	if t.offline != nil && t.offline.Get() != 0 {
+		explain(req, "hit: offline, age %s, lifetime %s", s.age, s.lifetime)
+		return s.serve(req), nil
+	}
+	if s.reusable(reqCC) {
+		explain(req, "hit: age %s, lifetime %s", s.age, s.lifetime)
+		return s.serve(req), nil
+	}
+	if maxAge, ok := reqCC.seconds("max-age"); ok && s.age > maxAge {
+		explain(req, "stale: age %s over the request max-age %s", s.age, maxAge)
+	} else {
+		explain(req, "stale: age %s, lifetime %s, Cache-Control %q", s.age, s.lifetime, s.res.Header.Get("Cache-Control"))
+	}
+	if reqCC.has("only-if-cached") {
+		explain(req, "miss: only-if-cached requested")
+		s.res.Body.Close()
+		return gatewayTimeout(req), nil
+	}
+	return t.revalidate(req, reqCC, s)
+}
+
+// revalidate validates a stored response with the origin, unless the
+// client sent its own validators.
+func (t *cacheTransport) revalidate(req *http.Request, reqCC directives, s *stored) (*http.Response, error) {
+	out, validated := req, false
+	if req.Header.Get("If-None-Match") == "" && req.Header.Get("If-Modified-Since") == "" {
+		etag, modified := s.res.Header.Get("Etag"), s.res.Header.Get("Last-Modified")
+		if etag != "" || modified != "" {
+			out, validated = req.Clone(req.Context()), true
+			if etag != "" {
+				out.Header.Set("If-None-Match", etag)
+			}
+			if modified != "" {
+				out.Header.Set("If-Modified-Since", modified)
+			}
+		}
+	}
+
+	res, err := t.transport.RoundTrip(out)
+	if (err != nil || res.StatusCode >= http.StatusInternalServerError) && s.staleIfError(reqCC) {
+		if err == nil {
+			res.Body.Close()
+		}
+		explain(req, "hit: stale response served on an origin error (stale-if-error)")
+		return s.serve(req), nil
+	}
+	if err != nil {
+		s.res.Body.Close()
+		return nil, err
+	}
+	if validated && res.StatusCode == http.StatusNotModified {
+		explain(req, "revalidated: origin answered 304 Not Modified")
+		res.Body.Close()
+		return t.refresh(req, s, res), nil
+	}
+	s.res.Body.Close()
+	return t.store(req, reqCC, res, true)
+}
+
+// refresh updates a stored response with the headers of the 304 Not
+// Modified response validating it (RFC 7234, 4.3.4), and serves it.
+func (t *cacheTransport) refresh(req *http.Request, s *stored, notModified *http.Response) *http.Response {
+	h := notModified.Header.Clone()
+	removeHopHeaders(h)
+	delete(h, "Content-Length")
+	for k, v := range h {
+		s.res.Header[k] = v
+	}
+	if _, ok := h["Date"]; !ok {
+		s.res.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+
+	if s.manifest != nil {
+		if req.Method == http.MethodGet {
+			t.setManifest(req.URL.String(), s.res, s.varied, *s.manifest) // the chunks are unchanged
+		}
+		*s = *newStored(s.res, s.varied, time.Now())
+		return s.serve(req)
+	}
+	body, _ := ioutil.ReadAll(s.res.Body) // read from the cache
+	s.res.Body.Close()
+	s.res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if req.Method == http.MethodGet {
+		t.set(req.URL.String(), s.res, s.varied, body)
+	}
+
+	*s = *newStored(s.res, s.varied, time.Now())
+	return s.serve(req)
+}
+
+// store stores a response from the origin if it can be, once its body
+// is read to the end. A response replacing a stored one which can't be
+// stored removes it.
+func (t *cacheTransport) store(req *http.Request, reqCC directives, res *http.Response, replacing bool) (*http.Response, error) {
+	if req.Method != http.MethodGet || res.StatusCode == http.StatusNotModified {
+		return res, nil
+	}
+	if !t.storable(req, reqCC, res) || !t.admit(req, res) {
+		if replacing && res.StatusCode < http.StatusInternalServerError {
+			explain(req, "removed: the stored response was replaced by an unstorable one")
+			t.cache.Delete(req.URL.String())
+		}
+		return res, nil
+	}
+	res, err := t.transform(req, res)
+	if err != nil {
+		return nil, err
+	}
+
+	varied := make(http.Header)
+	for _, name := range headerValues(res.Header, "Vary") {
+		name = http.CanonicalHeaderKey(name)
+		if v := req.Header.Get(name); v != "" {
+			varied.Set(name, v)
+		}
+	}
+
+	cpy := *res
+	cpy.Header = res.Header.Clone()
+	if cpy.Header.Get("Date") == "" {
+		cpy.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	if t.chunks.chunked(&cpy) {
+		t.storeChunked(req, res, &cpy, varied)
+		return res, nil
+	}
+	var max int64
+	if t.maxObject != nil {
+		max = *t.maxObject
+	}
+	if max > 0 && cpy.ContentLength > max {
+		explain(req, "not stored: %d bytes over the maximum object size of %d bytes", cpy.ContentLength, max)
+		if replacing {
+			t.cache.Delete(req.URL.String())
+		}
+		return res, nil
+	}
+	cc := res.Header.Get("Cache-Control")
+	explain(req, "stored: Cache-Control %q, lifetime %s, once the body is read whole", cc, lifetime(res.Header, parseDirectives(res.Header["Cache-Control"])))
+	res.Body = &cachingBody{ReadCloser: res.Body, max: max, store: func(body []byte) {
+		// aborted transfers fail the reads, but a body can still end
+		// early without error, like after a proxy lost the connection
+		if cpy.ContentLength >= 0 && int64(len(body)) != cpy.ContentLength {
+			if t.stats != nil {
+				t.stats.Truncated.Add(1)
+			}
+			return
+		}
+		d, ok := t.expected(req, &cpy)
+		if ok && !d.matches(body) {
+			t.mismatched(req, d)
+			return
+		}
+		if ok {
+			cpy.Header.Set(digestHeader, d.String())
+		}
+		t.set(req.URL.String(), &cpy, varied, body)
+		if ok {
+			t.indexDigest(req.URL.String(), d)
+		}
+	}}
+	return res, nil
+}
+
+// set stores a response with the given body at key.
+func (t *cacheTransport) set(key string, res *http.Response, varied http.Header, body []byte) {
+	cpy := *res
+	cpy.Header = res.Header.Clone()
+	cpy.Body = ioutil.NopCloser(bytes.NewReader(body))
+	for name, v := range varied {
+		cpy.Header[variedPrefix+name] = v
+	}
+	// the fields listed by no-cache and private are not stored
+	cc := parseDirectives(cpy.Header["Cache-Control"])
+	for _, directive := range []string{"no-cache", "private"} {
+		for _, name := range strings.Split(cc[directive], ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				cpy.Header.Del(name)
+			}
+		}
+	}
+
+	if dump, err := httputil.DumpResponse(&cpy, true); err == nil {
+		t.cache.Set(key, dump)
+	}
+}
+
+// storable reports whether a shared cache can store a response
+// (RFC 7234, 3).
+func (t *cacheTransport) storable(req *http.Request, reqCC directives, res *http.Response) bool {
+	cc := parseDirectives(res.Header["Cache-Control"])
+	switch {
+	case reqCC.has("no-store"):
+		explain(req, "not stored: request Cache-Control no-store")
+		return false
+	case cc.has("no-store"):
+		explain(req, "not stored: response Cache-Control no-store")
+		return false
+	case cc.has("private") && cc["private"] == "":
+		explain(req, "not stored: response Cache-Control private")
+		return false
+	}
+	explicit := cc.has("max-age") || cc.has("s-maxage") || cc.has("public") || res.Header.Get("Expires") != ""
+	if res.StatusCode == http.StatusPartialContent || !cacheableStatuses[res.StatusCode] && !explicit {
+		explain(req, "not stored: status %d is not cacheable without explicit freshness", res.StatusCode)
+		return false
+	}
+	for _, name := range headerValues(res.Header, "Vary") {
+		if name == "*" {
+			explain(req, "not stored: response varies on *")
+			return false
+		}
+		if t.vary != nil && !t.vary.allows(name) {
+			explain(req, "not stored: response varies on %s, not an allowed Vary header", name)
+			return false
+		}
+	}
+	if _, ok := req.Header["Authorization"]; ok {
+		if _, own := t.credentials.of(req.URL); !own {
+			if cc.has("public") || cc.has("s-maxage") || cc.has("must-revalidate") {
+				return true
+			}
+			explain(req, "not stored: authorized request without public, s-maxage or must-revalidate")
+			return false
+		}
+	}
+	return true
+}
+
+// admit reports whether the admission policy admits a storable response.
+func (t *cacheTransport) admit(req *http.Request, res *http.Response) bool {
+	if t.admission == nil || *t.admission == nil || (*t.admission).ShouldCache(req, res) {
+		return true
+	}
+	if t.stats != nil {
+		t.stats.NotAdmitted.Add(1)
+	}
+	explain(req, "not stored: rejected by the admission policy")
+	return false
+}
+
+// lookup returns the response stored at key for a request, nil if there
+// is none or if it varies on request headers with other values.
+func (t *cacheTransport) lookup(req *http.Request, key string, now time.Time) *stored {
+	dump, ok := t.cache.Get(key)
+	if !ok {
+		return nil
+	}
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(dump)), nil)
+	if err != nil {
+		return nil
+	}
+
+	varied := make(http.Header)
+	for name, v := range res.Header {
+		if strings.HasPrefix(name, variedPrefix) {
+			varied[strings.TrimPrefix(name, variedPrefix)] = v
+			delete(res.Header, name)
+		}
+	}
+	for _, name := range headerValues(res.Header, "Vary") {
+		name = http.CanonicalHeaderKey(name)
+		if name == "*" || req.Header.Get(name) != varied.Get(name) {
+			explain(req, "miss: the stored response varies on %s", name)
+			res.Body.Close()
+			return nil
+		}
+	}
+	s := newStored(res, varied, now)
+	s.digest = res.Header.Get(digestHeader) // removed once served
+	if m, ok := parseManifest(res.Header); ok {
+		res.Body.Close()
+		delete(res.Header, chunksHeader)
+		res.ContentLength = m.length
+		res.Header.Set("Content-Length", strconv.FormatInt(m.length, 10))
+		res.Body = &chunkedBody{t: t, req: req, header: res.Header.Clone(), m: m, last: m.length - 1}
+		s.manifest = &m
+	}
+	return s
+}
+
+// invalidate removes the responses stored for the URLs a successful
+// request with an unsafe method changed (RFC 7234, 4.4).
+func (t *cacheTransport) invalidate(req *http.Request, res *http.Response) {
+	t.cache.Delete(req.URL.String())
+	for _, name := range []string{"Location", "Content-Location"} {
+		if v := res.Header.Get(name); v != "" {
+			if u, err := req.URL.Parse(v); err == nil && u.Host == req.URL.Host {
+				t.cache.Delete(u.String())
+			}
+		}
+	}
+}
+
+// stored is a response read from the cache.
+type stored struct {
+	res      *http.Response
+	varied   http.Header // the request headers it varies on
+	cc       directives
+	age      time.Duration
+	lifetime time.Duration // 0 without Date
+	manifest *manifest     // nil unless the body is stored in chunks
+	digest   string        // of the body, if it was verified
+}
+
+func newStored(res *http.Response, varied http.Header, now time.Time) *stored {
+	s := &stored{res: res, varied: varied, cc: parseDirectives(res.Header["Cache-Control"])}
+	if age, ok := currentAge(res.Header, now); ok {
+		s.age, s.lifetime = age, lifetime(res.Header, s.cc)
+	}
+	return s
+}
+
+// reusable reports whether the stored response can be served without
+// being validated (RFC 7234, 4.2 and 5.2.1).
+func (s *stored) reusable(reqCC directives) bool {
+	if reqCC.has("no-cache") || (s.cc.has("no-cache") && s.cc["no-cache"] == "") {
+		return false
+	}
+	if maxAge, ok := reqCC.seconds("max-age"); ok && s.age > maxAge {
+		return false
+	}
+	minFresh, _ := reqCC.seconds("min-fresh")
+	if s.age+minFresh < s.lifetime {
+		return true
+	}
+	if !reqCC.has("max-stale") || s.mustRevalidate() {
+		return false
+	}
+	maxStale, ok := reqCC.seconds("max-stale")
+	return !ok || s.age-s.lifetime <= maxStale
+}
+
+// staleIfError reports whether the stored response can be served when
+// the origin can't be reached or fails (RFC 5861, 4).
+func (s *stored) staleIfError(reqCC directives) bool {
+	if s.mustRevalidate() {
+		return false
+	}
+	for _, cc := range []directives{reqCC, s.cc} {
+		if d, ok := cc.seconds("stale-if-error"); ok && s.age-s.lifetime <= d {
+			return true
+		}
+	}
+	return false
+}
+
+// mustRevalidate reports whether the stored response must not be
+// served stale by a shared cache.
+func (s *stored) mustRevalidate() bool {
+	return s.cc.has("must-revalidate") || s.cc.has("proxy-revalidate") || s.cc.has("s-maxage")
+}
+
+// serve returns the stored response for a request, with its age.
+func (s *stored) serve(req *http.Request) *http.Response {
+	res := s.res
+	res.Request = req
+	delete(res.Header, digestHeader)
+	res.Header.Set(httpcache.XFromCache, "1")
+	res.Header.Set("Age", strconv.FormatInt(int64(s.age/time.Second), 10))
+	if req.Method == http.MethodHead {
+		res.Body.Close()
+		res.Body = http.NoBody
+	}
+	return res
+}
+
+// cachingBody copies a body while it is read and hands the copy to
+// store once the body is read to the end. Bodies closed early, or longer
+// than max bytes if max isn't 0, are not stored.
+type cachingBody struct {
+	io.ReadCloser
+	buf   bytes.Buffer
+	max   int64
+	store func(body []byte)
+	done  bool
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.done {
+		b.buf.Write(p[:n])
+		if b.max > 0 && int64(b.buf.Len()) > b.max {
+			b.done = true
+			b.buf = bytes.Buffer{} // released while the body is read
+		}
+	}
+	if err == io.EOF && !b.done {
+		b.done = true
+		b.store(b.buf.Bytes())
+	}
+	return n, err
+}
+
+// currentAge returns the age of a response, approximating the time it
+// was received by its Date (RFC 7234, 4.2.3).
+func currentAge(h http.Header, now time.Time) (time.Duration, bool) {
+	date, err := parseDate(h.Get("Date"))
+	if err != nil {
+		return 0, false
+	}
+	age := now.Sub(date)
+	if age < 0 {
+		age = 0
+	}
+	if secs, err := strconv.ParseInt(strings.TrimSpace(h.Get("Age")), 10, 64); err == nil && secs > 0 {
+		age += time.Duration(secs) * time.Second
+	}
+	return age, true
+}
+
+// lifetime returns the freshness lifetime of a response for a shared
+// cache, 0 without explicit expiration (RFC 7234, 4.2.1).
+func lifetime(h http.Header, cc directives) time.Duration {
+	if d, ok := cc.seconds("s-maxage"); ok {
+		return d
+	}
+	if d, ok := cc.seconds("max-age"); ok {
+		return d
+	}
+	expires, err := parseDate(h.Get("Expires"))
+	if err != nil {
+		return 0
+	}
+	date, err := parseDate(h.Get("Date"))
+	if err != nil {
+		return 0
+	}
+	return expires.Sub(date)
+}
+
+// parseDate parses an HTTP date, also accepting RFC 1123 dates with
+// another zone than GMT.
+func parseDate(s string) (time.Time, error) {
+	t, err := http.ParseTime(s)
+	if err != nil {
+		t, err = time.Parse(time.RFC1123, s)
+	}
+	return t, err
+}
+
+// directives are the Cache-Control directives of a message by lowercase
+// name, with their unquoted values.
+type directives map[string]string
+
+// parseDirectives parses Cache-Control header values. Duplicated
+// directives keep their first value.
+func parseDirectives(values []string) directives {
+	cc := make(directives)
+	for _, v := range values {
+		for _, directive := range splitQuoted(v) {
+			name, value := directive, ""
+			if i := strings.IndexByte(directive, '='); i >= 0 {
+				name, value = directive[:i], strings.TrimSpace(directive[i+1:])
+				if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+					value = strings.Replace(value[1:len(value)-1], `\`, "", -1)
+				}
+			}
+			name = strings.ToLower(strings.TrimSpace(name))
+			if _, ok := cc[name]; !ok && name != "" {
+				cc[name] = value
+			}
+		}
+	}
+	return cc
+}
+
+// requestDirectives returns the Cache-Control directives of a request,
+// a Pragma: no-cache standing for no-cache without Cache-Control.
+func requestDirectives(h http.Header) directives {
+	cc := parseDirectives(h["Cache-Control"])
+	if len(h["Cache-Control"]) == 0 && strings.Contains(strings.ToLower(h.Get("Pragma")), "no-cache") {
+		cc["no-cache"] = ""
+	}
+	return cc
+}
+
+func (cc directives) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns the delta-seconds value of a directive, 0 if it is
+// invalid and at most 2^31 seconds (RFC 7234, 1.2.1).
+func (cc directives) seconds(name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	secs, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); !ok || ne.Err != strconv.ErrRange {
+			return 0, true
+		}
+		secs = 1 << 31
+	}
+	if secs > 1<<31 {
+		secs = 1 << 31
+	}
+	return time.Duration(secs) * time.Second, true
+}
+
+// splitQuoted splits a comma separated list, ignoring the commas of
+// quoted strings.
+func splitQuoted(s string) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				parts = append(parts, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, strings.TrimSpace(s[start:]))
+}
+
+// headerValues returns the elements of the comma separated lists of
+// a header.
+func headerValues(h http.Header, name string) []string {
+	var values []string
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, value := range strings.Split(v, ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
+
+// gatewayTimeout is the response to requests the cache can't satisfy
+// without contacting the origin when it is not allowed to.
+func gatewayTimeout(req *http.Request) *http.Response {
+	return &http.Response{
+		Status:     "504 Gateway Timeout",
+		StatusCode: http.StatusGatewayTimeout,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
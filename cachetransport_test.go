@@ -0,0 +1,402 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestCacheTransport(t *testing.T) {
+	ago := time.Now().Add(-30 * time.Second).UTC().Format(http.TimeFormat)
+
+	testCases := []struct {
+		desc   string
+		header http.Header // of the origin responses
+		first  http.Header // of the first request
+		second http.Header // of the second request
+		method string      // of the second request, GET if empty
+		want   bool        // whether the second request is served from the cache
+	}{
+		{"max-age", http.Header{"Cache-Control": {"max-age=60"}}, nil, nil, "", true},
+		{"expires", http.Header{"Expires": {time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)}}, nil, nil, "", true},
+		{"no expiration", http.Header{}, nil, nil, "", false},
+		{"s-maxage over max-age", http.Header{"Cache-Control": {"max-age=60, s-maxage=10"}, "Date": {ago}}, nil, nil, "", false},
+		{"age", http.Header{"Cache-Control": {"max-age=60"}, "Age": {"45"}, "Date": {ago}}, nil, nil, "", false},
+		{"private", http.Header{"Cache-Control": {"private, max-age=60"}}, nil, nil, "", false},
+		{"private fields", http.Header{"Cache-Control": {`private="Set-Cookie", max-age=60`}}, nil, nil, "", true},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache, max-age=60"}}, nil, nil, "", false},
+		{"no-cache fields", http.Header{"Cache-Control": {`no-cache="Set-Cookie, X-User", max-age=60`}}, nil, nil, "", true},
+		{"no-store", http.Header{"Cache-Control": {"no-store, max-age=60"}}, nil, nil, "", false},
+		{"request no-store", http.Header{"Cache-Control": {"max-age=60"}}, http.Header{"Cache-Control": {"no-store"}}, nil, "", false},
+		{"request no-cache", http.Header{"Cache-Control": {"max-age=60"}}, nil, http.Header{"Cache-Control": {"no-cache"}}, "", false},
+		{"pragma", http.Header{"Cache-Control": {"max-age=60"}}, nil, http.Header{"Pragma": {"no-cache"}}, "", false},
+		{"request max-age", http.Header{"Cache-Control": {"max-age=60"}, "Date": {ago}}, nil, http.Header{"Cache-Control": {"max-age=10"}}, "", false},
+		{"min-fresh", http.Header{"Cache-Control": {"max-age=60"}, "Date": {ago}}, nil, http.Header{"Cache-Control": {"min-fresh=45"}}, "", false},
+		{"max-stale", http.Header{"Cache-Control": {"max-age=10"}, "Date": {ago}}, nil, http.Header{"Cache-Control": {"max-stale=60"}}, "", true},
+		{"max-stale exceeded", http.Header{"Cache-Control": {"max-age=10"}, "Date": {ago}}, nil, http.Header{"Cache-Control": {"max-stale=10"}}, "", false},
+		{"must-revalidate", http.Header{"Cache-Control": {"max-age=10, must-revalidate"}, "Date": {ago}}, nil, http.Header{"Cache-Control": {"max-stale"}}, "", false},
+		{"vary", http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept"}}, http.Header{"Accept": {"text/css"}}, http.Header{"Accept": {"text/css"}}, "", true},
+		{"vary mismatch", http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept"}}, http.Header{"Accept": {"text/css"}}, http.Header{"Accept": {"*/*"}}, "", false},
+		{"vary star", http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}, nil, nil, "", false},
+		{"authorization", http.Header{"Cache-Control": {"max-age=60"}}, http.Header{"Authorization": {"Bearer token"}}, nil, "", false},
+		{"authorization public", http.Header{"Cache-Control": {"public, max-age=60"}}, http.Header{"Authorization": {"Bearer token"}}, nil, "", true},
+		{"head", http.Header{"Cache-Control": {"max-age=60"}}, nil, nil, "HEAD", true},
+		{"post", http.Header{"Cache-Control": {"max-age=60"}}, nil, nil, "POST", false},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			fetches := 0
+			origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				fetches++
+				res := okResponse()
+				res.Header = http.Header{"Set-Cookie": {"id=1"}, "X-User": {"1"}}
+				for k, v := range tC.header {
+					res.Header[k] = v
+				}
+				return res, nil
+			})
+			var creds credentials
+			transport := &cacheTransport{cache: httpcache.NewMemoryCache(), credentials: &creds, transport: origin}
+
+			do := func(method string, header http.Header) *http.Response {
+				req, _ := http.NewRequest(method, "http://cdn.com/jquery.js", nil)
+				for k, v := range header {
+					req.Header[k] = v
+				}
+				res, err := transport.RoundTrip(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				ioutil.ReadAll(res.Body)
+				res.Body.Close()
+				return res
+			}
+			do("GET", tC.first)
+			method := tC.method
+			if method == "" {
+				method = "GET"
+			}
+			res := do(method, tC.second)
+
+			if got := res.Header.Get(httpcache.XFromCache) != ""; got != tC.want {
+				t.Errorf("unexpected cache hit: got %v, want %v", got, tC.want)
+			}
+			if want := map[bool]int{true: 1, false: 2}[tC.want]; fetches != want {
+				t.Errorf("unexpected origin fetches: got %d, want %d", fetches, want)
+			}
+			if tC.want && (res.Header.Get("Set-Cookie") != "") == strings.Contains(tC.header.Get("Cache-Control"), "Set-Cookie") {
+				t.Errorf("unexpected Set-Cookie: got %q", res.Header.Get("Set-Cookie"))
+			}
+		})
+	}
+}
+
+func TestCacheTransportRevalidate(t *testing.T) {
+	var fetches, revalidations int
+	status := http.StatusOK
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		fetches++
+		res := okResponse()
+		res.StatusCode = status
+		res.Header = http.Header{
+			"Date":          {time.Now().UTC().Format(http.TimeFormat)},
+			"Cache-Control": {"max-age=0, stale-if-error=60"},
+			"Etag":          {`"v1"`},
+			"X-Version":     {"1"},
+		}
+		if req.Header.Get("If-None-Match") == `"v1"` && status == http.StatusOK {
+			revalidations++
+			res.StatusCode = http.StatusNotModified
+			res.Header.Set("X-Version", "2")
+			res.Body = http.NoBody
+			res.ContentLength = 0
+		}
+		return res, nil
+	})
+	transport := &cacheTransport{cache: httpcache.NewMemoryCache(), credentials: new(credentials), transport: origin}
+
+	testCases := []struct {
+		desc          string
+		status        int // of the origin
+		revalidations int
+		version       string
+		fromCache     bool
+	}{
+		{"miss", http.StatusOK, 0, "1", false},
+		{"not modified", http.StatusOK, 1, "2", true},
+		{"stale if error", http.StatusInternalServerError, 1, "2", true},
+		{"refreshed", http.StatusOK, 2, "2", true},
+	}
+	for i, tC := range testCases {
+		status = tC.status
+		req, _ := http.NewRequest("GET", "http://cdn.com/jquery.js", nil)
+		res, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusOK || string(body) != "OK" {
+			t.Errorf("%s: unexpected response: got %d %q, want %d %q", tC.desc, res.StatusCode, body, http.StatusOK, "OK")
+		}
+		if fetches != i+1 || revalidations != tC.revalidations {
+			t.Errorf("%s: unexpected origin requests: got %d (%d revalidations), want %d (%d revalidations)", tC.desc, fetches, revalidations, i+1, tC.revalidations)
+		}
+		if v := res.Header.Get("X-Version"); v != tC.version {
+			t.Errorf("%s: unexpected X-Version: got %q, want %q", tC.desc, v, tC.version)
+		}
+		if got := res.Header.Get(httpcache.XFromCache) != ""; got != tC.fromCache {
+			t.Errorf("%s: unexpected cache hit: got %v, want %v", tC.desc, got, tC.fromCache)
+		}
+	}
+}
+
+func TestCacheTransportAge(t *testing.T) {
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header = http.Header{
+			"Date":          {time.Now().Add(-10 * time.Second).UTC().Format(http.TimeFormat)},
+			"Cache-Control": {"max-age=60"},
+			"Age":           {"5"},
+		}
+		return res, nil
+	})
+	transport := &cacheTransport{cache: httpcache.NewMemoryCache(), credentials: new(credentials), transport: origin}
+
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "http://cdn.com/jquery.js", nil)
+		res, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		want := []string{"5", "15"}[i]
+		if age := res.Header.Get("Age"); age != want && age != "16" {
+			t.Errorf("request %d: unexpected Age: got %q, want %q", i, age, want)
+		}
+	}
+}
+
+func TestCacheTransportRequestMaxAge(t *testing.T) {
+	fetches := 0
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		fetches++
+		res := okResponse()
+		res.Header = http.Header{
+			"Date":          {time.Now().Add(-30 * time.Second).UTC().Format(http.TimeFormat)},
+			"Cache-Control": {"max-age=60"},
+		}
+		return res, nil
+	})
+	transport := &cacheTransport{cache: httpcache.NewMemoryCache(), credentials: new(credentials), transport: origin}
+
+	testCases := []struct {
+		cacheControl string // of the request
+		explanation  string
+		fetches      int
+	}{
+		{"", "miss:", 1},
+		{"max-age=100", "hit:", 1},
+		{"max-age=10", "over the request max-age 10s", 2},
+		{"", "hit:", 2},
+	}
+	for i, tC := range testCases {
+		e := new(explanation)
+		req, _ := http.NewRequest("GET", "http://cdn.com/jquery.js", nil)
+		req = withExplanation(req, e)
+		if tC.cacheControl != "" {
+			req.Header.Set("Cache-Control", tC.cacheControl)
+		}
+		res, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		if len(e.notes) == 0 || !strings.Contains(e.notes[0], tC.explanation) {
+			t.Errorf("request %d: unexpected explanations: got %q, want first containing %q", i, e.notes, tC.explanation)
+		}
+		if fetches != tC.fetches {
+			t.Errorf("request %d: unexpected origin fetches: got %d, want %d", i, fetches, tC.fetches)
+		}
+	}
+}
+
+func TestCacheTransportStreaming(t *testing.T) {
+	cache := httpcache.NewMemoryCache()
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("Cache-Control", "max-age=60")
+		return res, nil
+	})
+	transport := &cacheTransport{cache: cache, credentials: new(credentials), transport: origin}
+
+	req, _ := http.NewRequest("GET", "http://cdn.com/jquery.js", nil)
+	res, err := transport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Read(make([]byte, 1))
+	res.Body.Close()
+	if _, ok := cache.Get(req.URL.String()); ok {
+		t.Errorf("unexpected response stored before its body was read")
+	}
+
+	res, _ = transport.RoundTrip(req)
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if _, ok := cache.Get(req.URL.String()); !ok {
+		t.Errorf("unexpected response not stored once its body was read")
+	}
+}
+
+func TestCacheTransportTruncated(t *testing.T) {
+	testCases := []struct {
+		name      string
+		body      io.ReadCloser
+		stored    bool
+		truncated int64
+	}{
+		{"whole", ioutil.NopCloser(strings.NewReader("OK")), true, 0},
+		{"ended early", ioutil.NopCloser(strings.NewReader("O")), false, 1},
+		{"aborted", &truncatedBody{"O"}, false, 0},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			cache := httpcache.NewMemoryCache()
+			origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				res := okResponse()
+				res.Body = tC.body
+				return res, nil
+			})
+			stats := newStats()
+			transport := &cacheTransport{cache: cache, credentials: new(credentials), stats: stats, transport: origin}
+
+			req, _ := http.NewRequest("GET", "http://cdn.com/jquery.js", nil)
+			res, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ioutil.ReadAll(res.Body)
+			res.Body.Close()
+
+			if _, ok := cache.Get(req.URL.String()); ok != tC.stored {
+				t.Errorf("unexpected stored response: got %v, want %v", ok, tC.stored)
+			}
+			if got := stats.Truncated.Get(); got != tC.truncated {
+				t.Errorf("unexpected truncated responses: got %d, want %d", got, tC.truncated)
+			}
+		})
+	}
+}
+
+func TestCacheTransportMaxObjectSize(t *testing.T) {
+	testCases := []struct {
+		name          string
+		body          string
+		contentLength int64
+		max           int64
+		stored        bool
+		read          string
+	}{
+		{"under", "OK", 2, 2, true, "OK"},
+		{"unlimited", "OK", 2, 0, true, "OK"},
+		{"known length over", "OK", 2, 1, false, "OK"},
+		{"unknown length over", "OK", -1, 1, false, "OK"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			cache := httpcache.NewMemoryCache()
+			origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				res := okResponse()
+				res.Body = ioutil.NopCloser(strings.NewReader(tC.body))
+				res.ContentLength = tC.contentLength
+				return res, nil
+			})
+			transport := &cacheTransport{cache: cache, credentials: new(credentials), maxObject: &tC.max, transport: origin}
+
+			req, _ := http.NewRequest("GET", "http://cdn.com/jquery.js", nil)
+			res, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+
+			if string(body) != tC.read {
+				t.Errorf("unexpected body: got %q, want %q", body, tC.read)
+			}
+			if _, ok := cache.Get(req.URL.String()); ok != tC.stored {
+				t.Errorf("unexpected stored response: got %v, want %v", ok, tC.stored)
+			}
+		})
+	}
+}
+
+func TestCacheTransportInvalidate(t *testing.T) {
+	cache := httpcache.NewMemoryCache()
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("Cache-Control", "max-age=60")
+		if req.Method == "POST" {
+			res.StatusCode = http.StatusCreated
+			res.Header.Set("Location", "/items/1")
+		}
+		return res, nil
+	})
+	transport := &cacheTransport{cache: cache, credentials: new(credentials), transport: origin}
+
+	for _, u := range []string{"http://cdn.com/items", "http://cdn.com/items/1", "http://other.com/items/1"} {
+		req, _ := http.NewRequest("GET", u, nil)
+		res, _ := transport.RoundTrip(req)
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+	req, _ := http.NewRequest("POST", "http://cdn.com/items", strings.NewReader("item"))
+	res, _ := transport.RoundTrip(req)
+	res.Body.Close()
+
+	for u, want := range map[string]bool{"http://cdn.com/items": false, "http://cdn.com/items/1": false, "http://other.com/items/1": true} {
+		if _, ok := cache.Get(u); ok != want {
+			t.Errorf("unexpected stored response for %s: got %v, want %v", u, ok, want)
+		}
+	}
+}
+
+func TestParseDirectives(t *testing.T) {
+	cc := parseDirectives([]string{`Max-Age=60, no-cache="Set-Cookie, X-User"`, `private, max-age=10, s-maxage=99999999999`})
+
+	for name, want := range map[string]string{"max-age": "60", "no-cache": "Set-Cookie, X-User", "private": "", "s-maxage": "99999999999"} {
+		if got, ok := cc[name]; !ok || got != want {
+			t.Errorf("unexpected %s: got %q, want %q", name, got, want)
+		}
+	}
+	if d, _ := cc.seconds("s-maxage"); d != 1<<31*time.Second {
+		t.Errorf("unexpected s-maxage: got %v, want %v", d, 1<<31*time.Second)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestClientCancel(t *testing.T) {
+	aborted := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(aborted)
+	}))
+	defer server.Close()
+
+	client := NewClient(WithPool(server.URL))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", "http://cdn.com/jquery.js", nil)
+	if _, err := client.RoundTrip(req.WithContext(ctx)); err == nil {
+		t.Fatal("expected an error once the context is canceled")
+	}
+
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Error("the peer request wasn't aborted")
+	}
+}
+
+func TestProxyCancel(t *testing.T) {
+	aborted := make(chan struct{})
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		<-req.Context().Done()
+		close(aborted)
+		return nil, req.Context().Err()
+	})
+	proxy := newProxy("/p", httpcache.NewMemoryCache(), origin, DefaultBufferPool)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	req := httptest.NewRequest("GET", "/p?q="+url.QueryEscape("http://cdn.com/jquery.js"), nil)
+	proxy.ServeHTTP(httptest.NewRecorder(), req.WithContext(ctx))
+
+	select {
+	case <-aborted:
+	case <-time.After(time.Second):
+		t.Error("the origin fetch wasn't aborted")
+	}
+}
+
+func TestCollapsingCancel(t *testing.T) {
+	var mu sync.Mutex
+	var fetches int
+	started, unblock := make(chan struct{}), make(chan struct{})
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		fetches++
+		first := fetches == 1
+		mu.Unlock()
+		if first {
+			close(started)
+			<-req.Context().Done()
+			return nil, req.Context().Err()
+		}
+		<-unblock
+		return okResponse(), nil
+	})
+	proxy := newProxy("/p", httpcache.NewMemoryCache(), origin, DefaultBufferPool)
+	proxy.micro.ttl = 5 * time.Second
+	proxy.micro.patterns = []string{"/api/*"}
+
+	get := func(ctx context.Context) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/p?q="+url.QueryEscape("http://app.com/api/items"), nil)
+		proxy.ServeHTTP(rr, req.WithContext(ctx))
+		return rr
+	}
+
+	// the first request fetches the origin and is canceled
+	leader, cancelLeader := context.WithCancel(context.Background())
+	go get(leader)
+	<-started
+
+	var wg sync.WaitGroup
+	codes := make([]int, 3)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = get(context.Background()).Code
+		}(i)
+	}
+
+	// a waiting request canceled returns promptly
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		get(ctx)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("a canceled waiting request didn't return")
+	}
+
+	// the waiting requests are served once the first one is canceled
+	cancelLeader()
+	time.Sleep(10 * time.Millisecond)
+	close(unblock)
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("unexpected status of waiting request %d: got %d, want %d", i, code, http.StatusOK)
+		}
+	}
+	if fetches != 2 {
+		t.Errorf("unexpected origin fetches: got %d, want %d", fetches, 2)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/mikegleasonjr/forwardcache/consistenthash"
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+var errNoRing = errors.New("forwardcache: the strategy isn't built on a consistent hash")
+
+// capacityCache is implemented by the caches knowing their capacity in
+// bytes, like lru.Cache.
+type capacityCache interface {
+	Capacity() int
+}
+
+// WithCapacity sets the capacity of the peer's cache in bytes, which
+// the peer reports to its clients (see protocol.LoadURL) so that they
+// can weigh it, see Client.Rebalance. Defaults to the capacity of the
+// cache if it has a Capacity() int method, like lru.Cache, or unknown.
+func WithCapacity(bytes int64) func(*Peer) {
+	return func(p *Peer) {
+		p.capacity = bytes
+	}
+}
+
+// Rebalance weighs the peers of the pool by the capacity of their
+// cache, so that the peers with large disks own more of the keyspace:
+// it asks each peer for its capacity (see protocol.LoadURL) and rebuilds
+// the consistent hash with protocol.WeightedReplicas replicas per peer,
+// peers of unknown capacity counting as the mean. The peers added to
+// the pool afterwards have the default number of replicas until the
+// next rebalance. All the clients of a pool must rebalance to agree on
+// the owners, or share the ring (see Ring and SetRing).
+//
+// Rebalance fails if the client's strategy isn't built on a consistent
+// hash. Otherwise it returns the first error encountered asking for the
+// capacities, the pool being rebalanced anyway.
+func (c *Client) Rebalance(ctx context.Context) error {
+	c.mu.RLock()
+	r, ok := c.strategy.(ringStrategy)
+	fetched := c.peers
+	c.mu.RUnlock()
+	if !ok || r.ring() == nil {
+		return errNoRing
+	}
+
+	var first error
+	capacities := make(map[string]int64, len(fetched))
+	for _, peer := range fetched {
+		load, err := c.fetchLoad(ctx, peer)
+		if err != nil && first == nil {
+			first = err
+		}
+		capacities[peer] = load.Capacity
+	}
+
+	c.update.Lock()
+	defer c.update.Unlock()
+
+	c.mu.RLock()
+	pool, strategy := c.peers, c.strategy.(ringStrategy) // SetPool and SetRing keep ring strategies
+	c.mu.RUnlock()
+	known := make(map[string]int64, len(pool))
+	for _, peer := range pool {
+		known[peer] = capacities[peer]
+	}
+	ring := consistenthash.New(c.replicas, c.hashFn)
+	for peer, n := range protocol.WeightedReplicas(c.replicas, known) {
+		ring.AddReplicas(n, peer)
+	}
+	c.swapPool(pool, strategy.withRing(ring, pool), nil, nil)
+	c.stats.RingEpoch.Add(1)
+	return first
+}
+
+// WithCapacityWeights makes the peer weigh the peers of its pool by
+// their capacity on a schedule, see Client.Rebalance. Defaults to
+// unweighted peers.
+func WithCapacityWeights(s Schedule) func(*Peer) {
+	return func(p *Peer) {
+		p.weights = s
+	}
+}
+
+// runRebalance rebalances the pool of the peer on its schedule until it
+// is closed.
+func (p *Peer) runRebalance() {
+	for {
+		now := time.Now()
+		timer := time.NewTimer(p.weights.Next(now).Sub(now))
+		select {
+		case <-timer.C:
+			if err := p.Rebalance(context.Background()); err != nil {
+				log.Printf("forwardcache: rebalancing the pool: %v", err)
+			}
+		case <-p.done:
+			timer.Stop()
+			return
+		}
+	}
+}
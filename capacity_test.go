@@ -0,0 +1,96 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/forwardcache/lru"
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+func TestRebalance(t *testing.T) {
+	capacities := map[string]int64{"a.com:3000": 1 << 30, "b.com:3000": 2 << 30, "c.com:3000": 3 << 30}
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		body := fmt.Sprintf(`{"inFlight":0,"cpus":1,"capacity":%d}`, capacities[req.URL.Host])
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+	})
+
+	testCases := []struct {
+		name     string
+		strategy Strategy
+		err      error
+		want     map[string]int // points per peer
+	}{
+		{"default", nil, nil, map[string]int{"http://a.com:3000": 25, "http://b.com:3000": 50, "http://c.com:3000": 75}},
+		{"rules", Rules(BoundedLoad(protocol.DefaultReplicas, protocol.Hash, 1.25)), nil, map[string]int{"http://a.com:3000": 25, "http://b.com:3000": 50, "http://c.com:3000": 75}},
+		{"static", Static(), errNoRing, nil},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			options := []func(*Client){WithPool(strategyPool...), WithClientTransport(transport)}
+			if tC.strategy != nil {
+				options = append(options, WithStrategy(tC.strategy))
+			}
+			client := NewClient(options...)
+			epoch := client.stats.RingEpoch.Get()
+
+			if err := client.Rebalance(context.Background()); err != tC.err {
+				t.Fatalf("unexpected error: got %v, want %v", err, tC.err)
+			}
+			if tC.err != nil {
+				return
+			}
+			points := make(map[string]int)
+			for _, p := range client.Ring().Points {
+				points[p.Key]++
+			}
+			if fmt.Sprint(points) != fmt.Sprint(tC.want) {
+				t.Errorf("unexpected points per peer: got %v, want %v", points, tC.want)
+			}
+			if got := client.stats.RingEpoch.Get(); got != epoch+1 {
+				t.Errorf("unexpected ring epoch: got %d, want %d", got, epoch+1)
+			}
+		})
+	}
+}
+
+func TestPeerCapacity(t *testing.T) {
+	testCases := []struct {
+		name    string
+		options []func(*Peer)
+		want    int64
+	}{
+		{"unknown", nil, 0},
+		{"cache", []func(*Peer){WithCache(lru.New(httpcache.NewMemoryCache(), 1<<20))}, 1 << 20},
+		{"option", []func(*Peer){WithCache(lru.New(httpcache.NewMemoryCache(), 1<<20)), WithCapacity(1 << 30)}, 1 << 30},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			peer := NewPeer("http://self.com:3000", tC.options...)
+			if got := peer.handler.capacity; got != tC.want {
+				t.Errorf("unexpected capacity: got %d, want %d", got, tC.want)
+			}
+		})
+	}
+}
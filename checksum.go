@@ -0,0 +1,250 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// digestHeader is the header of the stored responses whose body was
+// verified, holding its digest.
+const digestHeader = "X-Forwardcache-Digest"
+
+var errDigest = errors.New("invalid digest, want algorithm:hex")
+
+// digestAlgorithms are the supported digest algorithms.
+var digestAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// Digest is the digest of a body, like the sha256 digests of the OCI
+// blobs.
+type Digest struct {
+	Algorithm string // md5, sha256 or sha512
+	Sum       []byte
+}
+
+// ParseDigest parses a digest written like OCI digests are, like
+// "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae".
+func ParseDigest(s string) (Digest, error) {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return Digest{}, errDigest
+	}
+	sum, err := hex.DecodeString(s[i+1:])
+	if err != nil {
+		return Digest{}, errDigest
+	}
+	d := Digest{Algorithm: s[:i], Sum: sum}
+	if !d.valid() {
+		return Digest{}, errDigest
+	}
+	return d, nil
+}
+
+func (d Digest) String() string {
+	return d.Algorithm + ":" + hex.EncodeToString(d.Sum)
+}
+
+// valid reports whether the digest has a supported algorithm and a sum
+// of its size.
+func (d Digest) valid() bool {
+	h, ok := digestAlgorithms[d.Algorithm]
+	return ok && len(d.Sum) == h().Size()
+}
+
+// matches reports whether a body has the digest.
+func (d Digest) matches(body []byte) bool {
+	h := digestAlgorithms[d.Algorithm]()
+	h.Write(body)
+	return bytes.Equal(h.Sum(nil), d.Sum)
+}
+
+// A Checksum returns the digest the body of a response is expected to
+// have, if it knows it. The response is nil when it is asked the digest
+// of the response to a request ahead of sending it, to look the
+// response up by its digest.
+type Checksum func(req *http.Request, res *http.Response) (Digest, bool)
+
+// OCIDigest is the checksum of the container registries: the
+// Docker-Content-Digest header of their responses, and the digest
+// addressing the blobs and the manifests in their URLs, like
+// /v2/library/alpine/blobs/sha256:2c26b4....
+func OCIDigest() Checksum {
+	return func(req *http.Request, res *http.Response) (Digest, bool) {
+		if res != nil {
+			if d, err := ParseDigest(res.Header.Get("Docker-Content-Digest")); err == nil {
+				return d, true
+			}
+		}
+		dir, ref := path.Split(req.URL.Path)
+		if !strings.HasSuffix(dir, "/blobs/") && !strings.HasSuffix(dir, "/manifests/") {
+			return Digest{}, false
+		}
+		d, err := ParseDigest(ref)
+		return d, err == nil
+	}
+}
+
+// DigestHeaders is the checksum of the responses announcing the digest
+// of their body in a Content-Digest header (RFC 9530), like
+// "sha-256=:LCa0a2j/xo/5m0U8HTBBNBNCLXBkg7+g+YpeiGJm564=:", or in a
+// Digest header (RFC 3230), like
+// "SHA-256=LCa0a2j/xo/5m0U8HTBBNBNCLXBkg7+g+YpeiGJm564=".
+func DigestHeaders() Checksum {
+	algorithms := map[string]string{"md5": "md5", "sha-256": "sha256", "sha-512": "sha512"}
+	return func(req *http.Request, res *http.Response) (Digest, bool) {
+		if res == nil {
+			return Digest{}, false
+		}
+		for _, name := range []string{"Content-Digest", "Digest"} {
+			for _, v := range headerValues(res.Header, name) {
+				i := strings.IndexByte(v, '=')
+				if i < 0 {
+					continue
+				}
+				d := Digest{Algorithm: algorithms[strings.ToLower(strings.TrimSpace(v[:i]))]}
+				sum, err := base64.StdEncoding.DecodeString(strings.Trim(strings.TrimSpace(v[i+1:]), ":"))
+				if d.Sum = sum; err == nil && d.valid() {
+					return d, true
+				}
+			}
+		}
+		return Digest{}, false
+	}
+}
+
+// ETagHash is the checksum of the origins whose ETags are the hex
+// digest of their bodies with the algorithm, like the md5 ETags of the
+// objects of S3 not uploaded in parts.
+func ETagHash(algorithm string) Checksum {
+	return func(req *http.Request, res *http.Response) (Digest, bool) {
+		if res == nil {
+			return Digest{}, false
+		}
+		etag := res.Header.Get("Etag")
+		if strings.HasPrefix(etag, "W/") {
+			return Digest{}, false
+		}
+		d, err := ParseDigest(algorithm + ":" + strings.Trim(etag, `"`))
+		return d, err == nil
+	}
+}
+
+// expected returns the digest the body of a response is expected to
+// have, from the first checksum knowing it.
+func (t *cacheTransport) expected(req *http.Request, res *http.Response) (Digest, bool) {
+	if t.checksums == nil {
+		return Digest{}, false
+	}
+	for _, checksum := range *t.checksums {
+		if d, ok := checksum(req, res); ok && d.valid() {
+			return d, true
+		}
+	}
+	return Digest{}, false
+}
+
+// verifyChunks reports whether a body stored in chunks has a digest.
+func (t *cacheTransport) verifyChunks(key string, d Digest, m manifest) bool {
+	h := digestAlgorithms[d.Algorithm]()
+	for i := int64(0); i < m.count(); i++ {
+		data, ok := t.getChunk(m.key(key, i))
+		if !ok {
+			return false
+		}
+		h.Write(data)
+	}
+	return bytes.Equal(h.Sum(nil), d.Sum)
+}
+
+// mismatched records a body not stored for not having its digest.
+func (t *cacheTransport) mismatched(req *http.Request, d Digest) {
+	explain(req, "not stored: the body doesn't match the digest %s", d)
+	if t.stats != nil {
+		t.stats.Mismatched.Add(1)
+	}
+}
+
+// digestKey returns the key of the URL of the response stored with a
+// verified body of a digest.
+func digestKey(d Digest) string {
+	return "digest:" + d.String()
+}
+
+// indexDigest makes the response stored at key with a verified body of
+// a digest served for the requests of other URLs addressing the same
+// digest.
+func (t *cacheTransport) indexDigest(key string, d Digest) {
+	t.setChunk(digestKey(d), []byte(key))
+}
+
+// lookupDigest returns the response stored with a verified body of the
+// digest the response to a request is expected to have, however stale:
+// the bodies addressed by their digest don't change. The requests
+// capping the age of the responses, or asking for a validated one, are
+// still honored.
+func (t *cacheTransport) lookupDigest(req *http.Request, reqCC directives, now time.Time) *stored {
+	if req.Method != http.MethodGet {
+		return nil
+	}
+	d, ok := t.expected(req, nil)
+	if !ok {
+		return nil
+	}
+	key, ok := t.getChunk(digestKey(d))
+	if !ok || string(key) == req.URL.String() {
+		return nil
+	}
+	s := t.lookup(req, string(key), now)
+	if s == nil {
+		return nil
+	}
+	maxAge, capped := reqCC.seconds("max-age")
+	if s.digest != d.String() || reqCC.has("no-cache") || capped && s.age > maxAge {
+		s.res.Body.Close() // stored again since, or too old for the request
+		return nil
+	}
+	return s
+}
+
+// WithChecksums makes the peer verify the bodies of the responses it
+// stores against the digest the first checksum knowing it expects, not
+// storing those that don't match. The checksums verify the bodies as
+// stored, once transformed. The responses to requests whose checksums
+// know the digest ahead, like the requests of the OCI blobs, are also
+// served from the response of another URL stored with the same verified
+// body, like the same blob of another repository. Defaults to storing
+// the bodies unverified.
+func WithChecksums(checksums ...Checksum) func(*Peer) {
+	return func(p *Peer) {
+		p.checksums = checksums
+	}
+}
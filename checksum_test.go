@@ -0,0 +1,211 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+const (
+	helloSHA256 = "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	helloMD5    = "5d41402abc4b2a76b9719d911017c592"
+)
+
+func TestParseDigest(t *testing.T) {
+	testCases := []struct {
+		s     string
+		valid bool
+	}{
+		{helloSHA256, true},
+		{"md5:" + helloMD5, true},
+		{"sha256:5d41402abc4b2a76b9719d911017c592", false},
+		{"sha1:aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d", false},
+		{"sha256:zz", false},
+		{"sha256", false},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.s, func(t *testing.T) {
+			d, err := ParseDigest(tC.s)
+			if (err == nil) != tC.valid {
+				t.Fatalf("unexpected error: got %v, want valid %v", err, tC.valid)
+			}
+			if err == nil && d.String() != tC.s {
+				t.Errorf("unexpected digest: got %q, want %q", d, tC.s)
+			}
+		})
+	}
+}
+
+func TestChecksums(t *testing.T) {
+	testCases := []struct {
+		name     string
+		checksum Checksum
+		url      string
+		header   http.Header // nil to ask the digest ahead of the response
+		digest   string      // empty if unknown
+	}{
+		{"oci header", OCIDigest(), "http://registry.com/v2/alpine/manifests/latest", http.Header{"Docker-Content-Digest": {helloSHA256}}, helloSHA256},
+		{"oci blob", OCIDigest(), "http://registry.com/v2/library/alpine/blobs/" + helloSHA256, nil, helloSHA256},
+		{"oci manifest", OCIDigest(), "http://registry.com/v2/alpine/manifests/" + helloSHA256, http.Header{}, helloSHA256},
+		{"oci tag", OCIDigest(), "http://registry.com/v2/alpine/manifests/latest", http.Header{}, ""},
+		{"content digest", DigestHeaders(), "http://cdn.com/hello", http.Header{"Content-Digest": {"sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:"}}, helloSHA256},
+		{"digest", DigestHeaders(), "http://cdn.com/hello", http.Header{"Digest": {"unixsum=30637, SHA-256=LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="}}, helloSHA256},
+		{"digest ahead", DigestHeaders(), "http://cdn.com/hello", nil, ""},
+		{"etag", ETagHash("md5"), "http://cdn.com/hello", http.Header{"Etag": {`"` + helloMD5 + `"`}}, "md5:" + helloMD5},
+		{"weak etag", ETagHash("md5"), "http://cdn.com/hello", http.Header{"Etag": {`W/"` + helloMD5 + `"`}}, ""},
+		{"etag not a hash", ETagHash("md5"), "http://cdn.com/hello", http.Header{"Etag": {`"v1"`}}, ""},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tC.url, nil)
+			var res *http.Response
+			if tC.header != nil {
+				res = &http.Response{Header: tC.header}
+			}
+			d, ok := tC.checksum(req, res)
+			if got := d.String(); !ok {
+				got = ""
+			} else if got != tC.digest {
+				t.Errorf("unexpected digest: got %q, want %q", got, tC.digest)
+			}
+			if ok != (tC.digest != "") {
+				t.Errorf("unexpected known digest: got %v, want %v", ok, tC.digest != "")
+			}
+		})
+	}
+}
+
+func TestChecksumVerification(t *testing.T) {
+	sha := sha256.Sum256([]byte("hello"))
+	sum := md5.Sum([]byte("hello"))
+	testCases := []struct {
+		name       string
+		digest     string // Docker-Content-Digest of the response
+		etag       string
+		chunked    bool
+		fetches    int
+		mismatched int64
+	}{
+		{"matching", helloSHA256, "", false, 1, 0},
+		{"mismatching", "sha256:" + strings.Repeat("0", 64), "", false, 2, 2},
+		{"matching chunks", "sha256:" + hex.EncodeToString(sha[:]), "", true, 1, 0},
+		{"mismatching chunks", "sha256:" + strings.Repeat("0", 64), "", true, 2, 2},
+		{"matching etag", "", `"` + hex.EncodeToString(sum[:]) + `"`, false, 1, 0},
+		{"unknown digest", "", `"v1"`, false, 1, 0},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			fetches := 0
+			origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				fetches++
+				res := &http.Response{
+					StatusCode:    http.StatusOK,
+					Header:        http.Header{"Cache-Control": {"max-age=60"}, "Content-Length": {"5"}},
+					ContentLength: 5,
+					Body:          ioutil.NopCloser(strings.NewReader("hello")),
+				}
+				if tC.digest != "" {
+					res.Header.Set("Docker-Content-Digest", tC.digest)
+				}
+				if tC.etag != "" {
+					res.Header.Set("Etag", tC.etag)
+				}
+				return res, nil
+			})
+			opts := []func(*Peer){WithPeerTransport(origin), WithChecksums(OCIDigest(), ETagHash("md5"))}
+			if tC.chunked {
+				opts = append(opts, WithChunkedStorage(4, 2))
+			}
+			peer := NewPeer("http://self.com:3000", opts...)
+
+			for i := 0; i < 2; i++ {
+				rr := httptest.NewRecorder()
+				peer.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape("http://registry.com/v2/alpine/manifests/latest"), nil))
+				if got := rr.Body.String(); got != "hello" {
+					t.Errorf("unexpected body of request %d: got %q, want %q", i, got, "hello")
+				}
+				if _, ok := rr.Header()[digestHeader]; ok {
+					t.Errorf("unexpected %s header served", digestHeader)
+				}
+			}
+			if fetches != tC.fetches {
+				t.Errorf("unexpected origin fetches: got %d, want %d", fetches, tC.fetches)
+			}
+			if got := peer.handler.stats.Mismatched.Get(); got != tC.mismatched {
+				t.Errorf("unexpected mismatched bodies: got %d, want %d", got, tC.mismatched)
+			}
+		})
+	}
+}
+
+func TestDigestLookup(t *testing.T) {
+	ago := time.Now().Add(-30 * time.Second).UTC().Format(http.TimeFormat)
+	fetches := 0
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		fetches++
+		return &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{"Cache-Control": {"max-age=60"}, "Content-Length": {"5"}, "Date": {ago}},
+			ContentLength: 5,
+			Body:          ioutil.NopCloser(strings.NewReader("hello")),
+		}, nil
+	})
+	peer := NewPeer("http://self.com:3000", WithPeerTransport(origin), WithChecksums(OCIDigest()))
+
+	testCases := []struct {
+		url          string
+		cacheControl string // of the request
+		xFromCache   string
+	}{
+		{"http://registry.com/v2/library/alpine/blobs/" + helloSHA256, "", ""},
+		{"http://registry.com/v2/library/alpine/blobs/" + helloSHA256, "", "1"},
+		{"http://registry.com/v2/mirror/alpine/blobs/" + helloSHA256, "", "1"},
+		{"http://registry.com/v2/other/alpine/blobs/" + helloSHA256, "max-age=10", ""},
+		{"http://registry.com/v2/library/alpine/blobs/sha256:" + strings.Repeat("0", 64), "", ""},
+	}
+	for i, tC := range testCases {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape(tC.url), nil)
+		if tC.cacheControl != "" {
+			req.Header.Set("Cache-Control", tC.cacheControl)
+		}
+		peer.Handler().ServeHTTP(rr, req)
+		if got := rr.Header().Get(httpcache.XFromCache); got != tC.xFromCache {
+			t.Errorf("request %d: unexpected %q header: got %q, want %q", i, httpcache.XFromCache, got, tC.xFromCache)
+		}
+		if got, want := rr.Body.String(), "hello"; got != want {
+			t.Errorf("request %d: unexpected body: got %q, want %q", i, got, want)
+		}
+	}
+	if fetches != 3 {
+		t.Errorf("unexpected origin fetches: got %d, want %d", fetches, 3)
+	}
+	if got := peer.handler.stats.Mismatched.Get(); got != 1 {
+		t.Errorf("unexpected mismatched bodies: got %d, want %d", got, 1)
+	}
+}
@@ -0,0 +1,479 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// chunksHeader is the header of the stored responses whose body is
+// stored in chunks, holding their manifest.
+const chunksHeader = "X-Forwardcache-Chunks"
+
+var (
+	errChunkMissing = errors.New("chunk evicted and the response has no validator to fetch it again")
+	errChunkChanged = errors.New("chunk changed at the origin")
+)
+
+// chunking is how a peer stores the bodies of large responses.
+type chunking struct {
+	threshold int64 // size of the smallest body stored in chunks, 0 if disabled
+	size      int64 // of the chunks
+	parallel  int   // chunks fetched at once from origins supporting ranges
+}
+
+// chunked reports whether a response is stored in chunks.
+func (c *chunking) chunked(res *http.Response) bool {
+	return c != nil && c.threshold > 0 && res.ContentLength >= c.threshold
+}
+
+// chunkIDs makes the ids of the manifests unique on the peer.
+var chunkIDs uint64
+
+// manifest describes a body stored in chunks. The chunks of a stored
+// response have keys of their own so that a response replacing it
+// doesn't mix its chunks with the ones of the previous response.
+type manifest struct {
+	id     string
+	size   int64 // of the chunks
+	length int64 // of the body
+}
+
+func newManifest(size, length int64) manifest {
+	id := strconv.FormatInt(time.Now().UnixNano(), 36) + "." + strconv.FormatUint(atomic.AddUint64(&chunkIDs, 1), 36)
+	return manifest{id: id, size: size, length: length}
+}
+
+// parseManifest returns the manifest held by the header of a stored
+// response, if it has one.
+func parseManifest(h http.Header) (manifest, bool) {
+	var m manifest
+	fields := strings.Fields(h.Get(chunksHeader))
+	if len(fields) != 3 {
+		return m, false
+	}
+	m.id = fields[0]
+	size, err1 := strconv.ParseInt(fields[1], 10, 64)
+	length, err2 := strconv.ParseInt(fields[2], 10, 64)
+	if err1 != nil || err2 != nil || size < 1 || length < 0 {
+		return m, false
+	}
+	m.size, m.length = size, length
+	return m, true
+}
+
+func (m manifest) String() string {
+	return fmt.Sprintf("%s %d %d", m.id, m.size, m.length)
+}
+
+// key returns the key of the i-th chunk of the response stored at key.
+// URLs having no fragment, it can't be the key of a response.
+func (m manifest) key(key string, i int64) string {
+	return key + "#chunk=" + m.id + "." + strconv.FormatInt(i, 10)
+}
+
+// count returns the number of chunks of the body.
+func (m manifest) count() int64 {
+	return (m.length + m.size - 1) / m.size
+}
+
+// bounds returns the offsets of the first and last bytes of a chunk.
+func (m manifest) bounds(i int64) (first, last int64) {
+	first, last = i*m.size, (i+1)*m.size-1
+	if last >= m.length {
+		last = m.length - 1
+	}
+	return first, last
+}
+
+// storeChunked stores a response in chunks as its body is read, and its
+// manifest once the body is read whole. Only a chunk is kept in memory.
+// Until then, the manifest of the responses with a validator is stored
+// as a partial one, so that the fetch can be resumed if interrupted.
+func (t *cacheTransport) storeChunked(req *http.Request, res, cpy *http.Response, varied http.Header) {
+	m := newManifest(t.chunks.size, cpy.ContentLength)
+	key := req.URL.String()
+	explain(req, "stored in chunks of %d bytes", m.size)
+	validator := strongValidator(cpy.Header)
+	if validator != "" {
+		t.setManifest(partialKey(key), cpy, varied, m)
+	}
+	if t.chunks.parallel > 1 && m.count() > 1 && validator != "" && cpy.Header.Get("Accept-Ranges") == "bytes" {
+		res.Body = t.fetchParallel(req, res.Body, validator, m, func() {
+			t.completeManifest(req, cpy, varied, m)
+		})
+		return
+	}
+	res.Body = t.chunkingBody(req, res.Body, cpy, varied, m, 0)
+}
+
+// chunkingBody returns a body stored in chunks as it is read, from the
+// first byte of the chunk first, and the manifest of the response once
+// read whole.
+func (t *cacheTransport) chunkingBody(req *http.Request, body io.ReadCloser, res *http.Response, varied http.Header, m manifest, first int64) *chunkingBody {
+	key := req.URL.String()
+	return &chunkingBody{ReadCloser: body, size: m.size, i: first, n: first * m.size,
+		store: func(i int64, data []byte) {
+			t.setChunk(m.key(key, i), data)
+		},
+		done: func(n int64) {
+			if n != m.length {
+				if t.stats != nil {
+					t.stats.Truncated.Add(1)
+				}
+				return
+			}
+			t.completeManifest(req, res, varied, m)
+		},
+	}
+}
+
+// completeManifest stores the manifest of a response whose chunks are
+// all stored, replacing its partial manifest, once they are verified.
+func (t *cacheTransport) completeManifest(req *http.Request, res *http.Response, varied http.Header, m manifest) {
+	key := req.URL.String()
+	t.cache.Delete(partialKey(key))
+	d, ok := t.expected(req, res)
+	if ok && !t.verifyChunks(key, d, m) {
+		t.mismatched(req, d)
+		return
+	}
+	cpy := *res
+	cpy.Header = res.Header.Clone()
+	if ok {
+		cpy.Header.Set(digestHeader, d.String())
+	}
+	t.setManifest(key, &cpy, varied, m)
+	if ok {
+		t.indexDigest(key, d)
+	}
+}
+
+// setManifest stores a response whose body is stored in chunks at key.
+func (t *cacheTransport) setManifest(key string, res *http.Response, varied http.Header, m manifest) {
+	cpy := *res
+	cpy.Header = res.Header.Clone()
+	cpy.Header.Set(chunksHeader, m.String())
+	cpy.Header.Del("Content-Length")
+	cpy.ContentLength = 0
+	t.set(key, &cpy, varied, nil)
+}
+
+// setChunk stores a chunk, as the body of a response so that the caches
+// handling dumped responses, like the purge cache, handle them too.
+func (t *cacheTransport) setChunk(key string, data []byte) {
+	res := &http.Response{
+		StatusCode:    http.StatusOK,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		ContentLength: int64(len(data)),
+		Body:          ioutil.NopCloser(bytes.NewReader(data)),
+	}
+	var buf bytes.Buffer
+	if err := res.Write(&buf); err == nil {
+		t.cache.Set(key, buf.Bytes())
+	}
+}
+
+// getChunk returns a stored chunk.
+func (t *cacheTransport) getChunk(key string) ([]byte, bool) {
+	dump, ok := t.cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(dump)), nil)
+	if err != nil {
+		return nil, false
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	return data, err == nil
+}
+
+// chunk returns the i-th chunk of the body of a stored response, fetched
+// from the origin with a range request, validated by the stored
+// response's validator, if it was evicted.
+func (t *cacheTransport) chunk(req *http.Request, stored http.Header, m manifest, i int64) ([]byte, error) {
+	key := m.key(req.URL.String(), i)
+	if data, ok := t.getChunk(key); ok {
+		return data, nil
+	}
+
+	validator := strongValidator(stored)
+	if validator == "" {
+		return nil, errChunkMissing
+	}
+	explain(req, "chunk %d evicted, fetched from the origin", i)
+	data, err := t.fetchChunk(req, validator, m, i)
+	if err == errChunkChanged {
+		t.cache.Delete(req.URL.String()) // the stored response is outdated
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.setChunk(key, data)
+	return data, nil
+}
+
+// fetchChunk fetches the i-th chunk of a body from the origin with a
+// range request, failing if the response doesn't have the validator
+// anymore or if the origin doesn't support range requests.
+func (t *cacheTransport) fetchChunk(req *http.Request, validator string, m manifest, i int64) ([]byte, error) {
+	first, last := m.bounds(i)
+	out := req.Clone(req.Context())
+	out.Method = http.MethodGet
+	for _, h := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since"} {
+		delete(out.Header, h)
+	}
+	out.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", first, last))
+	out.Header.Set("If-Range", validator)
+
+	res, err := t.transport.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusPartialContent || res.Header.Get("Content-Range") != fmt.Sprintf("bytes %d-%d/%d", first, last, m.length) {
+		return nil, errChunkChanged
+	}
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != last-first+1 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, nil
+}
+
+// strongValidator returns the validator of a response usable with
+// If-Range, empty if it has none: weak ETags can't be.
+func strongValidator(h http.Header) string {
+	if etag := h.Get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return h.Get("Last-Modified")
+}
+
+// chunkedBody reads the bytes from first to last of a body stored in
+// chunks, fetching the chunks as they are read.
+type chunkedBody struct {
+	t           *cacheTransport
+	req         *http.Request
+	header      http.Header // of the stored response
+	m           manifest
+	first, last int64
+	buf         []byte // the rest of the current chunk
+}
+
+func (b *chunkedBody) Read(p []byte) (int, error) {
+	if len(b.buf) == 0 {
+		if b.first > b.last {
+			return 0, io.EOF
+		}
+		if err := b.fill(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	b.first += int64(n)
+	return n, nil
+}
+
+// fill reads the chunk holding the next byte.
+func (b *chunkedBody) fill() error {
+	i := b.first / b.m.size
+	data, err := b.t.chunk(b.req, b.header, b.m, i)
+	if err != nil {
+		return err
+	}
+	start, _ := b.m.bounds(i)
+	from, to := b.first-start, b.last-start+1
+	if to > int64(len(data)) {
+		to = int64(len(data))
+	}
+	if from >= to {
+		return io.ErrUnexpectedEOF
+	}
+	b.buf = data[from:to]
+	return nil
+}
+
+func (b *chunkedBody) Close() error {
+	return nil
+}
+
+// chunkingBody hands a body to store by chunks of size as it is read,
+// and its length to done once it is read to the end. Bodies closed
+// early are not stored whole.
+type chunkingBody struct {
+	io.ReadCloser
+	size  int64
+	buf   []byte
+	i     int64 // index of the chunk in buf
+	n     int64 // bytes read
+	store func(i int64, data []byte)
+	done  func(n int64)
+	ended bool
+}
+
+func (b *chunkingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	for data := p[:n]; len(data) > 0; {
+		room := int(b.size) - len(b.buf)
+		if room > len(data) {
+			room = len(data)
+		}
+		b.buf = append(b.buf, data[:room]...)
+		data = data[room:]
+		if int64(len(b.buf)) == b.size {
+			b.store(b.i, b.buf)
+			b.buf, b.i = nil, b.i+1
+		}
+	}
+	if err == io.EOF && !b.ended {
+		b.ended = true
+		if len(b.buf) > 0 {
+			b.store(b.i, b.buf)
+			b.buf = nil
+		}
+		b.done(b.n)
+	}
+	return n, err
+}
+
+// serveRange serves a request for a single range of a fresh response
+// stored in chunks from the chunks holding the range, or returns nil
+// if it can't, like when the first of them can't be read.
+func (t *cacheTransport) serveRange(req *http.Request, reqCC directives, now time.Time) *http.Response {
+	if t.chunks == nil || t.chunks.threshold <= 0 || req.Method != http.MethodGet {
+		return nil
+	}
+	s := t.lookup(req, req.URL.String(), now)
+	if s == nil {
+		return nil
+	}
+	m := s.manifest
+	first, last, ok := parseRange(req.Header.Get("Range"), s.res.ContentLength)
+	if m == nil || !ok || !s.reusable(reqCC) || !matchesIfRange(req.Header.Get("If-Range"), s.res.Header) {
+		s.res.Body.Close()
+		return nil
+	}
+
+	body := &chunkedBody{t: t, req: req, header: s.res.Header.Clone(), m: *m, first: first, last: last}
+	s.res.Body.Close()
+	if err := body.fill(); err != nil {
+		explain(req, "miss: %v", err)
+		return nil
+	}
+
+	explain(req, "hit: bytes %d-%d from the chunks, age %s, lifetime %s", first, last, s.age, s.lifetime)
+	res := s.serve(req)
+	res.StatusCode = http.StatusPartialContent
+	res.Status = "206 Partial Content"
+	res.ContentLength = last - first + 1
+	res.Header.Set("Content-Length", strconv.FormatInt(res.ContentLength, 10))
+	res.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, m.length))
+	res.Body = body
+	return res
+}
+
+// parseRange parses a Range header of a single satisfiable range of
+// bytes of a body of length bytes, like "bytes=0-499", "bytes=500-" or
+// "bytes=-500".
+func parseRange(h string, length int64) (first, last int64, ok bool) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(h, prefix) || strings.Contains(h, ",") {
+		return 0, 0, false
+	}
+	spec := strings.TrimSpace(h[len(prefix):])
+	i := strings.IndexByte(spec, '-')
+	if i < 0 {
+		return 0, 0, false
+	}
+	from, to := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if from == "" {
+		n, err := strconv.ParseInt(to, 10, 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false
+		}
+		if n > length {
+			n = length
+		}
+		return length - n, length - 1, length > 0
+	}
+	first, err := strconv.ParseInt(from, 10, 64)
+	if err != nil || first < 0 || first >= length {
+		return 0, 0, false
+	}
+	last = length - 1
+	if to != "" {
+		if last, err = strconv.ParseInt(to, 10, 64); err != nil || last < first {
+			return 0, 0, false
+		}
+		if last >= length {
+			last = length - 1
+		}
+	}
+	return first, last, true
+}
+
+// matchesIfRange reports whether the If-Range header of a request, if
+// any, matches the stored response with header h.
+func matchesIfRange(ifRange string, h http.Header) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		etag := h.Get("Etag")
+		return etag == ifRange && !strings.HasPrefix(etag, "W/")
+	}
+	return ifRange == h.Get("Last-Modified")
+}
+
+// WithChunkedStorage makes the peer store the bodies of the responses of
+// threshold bytes or more in chunks of size bytes, each stored under a
+// key of its own, so that only a chunk is kept in memory while they are
+// stored. Range requests for those responses are served from the
+// chunks holding the range (other range requests are sent to origins),
+// and the chunks evicted by the cache, like the cold chunks of huge
+// files, are fetched again from the origin with range requests when
+// read. That requires the responses to have a strong ETag or a
+// Last-Modified date, the responses whose chunks can't be fetched again
+// failing. The origin fetches of those responses interrupted before
+// their bodies are stored whole are resumed from the chunks stored by
+// the next request. Defaults to storing the bodies whole.
+func WithChunkedStorage(threshold, size int64) func(*Peer) {
+	return func(p *Peer) {
+		if size < 1 {
+			size = 1 << 20
+		}
+		p.chunking.threshold, p.chunking.size = threshold, size
+	}
+}
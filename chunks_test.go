@@ -0,0 +1,212 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mapCache is an httpcache.Cache listing its keys.
+type mapCache struct {
+	mu    sync.Mutex
+	items map[string][]byte
+}
+
+func (c *mapCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	v, ok := c.items[key]
+	return v, ok
+}
+
+func (c *mapCache) Set(key string, v []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items[key] = v
+}
+
+func (c *mapCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.items, key)
+}
+
+func (c *mapCache) chunks() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var keys []string
+	for k := range c.items {
+		if strings.Contains(k, "#chunk=") {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// rangeOrigin serves body, with its single byte ranges.
+func rangeOrigin(body, etag string, fetches *[]string) roundTripperFunc {
+	return func(req *http.Request) (*http.Response, error) {
+		*fetches = append(*fetches, req.Header.Get("Range"))
+		res := &http.Response{
+			StatusCode:    http.StatusOK,
+			Header:        http.Header{"Cache-Control": {"max-age=60"}, "Etag": {etag}},
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}
+		first, last, ok := parseRange(req.Header.Get("Range"), int64(len(body)))
+		if ifRange := req.Header.Get("If-Range"); ok && (ifRange == "" || ifRange == etag) {
+			res.StatusCode = http.StatusPartialContent
+			res.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, len(body)))
+			body = body[first : last+1]
+			res.ContentLength = int64(len(body))
+		}
+		res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		res.Body = ioutil.NopCloser(strings.NewReader(body))
+		return res, nil
+	}
+}
+
+func TestChunkedStorage(t *testing.T) {
+	const body = "0123456789"
+
+	testCases := []struct {
+		name    string
+		rng     string // of the second request
+		evict   bool   // the second chunk before the second request
+		status  int
+		body    string
+		fetches []string
+	}{
+		{"whole", "", false, http.StatusOK, body, []string{""}},
+		{"range", "bytes=3-6", false, http.StatusPartialContent, "3456", []string{""}},
+		{"suffix range", "bytes=-3", false, http.StatusPartialContent, "789", []string{""}},
+		{"multiple ranges", "bytes=0-1,3-4", false, http.StatusOK, body, []string{"", "bytes=0-1,3-4"}},
+		{"evicted chunk", "", true, http.StatusOK, body, []string{"", "bytes=4-7"}},
+		{"range of an evicted chunk", "bytes=5-", true, http.StatusPartialContent, "56789", []string{"", "bytes=4-7"}},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			var fetches []string
+			cache := &mapCache{items: make(map[string][]byte)}
+			peer := NewPeer("http://self.com:3000", WithCache(cache), WithPeerTransport(rangeOrigin(body, `"v1"`, &fetches)), WithChunkedStorage(5, 4))
+			target := "/proxy?q=" + url.QueryEscape("http://cdn.com/video.mp4")
+
+			rr := httptest.NewRecorder()
+			peer.Handler().ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+			if got := rr.Body.String(); got != body {
+				t.Fatalf("unexpected body: got %q, want %q", got, body)
+			}
+			chunks := cache.chunks()
+			if len(chunks) != 3 {
+				t.Fatalf("unexpected chunks: got %d, want 3", len(chunks))
+			}
+			if tC.evict {
+				for _, k := range chunks {
+					if strings.HasSuffix(k, ".1") {
+						cache.Delete(k)
+					}
+				}
+			}
+
+			rr = httptest.NewRecorder()
+			req := httptest.NewRequest("GET", target, nil)
+			if tC.rng != "" {
+				req.Header.Set("Range", tC.rng)
+			}
+			peer.Handler().ServeHTTP(rr, req)
+
+			if rr.Code != tC.status {
+				t.Errorf("unexpected status: got %d, want %d", rr.Code, tC.status)
+			}
+			if got := rr.Body.String(); got != tC.body {
+				t.Errorf("unexpected body: got %q, want %q", got, tC.body)
+			}
+			if got, want := rr.Header().Get("Content-Length"), strconv.Itoa(len(tC.body)); got != want {
+				t.Errorf("unexpected Content-Length: got %q, want %q", got, want)
+			}
+			if got, want := strings.Join(fetches, ";"), strings.Join(tC.fetches, ";"); got != want {
+				t.Errorf("unexpected origin ranges: got %q, want %q", got, want)
+			}
+			if len(cache.chunks()) != 3 {
+				t.Errorf("unexpected chunks: got %d, want 3", len(cache.chunks()))
+			}
+		})
+	}
+}
+
+func TestChunkedStorageChanged(t *testing.T) {
+	var fetches []string
+	cache := &mapCache{items: make(map[string][]byte)}
+	origin := rangeOrigin("0123456789", `"v1"`, &fetches)
+	peer := NewPeer("http://self.com:3000", WithCache(cache), WithPeerTransport(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return origin(req)
+	})), WithChunkedStorage(5, 4))
+	target := "/proxy?q=" + url.QueryEscape("http://cdn.com/video.mp4")
+
+	rr := httptest.NewRecorder()
+	peer.Handler().ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+	for _, k := range cache.chunks() {
+		cache.Delete(k)
+	}
+	origin = rangeOrigin("abcdefghij", `"v2"`, &fetches)
+
+	rr = httptest.NewRecorder()
+	req := httptest.NewRequest("GET", target, nil)
+	req.Header.Set("Range", "bytes=0-1")
+	peer.Handler().ServeHTTP(rr, req)
+	if got, want := rr.Body.String(), "ab"; got != want {
+		t.Errorf("unexpected body: got %q, want %q", got, want)
+	}
+	if _, ok := cache.Get("http://cdn.com/video.mp4"); ok {
+		t.Errorf("unexpected outdated response still stored")
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	testCases := []struct {
+		header      string
+		first, last int64
+		ok          bool
+	}{
+		{"bytes=0-499", 0, 499, true},
+		{"bytes=500-", 500, 999, true},
+		{"bytes=-200", 800, 999, true},
+		{"bytes=-2000", 0, 999, true},
+		{"bytes=900-2000", 900, 999, true},
+		{"bytes=1000-", 0, 0, false},
+		{"bytes=5-4", 0, 0, false},
+		{"bytes=0-1,5-6", 0, 0, false},
+		{"items=0-1", 0, 0, false},
+		{"bytes=-0", 0, 0, false},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.header, func(t *testing.T) {
+			first, last, ok := parseRange(tC.header, 1000)
+			if first != tC.first || last != tC.last || ok != tC.ok {
+				t.Errorf("unexpected range: got %d-%d %v, want %d-%d %v", first, last, ok, tC.first, tC.last, tC.ok)
+			}
+		})
+	}
+}
@@ -1,17 +1,14 @@
 package forwardcache
 
 import (
-	"hash/crc32"
+	"errors"
 	"net/http"
 	"net/url"
 	"sync"
+	"time"
 
 	"github.com/mikegleasonjr/forwardcache/consistenthash"
-)
-
-const (
-	defaultPath     = "/proxy"
-	defaultReplicas = 50
+	"github.com/mikegleasonjr/forwardcache/protocol"
 )
 
 // Client represents a nonparticipating client in the pool. It delegates
@@ -20,38 +17,170 @@ type Client struct {
 	path      string
 	replicas  int
 	hashFn    consistenthash.Hash
+	keyFn     protocol.KeyFunc
 	transport http.RoundTripper
 	peers     []string
-	mu        sync.RWMutex // guards peers
-	hashMap   *consistenthash.Map
+	bases     map[string]*url.URL // the peers' handler URLs, without query
+	mu        sync.RWMutex        // guards peers, bases and strategy
+	update    sync.Mutex          // serializes the updates of the pool
+	strategy  Strategy
+	loads     *peerLoads // of the BoundedLoad strategy, nil for others
+	limits    limits
+	failover  int
+	budget    *retryBudget
+	stats     *ClientStats
+	apiKey    string
+	secret    []byte // signs the requests, see WithClusterSecret and WithClientSecret
+	bypass    bypass
+	hooks     ClientHooks
+	secondary *Client // see WithSecondaryPool
+	pools     []originPool
 }
 
 // NewClient creates a Client.
 func NewClient(options ...func(*Client)) *Client {
 	c := &Client{
-		path:      defaultPath,
-		replicas:  defaultReplicas,
-		hashFn:    crc32.ChecksumIEEE,
+		path:      protocol.DefaultPath,
+		replicas:  protocol.DefaultReplicas,
+		hashFn:    protocol.Hash,
+		keyFn:     protocol.Key,
 		transport: http.DefaultTransport,
+		stats:     newClientStats(),
 	}
 
 	for _, option := range options {
 		option(c)
 	}
 
+	if c.budget == nil {
+		c.budget = newRetryBudget(defaultRetryRatio, defaultMinRetries, defaultRetryWindow)
+	}
+	if c.strategy == nil {
+		c.strategy = ConsistentHash(c.replicas, c.hashFn)
+	}
+	if s, ok := c.strategy.(clientStrategy); ok {
+		s.bind(c)
+	}
+
 	c.SetPool(c.peers...)
 	return c
 }
 
 // SetPool updates the client's peers list. Each peer should
 // be a valid base URL, for example "http://example.net:8000".
+// Only the peers added or removed change the ring and the state kept
+// per peer, the requests being routed with the previous pool until the
+// new one is ready.
 func (c *Client) SetPool(peers ...string) {
+	c.update.Lock()
+	defer c.update.Unlock()
+
+	c.mu.RLock()
+	old, strategy := c.peers, c.strategy
+	if c.bases == nil {
+		old = nil // the first pool
+	}
+	c.mu.RUnlock()
+
+	added, removed := diffPeers(old, peers)
+	c.swapPool(peers, strategy.WithPool(peers, added, removed), added, removed)
+}
+
+// swapPool replaces the pool, the client being updated.
+func (c *Client) swapPool(peers []string, strategy Strategy, added, removed []string) {
+	changed := len(added) > 0 || len(removed) > 0 || c.bases == nil
+	bases := c.parseBases(added)
+	for _, peer := range peers {
+		if base, ok := c.bases[peer]; ok {
+			bases[peer] = base
+		}
+	}
+
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.peers, c.bases, c.strategy = peers, bases, strategy
+	if changed {
+		c.stats.RingEpoch.Add(1)
+	}
+	c.mu.Unlock()
+
+	c.limits.forget(removed)
+	c.stats.forget(removed)
+}
 
-	c.peers = peers
-	c.hashMap = consistenthash.New(c.replicas, c.hashFn)
-	c.hashMap.Add(c.peers...)
+// diffPeers returns the peers added to and removed from a pool.
+func diffPeers(old, peers []string) (added, removed []string) {
+	for _, peer := range peers {
+		if !contains(old, peer) {
+			added = append(added, peer)
+		}
+	}
+	for _, peer := range old {
+		if !contains(peers, peer) {
+			removed = append(removed, peer)
+		}
+	}
+	return added, removed
+}
+
+// parseBases parses the peers' handler URLs once instead of per request.
+func (c *Client) parseBases(peers []string) map[string]*url.URL {
+	bases := make(map[string]*url.URL, len(peers))
+	for _, peer := range peers {
+		if u, err := url.Parse(peer); err == nil {
+			u.Path = c.path
+			bases[peer] = u
+		}
+	}
+	return bases
+}
+
+// SetRing updates the client's peers list using the exact ring layout
+// of a snapshot, as exported by Ring(). The snapshot must have been
+// computed with the same hash function as the client's. Clients whose
+// strategy isn't built on a consistent hash switch to ConsistentHash.
+func (c *Client) SetRing(s consistenthash.Snapshot) {
+	c.update.Lock()
+	defer c.update.Unlock()
+
+	seen := make(map[string]bool)
+	var peers []string
+	for _, p := range s.Points {
+		if !seen[p.Key] {
+			seen[p.Key] = true
+			peers = append(peers, p.Key)
+		}
+	}
+
+	c.mu.RLock()
+	old, strategy := c.peers, c.strategy
+	c.mu.RUnlock()
+	added, removed := diffPeers(old, peers)
+	ring := consistenthash.Restore(s, c.hashFn)
+	if r, ok := strategy.(ringStrategy); ok {
+		strategy = r.withRing(ring, peers)
+	} else {
+		strategy = &consistentHash{ring}
+	}
+	c.swapPool(peers, strategy, added, removed)
+}
+
+// Ring exports the exact layout of the client's consistent hash, so
+// that clients written in other languages or debugging tools can
+// compute the same placement. It is empty if the client's strategy
+// isn't built on a consistent hash.
+func (c *Client) Ring() consistenthash.Snapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if r, ok := c.strategy.(ringStrategy); ok && r.ring() != nil {
+		return r.ring().Snapshot()
+	}
+	return consistenthash.Snapshot{}
+}
+
+// ClientStats returns the statistics of the requests made by the client.
+func (c *Client) ClientStats() *ClientStats {
+	return c.stats
 }
 
 // HTTPClient returns an http.Client that uses the Client as its transport.
@@ -64,34 +193,209 @@ func (c *Client) HTTPClient() *http.Client {
 
 // RoundTrip makes the request go through one of the peer. Since Client
 // implements the Roundtripper interface, it can be used as a transport.
+//
+// Canceling the context of the request aborts it promptly, whether it
+// waits for a rate limit, for the peer or for its response, and the
+// peer aborts the origin fetch it made for the request, if any.
 func (c *Client) RoundTrip(req *http.Request) (*http.Response, error) {
-	peer := c.choosePeer(req.URL.String())
-	return c.roundTripTo(peer, req)
+	return c.route(req, "", nil)
+}
+
+// route makes the request go through the owner of the resource, failing
+// over to the next peers of the ring, then to the secondary pool, if
+// needed. Requests for self are made using local, the ones matching the
+// bypass rules directly, and the ones matching an origin pool through
+// that pool.
+func (c *Client) route(req *http.Request, self string, local func(*http.Request) (*http.Response, error)) (res *http.Response, err error) {
+	var peer string // the last peer tried
+	if done := c.hooks.OnRoundTripDone; done != nil {
+		start := time.Now()
+		defer func() {
+			status := CacheNone
+			if peer != "" {
+				status = cacheStatus(res)
+			}
+			done(RoundTripDone{Request: req, Peer: peer, Duration: time.Since(start), Cache: status, Err: err})
+		}()
+	}
+
+	if c.bypass.matches(req) {
+		return c.bypass.roundTrip(req, c.stats)
+	}
+	if pool := c.originPool(req); pool != nil {
+		c.stats.Delegated.Add(1)
+		return pool.RoundTrip(req)
+	}
+
+	c.budget.request()
+	origin, key := req.URL.String(), c.keyFn(req.URL)
+	var buf [1]string // avoids allocating without failover
+	peers, epoch := c.choosePeers(buf[:0], key, 1+c.failover)
+
+attempts:
+	for i := 0; i < len(peers); i++ {
+		if i > 0 {
+			if !retryable(req) || req.Context().Err() != nil {
+				break
+			}
+			if !c.budget.allow() {
+				c.stats.RetriesDenied.Add(1)
+				break
+			}
+			c.stats.Retries.Add(1)
+		}
+
+		for {
+			if c.stats.RingEpoch.Get() != epoch {
+				// the pool changed since the peers were chosen
+				c.stats.Rerouted.Add(1)
+				peers, epoch = c.rechoosePeers(key, peers[:i])
+				if i >= len(peers) {
+					break attempts
+				}
+			}
+			peer = peers[i]
+			c.hooks.selectPeer(req, peer)
+			if peer == self && local != nil {
+				res, err = local(req)
+			} else {
+				res, err = c.roundTripTo(peer, origin, req, epoch)
+			}
+			if err != errRingChanged {
+				break
+			}
+		}
+		if err == nil {
+			return res, nil
+		}
+	}
+
+	if c.secondary != nil && retryable(req) && req.Context().Err() == nil {
+		c.stats.Secondary.Add(1)
+		return c.secondary.RoundTrip(req)
+	}
+	return nil, err
+}
+
+// errRingChanged is returned by roundTripTo when the pool changed while
+// a request was waiting to be sent.
+var errRingChanged = errors.New("ring changed")
+
+// Owner returns the base URL of the peer owning the resource at url.
+func (c *Client) Owner(resource string) string {
+	if u, err := url.Parse(resource); err == nil {
+		resource = c.keyFn(u)
+	}
+	return c.choosePeer(resource)
 }
 
 func (c *Client) choosePeer(url string) string {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	return c.hashMap.Get(url)
+	if peers := c.strategy.Peers(nil, url, 1); len(peers) > 0 {
+		return peers[0]
+	}
+	return ""
+}
+
+// choosePeers appends the n first peers of url to dst, or "" if the
+// pool is empty, and returns them with the epoch of the ring.
+func (c *Client) choosePeers(dst []string, url string, n int) ([]string, int64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	dst = c.strategy.Peers(dst, url, n)
+	if len(dst) == 0 {
+		dst = append(dst, "")
+	}
+	return dst, c.stats.RingEpoch.Get()
 }
 
-func (c *Client) roundTripTo(peer string, req *http.Request) (*http.Response, error) {
-	query := c.peerHandlerURL(peer, req.URL.String())
+// rechoosePeers chooses the peers of url again after a ring change.
+// The peers already tried are kept first and not chosen again.
+func (c *Client) rechoosePeers(url string, tried []string) ([]string, int64) {
+	chosen, epoch := c.choosePeers(nil, url, 1+c.failover+len(tried))
+	peers := append([]string(nil), tried...)
+	for _, peer := range chosen {
+		if len(peers) > c.failover {
+			break
+		}
+		if !contains(tried, peer) {
+			peers = append(peers, peer)
+		}
+	}
+	return peers, epoch
+}
+
+func contains(peers []string, peer string) bool {
+	for _, p := range peers {
+		if p == peer {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Client) roundTripTo(peer, origin string, req *http.Request, epoch int64) (*http.Response, error) {
+	if err := c.limits.wait(req.Context(), peer, c.stats); err != nil {
+		c.stats.Requests.Add(1)
+		return nil, err
+	}
+	if c.stats.RingEpoch.Get() != epoch {
+		return nil, errRingChanged
+	}
+	c.stats.Requests.Add(1)
+	counters := c.stats.Peer(peer)
+	counters.Requests.Add(1)
+
+	query := c.peerHandlerURL(peer, origin)
 
 	cpy := clone(req) // per RoundTripper contract
 	cpy.URL = query
 	cpy.Host = query.Host
+	if p := PriorityFromContext(req.Context()); p != PriorityInteractive {
+		cpy.Header.Set(protocol.PriorityHeader, p.String())
+	}
+	if c.apiKey != "" {
+		cpy.Header.Set(protocol.APIKeyHeader, c.apiKey)
+	}
+	if c.secret != nil {
+		protocol.SignRequest(cpy, c.secret, origin, time.Now())
+	}
 
-	return c.transport.RoundTrip(cpy)
+	start := time.Now()
+	c.loads.start(peer)
+	res, err := c.transport.RoundTrip(cpy)
+	c.loads.done(peer)
+	c.stats.PeerLatency.Observe(time.Since(start).Seconds())
+	if err != nil || res.StatusCode >= http.StatusInternalServerError {
+		counters.Errors.Add(1)
+	}
+	return res, err
+}
+
+var queryBuffers = sync.Pool{
+	New: func() interface{} { return new([]byte) },
 }
 
 func (c *Client) peerHandlerURL(peer string, origin string) *url.URL {
-	u, _ := url.Parse(peer)
+	c.mu.RLock()
+	base, ok := c.bases[peer]
+	c.mu.RUnlock()
+	if !ok {
+		u, _ := protocol.PeerURL(peer, c.path, origin)
+		return u
+	}
 
-	u.Path = c.path
-	u.RawQuery = "q=" + url.QueryEscape(origin)
+	buf := queryBuffers.Get().(*[]byte)
+	*buf = protocol.AppendQuery((*buf)[:0], origin)
 
+	u := new(url.URL)
+	*u = *base
+	u.RawQuery = string(*buf)
+
+	queryBuffers.Put(buf)
 	return u
 }
 
@@ -103,15 +407,16 @@ func WithPath(p string) func(*Client) {
 	}
 }
 
-// WithReplicas specifies the number of key replicas on the consistent hash.
-// Defaults to 50.
+// WithReplicas specifies the number of key replicas on the consistent hash
+// of the default strategy. Defaults to 50.
 func WithReplicas(r int) func(*Client) {
 	return func(c *Client) {
 		c.replicas = r
 	}
 }
 
-// WithHashFn specifies the hash function of the consistent hash.
+// WithHashFn specifies the hash function of the consistent hash of the
+// default strategy, also used to restore rings (see SetRing).
 // Defaults to crc32.ChecksumIEEE.
 func WithHashFn(h consistenthash.Hash) func(*Client) {
 	return func(c *Client) {
@@ -119,6 +424,16 @@ func WithHashFn(h consistenthash.Hash) func(*Client) {
 	}
 }
 
+// WithKeyFn specifies the key hashed to find the owner of a resource,
+// for example protocol.NormalizedKey so that equivalent URLs are cached
+// by the same peer. All the clients and peers of a pool must use the
+// same key function. Defaults to protocol.Key, the URL of the resource.
+func WithKeyFn(fn protocol.KeyFunc) func(*Client) {
+	return func(c *Client) {
+		c.keyFn = fn
+	}
+}
+
 // WithClientTransport lets you configure a custom transport
 // used between the local client and the proxies.
 // Defaults to http.DefaultTransport.
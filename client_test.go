@@ -7,6 +7,8 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+
+	"github.com/mikegleasonjr/forwardcache/protocol"
 )
 
 func TestClient(t *testing.T) {
@@ -56,6 +58,50 @@ func TestClient(t *testing.T) {
 	}
 }
 
+func TestClientKeyFn(t *testing.T) {
+	hash := newHashMock().
+		with("http://a.com:3000", 0).
+		with("http://b.com:3000", 1).
+		with("some.url/res-a.js", 0).
+		with("some.url/res-b.js", 1)
+
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("X-Requested-URL", req.URL.String())
+		return res, nil
+	})
+
+	client := NewClient(
+		WithPool("http://a.com:3000", "http://b.com:3000"),
+		WithHashFn(hash.fn),
+		WithKeyFn(protocol.NormalizedKey),
+		WithClientTransport(transport),
+	)
+
+	testCases := []struct {
+		url  string
+		want string
+	}{
+		{"http://SOME.url:80/res-a.js", "http://a.com:3000/proxy?q=" + url.QueryEscape("http://SOME.url:80/res-a.js")},
+		{"https://some.url/./res-b.js", "http://b.com:3000/proxy?q=" + url.QueryEscape("https://some.url/./res-b.js")},
+	}
+	for _, tC := range testCases {
+		req, _ := http.NewRequest("GET", tC.url, nil)
+		res, err := client.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("unexpected error: got %q, want <nil>", err)
+		}
+		res.Body.Close()
+
+		if got := res.Header.Get("X-Requested-URL"); got != tC.want {
+			t.Errorf("unexpected request to peer: got %q, want %q", got, tC.want)
+		}
+		if got, want := client.Owner(tC.url), tC.want[:len("http://a.com:3000")]; got != want {
+			t.Errorf("unexpected owner of %q: got %q, want %q", tC.url, got, want)
+		}
+	}
+}
+
 func ExampleNewClient() {
 	client := NewClient(WithPool("http://10.0.1.1:3000", "http://10.0.1.2:3000"))
 
@@ -101,3 +147,62 @@ func BenchmarkClient(b *testing.B) {
 		res.Body.Close()
 	}
 }
+
+func TestClientRing(t *testing.T) {
+	a := NewClient(WithPool("http://a.com:3000", "http://b.com:3000", "http://c.com:3000"))
+	b := NewClient()
+	b.SetRing(a.Ring())
+
+	if got, want := len(b.peers), 3; got != want {
+		t.Fatalf("unexpected number of peers: got %d, want %d", got, want)
+	}
+
+	for _, u := range []string{"http://some.url/res-a.js", "http://some.url/res-b.js", "http://some.url/res-c.js"} {
+		if got, want := b.choosePeer(u), a.choosePeer(u); got != want {
+			t.Errorf("unexpected peer for %q: got %q, want %q", u, got, want)
+		}
+	}
+}
+
+func TestClientSetPool(t *testing.T) {
+	client := NewClient(
+		WithPool("http://a.com:3000", "http://b.com:3000", "http://c.com:3000"),
+		WithPeerRateLimit(1, 1),
+	)
+	urls := []string{"http://some.url/res-a.js", "http://some.url/res-b.js", "http://some.url/res-c.js", "http://some.url/res-d.js"}
+	before := make(map[string]string)
+	for _, u := range urls {
+		before[u] = client.choosePeer(u)
+	}
+	a, b := client.limits.peer("http://a.com:3000"), client.limits.peer("http://b.com:3000")
+	epoch := client.stats.RingEpoch.Get()
+
+	client.SetPool("http://a.com:3000", "http://b.com:3000", "http://c.com:3000")
+	if got, want := client.stats.RingEpoch.Get(), epoch; got != want {
+		t.Errorf("unexpected ring epoch for the same pool: got %d, want %d", got, want)
+	}
+
+	client.SetPool("http://a.com:3000", "http://b.com:3000", "http://d.com:3000")
+	if got, want := client.stats.RingEpoch.Get(), epoch+1; got != want {
+		t.Errorf("unexpected ring epoch: got %d, want %d", got, want)
+	}
+	for _, u := range urls {
+		got := client.choosePeer(u)
+		if before[u] == "http://c.com:3000" || got == "http://d.com:3000" {
+			continue
+		}
+		if want := before[u]; got != want {
+			t.Errorf("unexpected peer for %q: got %q, want %q", u, got, want)
+		}
+	}
+
+	if client.limits.peer("http://a.com:3000") != a || client.limits.peer("http://b.com:3000") != b {
+		t.Error("unexpected rate limits of the unchanged peers: they were reset")
+	}
+	client.limits.mu.Lock()
+	_, ok := client.limits.peers["http://c.com:3000"]
+	client.limits.mu.Unlock()
+	if ok {
+		t.Error("unexpected rate limit of the removed peer: it was kept")
+	}
+}
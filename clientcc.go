@@ -0,0 +1,85 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// CacheControlRule overrides the Cache-Control of the responses served
+// to the clients for the URLs matching a pattern, see
+// WithClientCacheControl.
+type CacheControlRule struct {
+	Pattern      string // of the URLs, like the patterns of Peer.Purge
+	CacheControl string // sent to the clients, empty to send none
+}
+
+// cacheControlRules are the rules overriding the Cache-Control sent to
+// the clients, the first rule matching a URL applying.
+type cacheControlRules []CacheControlRule
+
+// apply overrides the Cache-Control of the header of a response to the
+// URL u served to a client, with the Expires matching it for the
+// HTTP/1.0 caches.
+func (r cacheControlRules) apply(u *url.URL, status int, h http.Header) {
+	if len(r) == 0 || status >= http.StatusInternalServerError {
+		return
+	}
+	key := u.String()
+	for _, rule := range r {
+		if !matchKey(rule.Pattern, key) {
+			continue
+		}
+		h.Del("Expires")
+		if rule.CacheControl == "" {
+			h.Del("Cache-Control")
+			return
+		}
+		h.Set("Cache-Control", rule.CacheControl)
+		cc := parseDirectives(h["Cache-Control"])
+		if maxAge, ok := cc.seconds("max-age"); ok {
+			date, err := parseDate(h.Get("Date"))
+			if err != nil {
+				date = time.Now()
+			}
+			h.Set("Expires", date.Add(maxAge).UTC().Format(http.TimeFormat))
+		} else if cc.has("no-store") || cc.has("no-cache") {
+			h.Set("Expires", "0") // already expired
+		}
+		return
+	}
+}
+
+// WithClientCacheControl overrides the Cache-Control sent to the clients
+// with the one of the first rule matching the URLs, whatever the
+// Cache-Control of the origins and how long the responses are stored by
+// the peer, for example to keep the browsers from storing the responses
+// the peer caches, or to let them store the responses longer. The
+// Expires header is replaced accordingly. The server errors are sent
+// as is. Defaults to sending the Cache-Control of the origins.
+//
+//	forwardcache.WithClientCacheControl(
+//		forwardcache.CacheControlRule{Pattern: "/api/*", CacheControl: "no-store"},
+//		forwardcache.CacheControlRule{Pattern: "/static/*", CacheControl: "public, max-age=86400"},
+//	)
+func WithClientCacheControl(rules ...CacheControlRule) func(*Peer) {
+	return func(p *Peer) {
+		p.clientCC = append(p.clientCC, rules...)
+	}
+}
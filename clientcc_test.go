@@ -0,0 +1,80 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestClientCacheControl(t *testing.T) {
+	now := time.Now().UTC()
+	date, tomorrow := now.Format(http.TimeFormat), now.Add(24*time.Hour).Format(http.TimeFormat)
+	testCases := []struct {
+		path         string
+		status       int
+		cacheControl string
+		expires      string
+	}{
+		{"/api/users", http.StatusOK, "no-store", "0"},
+		{"/static/app.js", http.StatusOK, "public, max-age=86400", tomorrow},
+		{"/static/app.js", http.StatusNotFound, "public, max-age=86400", tomorrow},
+		{"/static/app.js", http.StatusBadGateway, "max-age=60", date},
+		{"/plain/index.html", http.StatusOK, "", ""},
+		{"/index.html", http.StatusOK, "max-age=60", date},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.path, func(t *testing.T) {
+			fetches := 0
+			origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				fetches++
+				return &http.Response{
+					StatusCode:    tC.status,
+					Header:        http.Header{"Cache-Control": {"max-age=60"}, "Expires": {date}, "Date": {date}},
+					ContentLength: 2,
+					Body:          ioutil.NopCloser(strings.NewReader("OK")),
+				}, nil
+			})
+			peer := NewPeer("http://self.com:3000", WithPeerTransport(origin), WithClientCacheControl(
+				CacheControlRule{Pattern: "/api/*", CacheControl: "no-store"},
+				CacheControlRule{Pattern: "/static/*", CacheControl: "public, max-age=86400"},
+				CacheControlRule{Pattern: "http://cdn.com/plain/*"},
+			))
+
+			for i := 0; i < 2; i++ {
+				rr := httptest.NewRecorder()
+				peer.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape("http://cdn.com"+tC.path), nil))
+				if got := rr.Header().Get("Cache-Control"); got != tC.cacheControl {
+					t.Errorf("unexpected Cache-Control of request %d: got %q, want %q", i, got, tC.cacheControl)
+				}
+				if got := rr.Header().Get("Expires"); got != tC.expires {
+					t.Errorf("unexpected Expires of request %d: got %q, want %q", i, got, tC.expires)
+				}
+				if tC.status == http.StatusOK && i == 1 && rr.Header().Get(httpcache.XFromCache) == "" {
+					t.Errorf("unexpected response not served from the cache")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command fcsim replays an access log against cache eviction policies
+// to help choose a policy and a capacity offline.
+//
+// The access log has one request per line, made of whitespace separated
+// fields: a timestamp (ignored, requests must be in order), the requested
+// URL and the size of the response in bytes. Blank lines and lines
+// starting with # are ignored.
+//
+//	1476905407 http://cdn.com/jquery.js 86709
+//	1476905408 http://cdn.com/bootstrap.js 36868
+//
+// Usage:
+//
+//	fcsim -policies lru,tinylfu -capacities 64M,256M,1G access.log
+//
+// The tinylfu policy is the one of the tinylfu package, whose caches
+// never store the responses larger than their capacity divided by their
+// number of shards.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/mikegleasonjr/forwardcache/tinylfu"
+)
+
+func main() {
+	policies := flag.String("policies", "lru,tinylfu", "comma separated list of policies to simulate (lru, tinylfu)")
+	capacities := flag.String("capacities", "64M,256M,1G", "comma separated list of capacities to simulate (K, M and G suffixes)")
+	shards := flag.Int("shards", tinylfu.DefaultShards, "number of shards of the tinylfu caches")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags] [access.log]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	var in io.Reader = os.Stdin
+	if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	trace, err := readTrace(in)
+	if err != nil {
+		fatal(err)
+	}
+
+	var sims []*simulation
+	for _, c := range strings.Split(*capacities, ",") {
+		capacity, err := parseSize(c)
+		if err != nil {
+			fatal(err)
+		}
+		for _, p := range strings.Split(*policies, ",") {
+			sim, err := newSimulation(p, capacity, *shards)
+			if err != nil {
+				fatal(err)
+			}
+			sims = append(sims, sim)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "policy\tcapacity\trequests\thits\thit ratio\tbyte hit ratio\t")
+	for _, sim := range sims {
+		r := sim.run(trace)
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%.2f%%\t%.2f%%\t\n", r.Policy, formatSize(r.Capacity), r.Requests, r.Hits, 100*r.HitRatio(), 100*r.ByteHitRatio())
+	}
+	w.Flush()
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "fcsim:", err)
+	os.Exit(1)
+}
@@ -0,0 +1,219 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/mikegleasonjr/forwardcache/lru"
+	"github.com/mikegleasonjr/forwardcache/tinylfu"
+)
+
+// request is a line of the access log.
+type request struct {
+	url  string
+	size int
+}
+
+func readTrace(r io.Reader) ([]request, error) {
+	var trace []request
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected 3 fields, got %d", line, len(fields))
+		}
+
+		size, err := strconv.Atoi(fields[2])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("line %d: invalid size %q", line, fields[2])
+		}
+
+		trace = append(trace, request{fields[1], size})
+	}
+	return trace, s.Err()
+}
+
+// policy is a cache eviction policy.
+type policy interface {
+	// access requests a key, adding it to the cache on a miss
+	// if the policy admits it. It reports whether it was a hit.
+	access(key string, size int) bool
+}
+
+type simulation struct {
+	name     string
+	capacity int
+	policy   policy
+}
+
+// newSimulation creates the simulation of a policy, the tinylfu one
+// having the given number of shards.
+func newSimulation(name string, capacity, shards int) (*simulation, error) {
+	var p policy
+	switch name {
+	case "lru":
+		p = newLRU(capacity)
+	case "tinylfu":
+		p = newTinyLFU(capacity, shards)
+	default:
+		return nil, fmt.Errorf("unknown policy %q", name)
+	}
+	return &simulation{name, capacity, p}, nil
+}
+
+// result is the outcome of a simulation.
+type result struct {
+	Policy    string
+	Capacity  int
+	Requests  int
+	Hits      int
+	Bytes     int64
+	BytesHits int64
+}
+
+func (r result) HitRatio() float64 {
+	if r.Requests == 0 {
+		return 0
+	}
+	return float64(r.Hits) / float64(r.Requests)
+}
+
+func (r result) ByteHitRatio() float64 {
+	if r.Bytes == 0 {
+		return 0
+	}
+	return float64(r.BytesHits) / float64(r.Bytes)
+}
+
+func (s *simulation) run(trace []request) result {
+	r := result{Policy: s.name, Capacity: s.capacity}
+	for _, req := range trace {
+		r.Requests++
+		r.Bytes += int64(req.size)
+		if s.policy.access(req.url, req.size) {
+			r.Hits++
+			r.BytesHits += int64(req.size)
+		}
+	}
+	return r
+}
+
+// lruPolicy simulates the lru package over a storage that only
+// remembers the keys, the sizes of the responses being counted without
+// storing them.
+type lruPolicy struct {
+	cache *lru.Cache
+}
+
+func newLRU(capacity int) *lruPolicy {
+	return &lruPolicy{cache: lru.New(keyStore{}, capacity, lru.WithCost(sizeOf))}
+}
+
+func (p *lruPolicy) access(key string, size int) bool {
+	if _, ok := p.cache.Get(key); ok {
+		return true
+	}
+	p.cache.Set(key, sized(size))
+	return false
+}
+
+// tinyLFUPolicy simulates the tinylfu package over a storage that only
+// remembers the sizes of the responses.
+type tinyLFUPolicy struct {
+	cache *tinylfu.Cache
+}
+
+func newTinyLFU(capacity, shards int) *tinyLFUPolicy {
+	return &tinyLFUPolicy{tinylfu.New(capacity, tinylfu.WithShards(shards), tinylfu.WithCost(sizeOf))}
+}
+
+func (p *tinyLFUPolicy) access(key string, size int) bool {
+	if _, ok := p.cache.Get(key); ok {
+		return true
+	}
+	p.cache.Set(key, sized(size))
+	return false
+}
+
+// sized returns a value standing for a response of size bytes, so that
+// the simulated caches count its size without storing it, see sizeOf.
+func sized(size int) []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	return buf[:binary.PutUvarint(buf, uint64(size))]
+}
+
+// sizeOf returns the size of the response a value stands for.
+func sizeOf(resp []byte) int {
+	n, _ := binary.Uvarint(resp)
+	return int(n)
+}
+
+// keyStore is an httpcache.Cache that only remembers keys.
+type keyStore map[string]struct{}
+
+func (s keyStore) Get(key string) ([]byte, bool) {
+	_, ok := s[key]
+	return nil, ok
+}
+
+func (s keyStore) Set(key string, resp []byte) { s[key] = struct{}{} }
+func (s keyStore) Delete(key string)           { delete(s, key) }
+
+func parseSize(s string) (int, error) {
+	s = strings.ToUpper(strings.TrimSpace(s))
+	mult := 1
+	switch {
+	case strings.HasSuffix(s, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(s, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(s, "G"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid capacity %q", s)
+	}
+	return n * mult, nil
+}
+
+func formatSize(n int) string {
+	switch {
+	case n >= 1<<30 && n%(1<<30) == 0:
+		return strconv.Itoa(n>>30) + "G"
+	case n >= 1<<20 && n%(1<<20) == 0:
+		return strconv.Itoa(n>>20) + "M"
+	case n >= 1<<10 && n%(1<<10) == 0:
+		return strconv.Itoa(n>>10) + "K"
+	}
+	return strconv.Itoa(n)
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestReadTrace(t *testing.T) {
+	trace, err := readTrace(strings.NewReader(`
+# time url size
+1476905407 http://cdn.com/jquery.js 86709
+
+1476905408 http://cdn.com/bootstrap.js 36868
+`))
+	if err != nil {
+		t.Fatalf("unexpected error: got %q, want <nil>", err)
+	}
+
+	want := []request{{"http://cdn.com/jquery.js", 86709}, {"http://cdn.com/bootstrap.js", 36868}}
+	if len(trace) != len(want) || trace[0] != want[0] || trace[1] != want[1] {
+		t.Errorf("unexpected trace: got %v, want %v", trace, want)
+	}
+
+	for _, bad := range []string{"1 http://cdn.com/jquery.js", "1 http://cdn.com/jquery.js big"} {
+		if _, err := readTrace(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error reading %q", bad)
+		}
+	}
+}
+
+func TestSimulation(t *testing.T) {
+	// a hot set of 10 urls requested between scans of one-hit wonders
+	var trace []request
+	for i := 0; i < 100; i++ {
+		for j := 0; j < 10; j++ {
+			trace = append(trace, request{fmt.Sprintf("http://cdn.com/hot-%d.js", j), 10})
+		}
+		for j := 0; j < 20; j++ {
+			trace = append(trace, request{fmt.Sprintf("http://cdn.com/scan-%d-%d.js", i, j), 10})
+		}
+	}
+
+	results := map[string]result{}
+	for _, p := range []string{"lru", "tinylfu"} {
+		sim, err := newSimulation(p, 150, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: got %q, want <nil>", err)
+		}
+		results[p] = sim.run(trace)
+	}
+
+	if got := results["lru"].Hits; got != 0 {
+		t.Errorf("unexpected lru hits: got %d, want %d", got, 0)
+	}
+	if got := results["tinylfu"].HitRatio(); got < 0.3 {
+		t.Errorf("tinylfu should resist scans: got hit ratio %.2f, want >= 0.30", got)
+	}
+
+	if _, err := newSimulation("fifo", 150, 1); err == nil {
+		t.Errorf("expected an error for an unknown policy")
+	}
+}
+
+func TestSimulationLargeObjects(t *testing.T) {
+	// the sizes are counted without allocating the objects
+	trace := []request{{"http://cdn.com/huge.iso", 1 << 40}, {"http://cdn.com/huge.iso", 1 << 40}}
+	for _, p := range []string{"lru", "tinylfu"} {
+		sim, err := newSimulation(p, 1<<41, 1)
+		if err != nil {
+			t.Fatalf("unexpected error: got %q, want <nil>", err)
+		}
+		if got := sim.run(trace).Hits; got != 1 {
+			t.Errorf("unexpected %s hits: got %d, want %d", p, got, 1)
+		}
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	testCases := []struct {
+		in   string
+		want int
+	}{
+		{"100", 100},
+		{"64k", 64 << 10},
+		{"256M", 256 << 20},
+		{"1G", 1 << 30},
+		{"", 0},
+		{"-1M", 0},
+		{"abc", 0},
+	}
+	for _, tC := range testCases {
+		got, _ := parseSize(tC.in)
+		if got != tC.want {
+			t.Errorf("unexpected size for %q: got %d, want %d", tC.in, got, tC.want)
+		}
+		if got > 0 && formatSize(got) != strings.ToUpper(tC.in) {
+			t.Errorf("unexpected format for %d: got %q, want %q", got, formatSize(got), strings.ToUpper(tC.in))
+		}
+	}
+}
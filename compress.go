@@ -0,0 +1,189 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"compress/gzip"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// minCompressedSize is the size of the smallest response compressed for
+// the clients, smaller ones gaining little.
+const minCompressedSize = 1024
+
+// compressibleTypes are the media types of the responses compressed for
+// the clients, besides the text and the +json and +xml ones.
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/javascript": true,
+	"application/xml":        true,
+	"application/wasm":       true,
+	"image/svg+xml":          true,
+}
+
+// compression gzips the responses to the clients accepting it.
+type compression struct {
+	level  int
+	budget chan struct{} // a slot per response being compressed, nil if disabled
+	stats  *Stats
+}
+
+// gzipWriters are the gzip writers of each compression level.
+var gzipWriters sync.Map // level -> *sync.Pool
+
+// compress returns a writer gzipping the body of res to w, changing the
+// headers of res accordingly, if the client accepts it, the response is
+// compressible and within the compression budget, or nil otherwise.
+func (c *compression) compress(w http.ResponseWriter, req *http.Request, res *http.Response) *gzipResponseWriter {
+	if c.budget == nil || !c.compressible(req, res) {
+		return nil
+	}
+	select {
+	case c.budget <- struct{}{}:
+	default:
+		c.stats.OverBudget.Add(1)
+		return nil
+	}
+	c.stats.Compressed.Add(1)
+
+	res.Header.Set("Content-Encoding", "gzip")
+	res.Header.Del("Content-Length")
+	varies := false
+	for _, name := range headerValues(res.Header, "Vary") {
+		varies = varies || strings.EqualFold(name, "Accept-Encoding")
+	}
+	if !varies {
+		res.Header.Add("Vary", "Accept-Encoding")
+	}
+	if etag := res.Header.Get("Etag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		res.Header.Set("Etag", "W/"+etag) // not the same bytes anymore
+	}
+
+	pool, _ := gzipWriters.LoadOrStore(c.level, &sync.Pool{})
+	gz, _ := pool.(*sync.Pool).Get().(*gzip.Writer)
+	if gz == nil {
+		gz, _ = gzip.NewWriterLevel(w, c.level)
+	} else {
+		gz.Reset(w)
+	}
+	return &gzipResponseWriter{ResponseWriter: w, gz: gz, pool: pool.(*sync.Pool), budget: c.budget}
+}
+
+// compressible reports whether a response is worth compressing for the
+// client of a request.
+func (c *compression) compressible(req *http.Request, res *http.Response) bool {
+	if req.Method != http.MethodGet || res.StatusCode != http.StatusOK || !acceptsGzip(req.Header) {
+		return false
+	}
+	if res.Header.Get("Content-Encoding") != "" || res.Header.Get("Content-Range") != "" {
+		return false
+	}
+	if res.ContentLength >= 0 && res.ContentLength < minCompressedSize {
+		return false
+	}
+	if parseDirectives(res.Header["Cache-Control"]).has("no-transform") {
+		return false
+	}
+	media, _, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(media, "text/") || strings.HasSuffix(media, "+json") ||
+		strings.HasSuffix(media, "+xml") || compressibleTypes[media]
+}
+
+// acceptsGzip reports whether the Accept-Encoding header of a request
+// accepts gzip, explicitly or with "*".
+func acceptsGzip(h http.Header) bool {
+	wildcard := false
+	for _, v := range h["Accept-Encoding"] {
+		for _, part := range strings.Split(v, ",") {
+			coding, params := part, ""
+			if i := strings.IndexByte(part, ';'); i >= 0 {
+				coding, params = part[:i], part[i+1:]
+			}
+			q := strings.ReplaceAll(strings.ToLower(params), " ", "")
+			accepted := q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+			switch strings.ToLower(strings.TrimSpace(coding)) {
+			case "gzip", "x-gzip":
+				return accepted
+			case "*":
+				wildcard = accepted
+			}
+		}
+	}
+	return wildcard
+}
+
+// gzipResponseWriter gzips the body written to a response.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz     *gzip.Writer
+	pool   *sync.Pool
+	budget chan struct{}
+}
+
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	return w.gz.Write(p)
+}
+
+// Flush flushes the compressed data written so far to the client.
+func (w *gzipResponseWriter) Flush() {
+	w.gz.Flush()
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close ends the compressed body and releases its compression budget.
+// It can be called several times.
+func (w *gzipResponseWriter) Close() error {
+	if w.gz == nil {
+		return nil
+	}
+	err := w.gz.Close()
+	w.pool.Put(w.gz)
+	w.gz = nil
+	<-w.budget
+	return err
+}
+
+// WithCompression makes the peer gzip the responses to the clients
+// accepting it, at a compression level of compress/gzip (the default
+// one if invalid), when they are not encoded already, like the stored
+// responses fetched uncompressed, so that the bandwidth savings extend
+// to the clients. Only the text,
+// JSON, JavaScript, XML, SVG and WebAssembly responses of 1KB or more
+// are compressed. To bound the CPU spent compressing, at most
+// concurrency responses are compressed at a time, the others being
+// served uncompressed and counted in Stats.OverBudget.
+// Defaults to no compression.
+func WithCompression(level, concurrency int) func(*Peer) {
+	return func(p *Peer) {
+		if concurrency < 1 {
+			concurrency = 1
+		}
+		if _, err := gzip.NewWriterLevel(nil, level); err != nil {
+			level = gzip.DefaultCompression
+		}
+		p.compression.level = level
+		p.compression.budget = make(chan struct{}, concurrency)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCompression(t *testing.T) {
+	text := strings.Repeat("forwardcache ", 200)
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		q := req.URL.Query()
+		body := text
+		if q.Get("small") != "" {
+			body = "small"
+		}
+		res := okResponse()
+		res.Body = ioutil.NopCloser(strings.NewReader(body))
+		res.ContentLength = int64(len(body))
+		res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		res.Header.Set("Content-Type", q.Get("type"))
+		res.Header.Set("Etag", `"v1"`)
+		if e := q.Get("encoding"); e != "" {
+			res.Header.Set("Content-Encoding", e)
+		}
+		if cc := q.Get("cc"); cc != "" {
+			res.Header.Set("Cache-Control", cc)
+		}
+		return res, nil
+	})
+
+	testCases := []struct {
+		name       string
+		query      string
+		accept     string
+		overBudget bool
+		gzipped    bool
+	}{
+		{"text", "type=text/css", "gzip, deflate", false, true},
+		{"json", "type=application/ld%2Bjson", "deflate, gzip;q=0.5", false, true},
+		{"not accepted", "type=text/css", "deflate", false, false},
+		{"refused", "type=text/css", "gzip;q=0", false, false},
+		{"image", "type=image/png", "gzip", false, false},
+		{"small", "type=text/css&small=1", "gzip", false, false},
+		{"encoded", "type=text/css&encoding=br", "gzip", false, false},
+		{"no-transform", "type=text/css&cc=no-transform", "gzip", false, false},
+		{"over budget", "type=text/css", "gzip", true, false},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			peer := NewPeer("http://self.com:3000", WithPeerTransport(origin), WithCompression(gzip.BestSpeed, 1))
+			if tC.overBudget {
+				peer.handler.compression.budget <- struct{}{}
+			}
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape("http://cdn.com/a?"+tC.query), nil)
+			req.Header.Set("Accept-Encoding", tC.accept)
+			peer.Handler().ServeHTTP(rr, req)
+
+			if got := rr.Header().Get("Content-Encoding") == "gzip"; got != tC.gzipped {
+				t.Fatalf("unexpected gzipped response: got %v, want %v", got, tC.gzipped)
+			}
+			if got := peer.Stats().OverBudget.Get() == 1; got != tC.overBudget {
+				t.Errorf("unexpected over budget response: got %v, want %v", got, tC.overBudget)
+			}
+			if !tC.gzipped {
+				return
+			}
+			if got, want := rr.Header().Get("Etag"), `W/"v1"`; got != want {
+				t.Errorf("unexpected ETag: got %q, want %q", got, want)
+			}
+			if got, want := rr.Header().Get("Vary"), "Accept-Encoding"; got != want {
+				t.Errorf("unexpected Vary header: got %q, want %q", got, want)
+			}
+			if got := rr.Header().Get("Content-Length"); got != "" {
+				t.Errorf("unexpected Content-Length: got %q, want none", got)
+			}
+			gz, err := gzip.NewReader(rr.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if body, _ := ioutil.ReadAll(gz); string(body) != text {
+				t.Errorf("unexpected body: got %d bytes, want %d", len(body), len(text))
+			}
+			if got := len(peer.handler.compression.budget); got != 0 {
+				t.Errorf("unexpected compression budget in use: got %d, want 0", got)
+			}
+		})
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	testCases := []struct {
+		accept string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"br, gzip;q=0.8", true},
+		{"x-gzip", true},
+		{"gzip; q=0", false},
+		{"gzip;q=0.000", false},
+		{"identity, *", true},
+		{"*, gzip;q=0", false},
+	}
+	for _, tC := range testCases {
+		h := http.Header{}
+		if tC.accept != "" {
+			h.Set("Accept-Encoding", tC.accept)
+		}
+		if got := acceptsGzip(h); got != tC.want {
+			t.Errorf("unexpected acceptance of gzip by %q: got %v, want %v", tC.accept, got, tC.want)
+		}
+	}
+}
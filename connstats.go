@@ -0,0 +1,155 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+)
+
+// connStatsTransport records how the connections to origins are opened
+// and reused, tracing the fetches. The idle connections are only
+// counted for the connections of an *http.Transport wrapped by
+// trackConns. It sits behind the cache.
+type connStatsTransport struct {
+	stats     *Stats
+	transport http.RoundTripper
+}
+
+func (t *connStatsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	origin := t.stats.Origin(req.URL.Host)
+
+	var mu sync.Mutex // the trace hooks are called from several goroutines
+	var start time.Time
+	var conn *trackedConn
+	trace := &httptrace.ClientTrace{
+		GetConn: func(string) {
+			mu.Lock()
+			start = time.Now()
+			mu.Unlock()
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			if info.Reused {
+				t.stats.ReusedConns.Add(1)
+				origin.ReusedConns.Add(1)
+			} else {
+				latency := time.Since(start)
+				t.stats.Dials.Add(1)
+				origin.Dials.Add(1)
+				t.stats.HandshakeTime.Add(int64(latency))
+				origin.HandshakeTime.Add(int64(latency))
+				t.stats.Handshakes.Observe(latency.Seconds())
+			}
+			if conn = tracked(info.Conn); conn != nil {
+				conn.busy(&t.stats.Counters, origin)
+			}
+		},
+		PutIdleConn: func(err error) {
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil && conn != nil {
+				conn.idle()
+			}
+		},
+	}
+	return t.transport.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+}
+
+// trackConns returns a copy of an *http.Transport whose connections
+// can tell whether they are idle when they are closed.
+func trackConns(t *http.Transport) *http.Transport {
+	dial := t.DialContext
+	if dial == nil && t.Dial != nil {
+		dial = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return t.Dial(network, addr)
+		}
+	} else if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	t = t.Clone()
+	t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &trackedConn{Conn: conn}, nil
+	}
+	return t
+}
+
+// trackedConn is a connection counted in the idle connections of its
+// origin while idle.
+type trackedConn struct {
+	net.Conn
+	mu            sync.Mutex
+	total, origin *Counters // nil until used
+	isIdle        bool
+}
+
+// tracked returns the trackedConn of a connection, if it is one or
+// a TLS connection over one.
+func tracked(conn net.Conn) *trackedConn {
+	if c, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = c.NetConn()
+	}
+	c, _ := conn.(*trackedConn)
+	return c
+}
+
+// busy marks the connection used by a fetch from an origin.
+func (c *trackedConn) busy(total, origin *Counters) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setIdle(false)
+	c.total, c.origin = total, origin
+}
+
+// idle marks the connection idle.
+func (c *trackedConn) idle() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setIdle(true)
+}
+
+func (c *trackedConn) Close() error {
+	c.mu.Lock()
+	c.setIdle(false)
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// setIdle updates the idle connection counts, the connection being
+// locked.
+func (c *trackedConn) setIdle(idle bool) {
+	if c.isIdle == idle || c.origin == nil {
+		return
+	}
+	c.isIdle = idle
+	n := int64(1)
+	if !idle {
+		n = -1
+	}
+	c.total.IdleConns.Add(n)
+	c.origin.IdleConns.Add(n)
+}
@@ -0,0 +1,71 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestConnStats(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte("OK"))
+	}))
+	defer origin.Close()
+	proxy := newProxy("/p", httpcache.NewMemoryCache(), &http.Transport{}, DefaultBufferPool)
+
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/p?q="+url.QueryEscape(origin.URL+"/api"), nil)
+		proxy.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("unexpected status: got %d, want %d", rr.Code, http.StatusOK)
+		}
+	}
+
+	stats := proxy.stats.Origin(origin.Listener.Addr().String())
+	waitIdle := func(want int64) {
+		for deadline := time.Now().Add(time.Second); stats.IdleConns.Get() != want && time.Now().Before(deadline); {
+			time.Sleep(time.Millisecond)
+		}
+		if got := stats.IdleConns.Get(); got != want {
+			t.Errorf("unexpected idle connections: got %d, want %d", got, want)
+		}
+	}
+	waitIdle(1)
+	if got := stats.Dials.Get(); got != 1 {
+		t.Errorf("unexpected dials: got %d, want %d", got, 1)
+	}
+	if got := stats.ReusedConns.Get(); got != 2 {
+		t.Errorf("unexpected reused connections: got %d, want %d", got, 2)
+	}
+	if got, want := stats.ReuseRatio(), 2.0/3; got != want {
+		t.Errorf("unexpected reuse ratio: got %v, want %v", got, want)
+	}
+	if got := proxy.stats.Handshakes.Snapshot().Count; got != 1 {
+		t.Errorf("unexpected handshakes: got %d, want %d", got, 1)
+	}
+
+	origin.CloseClientConnections()
+	waitIdle(0)
+}
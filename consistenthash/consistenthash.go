@@ -16,6 +16,7 @@ package consistenthash
 
 import (
 	"hash/crc32"
+	"math"
 	"sort"
 	"strconv"
 )
@@ -48,8 +49,14 @@ func (m *Map) IsEmpty() bool {
 
 // Adds some keys to the hash.
 func (m *Map) Add(keys ...string) {
+	m.AddReplicas(m.replicas, keys...)
+}
+
+// Adds some keys to the hash with their own number of replicas, for
+// example to weigh them by capacity.
+func (m *Map) AddReplicas(replicas int, keys ...string) {
 	for _, key := range keys {
-		for i := 0; i < m.replicas; i++ {
+		for i := 0; i < replicas; i++ {
 			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
 			m.keys = append(m.keys, hash)
 			m.hashMap[hash] = key
@@ -58,6 +65,36 @@ func (m *Map) Add(keys ...string) {
 	sort.Ints(m.keys)
 }
 
+// Removes some keys from the hash.
+func (m *Map) Remove(keys ...string) {
+	removed := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		removed[key] = true
+	}
+	kept := m.keys[:0]
+	for _, hash := range m.keys {
+		if !removed[m.hashMap[hash]] {
+			kept = append(kept, hash)
+		}
+	}
+	m.keys = kept
+	for hash, key := range m.hashMap {
+		if removed[key] {
+			delete(m.hashMap, hash)
+		}
+	}
+}
+
+// Returns a copy of the map, which can be changed while the map is in use.
+func (m *Map) Clone() *Map {
+	c := New(m.replicas, m.hash)
+	c.keys = append([]int(nil), m.keys...)
+	for hash, key := range m.hashMap {
+		c.hashMap[hash] = key
+	}
+	return c
+}
+
 // Gets the closest item in the hash to the provided key.
 func (m *Map) Get(key string) string {
 	if m.IsEmpty() {
@@ -76,3 +113,121 @@ func (m *Map) Get(key string) string {
 
 	return m.hashMap[m.keys[idx]]
 }
+
+// Gets the n distinct items following the provided key on the ring,
+// the closest first. Fewer items are returned if there are not enough.
+func (m *Map) GetN(key string, n int) []string {
+	if m.IsEmpty() || n <= 0 {
+		return nil
+	}
+
+	hash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(m.keys), func(i int) bool { return m.keys[i] >= hash })
+
+	var items []string
+	seen := make(map[string]bool, n)
+	for i := 0; i < len(m.keys) && len(items) < n; i++ {
+		item := m.hashMap[m.keys[(idx+i)%len(m.keys)]]
+		if !seen[item] {
+			seen[item] = true
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Point is the position of a key's replica on the ring.
+type Point struct {
+	Hash uint32 `json:"hash"`
+	Key  string `json:"key"`
+}
+
+// Snapshot is the exact layout of a ring. It can be exported and
+// imported elsewhere so that other implementations (or debugging tools)
+// compute the same placement without having to agree on how replicas
+// are derived from keys.
+type Snapshot struct {
+	Replicas int     `json:"replicas"`
+	Points   []Point `json:"points"`
+}
+
+// Returns the layout of the ring, sorted by hash.
+func (m *Map) Snapshot() Snapshot {
+	s := Snapshot{
+		Replicas: m.replicas,
+		Points:   make([]Point, len(m.keys)),
+	}
+	for i, hash := range m.keys {
+		s.Points[i] = Point{Hash: uint32(hash), Key: m.hashMap[hash]}
+	}
+	return s
+}
+
+// Restore creates a Map with the exact layout of a snapshot. The hash
+// function is only used to hash the keys passed to Get.
+func Restore(s Snapshot, fn Hash) *Map {
+	m := New(s.Replicas, fn)
+	for _, p := range s.Points {
+		m.keys = append(m.keys, int(p.Hash))
+		m.hashMap[int(p.Hash)] = p.Key
+	}
+	sort.Ints(m.keys)
+	return m
+}
+
+// Distribution is the share of the keyspace owned by each item,
+// between 0 and 1.
+type Distribution map[string]float64
+
+// Returns the share of the keyspace owned by each item of the ring.
+func (m *Map) Distribution() Distribution {
+	d := make(Distribution)
+	for i, hash := range m.keys {
+		var arc float64
+		if i == 0 {
+			arc = float64(hash) + (1 << 32) - float64(m.keys[len(m.keys)-1])
+		} else {
+			arc = float64(hash - m.keys[i-1])
+		}
+		d[m.hashMap[hash]] += arc / (1 << 32)
+	}
+	return d
+}
+
+// Returns the standard deviation of the shares relative to a perfectly
+// balanced ring, 0 meaning that all items own the same share and 0.1
+// that they typically own 10% more or less than their fair share.
+func (d Distribution) Deviation() float64 {
+	if len(d) == 0 {
+		return 0
+	}
+
+	fair := 1 / float64(len(d))
+	var sum float64
+	for _, share := range d {
+		sum += (share/fair - 1) * (share/fair - 1)
+	}
+	return math.Sqrt(sum / float64(len(d)))
+}
+
+// Returns the smallest replica count, up to max, giving a distribution
+// of the items with a deviation of at most target (see Deviation), or
+// the replica count with the smallest deviation if none does. Since
+// all the clients of a ring must use the same replica count, the result
+// should be computed once and configured everywhere rather than tuned
+// again each time the items change.
+func Tune(items []string, fn Hash, target float64, max int) int {
+	best, bestDeviation := 1, math.Inf(1)
+	for r := 1; r <= max; r++ {
+		m := New(r, fn)
+		m.Add(items...)
+		deviation := m.Distribution().Deviation()
+		if deviation <= target {
+			return r
+		}
+		if deviation < bestDeviation {
+			best, bestDeviation = r, deviation
+		}
+	}
+	return best
+}
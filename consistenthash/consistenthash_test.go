@@ -15,6 +15,7 @@ package consistenthash
 
 import (
 	"fmt"
+	"math"
 	"strconv"
 	"testing"
 )
@@ -62,6 +63,83 @@ func TestHashing(t *testing.T) {
 
 }
 
+func TestGetN(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, err := strconv.Atoi(string(key))
+		if err != nil {
+			panic(err)
+		}
+		return uint32(i)
+	})
+
+	// 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("6", "4", "2")
+
+	testCases := []struct {
+		key  string
+		n    int
+		want string
+	}{
+		{"2", 1, "[2]"},
+		{"3", 2, "[4 6]"},
+		{"11", 3, "[2 4 6]"},
+		{"27", 5, "[2 4 6]"},
+		{"27", 0, "[]"},
+	}
+	for _, tC := range testCases {
+		if got := fmt.Sprint(hash.GetN(tC.key, tC.n)); got != tC.want {
+			t.Errorf("Asking for %d items from %s yielded %s, should have yielded %s", tC.n, tC.key, got, tC.want)
+		}
+	}
+}
+
+func TestDistribution(t *testing.T) {
+	hashes := map[string]uint32{"0a": 1 << 30, "0b": 2 << 30, "0c": 3 << 30, "0d": 0}
+	fn := func(key []byte) uint32 { return hashes[string(key)] }
+
+	testCases := []struct {
+		items     []string
+		want      string
+		deviation float64
+	}{
+		{[]string{"a", "b", "c", "d"}, "map[a:0.25 b:0.25 c:0.25 d:0.25]", 0},
+		{[]string{"a", "b", "c"}, "map[a:0.5 b:0.25 c:0.25]", math.Sqrt(0.125)},
+	}
+	for _, tC := range testCases {
+		hash := New(1, fn)
+		hash.Add(tC.items...)
+
+		d := hash.Distribution()
+		if got := fmt.Sprint(d); got != tC.want {
+			t.Errorf("Distribution of %v is %s, should be %s", tC.items, got, tC.want)
+		}
+		if got := d.Deviation(); math.Abs(got-tC.deviation) > 1e-9 {
+			t.Errorf("Deviation of %v is %f, should be %f", tC.items, got, tC.deviation)
+		}
+	}
+}
+
+func TestTune(t *testing.T) {
+	items := []string{"http://10.0.0.1:3000", "http://10.0.0.2:3000", "http://10.0.0.3:3000"}
+	deviation := func(replicas int) float64 {
+		hash := New(replicas, nil)
+		hash.Add(items...)
+		return hash.Distribution().Deviation()
+	}
+
+	r := Tune(items, nil, 0.05, 1000)
+	if got := deviation(r); got > 0.05 {
+		t.Errorf("Tuned %d replicas with a deviation of %f, should be at most 0.05", r, got)
+	}
+	if r > 1 && deviation(r-1) <= 0.05 {
+		t.Errorf("Tuned %d replicas, %d should have been enough", r, r-1)
+	}
+
+	if r := Tune(items, nil, 0, 5); deviation(r) > deviation(1) {
+		t.Errorf("Tuned %d replicas, should have been the best of the first 5", r)
+	}
+}
+
 func TestConsistency(t *testing.T) {
 	hash1 := New(1, nil)
 	hash2 := New(1, nil)
@@ -105,3 +183,78 @@ func benchmarkGet(b *testing.B, shards int) {
 		hash.Get(buckets[i&(shards-1)])
 	}
 }
+
+func TestSnapshot(t *testing.T) {
+	hash := New(50, nil)
+	hash.Add("Bill", "Bob", "Bonny")
+
+	s := hash.Snapshot()
+	if len(s.Points) != 150 {
+		t.Fatalf("unexpected number of points: got %d, want %d", len(s.Points), 150)
+	}
+	for i := 1; i < len(s.Points); i++ {
+		if s.Points[i-1].Hash > s.Points[i].Hash {
+			t.Fatalf("snapshot points are not sorted at index %d", i)
+		}
+	}
+
+	restored := Restore(s, nil)
+	for _, k := range []string{"Ben", "Becky", "Bobby", "http://cdn.com/jquery.js"} {
+		if got, want := restored.Get(k), hash.Get(k); got != want {
+			t.Errorf("Asking for %s on the restored ring yielded %s, should have yielded %s", k, got, want)
+		}
+	}
+}
+
+func TestAddReplicas(t *testing.T) {
+	hash := New(3, nil)
+	hash.Add("a")
+	hash.AddReplicas(6, "b")
+
+	points := make(map[string]int)
+	for _, p := range hash.Snapshot().Points {
+		points[p.Key]++
+	}
+	if points["a"] != 3 || points["b"] != 6 {
+		t.Errorf("unexpected points per key: got %v, want 3 for a and 6 for b", points)
+	}
+
+	hash.Remove("b")
+	if got := len(hash.Snapshot().Points); got != 3 {
+		t.Errorf("unexpected points after removing b: got %d, want 3", got)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, err := strconv.Atoi(string(key))
+		if err != nil {
+			panic(err)
+		}
+		return uint32(i)
+	})
+
+	// 2, 4, 6, 8, 12, 14, 16, 18, 22, 24, 26, 28
+	hash.Add("6", "4", "2", "8")
+	clone := hash.Clone()
+	clone.Remove("8")
+
+	testCases := []struct {
+		key   string
+		hash  string
+		clone string
+	}{
+		{"2", "2", "2"},
+		{"23", "4", "4"},
+		{"27", "8", "2"},
+	}
+
+	for _, tC := range testCases {
+		if got := hash.Get(tC.key); got != tC.hash {
+			t.Errorf("unexpected item for %s: got %s, want %s", tC.key, got, tC.hash)
+		}
+		if got := clone.Get(tC.key); got != tC.clone {
+			t.Errorf("unexpected item of the clone for %s: got %s, want %s", tC.key, got, tC.clone)
+		}
+	}
+}
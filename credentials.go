@@ -0,0 +1,79 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"net/url"
+)
+
+// Credential authenticates a peer to an origin by setting headers on
+// the requests it sends to it.
+type Credential func(h http.Header)
+
+// BasicAuth authenticates with the given username and password.
+func BasicAuth(username, password string) Credential {
+	req := &http.Request{Header: make(http.Header)}
+	req.SetBasicAuth(username, password)
+	return HeaderCredential("Authorization", req.Header.Get("Authorization"))
+}
+
+// BearerToken authenticates with the given token.
+func BearerToken(token string) Credential {
+	return HeaderCredential("Authorization", "Bearer "+token)
+}
+
+// HeaderCredential authenticates by setting a custom header,
+// like "X-Api-Key".
+func HeaderCredential(name, value string) Credential {
+	return func(h http.Header) {
+		h.Set(name, value)
+	}
+}
+
+// credentials are the credentials of origins by host.
+type credentials map[string]Credential
+
+// apply sets the credentials of the request's origin, if any.
+// Hosts with a port take precedence over hosts without one.
+func (c credentials) apply(req *http.Request) {
+	if cred, ok := c.of(req.URL); ok {
+		cred(req.Header)
+	}
+}
+
+// of returns the credentials of an origin.
+func (c credentials) of(u *url.URL) (Credential, bool) {
+	cred, ok := c[u.Host]
+	if !ok {
+		cred, ok = c[u.Hostname()]
+	}
+	return cred, ok
+}
+
+// WithOriginCredentials injects credentials in the requests the peer
+// sends to an origin host (like "registry.example.com" or
+// "registry.example.com:8443"), so clients never hold upstream
+// credentials. They replace any credentials sent by clients.
+func WithOriginCredentials(host string, cred Credential) func(*Peer) {
+	return func(p *Peer) {
+		if p.credentials == nil {
+			p.credentials = make(credentials)
+		}
+		p.credentials[host] = cred
+	}
+}
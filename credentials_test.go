@@ -0,0 +1,68 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestOriginCredentials(t *testing.T) {
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("X-Got-Auth", req.Header.Get("Authorization"))
+		res.Header.Set("X-Got-Key", req.Header.Get("X-Api-Key"))
+		return res, nil
+	})
+	peer := NewPeer("http://self.com:3000",
+		WithPeerTransport(origin),
+		WithOriginCredentials("basic.com", BasicAuth("user", "pass")),
+		WithOriginCredentials("bearer.com", BearerToken("token")),
+		WithOriginCredentials("bearer.com:8443", BearerToken("other")),
+		WithOriginCredentials("key.com", HeaderCredential("X-Api-Key", "secret")),
+	)
+
+	testCases := []struct {
+		origin   string
+		wantAuth string
+		wantKey  string
+	}{
+		{"http://basic.com/a", "Basic dXNlcjpwYXNz", ""},
+		{"http://bearer.com/a", "Bearer token", ""},
+		{"http://bearer.com:8080/a", "Bearer token", ""},
+		{"http://bearer.com:8443/a", "Bearer other", ""},
+		{"http://key.com/a", "client", "secret"},
+		{"http://none.com/a", "client", ""},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.origin, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape(tC.origin), nil)
+			req.Header.Set("Authorization", "client")
+			peer.Handler().ServeHTTP(rr, req)
+
+			if got := rr.Header().Get("X-Got-Auth"); got != tC.wantAuth {
+				t.Errorf("unexpected Authorization: got %q, want %q", got, tC.wantAuth)
+			}
+			if got := rr.Header().Get("X-Got-Key"); got != tC.wantKey {
+				t.Errorf("unexpected X-Api-Key: got %q, want %q", got, tC.wantKey)
+			}
+		})
+	}
+}
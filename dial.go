@@ -0,0 +1,319 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// AddressFamily is the IP version a peer connects to origins with.
+type AddressFamily int
+
+const (
+	// DualStack races IPv6 and IPv4 connections, IPv6 first
+	// (Happy Eyeballs, RFC 8305). It is the default.
+	DualStack AddressFamily = iota
+
+	// PreferIPv4 races IPv4 and IPv6 connections, IPv4 first, for
+	// networks with broken IPv6.
+	PreferIPv4
+
+	// IPv4Only only connects with IPv4.
+	IPv4Only
+
+	// IPv6Only only connects with IPv6.
+	IPv6Only
+)
+
+// EgressMode is how a peer picks the source address of a connection
+// to an origin among the addresses of its egress pool.
+type EgressMode int
+
+const (
+	// EgressRoundRobin uses the addresses in turn, connection after
+	// connection.
+	EgressRoundRobin EgressMode = iota
+
+	// EgressPerOrigin always uses the same address for an origin
+	// host, spreading the hosts over the addresses.
+	EgressPerOrigin
+)
+
+// defaultFallbackDelay is how long a connection attempt has before
+// the other address family is tried, like net.Dialer.
+const defaultFallbackDelay = 300 * time.Millisecond
+
+var (
+	errNoSourceAddress = errors.New("no source address for the address family")
+	errPrivateOrigin   = errors.New("private origin address denied")
+)
+
+// originDialer connects a peer to origins, for multi-homed peers.
+type originDialer struct {
+	enabled  bool // the default is the dialer of the peer's transport
+	family   AddressFamily
+	fallback time.Duration // before trying the other family
+	sources  []net.IP      // the egress pool, empty for any
+	mode     EgressMode    // of picking the source addresses
+	next     uint64        // round-robin counter, atomically updated
+	iface    string        // whose addresses are the source addresses, "" for any
+	public   bool          // refuses to connect to private addresses
+}
+
+// apply makes a peer transport connect with the dialer, if it is an
+// *http.Transport.
+func (d *originDialer) apply(transport http.RoundTripper) http.RoundTripper {
+	t, ok := transport.(*http.Transport)
+	if !d.enabled || !ok {
+		return transport
+	}
+	t = t.Clone()
+	t.DialContext = d.dialContext
+	return t
+}
+
+// networks returns the networks to connect with, by preference.
+func (d *originDialer) networks() []string {
+	switch d.family {
+	case PreferIPv4:
+		return []string{"tcp4", "tcp6"}
+	case IPv4Only:
+		return []string{"tcp4"}
+	case IPv6Only:
+		return []string{"tcp6"}
+	default:
+		return []string{"tcp6", "tcp4"}
+	}
+}
+
+// dialContext connects to addr, starting a connection with the next
+// network when the previous one fails or takes longer than the
+// fallback delay. The first established connection wins.
+func (d *originDialer) dialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	networks := d.networks()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(networks))
+	pending := 0
+	next := func() {
+		n := networks[0]
+		networks = networks[1:]
+		pending++
+		go func() {
+			conn, err := d.dial(ctx, n, addr)
+			results <- result{conn, err}
+		}()
+	}
+	next()
+
+	fallback := d.fallback
+	if fallback <= 0 {
+		fallback = defaultFallbackDelay
+	}
+	timer := time.NewTimer(fallback)
+	defer timer.Stop()
+
+	var firstErr error
+	for {
+		select {
+		case <-timer.C:
+			if len(networks) > 0 {
+				next()
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				go func(pending int) { // closes the connections losing the race
+					for ; pending > 0; pending-- {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+			if firstErr == nil || firstErr == errNoSourceAddress {
+				firstErr = r.err
+			}
+			if len(networks) > 0 {
+				next()
+				timer.Reset(fallback)
+			} else if pending == 0 {
+				return nil, firstErr
+			}
+		}
+	}
+}
+
+// dial connects to addr with a single network.
+func (d *originDialer) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	source, err := d.sourceAddr(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{
+		Timeout:       30 * time.Second,
+		KeepAlive:     30 * time.Second,
+		FallbackDelay: -1, // a single family
+	}
+	if source != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: source}
+	}
+	if d.public {
+		dialer.Control = denyPrivate
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// denyPrivate refuses the connections to private addresses. It checks
+// the resolved address being connected to, so that hostnames resolving
+// to private addresses are refused too.
+func denyPrivate(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if privateHost(host) {
+		return errPrivateOrigin
+	}
+	return nil
+}
+
+// sourceAddr returns the source address of a connection to addr with
+// a network, nil for any.
+func (d *originDialer) sourceAddr(network, addr string) (net.IP, error) {
+	v4 := network == "tcp4"
+	if len(d.sources) > 0 {
+		var pool []net.IP
+		for _, ip := range d.sources {
+			if (ip.To4() != nil) == v4 {
+				pool = append(pool, ip)
+			}
+		}
+		if len(pool) == 0 {
+			return nil, errNoSourceAddress
+		}
+		if d.mode == EgressPerOrigin {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil {
+				host = addr
+			}
+			h := fnv.New32a()
+			h.Write([]byte(host))
+			return pool[h.Sum32()%uint32(len(pool))], nil
+		}
+		return pool[(atomic.AddUint64(&d.next, 1)-1)%uint64(len(pool))], nil
+	}
+	if d.iface == "" {
+		return nil, nil
+	}
+
+	iface, err := net.InterfaceByName(d.iface)
+	if err != nil {
+		return nil, fmt.Errorf("source interface: %v", err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("source interface: %v", err)
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && (n.IP.To4() != nil) == v4 && !n.IP.IsLinkLocalUnicast() {
+			return n.IP, nil
+		}
+	}
+	return nil, errNoSourceAddress
+}
+
+// WithAddressFamily sets the IP version the peer connects to origins
+// with. When both are allowed, a connection attempt has fallbackDelay
+// (300ms if 0) before one with the other version is raced against it.
+// Like the other dialing options, it only applies to peer transports
+// which are *http.Transports, like the default one.
+// Defaults to DualStack.
+func WithAddressFamily(family AddressFamily, fallbackDelay time.Duration) func(*Peer) {
+	return func(p *Peer) {
+		p.dialer.enabled = true
+		p.dialer.family = family
+		p.dialer.fallback = fallbackDelay
+	}
+}
+
+// WithSourceAddress makes the peer connect to origins from a local IP
+// address, like "192.0.2.1", only with its IP version. An invalid
+// address is ignored. Defaults to any address.
+func WithSourceAddress(ip string) func(*Peer) {
+	return func(p *Peer) {
+		if source := net.ParseIP(ip); source != nil {
+			p.dialer.enabled = true
+			p.dialer.sources = []net.IP{source}
+		}
+	}
+}
+
+// WithEgressPool makes the peer connect to origins from several local
+// IP addresses, like "192.0.2.1" and "192.0.2.2", for origins limiting
+// the rate of each client address. A connection only uses the addresses
+// of its IP version. Connections are kept alive and reused, so the
+// source address changes with new connections only. Invalid addresses
+// are ignored. Defaults to any address.
+func WithEgressPool(mode EgressMode, ips ...string) func(*Peer) {
+	return func(p *Peer) {
+		p.dialer.sources, p.dialer.mode = nil, mode
+		for _, ip := range ips {
+			if source := net.ParseIP(ip); source != nil {
+				p.dialer.sources = append(p.dialer.sources, source)
+			}
+		}
+		p.dialer.enabled = len(p.dialer.sources) > 0 || p.dialer.enabled
+	}
+}
+
+// WithSourceInterface makes the peer connect to origins from the
+// addresses of a network interface, like "eth1". Link-local addresses
+// are not used. Defaults to any interface.
+func WithSourceInterface(name string) func(*Peer) {
+	return func(p *Peer) {
+		p.dialer.enabled = true
+		p.dialer.iface = name
+	}
+}
+
+// WithDenyPrivateOrigins refuses to connect to origins at loopback,
+// link-local, private (RFC 1918, RFC 4193) or unspecified addresses,
+// to protect the peer's network from server-side request forgery. The
+// addresses are checked once resolved, so hostnames resolving to
+// private addresses are refused as well. Requests for such origins
+// fail with a 502 Bad Gateway. Defaults to allowing any address.
+func WithDenyPrivateOrigins() func(*Peer) {
+	return func(p *Peer) {
+		p.dialer.enabled = true
+		p.dialer.public = true
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestOriginDialer(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	var loopback string
+	ifaces, _ := net.Interfaces()
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			loopback = iface.Name
+		}
+	}
+
+	testCases := []struct {
+		desc    string
+		dialer  originDialer
+		wantErr bool
+	}{
+		{"dual stack", originDialer{}, false},
+		{"prefer ipv4", originDialer{family: PreferIPv4}, false},
+		{"ipv4 only", originDialer{family: IPv4Only}, false},
+		{"ipv6 only", originDialer{family: IPv6Only}, true},
+		{"source", originDialer{sources: []net.IP{net.ParseIP("127.0.0.1")}}, false},
+		{"source of another family", originDialer{sources: []net.IP{net.ParseIP("::1")}}, true},
+		{"egress pool", originDialer{sources: []net.IP{net.ParseIP("::1"), net.ParseIP("127.0.0.2")}}, false},
+		{"interface", originDialer{iface: loopback}, false},
+		{"unknown interface", originDialer{iface: "forwardcache0"}, true},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			conn, err := tC.dialer.dialContext(ctx, "tcp", l.Addr().String())
+			if (err != nil) != tC.wantErr {
+				t.Fatalf("unexpected error: got %v, want error: %v", err, tC.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			if ip := conn.LocalAddr().(*net.TCPAddr).IP; !ip.IsLoopback() {
+				t.Errorf("unexpected source address: got %v", ip)
+			}
+		})
+	}
+}
+
+func TestOriginDialerApply(t *testing.T) {
+	custom := roundTripperFunc(func(req *http.Request) (*http.Response, error) { return okResponse(), nil })
+
+	testCases := []struct {
+		desc      string
+		dialer    originDialer
+		transport http.RoundTripper
+		wantDial  bool
+	}{
+		{"disabled", originDialer{}, http.DefaultTransport, false},
+		{"http transport", originDialer{enabled: true}, http.DefaultTransport, true},
+		{"custom transport", originDialer{enabled: true}, custom, false},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			transport := tC.dialer.apply(tC.transport)
+			ht, ok := transport.(*http.Transport)
+			if got := ok && ht != http.DefaultTransport; got != tC.wantDial {
+				t.Errorf("unexpected dialing transport: got %v, want %v", got, tC.wantDial)
+			}
+		})
+	}
+}
+
+func TestEgressPool(t *testing.T) {
+	pool := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), net.ParseIP("2001:db8::1"), net.ParseIP("192.0.2.3")}
+
+	d := originDialer{sources: pool}
+	var got []string
+	for i := 0; i < 4; i++ {
+		ip, _ := d.sourceAddr("tcp4", "cdn.com:80")
+		got = append(got, ip.String())
+	}
+	if want := "192.0.2.1 192.0.2.2 192.0.2.3 192.0.2.1"; strings.Join(got, " ") != want {
+		t.Errorf("unexpected round-robin sources: got %q, want %q", strings.Join(got, " "), want)
+	}
+	if ip, _ := d.sourceAddr("tcp6", "cdn.com:80"); !ip.Equal(pool[2]) {
+		t.Errorf("unexpected IPv6 source: got %v, want %v", ip, pool[2])
+	}
+
+	d = originDialer{sources: pool, mode: EgressPerOrigin}
+	seen := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		host := fmt.Sprintf("origin%d.com", i)
+		a, _ := d.sourceAddr("tcp4", host+":80")
+		b, _ := d.sourceAddr("tcp4", host+":443")
+		if !a.Equal(b) {
+			t.Errorf("unexpected sources for %s: got %v and %v, want the same", host, a, b)
+		}
+		seen[a.String()] = true
+	}
+	if len(seen) != 3 {
+		t.Errorf("unexpected sources used: got %d, want %d", len(seen), 3)
+	}
+}
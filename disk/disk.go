@@ -0,0 +1,491 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package disk provides a cache storing responses on disk, the headers
+// and the body of each response in separate files so that the bodies
+// can be served straight from the files.
+//
+// A body file is never modified: each store writes a body file of its
+// own, named after a random stamp, then atomically replaces the meta
+// file naming it on its first line. A reader always gets the body of
+// the headers it read, or a miss if the body was replaced since.
+package disk
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	metaExt = ".meta"
+	bodyExt = ".body"
+)
+
+// Cache is an httpcache.Cache storing responses in a directory.
+// It is safe for concurrent access.
+type Cache struct {
+	dir   string
+	low   int64 // background eviction starts above, 0 to never evict
+	high  int64 // stores are denied above, 0 for unlimited
+	mu    sync.Mutex
+	index map[string]*list.Element // by entry name
+	list  *list.List               // entries, most recently used first
+	gen   uint64                   // of the last reservation
+	stats Stats
+	evict chan struct{}
+	done  chan struct{}
+	close sync.Once
+}
+
+// Stats are statistics about the cache.
+type Stats struct {
+	Size      int64 // bytes stored
+	Entries   int64 // responses stored
+	Evictions int64 // responses evicted to go back under the low watermark
+	Denied    int64 // responses not stored because of the high watermark
+}
+
+type entry struct {
+	name  string
+	size  int64
+	gen   uint64 // of the reservation of the entry, see Cache.reserve
+	stamp string // of the body file, empty until stored
+}
+
+// New creates a Cache storing responses in dir, which is created
+// if needed. The responses already in dir are kept.
+func New(dir string, options ...func(*Cache)) *Cache {
+	os.MkdirAll(dir, 0700)
+	c := &Cache{
+		dir:   dir,
+		index: make(map[string]*list.Element),
+		list:  list.New(),
+		evict: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	c.load()
+	if c.low > 0 {
+		go c.evictor()
+		c.signal()
+	}
+	return c
+}
+
+// WithWatermarks bounds the size of the cache like mature caches do:
+// once it stores more than low bytes, the least recently used responses
+// are evicted in the background until it is back under low, and
+// responses that would make it store more than high bytes are not
+// stored at all. The size of a response counts its headers and body.
+// Defaults to unbounded.
+func WithWatermarks(low, high int64) func(*Cache) {
+	return func(c *Cache) {
+		c.low, c.high = low, high
+	}
+}
+
+// Stats returns the statistics of the cache.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
+
+// Close stops the background eviction.
+func (c *Cache) Close() error {
+	c.close.Do(func() { close(c.done) })
+	return nil
+}
+
+// Get returns the response stored for key, as stored by Set.
+func (c *Cache) Get(key string) (resp []byte, ok bool) {
+	path := c.path(key)
+	c.touch(filepath.Base(path))
+	meta, err := ioutil.ReadFile(path + metaExt)
+	if err != nil {
+		return nil, false
+	}
+	stamp, head, ok := splitMeta(meta)
+	if !ok {
+		return nil, false
+	}
+	body, err := ioutil.ReadFile(bodyPath(path, stamp))
+	if err != nil {
+		return nil, false // replaced since the meta file was read
+	}
+	return append(head, body...), true
+}
+
+// Set stores a response dumped with httputil.DumpResponse. Chunked
+// bodies are decoded so that the body file holds the raw body.
+func (c *Cache) Set(key string, resp []byte) {
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(resp)), nil)
+	if err != nil {
+		return
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return
+	}
+
+	stamp, err := newStamp()
+	if err != nil {
+		return
+	}
+	var meta bytes.Buffer
+	res.Header.Del("Transfer-Encoding")
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	meta.WriteString(stamp + "\n")
+	meta.WriteString(res.Proto + " " + res.Status + "\r\n")
+	res.Header.Write(&meta)
+	meta.WriteString("\r\n")
+
+	path := c.path(key)
+	name := filepath.Base(path)
+	size := int64(meta.Len() + len(body))
+	gen, ok := c.reserve(name, size)
+	if !ok {
+		return
+	}
+
+	// the body is written under a name of its own, the meta file
+	// replaced once the entry is still the one reserved marks it stored
+	var tmp string
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err == nil {
+		err = writeFile(bodyPath(path, stamp), body)
+	}
+	if err == nil {
+		tmp, err = writeTemp(filepath.Dir(path), meta.Bytes())
+	}
+	if err != nil || !c.commit(name, gen, stamp, tmp) {
+		c.release(name, gen)
+		os.Remove(bodyPath(path, stamp))
+		if tmp != "" {
+			os.Remove(tmp)
+		}
+	}
+}
+
+// Delete removes the response stored for key.
+func (c *Cache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.index[filepath.Base(c.path(key))]; ok {
+		c.drop(el)
+	}
+}
+
+// Open returns the response stored for key, its body being the body
+// file. The caller must close the body.
+func (c *Cache) Open(key string) (*http.Response, error) {
+	path := c.path(key)
+	c.touch(filepath.Base(path))
+	meta, err := os.Open(path + metaExt)
+	if err != nil {
+		return nil, err
+	}
+	defer meta.Close()
+
+	r := bufio.NewReader(meta)
+	stamp, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	res, err := http.ReadResponse(r, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := os.Open(bodyPath(path, strings.TrimSuffix(stamp, "\n")))
+	if err != nil {
+		return nil, err
+	}
+	fi, err := body.Stat()
+	if err != nil {
+		body.Close()
+		return nil, err
+	}
+
+	res.Body = body
+	res.ContentLength = fi.Size()
+	return res, nil
+}
+
+// path returns the path of the files of key, without extension.
+func (c *Cache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return c.pathOf(hex.EncodeToString(sum[:]))
+}
+
+// pathOf returns the path of the files of an entry, without extension.
+func (c *Cache) pathOf(name string) string {
+	return filepath.Join(c.dir, name[:2], name)
+}
+
+// bodyPath returns the path of the body file of an entry with a stamp.
+func bodyPath(path, stamp string) string {
+	return path + "-" + stamp + bodyExt
+}
+
+// newStamp returns a random stamp naming a body file.
+func newStamp() (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+// splitMeta splits the content of a meta file into the stamp of its
+// body file and the head of the response.
+func splitMeta(meta []byte) (stamp string, head []byte, ok bool) {
+	i := bytes.IndexByte(meta, '\n')
+	if i < 0 {
+		return "", nil, false
+	}
+	return string(meta[:i]), meta[i+1:], true
+}
+
+// load indexes the entries already stored, the most recently modified
+// being considered the most recently used, and removes the body files
+// of no entry, left by an interrupted store.
+func (c *Cache) load() {
+	metas, _ := filepath.Glob(filepath.Join(c.dir, "*", "*"+metaExt))
+	type stored struct {
+		entry
+		mod int64
+	}
+	var entries []stored
+	bodies := make(map[string]bool)
+	for _, meta := range metas {
+		name := strings.TrimSuffix(filepath.Base(meta), metaExt)
+		mi, err := os.Stat(meta)
+		if err != nil {
+			continue
+		}
+		f, err := os.Open(meta)
+		if err != nil {
+			continue
+		}
+		stamp, err := bufio.NewReader(f).ReadString('\n')
+		f.Close()
+		if err != nil {
+			continue
+		}
+		stamp = strings.TrimSuffix(stamp, "\n")
+		body := bodyPath(c.pathOf(name), stamp)
+		bi, err := os.Stat(body)
+		if err != nil {
+			continue
+		}
+		bodies[body] = true
+		entries = append(entries, stored{entry{name: name, size: mi.Size() + bi.Size(), stamp: stamp}, mi.ModTime().UnixNano()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].mod > entries[j].mod })
+
+	orphans, _ := filepath.Glob(filepath.Join(c.dir, "*", "*"+bodyExt))
+	for _, body := range orphans {
+		if !bodies[body] {
+			os.Remove(body)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, e := range entries {
+		e := e.entry
+		c.index[e.name] = c.list.PushBack(&e)
+		c.stats.Size += e.size
+		c.stats.Entries++
+	}
+}
+
+// reserve accounts for an entry about to be stored, unless it would
+// exceed the high watermark. It returns the generation of the
+// reservation, which a later reservation of the same entry replaces.
+func (c *Cache) reserve(name string, size int64) (uint64, bool) {
+	c.mu.Lock()
+	var old int64
+	if el, ok := c.index[name]; ok {
+		old = el.Value.(*entry).size
+	}
+	if c.high > 0 && c.stats.Size-old+size > c.high {
+		c.stats.Denied++
+		c.mu.Unlock()
+		return 0, false
+	}
+
+	c.gen++
+	if el, ok := c.index[name]; ok {
+		e := el.Value.(*entry)
+		e.size, e.gen = size, c.gen
+		c.list.MoveToFront(el)
+	} else {
+		c.index[name] = c.list.PushFront(&entry{name: name, size: size, gen: c.gen})
+		c.stats.Entries++
+	}
+	c.stats.Size += size - old
+	over := c.low > 0 && c.stats.Size > c.low
+	gen := c.gen
+	c.mu.Unlock()
+
+	if over {
+		c.signal()
+	}
+	return gen, true
+}
+
+// commit replaces the meta file of an entry with tmp, naming the body
+// file of stamp, and removes the previous body file. It reports false
+// if the entry was evicted, deleted or reserved again since gen.
+func (c *Cache) commit(name string, gen uint64, stamp, tmp string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.index[name]
+	if !ok || el.Value.(*entry).gen != gen {
+		return false
+	}
+	e := el.Value.(*entry)
+	path := c.pathOf(name)
+	if err := os.Rename(tmp, path+metaExt); err != nil {
+		return false
+	}
+	if e.stamp != "" && e.stamp != stamp {
+		os.Remove(bodyPath(path, e.stamp))
+	}
+	e.stamp = stamp
+	return true
+}
+
+// release forgets an entry whose store failed, unless it was reserved
+// again since gen.
+func (c *Cache) release(name string, gen uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.index[name]; ok && el.Value.(*entry).gen == gen {
+		c.drop(el)
+	}
+}
+
+// drop forgets an entry and removes its files, the cache being locked
+// so that a store can't replace them in between.
+func (c *Cache) drop(el *list.Element) {
+	e := el.Value.(*entry)
+	c.list.Remove(el)
+	delete(c.index, e.name)
+	c.stats.Size -= e.size
+	c.stats.Entries--
+
+	path := c.pathOf(e.name)
+	os.Remove(path + metaExt)
+	if e.stamp != "" {
+		os.Remove(bodyPath(path, e.stamp))
+	}
+}
+
+// touch marks an entry as recently used.
+func (c *Cache) touch(name string) {
+	c.mu.Lock()
+	if el, ok := c.index[name]; ok {
+		c.list.MoveToFront(el)
+	}
+	c.mu.Unlock()
+}
+
+// signal wakes the evictor up.
+func (c *Cache) signal() {
+	select {
+	case c.evict <- struct{}{}:
+	default:
+	}
+}
+
+// evictor evicts the least recently used entries in the background
+// while the cache is above the low watermark.
+func (c *Cache) evictor() {
+	for {
+		select {
+		case <-c.evict:
+		case <-c.done:
+			return
+		}
+
+		for {
+			c.mu.Lock()
+			el := c.list.Back()
+			if c.stats.Size <= c.low || el == nil {
+				c.mu.Unlock()
+				break
+			}
+			c.drop(el)
+			c.stats.Evictions++
+			c.mu.Unlock()
+		}
+	}
+}
+
+// writeFile atomically replaces the file at path.
+func writeFile(path string, data []byte) error {
+	tmp, err := writeTemp(filepath.Dir(path), data)
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// writeTemp writes data to a new temporary file in dir and returns
+// its path.
+func writeTemp(dir string, data []byte) (string, error) {
+	f, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	_, err = io.Copy(f, bytes.NewReader(data))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
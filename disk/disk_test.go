@@ -0,0 +1,224 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package disk
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := New(dir)
+	if _, ok := c.Get("http://cdn.com/jquery.js"); ok {
+		t.Fatalf("unexpected entry in an empty cache")
+	}
+
+	testCases := []struct {
+		name string
+		resp string
+	}{
+		{"length", "HTTP/1.1 200 OK\r\nContent-Length: 2\r\nCache-Control: max-age=60\r\n\r\nOK"},
+		{"chunked", "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\nCache-Control: max-age=60\r\n\r\n1\r\nO\r\n1\r\nK\r\n0\r\n\r\n"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			key := "http://cdn.com/" + tC.name
+			c.Set(key, []byte(tC.resp))
+
+			b, ok := c.Get(key)
+			if !ok {
+				t.Fatalf("missing entry")
+			}
+			res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			body, _ := ioutil.ReadAll(res.Body)
+			if string(body) != "OK" || res.Header.Get("Cache-Control") != "max-age=60" || res.ContentLength != 2 {
+				t.Errorf("unexpected response: got %+v with body %q", res, body)
+			}
+
+			res, err = c.Open(key)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			body, _ = ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if _, ok := res.Body.(*os.File); !ok || string(body) != "OK" || res.ContentLength != 2 {
+				t.Errorf("unexpected opened response: got %+v with body %q", res, body)
+			}
+
+			c.Delete(key)
+			if _, ok := c.Get(key); ok {
+				t.Errorf("unexpected entry after delete")
+			}
+			if _, err := c.Open(key); err == nil {
+				t.Errorf("unexpected opened entry after delete")
+			}
+		})
+	}
+}
+
+func TestWatermarks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	resp := []byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nOK")
+	c := New(dir)
+	c.Set("probe", resp)
+	size := c.Stats().Size
+	c.Delete("probe")
+
+	// room for 3 responses before evicting, 4 before denying
+	c = New(dir, WithWatermarks(3*size, 4*size))
+	defer c.Close()
+
+	for _, key := range []string{"a", "b", "c"} {
+		c.Set(key, resp)
+	}
+	c.Get("a") // b is now the least recently used
+	c.Set("d", resp)
+	c.Set("e", resp) // may be denied if the evictor is late
+
+	deadline := time.Now().Add(time.Second)
+	for c.Stats().Size > 3*size && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	stats := c.Stats()
+	if stats.Size > 3*size || stats.Evictions < 1 {
+		t.Fatalf("unexpected stats after eviction: got %+v", stats)
+	}
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("expected the least recently used response to be evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Errorf("expected a recently used response to be kept")
+	}
+
+	// the index is rebuilt from the files
+	c.Close()
+	reopened := New(dir)
+	if got := reopened.Stats(); got.Size != stats.Size || got.Entries != stats.Entries {
+		t.Errorf("unexpected stats after reopening: got %+v, want %+v", got, stats)
+	}
+}
+
+func TestHighWatermark(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	c := New(dir, WithWatermarks(0, 10))
+	c.Set("a", []byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nOK"))
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("unexpected response stored above the high watermark")
+	}
+	if got := c.Stats(); got.Denied != 1 || got.Size != 0 {
+		t.Errorf("unexpected stats: got %+v", got)
+	}
+}
+
+func TestConcurrentGetSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "disk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the responses differ in their headers and body, and so in length
+	resps := []string{
+		"HTTP/1.1 200 OK\r\nContent-Length: 1\r\nEtag: \"a\"\r\n\r\na",
+		"HTTP/1.1 200 OK\r\nContent-Length: 3\r\nEtag: \"b\"\r\n\r\nbbb",
+	}
+	set := func(c *Cache, done chan struct{}) *sync.WaitGroup {
+		var wg sync.WaitGroup
+		for _, resp := range resps {
+			wg.Add(1)
+			go func(resp string) {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+						c.Set("key", []byte(resp))
+					}
+				}
+			}(resp)
+		}
+		return &wg
+	}
+
+	c := New(dir)
+	done := make(chan struct{})
+	wg := set(c, done)
+	hits := 0
+	for deadline := time.Now().Add(time.Second); hits < 100 && time.Now().Before(deadline); {
+		b, ok := c.Get("key")
+		if !ok {
+			continue
+		}
+		hits++
+		res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if etag := strings.Trim(res.Header.Get("Etag"), `"`); strings.Trim(string(body), etag) != "" || int64(len(body)) != res.ContentLength {
+			t.Fatalf("unexpected response: got Etag %s with body %q", etag, body)
+		}
+	}
+	close(done)
+	wg.Wait()
+	if hits == 0 {
+		t.Errorf("expected some hits")
+	}
+
+	// stores racing with the evictor leave the index matching the files
+	c = New(dir, WithWatermarks(1, 0))
+	done = make(chan struct{})
+	wg = set(c, done)
+	time.Sleep(50 * time.Millisecond)
+	close(done)
+	wg.Wait()
+	c.Close()
+	if got, want := New(dir).Stats(), c.Stats(); got.Size != want.Size || got.Entries != want.Entries {
+		t.Errorf("unexpected stats after reopening: got %+v, want %+v", got, want)
+	}
+}
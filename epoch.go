@@ -0,0 +1,99 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gregjones/httpcache"
+)
+
+// epochCache prefixes the keys of a cache with an epoch, so that
+// bumping the epoch invalidates all the entries at once. The entries
+// of previous epochs are left to the eviction of the cache.
+type epochCache struct {
+	httpcache.Cache
+	epoch *AtomicInt
+}
+
+func (c *epochCache) key(key string) string {
+	return strconv.FormatInt(c.epoch.Get(), 10) + " " + key
+}
+
+func (c *epochCache) Get(key string) ([]byte, bool) {
+	return c.Cache.Get(c.key(key))
+}
+
+func (c *epochCache) Set(key string, resp []byte) {
+	c.Cache.Set(c.key(key), resp)
+}
+
+func (c *epochCache) Delete(key string) {
+	c.Cache.Delete(c.key(key))
+}
+
+// epochFileCache is an epochCache over a file cache.
+type epochFileCache struct {
+	*epochCache
+	files fileCache
+}
+
+func (c *epochFileCache) Open(key string) (*http.Response, error) {
+	return c.files.Open(c.key(key))
+}
+
+// withEpoch wraps a cache so that its keys are prefixed with epoch.
+func withEpoch(cache httpcache.Cache, epoch *AtomicInt) httpcache.Cache {
+	c := &epochCache{cache, epoch}
+	if files, ok := cache.(fileCache); ok {
+		return &epochFileCache{c, files}
+	}
+	return c
+}
+
+// WithCacheEpoch prefixes the cache keys with an epoch, starting at
+// epoch, that Peer.BumpEpoch increments to invalidate the whole cache
+// instantly without iterating it. Caches persisting across restarts
+// must be given their last epoch back, as the entries of previous
+// epochs are only removed by their eviction.
+// Defaults to no epoch.
+func WithCacheEpoch(epoch int64) func(*Peer) {
+	return func(p *Peer) {
+		p.epoch = new(AtomicInt)
+		p.epoch.Add(epoch)
+	}
+}
+
+// Epoch returns the epoch of the cache, see WithCacheEpoch.
+func (p *Peer) Epoch() int64 {
+	if p.epoch == nil {
+		return 0
+	}
+	return p.epoch.Get()
+}
+
+// BumpEpoch invalidates the whole cache of the peer and returns the new
+// epoch. The caches of the other peers of the pool are left untouched.
+// It does nothing without WithCacheEpoch.
+func (p *Peer) BumpEpoch() int64 {
+	if p.epoch == nil {
+		return 0
+	}
+	p.epoch.Add(1)
+	return p.epoch.Get()
+}
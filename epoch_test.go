@@ -0,0 +1,67 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestCacheEpoch(t *testing.T) {
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return okResponse(), nil
+	})
+	peer := NewPeer("http://self.com:3000", WithPeerTransport(origin), WithCacheEpoch(41))
+
+	get := func() string {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/proxy?q="+url.QueryEscape("http://cdn.com/jquery.js"), nil)
+		peer.Handler().ServeHTTP(rr, req)
+		return rr.HeaderMap.Get(httpcache.XFromCache)
+	}
+
+	testCases := []struct {
+		bump       bool
+		xFromCache string
+	}{
+		{false, ""},
+		{false, "1"},
+		{true, ""},
+		{false, "1"},
+	}
+	for i, tC := range testCases {
+		if tC.bump {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/epoch", nil)
+			peer.AdminHandler().ServeHTTP(rr, req)
+
+			var v struct{ Epoch int64 }
+			json.Unmarshal(rr.Body.Bytes(), &v)
+			if v.Epoch != 42 {
+				t.Errorf("unexpected epoch: got %d, want %d", v.Epoch, 42)
+			}
+		}
+		if xFromCache := get(); xFromCache != tC.xFromCache {
+			t.Errorf("request %d: unexpected %q header: got %q, want %q", i, httpcache.XFromCache, xFromCache, tC.xFromCache)
+		}
+	}
+}
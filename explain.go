@@ -0,0 +1,76 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+// WithExplain lets the clients ask the peer to explain its caching
+// decisions by sending the protocol.ExplainHeader request header, like
+// the debug headers of CDNs. The response then carries one
+// protocol.ExplainHeader value per decision, for example:
+//
+//	X-Forwardcache-Explain: miss: no stored response matching the request
+//	X-Forwardcache-Explain: freshness: lifetime 0s adjusted to 1m0s by the freshness policies
+//	X-Forwardcache-Explain: stored: Cache-Control "max-age=60", lifetime 1m0s
+//
+// Explaining doesn't change how requests are served. It is disabled by
+// default since the explanations reveal the peer's policies.
+func WithExplain() func(*Peer) {
+	return func(p *Peer) {
+		p.explain = true
+	}
+}
+
+type explainKey struct{}
+
+// explanation collects the caching decisions made for a request.
+type explanation struct {
+	mu    sync.Mutex
+	notes []string
+}
+
+// withExplanation returns a copy of req collecting the caching decisions
+// made for it in e.
+func withExplanation(req *http.Request, e *explanation) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), explainKey{}, e))
+}
+
+// explain records a caching decision made for req, if it is explained.
+func explain(req *http.Request, format string, args ...interface{}) {
+	e, ok := req.Context().Value(explainKey{}).(*explanation)
+	if !ok {
+		return
+	}
+	e.mu.Lock()
+	e.notes = append(e.notes, fmt.Sprintf(format, args...))
+	e.mu.Unlock()
+}
+
+// annotate adds the decisions recorded in e to h.
+func (e *explanation) annotate(h http.Header) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	h[protocol.ExplainHeader] = append(h[protocol.ExplainHeader], e.notes...)
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+func TestExplain(t *testing.T) {
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if v := req.Header.Get(protocol.ExplainHeader); v != "" {
+			t.Errorf("unexpected %s header sent to the origin: %q", protocol.ExplainHeader, v)
+		}
+		res := okResponse()
+		if req.URL.Path == "/private.js" {
+			res.Header.Set("Cache-Control", "private")
+		}
+		return res, nil
+	})
+
+	testCases := []struct {
+		name    string
+		peer    *Peer
+		url     string
+		explain string
+		want    []string
+	}{
+		{"miss", NewPeer("http://self.com:3000", WithPeerTransport(origin), WithExplain()), "http://cdn.com/jquery.js", "1", []string{"miss:", "stored:"}},
+		{"not stored", NewPeer("http://self.com:3000", WithPeerTransport(origin), WithExplain()), "http://cdn.com/private.js", "1", []string{"miss:", "not stored: response Cache-Control private"}},
+		{"not asked", NewPeer("http://self.com:3000", WithPeerTransport(origin), WithExplain()), "http://cdn.com/jquery.js", "", nil},
+		{"disabled", NewPeer("http://self.com:3000", WithPeerTransport(origin)), "http://cdn.com/jquery.js", "1", nil},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/proxy?q="+url.QueryEscape(tC.url), nil)
+			if tC.explain != "" {
+				req.Header.Set(protocol.ExplainHeader, tC.explain)
+			}
+			tC.peer.Handler().ServeHTTP(rr, req)
+
+			got := rr.HeaderMap[protocol.ExplainHeader]
+			if len(got) != len(tC.want) {
+				t.Fatalf("unexpected explanations: got %q, want %q", got, tC.want)
+			}
+			for i, want := range tC.want {
+				if !strings.HasPrefix(got[i], want) {
+					t.Errorf("unexpected explanation: got %q, want prefix %q", got[i], want)
+				}
+			}
+		})
+	}
+
+	peer := NewPeer("http://self.com:3000", WithPeerTransport(origin), WithExplain())
+	for i, want := range []string{"stored:", "hit:"} {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/proxy?q="+url.QueryEscape("http://cdn.com/jquery.js"), nil)
+		req.Header.Set(protocol.ExplainHeader, "1")
+		peer.Handler().ServeHTTP(rr, req)
+
+		got := rr.HeaderMap[protocol.ExplainHeader]
+		if len(got) == 0 || !strings.HasPrefix(got[len(got)-1], want) {
+			t.Errorf("unexpected explanations of request %d: got %q, want last with prefix %q", i, got, want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+// fileCache is a cache able to open the bodies of its responses as
+// files, like disk.Cache.
+type fileCache interface {
+	Open(key string) (*http.Response, error)
+}
+
+// serveFile serves a fresh response of a file cache straight from its
+// file, letting http.ServeContent handle Range and conditional requests
+// and the kernel the transfer. It reports whether it served the request,
+// the others going through the cache transport.
+func (p *proxy) serveFile(w http.ResponseWriter, req *http.Request, origin *url.URL) bool {
+	if p.files == nil || req.Method != http.MethodGet || !p.direct(req) {
+		return false
+	}
+
+	res, err := p.files.Open(origin.String())
+	if err != nil {
+		return false
+	}
+	defer res.Body.Close()
+
+	f, ok := res.Body.(*os.File)
+	if !ok || res.Header.Get(chunksHeader) != "" || !fresh(res, time.Now()) {
+		return false
+	}
+
+	stats := p.stats.Origin(origin.Host)
+	p.stats.Requests.Add(1)
+	stats.Requests.Add(1)
+	p.stats.Hits.Add(1)
+	stats.Hits.Add(1)
+
+	h := w.Header()
+	header, _ := clientHeader(res.Header)
+	for k, v := range header {
+		if k != "Content-Length" {
+			h[k] = v
+		}
+	}
+	h.Set(httpcache.XFromCache, "1")
+	p.clientCC.apply(origin, http.StatusOK, h)
+
+	modtime, _ := http.ParseTime(res.Header.Get("Last-Modified"))
+	cw := &countingWriter{w, &p.stats.BytesServed, &stats.BytesServed}
+	http.ServeContent(cw, req, "", modtime, f)
+	return true
+}
+
+// direct reports whether a request can be served without the rules
+// of the cache transport, being neither conditional on the client's
+// cache directives nor modified for the origin.
+func (p *proxy) direct(req *http.Request) bool {
+	for _, h := range []string{"Cache-Control", "Pragma", "Authorization"} {
+		if _, ok := req.Header[h]; ok {
+			return false
+		}
+	}
+	return !p.headers.enabled() && !p.userAgent.enabled() && len(p.credentials) == 0
+}
+
+// fresh reports whether a cached response can be served without being
+// revalidated with the origin. Unlike the cache transport, it refuses
+// anything it is unsure about, like responses varying on request
+// headers or without explicit expiration.
+func fresh(res *http.Response, now time.Time) bool {
+	if res.StatusCode != http.StatusOK || res.Header.Get("Vary") != "" {
+		return false
+	}
+
+	cc := parseDirectives(res.Header["Cache-Control"])
+	if cc.has("no-cache") || cc.has("no-store") {
+		return false
+	}
+	age, ok := currentAge(res.Header, now)
+	return ok && age < lifetime(res.Header, cc)
+}
+
+// countingWriter counts the bytes written to a ResponseWriter, keeping
+// its io.ReaderFrom implementation so files can be sent with sendfile.
+type countingWriter struct {
+	http.ResponseWriter
+	total, origin *AtomicInt
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.total.Add(int64(n))
+	w.origin.Add(int64(n))
+	return n, err
+}
+
+func (w *countingWriter) ReadFrom(r io.Reader) (int64, error) {
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(struct{ io.Writer }{w.ResponseWriter}, r)
+	}
+	w.total.Add(n)
+	w.origin.Add(n)
+	return n, err
+}
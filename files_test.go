@@ -0,0 +1,117 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/forwardcache/disk"
+)
+
+func TestFresh(t *testing.T) {
+	now := time.Now()
+	date := now.Add(-30 * time.Second).UTC().Format(http.TimeFormat)
+
+	testCases := []struct {
+		name   string
+		status int
+		header map[string]string
+		want   bool
+	}{
+		{"max-age", 200, map[string]string{"Date": date, "Cache-Control": "public, max-age=60"}, true},
+		{"max-age expired", 200, map[string]string{"Date": date, "Cache-Control": "max-age=10"}, false},
+		{"expires", 200, map[string]string{"Date": date, "Expires": now.Add(time.Minute).UTC().Format(http.TimeFormat)}, true},
+		{"max-age over expires", 200, map[string]string{"Date": date, "Cache-Control": "max-age=10", "Expires": now.Add(time.Minute).UTC().Format(http.TimeFormat)}, false},
+		{"no-cache", 200, map[string]string{"Date": date, "Cache-Control": "max-age=60, no-cache"}, false},
+		{"no date", 200, map[string]string{"Cache-Control": "max-age=60"}, false},
+		{"no expiration", 200, map[string]string{"Date": date}, false},
+		{"vary", 200, map[string]string{"Date": date, "Cache-Control": "max-age=60", "Vary": "Accept"}, false},
+		{"not found", 404, map[string]string{"Date": date, "Cache-Control": "max-age=60"}, false},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			res := &http.Response{StatusCode: tC.status, Header: make(http.Header)}
+			for k, v := range tC.header {
+				res.Header.Set(k, v)
+			}
+			if got := fresh(res, now); got != tC.want {
+				t.Errorf("unexpected freshness: got %v, want %v", got, tC.want)
+			}
+		})
+	}
+}
+
+func TestServeFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forwardcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fetches := 0
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		fetches++
+		res := okResponse()
+		res.Header.Set("Cache-Control", "max-age=60")
+		res.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		res.Header.Set("Content-Type", "application/javascript")
+		return res, nil
+	})
+	proxy := newProxy("/p", disk.New(dir), origin, DefaultBufferPool)
+
+	testCases := []struct {
+		name       string
+		header     map[string]string
+		status     int
+		body       string
+		xFromCache string
+	}{
+		{"miss", nil, http.StatusOK, "OK", ""},
+		{"file", nil, http.StatusOK, "OK", "1"},
+		{"range", map[string]string{"Range": "bytes=1-"}, http.StatusPartialContent, "K", "1"},
+		{"no-cache", map[string]string{"Cache-Control": "no-cache"}, http.StatusOK, "OK", ""},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/p?q="+url.QueryEscape("http://cdn.com/jquery.js"), nil)
+			for k, v := range tC.header {
+				req.Header.Set(k, v)
+			}
+			proxy.ServeHTTP(rr, req)
+
+			if rr.Code != tC.status || rr.Body.String() != tC.body || rr.Header().Get(httpcache.XFromCache) != tC.xFromCache {
+				t.Errorf("unexpected response: got %d %q (X-From-Cache: %q), want %d %q (X-From-Cache: %q)",
+					rr.Code, rr.Body.String(), rr.Header().Get(httpcache.XFromCache), tC.status, tC.body, tC.xFromCache)
+			}
+		})
+	}
+
+	if fetches != 2 {
+		t.Errorf("unexpected number of origin fetches: got %d, want %d", fetches, 2)
+	}
+	if got := proxy.stats.Hits.Get(); got != 2 {
+		t.Errorf("unexpected hits: got %d, want %d", got, 2)
+	}
+}
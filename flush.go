@@ -0,0 +1,137 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"mime"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// flushPolicy is when a peer flushes the responses it copies to the
+// clients.
+type flushPolicy struct {
+	interval time.Duration // 0 to never flush, negative to flush after each write
+	types    []string      // media types flushed after each write, nil for the default ones
+}
+
+// defaultStreamingTypes are the media types flushed after each write
+// by default.
+var defaultStreamingTypes = []string{"text/event-stream"}
+
+// delay returns the flush delay of a response of size bytes (-1 if
+// unknown): negative to flush after each write, 0 to never flush.
+// Streamed bodies and the ones of streaming media types are flushed
+// after each write.
+func (f flushPolicy) delay(h http.Header, size int64) time.Duration {
+	if size == -1 || f.streaming(h.Get("Content-Type")) {
+		return -1
+	}
+	return f.interval
+}
+
+func (f flushPolicy) streaming(contentType string) bool {
+	if contentType == "" {
+		return false
+	}
+	media, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	types := f.types
+	if types == nil {
+		types = defaultStreamingTypes
+	}
+	for _, pattern := range types {
+		if match(pattern, media) {
+			return true
+		}
+	}
+	return false
+}
+
+// delayedFlusher flushes the writes to a response at most delay after
+// them, like httputil.ReverseProxy does with its FlushInterval.
+type delayedFlusher struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	delay   time.Duration
+	mu      sync.Mutex // guards the writes, pending and timer
+	pending bool
+	timer   *time.Timer
+}
+
+func (d *delayedFlusher) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n, err := d.w.Write(p)
+	if !d.pending {
+		d.pending = true
+		if d.timer == nil {
+			d.timer = time.AfterFunc(d.delay, d.flush)
+		} else {
+			d.timer.Reset(d.delay)
+		}
+	}
+	return n, err
+}
+
+func (d *delayedFlusher) flush() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.pending {
+		d.flusher.Flush()
+		d.pending = false
+	}
+}
+
+// stop stops flushing, the response being complete.
+func (d *delayedFlusher) stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.pending = false
+	if d.timer != nil {
+		d.timer.Stop()
+	}
+}
+
+// WithFlushInterval makes the peer flush the responses it copies to the
+// clients at most d after writing to them, negative values flushing
+// after each write, like httputil.ReverseProxy's FlushInterval. The
+// responses of unknown length and of streaming media types (see
+// WithStreamingTypes) are always flushed after each write.
+// Defaults to 0, the other responses being flushed when complete.
+func WithFlushInterval(d time.Duration) func(*Peer) {
+	return func(p *Peer) {
+		p.flush.interval = d
+	}
+}
+
+// WithStreamingTypes specifies the media types of the responses flushed
+// to the clients after each write, so that progressive downloads and
+// streaming APIs aren't held back, for example "text/event-stream",
+// "application/x-ndjson" or "video/*". Stars match any sequence of
+// characters. Defaults to "text/event-stream".
+func WithStreamingTypes(types ...string) func(*Peer) {
+	return func(p *Peer) {
+		p.flush.types = append([]string{}, types...)
+	}
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlushPolicy(t *testing.T) {
+	testCases := []struct {
+		policy      flushPolicy
+		contentType string
+		size        int64
+		want        time.Duration
+	}{
+		{flushPolicy{}, "text/html", 10, 0},
+		{flushPolicy{}, "text/html", -1, -1},
+		{flushPolicy{}, "text/event-stream; charset=utf-8", 10, -1},
+		{flushPolicy{interval: time.Second}, "text/html", 10, time.Second},
+		{flushPolicy{interval: -1}, "text/html", 10, -1},
+		{flushPolicy{types: []string{"video/*"}}, "video/mp4", 10, -1},
+		{flushPolicy{types: []string{"video/*"}}, "text/event-stream", 10, 0},
+		{flushPolicy{types: []string{"video/*"}}, "", 10, 0},
+	}
+	for _, tC := range testCases {
+		h := http.Header{}
+		if tC.contentType != "" {
+			h.Set("Content-Type", tC.contentType)
+		}
+		if got := tC.policy.delay(h, tC.size); got != tC.want {
+			t.Errorf("unexpected delay of %q (%d bytes) with %+v: got %v, want %v", tC.contentType, tC.size, tC.policy, got, tC.want)
+		}
+	}
+}
+
+// flushRecorder is a ResponseRecorder counting its flushes.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes int32
+}
+
+func (r *flushRecorder) Flush() {
+	atomic.AddInt32(&r.flushes, 1)
+}
+
+func TestCopyBodyFlush(t *testing.T) {
+	testCases := []struct {
+		delay time.Duration
+		want  int32
+	}{
+		{0, 0},
+		{-1, 3},
+		{time.Minute, 0}, // complete before the delay
+	}
+	for _, tC := range testCases {
+		w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+		body := io.MultiReader(strings.NewReader("a"), strings.NewReader("b"), strings.NewReader("c"))
+		if err := copyBody(w, body, 3, nil, tC.delay); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := w.Body.String(), "abc"; got != want {
+			t.Errorf("unexpected body: got %q, want %q", got, want)
+		}
+		if got := atomic.LoadInt32(&w.flushes); got != tC.want {
+			t.Errorf("unexpected flushes with a delay of %v: got %d, want %d", tC.delay, got, tC.want)
+		}
+	}
+}
+
+func TestDelayedFlusher(t *testing.T) {
+	w := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	pr, pw := io.Pipe()
+	done := make(chan error)
+	go func() { done <- copyBody(w, pr, -1, nil, 10*time.Millisecond) }()
+
+	pw.Write([]byte("a"))
+	pw.Write([]byte("b"))
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt32(&w.flushes) == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	pw.Close()
+	<-done
+
+	if got := atomic.LoadInt32(&w.flushes); got == 0 {
+		t.Errorf("unexpected flushes: got %d, want at least 1", got)
+	}
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package forwardcachetest provides utilities to test code using
+// a forwardcache pool.
+package forwardcachetest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/forwardcache"
+)
+
+// Pool is a pool of peers served by local httptest servers, fetching
+// resources from an in-memory origin.
+type Pool struct {
+	Peers   []*forwardcache.Peer
+	Servers []*httptest.Server
+	Caches  []*httpcache.MemoryCache
+	Buffers *forwardcache.BufferPool // shared by the peers
+}
+
+// NewPool starts a pool of n peers. Every request made to an origin
+// by the peers is served by origin, whatever the requested host is.
+// The options are applied to every peer after the defaults, for
+// example to wrap the caches. The pool must be closed after use.
+func NewPool(n int, origin http.Handler, options ...func(*forwardcache.Peer)) *Pool {
+	p := &Pool{
+		Peers:   make([]*forwardcache.Peer, n),
+		Servers: make([]*httptest.Server, n),
+		Caches:  make([]*httpcache.MemoryCache, n),
+		Buffers: forwardcache.NewTieredBufferPool(4*1024, 32*1024, 256*1024),
+	}
+
+	handlers := make([]http.Handler, n)
+	urls := make([]string, n)
+	for i := range p.Servers {
+		i := i
+		p.Servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers[i].ServeHTTP(w, r)
+		}))
+		urls[i] = p.Servers[i].URL
+	}
+
+	for i := range p.Peers {
+		p.Caches[i] = httpcache.NewMemoryCache()
+		opts := append([]func(*forwardcache.Peer){
+			forwardcache.WithCache(p.Caches[i]),
+			forwardcache.WithPeerTransport(HandlerTransport(origin)),
+			forwardcache.WithBufferPool(p.Buffers),
+		}, options...)
+
+		p.Peers[i] = forwardcache.NewPeer(urls[i], opts...)
+		p.Peers[i].SetPool(urls...)
+		handlers[i] = p.Peers[i].Handler()
+	}
+
+	return p
+}
+
+// URLs returns the base URLs of the peers.
+func (p *Pool) URLs() []string {
+	urls := make([]string, len(p.Servers))
+	for i, s := range p.Servers {
+		urls[i] = s.URL
+	}
+	return urls
+}
+
+// Client returns a nonparticipating client of the pool.
+func (p *Pool) Client(options ...func(*forwardcache.Client)) *forwardcache.Client {
+	return forwardcache.NewClient(append([]func(*forwardcache.Client){forwardcache.WithPool(p.URLs()...)}, options...)...)
+}
+
+// Owner returns the index of the peer owning url.
+func (p *Pool) Owner(url string) int {
+	owner := p.Peers[0].Owner(url)
+	for i, s := range p.Servers {
+		if s.URL == owner {
+			return i
+		}
+	}
+	return -1
+}
+
+// Cached returns the indexes of the peers having url in their cache.
+func (p *Pool) Cached(url string) []int {
+	var peers []int
+	for i, c := range p.Caches {
+		if _, ok := c.Get(url); ok {
+			peers = append(peers, i)
+		}
+	}
+	return peers
+}
+
+// Close shuts down the servers of the pool.
+func (p *Pool) Close() {
+	for _, s := range p.Servers {
+		s.Close()
+	}
+}
+
+// HandlerTransport returns a RoundTripper serving every request with h,
+// without going through the network. Like http.Server, it adds a Date
+// header to the responses not having one.
+func HandlerTransport(h http.Handler) http.RoundTripper {
+	return handlerTransport{h}
+}
+
+type handlerTransport struct {
+	h http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rr := httptest.NewRecorder()
+	t.h.ServeHTTP(rr, req)
+
+	res := rr.Result()
+	res.Request = req
+	if _, ok := res.Header["Date"]; !ok {
+		res.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	return res, nil
+}
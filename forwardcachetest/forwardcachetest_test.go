@@ -0,0 +1,73 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcachetest
+
+import (
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	var fetches int64
+	origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&fetches, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte(r.URL.String()))
+	})
+
+	pool := NewPool(3, origin)
+	defer pool.Close()
+
+	client := pool.Client().HTTPClient()
+	urls := []string{"http://cdn.com/jquery.js", "http://cdn.com/bootstrap.js", "http://cdn.com/angular.js"}
+
+	for i := 0; i < 2; i++ {
+		for _, u := range urls {
+			res, err := client.Get(u)
+			if err != nil {
+				t.Fatalf("unexpected error: got %q, want <nil>", err)
+			}
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+
+			if string(body) != u {
+				t.Errorf("unexpected body: got %q, want %q", body, u)
+			}
+		}
+	}
+
+	if got, want := atomic.LoadInt64(&fetches), int64(len(urls)); got != want {
+		t.Errorf("unexpected number of origin fetches: got %d, want %d", got, want)
+	}
+
+	for _, u := range urls {
+		cached := pool.Cached(u)
+		if len(cached) != 1 || cached[0] != pool.Owner(u) {
+			t.Errorf("%q should only be cached on its owner %d: got %v", u, pool.Owner(u), cached)
+		}
+	}
+
+	var gets int64
+	for _, class := range pool.Buffers.Stats() {
+		gets += class.Gets
+	}
+	if want := int64(2 * len(urls)); gets != want {
+		t.Errorf("unexpected number of buffers used by the peers: got %d, want %d", gets, want)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcachetest
+
+import "bytes"
+
+// Hash is a deterministic, table-driven hash function to write stable
+// tests of peer routing. Use its Fn method with forwardcache.WithHashFn.
+//
+// Since the replicas of a peer are hashed from keys containing the
+// peer's URL, registering a peer's URL places all of its replicas at
+// the same position on the ring:
+//
+//	hash := forwardcachetest.NewHash().
+//		With("http://a.com:3000", 0).
+//		With("http://b.com:3000", 1).
+//		With("/res-a.js", 0). // owned by a
+//		With("/res-b.js", 1)  // owned by b
+type Hash struct {
+	entries []hashEntry
+	def     uint32
+}
+
+type hashEntry struct {
+	substr []byte
+	hashTo uint32
+}
+
+// NewHash creates a Hash hashing everything to 0.
+func NewHash() *Hash {
+	return &Hash{}
+}
+
+// With hashes the data containing substr to hashTo. Entries are
+// matched in the order they were added.
+func (h *Hash) With(substr string, hashTo uint32) *Hash {
+	h.entries = append(h.entries, hashEntry{[]byte(substr), hashTo})
+	return h
+}
+
+// Default hashes the data not matching any entry to hashTo.
+func (h *Hash) Default(hashTo uint32) *Hash {
+	h.def = hashTo
+	return h
+}
+
+// Fn is the hash function.
+func (h *Hash) Fn(data []byte) uint32 {
+	for _, e := range h.entries {
+		if bytes.Contains(data, e.substr) {
+			return e.hashTo
+		}
+	}
+	return h.def
+}
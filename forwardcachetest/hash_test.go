@@ -0,0 +1,54 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcachetest
+
+import (
+	"testing"
+
+	"github.com/mikegleasonjr/forwardcache"
+)
+
+func TestHash(t *testing.T) {
+	hash := NewHash().
+		With("http://a.com:3000", 0).
+		With("http://b.com:3000", 1).
+		With("http://c.com:3000", 2).
+		With("/res-a.js", 0).
+		With("/res-b.js", 1).
+		With("/res-c.js", 2).
+		Default(3)
+
+	client := forwardcache.NewClient(
+		forwardcache.WithPool("http://a.com:3000", "http://b.com:3000", "http://c.com:3000"),
+		forwardcache.WithHashFn(hash.Fn),
+	)
+
+	testCases := []struct {
+		url  string
+		want string
+	}{
+		{"http://some.url/res-a.js", "http://a.com:3000"},
+		{"http://some.url/res-b.js", "http://b.com:3000"},
+		{"http://some.url/res-c.js", "http://c.com:3000"},
+		{"http://some.url/other.js", "http://a.com:3000"}, // 3 wraps around the ring
+	}
+	for _, tC := range testCases {
+		if got := client.Owner(tC.url); got != tC.want {
+			t.Errorf("unexpected owner of %q: got %q, want %q", tC.url, got, tC.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ForwardedMode is how a peer tells origins who the client is.
+type ForwardedMode int
+
+const (
+	// ForwardedXFF appends the client's address to X-Forwarded-For.
+	ForwardedXFF ForwardedMode = iota
+
+	// ForwardedRFC7239 appends the client's address to Forwarded,
+	// as described in RFC 7239.
+	ForwardedRFC7239
+
+	// ForwardedStrip removes X-Forwarded-For and Forwarded, origins
+	// only see the peer.
+	ForwardedStrip
+)
+
+// forwardedPolicy sets the forwarding headers of origin requests.
+type forwardedPolicy struct {
+	enabled bool // the default is to append to X-Forwarded-For, trusting every client
+	mode    ForwardedMode
+	trusted []*net.IPNet
+}
+
+// apply sets the forwarding headers of an outgoing request.
+func (f *forwardedPolicy) apply(req *http.Request) {
+	ip, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		ip = req.RemoteAddr
+	}
+
+	if f.enabled && (f.mode == ForwardedStrip || !f.trust(ip)) {
+		req.Header.Del("Forwarded")
+		req.Header.Del("X-Forwarded-For")
+	}
+
+	if ip == "" {
+		return
+	}
+
+	switch f.mode {
+	case ForwardedXFF:
+		if prior := req.Header["X-Forwarded-For"]; len(prior) > 0 {
+			ip = strings.Join(prior, ", ") + ", " + ip
+		}
+		req.Header.Set("X-Forwarded-For", ip)
+	case ForwardedRFC7239:
+		node := ip
+		if strings.Contains(ip, ":") {
+			node = `"[` + ip + `]"`
+		}
+		if prior := req.Header.Get("Forwarded"); prior != "" {
+			req.Header.Set("Forwarded", prior+", for="+node)
+		} else {
+			req.Header.Set("Forwarded", "for="+node)
+		}
+	}
+}
+
+func (f *forwardedPolicy) trust(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, n := range f.trusted {
+		if n.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithForwarded sets how the peer tells origins who the client is.
+// The forwarding headers sent by clients are kept only if the clients
+// are in one of the trusted networks (in CIDR notation, like
+// "10.0.0.0/8"), and dropped otherwise. Invalid networks are ignored.
+// Defaults to appending to X-Forwarded-For, trusting every client.
+func WithForwarded(mode ForwardedMode, trusted ...string) func(*Peer) {
+	return func(p *Peer) {
+		p.forwarded = forwardedPolicy{enabled: true, mode: mode}
+		for _, cidr := range trusted {
+			if _, n, err := net.ParseCIDR(cidr); err == nil {
+				p.forwarded.trusted = append(p.forwarded.trusted, n)
+			}
+		}
+	}
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestForwarded(t *testing.T) {
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("X-Got-XFF", req.Header.Get("X-Forwarded-For"))
+		res.Header.Set("X-Got-Forwarded", req.Header.Get("Forwarded"))
+		return res, nil
+	})
+
+	testCases := []struct {
+		name       string
+		options    []func(*Peer)
+		remoteAddr string
+		xff        string
+		wantXFF    string
+		wantFwd    string
+	}{
+		{"default", nil, "10.0.0.1:1234", "1.2.3.4", "1.2.3.4, 10.0.0.1", ""},
+		{"xff untrusted", []func(*Peer){WithForwarded(ForwardedXFF)}, "10.0.0.1:1234", "1.2.3.4", "10.0.0.1", ""},
+		{"xff trusted", []func(*Peer){WithForwarded(ForwardedXFF, "10.0.0.0/8")}, "10.0.0.1:1234", "1.2.3.4", "1.2.3.4, 10.0.0.1", ""},
+		{"rfc7239", []func(*Peer){WithForwarded(ForwardedRFC7239)}, "10.0.0.1:1234", "1.2.3.4", "", "for=10.0.0.1"},
+		{"rfc7239 ipv6", []func(*Peer){WithForwarded(ForwardedRFC7239)}, "[::1]:1234", "", "", `for="[::1]"`},
+		{"strip", []func(*Peer){WithForwarded(ForwardedStrip, "10.0.0.0/8")}, "10.0.0.1:1234", "1.2.3.4", "", ""},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			peer := NewPeer("http://self.com:3000", append([]func(*Peer){WithPeerTransport(origin)}, tC.options...)...)
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape("http://cdn.com/jquery.js?"+tC.name), nil)
+			req.RemoteAddr = tC.remoteAddr
+			if tC.xff != "" {
+				req.Header.Set("X-Forwarded-For", tC.xff)
+			}
+			peer.Handler().ServeHTTP(rr, req)
+
+			if got := rr.Header().Get("X-Got-XFF"); got != tC.wantXFF {
+				t.Errorf("unexpected X-Forwarded-For: got %q, want %q", got, tC.wantXFF)
+			}
+			if got := rr.Header().Get("X-Got-Forwarded"); got != tC.wantFwd {
+				t.Errorf("unexpected Forwarded: got %q, want %q", got, tC.wantFwd)
+			}
+		})
+	}
+}
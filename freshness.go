@@ -0,0 +1,146 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FreshnessPolicy adjusts how long the responses stored by a peer stay
+// fresh, see WithFreshness.
+type FreshnessPolicy interface {
+	// Freshness returns the freshness lifetime of the response to req,
+	// given the one computed so far from its headers, 0 if it has none
+	// or can't be stored without being revalidated (no-store, no-cache
+	// or private). Lifetimes count from when the response was generated,
+	// its age included (see RFC 7234, 4.2).
+	Freshness(req *http.Request, res *http.Response, lifetime time.Duration) time.Duration
+}
+
+// FreshnessFunc is a function used as a FreshnessPolicy.
+type FreshnessFunc func(req *http.Request, res *http.Response, lifetime time.Duration) time.Duration
+
+// Freshness returns f(req, res, lifetime).
+func (f FreshnessFunc) Freshness(req *http.Request, res *http.Response, lifetime time.Duration) time.Duration {
+	return f(req, res, lifetime)
+}
+
+// FreshnessOverride keeps the successful responses of the URLs matching
+// one of the patterns (see Peer.Purge) fresh for ttl, whatever their
+// Cache-Control.
+func FreshnessOverride(ttl time.Duration, patterns ...string) FreshnessPolicy {
+	return FreshnessFunc(func(req *http.Request, res *http.Response, lifetime time.Duration) time.Duration {
+		if res.StatusCode != http.StatusOK {
+			return lifetime
+		}
+		key := req.URL.String()
+		for _, pattern := range patterns {
+			if matchKey(pattern, key) {
+				return ttl
+			}
+		}
+		return lifetime
+	})
+}
+
+// FreshnessJitter shortens the freshness lifetimes by a random fraction
+// of at most fraction, so that responses stored at the same time don't
+// all expire, and get revalidated, at the same time.
+func FreshnessJitter(fraction float64) FreshnessPolicy {
+	return FreshnessFunc(func(req *http.Request, res *http.Response, lifetime time.Duration) time.Duration {
+		return lifetime - time.Duration(rand.Float64()*fraction*float64(lifetime))
+	})
+}
+
+// WithFreshness adjusts the freshness lifetimes of the responses stored
+// by the peer with the policies, in order, after the ones set by
+// WithMinTTL and WithMicroCache. Extending the lifetime of a response
+// makes it storable whatever its Cache-Control, which clients still get
+// from the origin.
+// Defaults to none.
+func WithFreshness(policies ...FreshnessPolicy) func(*Peer) {
+	return func(p *Peer) {
+		p.freshness = append(p.freshness, policies...)
+	}
+}
+
+// freshnessTransport adjusts the freshness lifetimes of the origin
+// responses with policies, replacing their Cache-Control for the cache
+// only. It sits behind the cache.
+type freshnessTransport struct {
+	builtin   []FreshnessPolicy
+	policies  *[]FreshnessPolicy
+	transport http.RoundTripper
+}
+
+func (t *freshnessTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	cc := parseDirectives(res.Header["Cache-Control"])
+	var computed time.Duration
+	if !cc.has("no-store") && !unqualified(cc, "no-cache") && !unqualified(cc, "private") {
+		computed = lifetime(res.Header, cc)
+	}
+	adjusted := computed
+	for _, p := range t.builtin {
+		adjusted = p.Freshness(req, res, adjusted)
+	}
+	for _, p := range *t.policies {
+		adjusted = p.Freshness(req, res, adjusted)
+	}
+	if adjusted < 0 {
+		adjusted = 0
+	}
+	if adjusted == computed {
+		return res, nil
+	}
+	explain(req, "freshness: lifetime %s adjusted to %s by the freshness policies", computed, adjusted)
+
+	var directives []string
+	for _, directive := range splitQuoted(strings.Join(res.Header["Cache-Control"], ", ")) {
+		name := strings.ToLower(directive)
+		qualified := false
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name, qualified = strings.TrimSpace(name[:i]), true
+		}
+		drop := directive == "" || name == "max-age" || name == "s-maxage"
+		if adjusted > computed {
+			// extended lifetimes make the responses storable
+			drop = drop || name == "no-store" || !qualified && (name == "no-cache" || name == "private")
+		}
+		if !drop {
+			directives = append(directives, directive)
+		}
+	}
+	seconds := (adjusted + time.Second - 1) / time.Second
+	directives = append(directives, "max-age="+strconv.FormatInt(int64(seconds), 10))
+	cacheOnly(res.Header, strings.Join(directives, ", "))
+	return res, nil
+}
+
+// unqualified reports whether a directive is present without field names.
+func unqualified(cc directives, name string) bool {
+	v, ok := cc[name]
+	return ok && v == ""
+}
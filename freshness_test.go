@@ -0,0 +1,103 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestFreshness(t *testing.T) {
+	halve := FreshnessFunc(func(req *http.Request, res *http.Response, lifetime time.Duration) time.Duration {
+		return lifetime / 2
+	})
+
+	testCases := []struct {
+		desc     string
+		policies []FreshnessPolicy
+		header   string // Cache-Control of the origin
+		want     string // Cache-Control for the cache
+	}{
+		{"unchanged", []FreshnessPolicy{FreshnessJitter(0)}, "public, max-age=60", "public, max-age=60"},
+		{"shortened", []FreshnessPolicy{halve}, "public, max-age=60, s-maxage=120", "public, max-age=60"},
+		{"shortened private", []FreshnessPolicy{halve}, "private, max-age=60", "private, max-age=60"},
+		{"override", []FreshnessPolicy{FreshnessOverride(10*time.Second, "/api/*")}, "private, no-cache, no-store", "max-age=10"},
+		{"override qualified", []FreshnessPolicy{FreshnessOverride(10*time.Second, "/api/*")}, `private="Set-Cookie", max-age=1`, `private="Set-Cookie", max-age=10`},
+		{"override mismatch", []FreshnessPolicy{FreshnessOverride(10*time.Second, "/static/*")}, "no-store", "no-store"},
+		{"in order", []FreshnessPolicy{FreshnessOverride(10*time.Second, "/api/*"), halve}, "", "max-age=5"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				res := okResponse()
+				res.Header = http.Header{"Date": {time.Now().UTC().Format(http.TimeFormat)}}
+				if tC.header != "" {
+					res.Header.Set("Cache-Control", tC.header)
+				}
+				return res, nil
+			})
+			transport := &freshnessTransport{nil, &tC.policies, origin}
+
+			req, _ := http.NewRequest("GET", "http://api.com/api/items", nil)
+			res, err := transport.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := res.Header.Get("Cache-Control"); got != tC.want {
+				t.Errorf("unexpected Cache-Control for the cache: got %q, want %q", got, tC.want)
+			}
+			restoreCacheControl(res.Header)
+			if got := res.Header.Get("Cache-Control"); got != tC.header {
+				t.Errorf("unexpected Cache-Control for the clients: got %q, want %q", got, tC.header)
+			}
+		})
+	}
+}
+
+func TestFreshnessJitter(t *testing.T) {
+	jitter := FreshnessJitter(0.2)
+	for i := 0; i < 100; i++ {
+		got := jitter.Freshness(nil, nil, 100*time.Second)
+		if got < 80*time.Second || got > 100*time.Second {
+			t.Fatalf("unexpected lifetime: got %v, want between 80s and 100s", got)
+		}
+	}
+}
+
+func TestWithFreshness(t *testing.T) {
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header = http.Header{"Date": {time.Now().UTC().Format(http.TimeFormat)}, "Cache-Control": {"no-cache"}}
+		return res, nil
+	})
+	peer := NewPeer("http://a.com:3000", WithPeerTransport(origin), WithFreshness(FreshnessOverride(time.Minute, "*")))
+
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape("http://api.com/items"), nil)
+		peer.Handler().ServeHTTP(rr, req)
+		if got, want := rr.Header().Get("Cache-Control"), "no-cache"; got != want {
+			t.Errorf("unexpected Cache-Control: got %q, want %q", got, want)
+		}
+	}
+	if got, want := peer.Stats().Hits.Get(), int64(1); got != want {
+		t.Errorf("unexpected hits: got %d, want %d", got, want)
+	}
+}
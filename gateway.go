@@ -0,0 +1,158 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// Gateway is an http.Handler serving ordinary requests, like the ones
+// of browsers, through the pool. Like the virtual hosts of a reverse
+// proxy, the host of each request is mapped to the base URL of an
+// origin, for example:
+//
+//	origin, _ := url.Parse("https://origin.example.com/static")
+//	gw := forwardcache.NewGateway(peer, forwardcache.WithVirtualHost("cdn.example.com", origin))
+//	http.ListenAndServe(":80", gw)
+//
+// makes a GET on http://cdn.example.com/app.js?v=2 go through the pool
+// as https://origin.example.com/static/app.js?v=2. Only GET and HEAD
+// requests are served.
+type Gateway struct {
+	transport http.RoundTripper
+	hosts     map[string]*url.URL
+	buffers   httputil.BufferPool
+}
+
+// NewGateway creates a Gateway making its requests with rt, a Client
+// or a Peer of the pool.
+func NewGateway(rt http.RoundTripper, options ...func(*Gateway)) *Gateway {
+	g := &Gateway{
+		transport: rt,
+		hosts:     make(map[string]*url.URL),
+		buffers:   DefaultBufferPool,
+	}
+
+	for _, option := range options {
+		option(g)
+	}
+
+	return g
+}
+
+// WithVirtualHost maps the requests for host, with or without port, to
+// origin. Their paths are appended to the path of origin and their
+// queries are kept.
+func WithVirtualHost(host string, origin *url.URL) func(*Gateway) {
+	return func(g *Gateway) {
+		g.hosts[strings.ToLower(host)] = origin
+	}
+}
+
+// WithGatewayBufferPool lets you configure a custom buffer pool used to
+// copy the responses to the clients. Defaults to DefaultBufferPool.
+func WithGatewayBufferPool(b httputil.BufferPool) func(*Gateway) {
+	return func(g *Gateway) {
+		g.buffers = b
+	}
+}
+
+// ServeHTTP serves a request through the pool.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	origin, ok := g.origin(req)
+	if !ok {
+		http.Error(w, "unknown host", http.StatusMisdirectedRequest)
+		return
+	}
+
+	out, err := http.NewRequestWithContext(req.Context(), req.Method, origin.String(), nil)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	out.Header = req.Header.Clone()
+	if out.Header == nil {
+		out.Header = make(http.Header)
+	}
+	removeHopHeaders(out.Header)
+
+	res, err := g.transport.RoundTrip(relayInformational(w, out))
+	if err != nil {
+		log.Printf("http: gateway error: %v", err)
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+
+	removeHopHeaders(res.Header)
+	h := w.Header()
+	for k, v := range res.Header {
+		h[k] = append(h[k], v...)
+	}
+	w.WriteHeader(res.StatusCode)
+
+	delay := flushPolicy{}.delay(res.Header, res.ContentLength)
+	if err := copyBody(w, res.Body, res.ContentLength, g.buffers, delay); err != nil {
+		// aborts the response so that the client doesn't take a
+		// truncated body for a complete one
+		if req.Context().Err() == nil {
+			log.Printf("http: gateway error: %v", err)
+		}
+		panic(http.ErrAbortHandler)
+	}
+
+	for k, v := range res.Trailer {
+		h[http.TrailerPrefix+k] = v
+	}
+}
+
+// origin returns the URL of the origin resource of a request.
+func (g *Gateway) origin(req *http.Request) (*url.URL, bool) {
+	host := strings.ToLower(req.Host)
+	base, ok := g.hosts[host]
+	if !ok {
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			base, ok = g.hosts[h]
+		}
+	}
+	if !ok {
+		return nil, false
+	}
+
+	u := new(url.URL)
+	*u = *base
+	prefix := strings.TrimSuffix(base.EscapedPath(), "/")
+	u.Path = strings.TrimSuffix(base.Path, "/") + req.URL.Path
+	u.RawPath = ""
+	if req.URL.RawPath != "" {
+		u.RawPath = prefix + req.URL.EscapedPath()
+	}
+	u.RawQuery = req.URL.RawQuery
+	u.Fragment = ""
+	return u, true
+}
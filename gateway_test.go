@@ -0,0 +1,82 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestGateway(t *testing.T) {
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("X-Requested-URL", req.URL.String())
+		res.Header.Set("X-Requested-Connection", req.Header.Get("Connection"))
+		res.Header.Set("Connection", "close")
+		return res, nil
+	})
+
+	static, _ := url.Parse("https://origin.com/static/")
+	api, _ := url.Parse("http://api.origin.com:8080")
+	gw := NewGateway(transport,
+		WithVirtualHost("cdn.example.com", static),
+		WithVirtualHost("API.example.com", api),
+	)
+
+	testCases := []struct {
+		method string
+		host   string
+		target string
+		status int
+		want   string
+	}{
+		{"GET", "cdn.example.com", "/app.js?v=2", http.StatusOK, "https://origin.com/static/app.js?v=2"},
+		{"HEAD", "CDN.example.com:80", "/a%2Fb.js", http.StatusOK, "https://origin.com/static/a%2Fb.js"},
+		{"GET", "api.example.com", "/", http.StatusOK, "http://api.origin.com:8080/"},
+		{"GET", "other.example.com", "/app.js", http.StatusMisdirectedRequest, ""},
+		{"POST", "cdn.example.com", "/app.js", http.StatusMethodNotAllowed, ""},
+	}
+	for _, tC := range testCases {
+		req := httptest.NewRequest(tC.method, tC.target, nil)
+		req.Host = tC.host
+		req.Header.Set("Connection", "keep-alive")
+		rec := httptest.NewRecorder()
+		gw.ServeHTTP(rec, req)
+
+		res := rec.Result()
+		ioutil.ReadAll(res.Body)
+		if got, want := res.StatusCode, tC.status; got != want {
+			t.Errorf("unexpected status of %s %s%s: got %d, want %d", tC.method, tC.host, tC.target, got, want)
+			continue
+		}
+		if got, want := res.Header.Get("X-Requested-URL"), tC.want; got != want {
+			t.Errorf("unexpected origin of %s%s: got %q, want %q", tC.host, tC.target, got, want)
+		}
+		if tC.status != http.StatusOK {
+			continue
+		}
+		if got := res.Header.Get("X-Requested-Connection"); got != "" {
+			t.Errorf("unexpected Connection header sent: got %q, want none", got)
+		}
+		if got := res.Header.Get("Connection"); got != "" {
+			t.Errorf("unexpected Connection header returned: got %q, want none", got)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import "net/http"
+
+// hardenedMaxSize is the size of the largest response a hardened peer
+// stores.
+const hardenedMaxSize = 64 << 20
+
+// Hardened bundles the options securing a peer exposed to untrusted
+// clients:
+//
+//   - origins at private addresses are refused (WithDenyPrivateOrigins)
+//   - origin URLs are validated strictly, so that only absolute http and
+//     https URLs of at most 4096 bytes are fetched (WithStrictOrigins)
+//   - the requests between the peers are signed with secret
+//     (WithClusterSecret)
+//   - responses over 64MB or setting cookies are not stored
+//     (WithAdmission)
+//   - the Cookie and Authorization client headers are not forwarded to
+//     origins (WithDeniedHeaders)
+//
+// Options given after it override its settings, for example to allow
+// other schemes or to admit fewer responses:
+//
+//	peer := forwardcache.NewPeer(self,
+//		forwardcache.Hardened(secret),
+//		forwardcache.WithAllowedSchemes("https"),
+//	)
+func Hardened(secret []byte) func(*Peer) {
+	options := []func(*Peer){
+		WithDenyPrivateOrigins(),
+		WithStrictOrigins(),
+		WithClusterSecret(secret),
+		WithAdmission(AdmitAll(AdmitMaxSize(hardenedMaxSize), admitCookieless)),
+		WithDeniedHeaders("Cookie", "Authorization"),
+	}
+	return func(p *Peer) {
+		for _, option := range options {
+			option(p)
+		}
+	}
+}
+
+// admitCookieless admits the responses which don't set cookies, which
+// are meant for a single client.
+var admitCookieless = AdmissionFunc(func(req *http.Request, res *http.Response) bool {
+	return len(res.Header["Set-Cookie"]) == 0
+})
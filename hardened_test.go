@@ -0,0 +1,99 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestHardened(t *testing.T) {
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("X-Got-Cookie", req.Header.Get("Cookie"))
+		if strings.HasSuffix(req.URL.Path, "/session") {
+			res.Header.Set("Set-Cookie", "session=1")
+		}
+		return res, nil
+	})
+	secret := []byte("cluster secret")
+	peer := NewPeer("http://self.com:3000", WithPeerTransport(origin), Hardened(secret))
+
+	if got := string(peer.handler.auth.secret); got != string(secret) {
+		t.Errorf("unexpected cluster secret: got %q, want %q", got, secret)
+	}
+
+	testCases := []struct {
+		url    string
+		status int
+		cookie string
+		stored bool
+	}{
+		{"http://cdn.com/jquery.js", http.StatusOK, "", true},
+		{"http://cdn.com/session", http.StatusOK, "", false},
+		{"ftp://cdn.com/jquery.js", http.StatusBadRequest, "", false},
+		{"/jquery.js", http.StatusBadRequest, "", false},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.url, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape(tC.url), nil)
+			req.Header.Set("Cookie", "session=0")
+			peer.Handler().ServeHTTP(rr, req)
+
+			if rr.Code != tC.status {
+				t.Fatalf("unexpected status: got %d, want %d", rr.Code, tC.status)
+			}
+			if got := rr.Header().Get("X-Got-Cookie"); got != tC.cookie {
+				t.Errorf("unexpected forwarded cookie: got %q, want %q", got, tC.cookie)
+			}
+			if probe, _ := peer.Probe(tC.url); probe.Cached != tC.stored {
+				t.Errorf("unexpected stored response: got %v, want %v", probe.Cached, tC.stored)
+			}
+		})
+	}
+}
+
+func TestDenyPrivateOrigins(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer origin.Close()
+
+	testCases := []struct {
+		name    string
+		options []func(*Peer)
+		status  int
+	}{
+		{"allowed", nil, http.StatusOK},
+		{"denied", []func(*Peer){WithDenyPrivateOrigins()}, http.StatusBadGateway},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			peer := NewPeer("http://self.com:3000", tC.options...)
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape(origin.URL+"/"+tC.name), nil)
+			peer.Handler().ServeHTTP(rr, req)
+
+			if rr.Code != tC.status {
+				t.Errorf("unexpected status: got %d, want %d", rr.Code, tC.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package hashkey provides a cache hashing the keys before storing them
+// in an existing cache, for backends limiting the length or the
+// characters of their keys like memcached or filesystems.
+package hashkey
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"sync"
+
+	"github.com/gregjones/httpcache"
+)
+
+// Cache is an httpcache.Cache storing its values in an underlying cache
+// under the SHA-256 hex digest of their keys. It remembers the original
+// keys to list them. It is safe for concurrent access.
+type Cache struct {
+	c     httpcache.Cache
+	mu    sync.RWMutex
+	index map[string]string // original keys by hashed key
+}
+
+// New creates a new Cache with c as its underlying storage.
+func New(c httpcache.Cache) *Cache {
+	return &Cache{
+		c:     c,
+		index: make(map[string]string),
+	}
+}
+
+// Hash returns the key under which key is stored in the underlying cache.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Get returns the value stored for key.
+func (c *Cache) Get(key string) (resp []byte, ok bool) {
+	hash := Hash(key)
+	resp, ok = c.c.Get(hash)
+	if !ok {
+		// evicted by the underlying cache
+		c.mu.Lock()
+		delete(c.index, hash)
+		c.mu.Unlock()
+	}
+	return
+}
+
+// Set stores a value for key.
+func (c *Cache) Set(key string, resp []byte) {
+	hash := Hash(key)
+	c.mu.Lock()
+	c.index[hash] = key
+	c.mu.Unlock()
+
+	c.c.Set(hash, resp)
+}
+
+// Delete removes the value stored for key.
+func (c *Cache) Delete(key string) {
+	hash := Hash(key)
+	c.mu.Lock()
+	delete(c.index, hash)
+	c.mu.Unlock()
+
+	c.c.Delete(hash)
+}
+
+// Key returns the original key of a hashed key, if it was stored
+// through the cache.
+func (c *Cache) Key(hash string) (key string, ok bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	key, ok = c.index[hash]
+	return
+}
+
+// Keys returns the sorted original keys stored through the cache. Keys
+// evicted by the underlying cache itself are listed until they are
+// looked up again.
+func (c *Cache) Keys() []string {
+	c.mu.RLock()
+	keys := make([]string, 0, len(c.index))
+	for _, key := range c.index {
+		keys = append(keys, key)
+	}
+	c.mu.RUnlock()
+
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package hashkey
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestCache(t *testing.T) {
+	backend := httpcache.NewMemoryCache()
+	cache := New(backend)
+	long := "http://example.com/" + strings.Repeat("a", 300)
+
+	cache.Set(long, []byte("long"))
+	cache.Set("http://example.com/short", []byte("short"))
+	if val, ok := cache.Get(long); !ok || string(val) != "long" {
+		t.Errorf("unexpected value: got %q, want %q", val, "long")
+	}
+	if _, ok := backend.Get(long); ok {
+		t.Errorf("unexpected unhashed key '%s' in backend", long)
+	}
+	if val, ok := backend.Get(Hash(long)); !ok || string(val) != "long" {
+		t.Errorf("unexpected value in backend: got %q, want %q", val, "long")
+	}
+	if key, ok := cache.Key(Hash(long)); !ok || key != long {
+		t.Errorf("unexpected key: got %q, want %q", key, long)
+	}
+
+	want := []string{long, "http://example.com/short"}
+	if keys := cache.Keys(); !reflect.DeepEqual(keys, want) {
+		t.Errorf("unexpected keys: got %q, want %q", keys, want)
+	}
+
+	cache.Delete(long)
+	if _, ok := backend.Get(Hash(long)); ok {
+		t.Errorf("unexpected key '%s' in backend", long)
+	}
+
+	// evicted by the backend
+	backend.Delete(Hash("http://example.com/short"))
+	if _, ok := cache.Get("http://example.com/short"); ok {
+		t.Errorf("unexpected key '%s' in cache", "http://example.com/short")
+	}
+	if keys := cache.Keys(); len(keys) != 0 {
+		t.Errorf("unexpected keys: got %q, want none", keys)
+	}
+}
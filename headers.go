@@ -0,0 +1,92 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import "net/http"
+
+// headerPolicy filters the client headers forwarded to origins.
+type headerPolicy struct {
+	allow map[string]bool // if set, only these headers are forwarded
+	deny  map[string]bool
+}
+
+// enabled reports whether the policy modifies requests.
+func (f *headerPolicy) enabled() bool {
+	return f.allow != nil || f.deny != nil
+}
+
+// apply removes the filtered headers of an outgoing request.
+func (f *headerPolicy) apply(h http.Header) {
+	for k := range h {
+		if f.deny[k] || (f.allow != nil && !f.allow[k]) {
+			delete(h, k)
+		}
+	}
+}
+
+// WithAllowedHeaders forwards only the given client headers to origins,
+// all others are dropped. The headers set by the peer itself (like
+// X-Forwarded-For or User-Agent, see WithForwarded and WithUserAgent)
+// are added after filtering.
+func WithAllowedHeaders(headers ...string) func(*Peer) {
+	return func(p *Peer) {
+		p.headers.allow = headerSet(p.headers.allow, headers)
+	}
+}
+
+// WithDeniedHeaders drops the given client headers before fetching
+// from origins, like personalization headers (Cookie, Authorization)
+// that would make the shared cache incorrect.
+func WithDeniedHeaders(headers ...string) func(*Peer) {
+	return func(p *Peer) {
+		p.headers.deny = headerSet(p.headers.deny, headers)
+	}
+}
+
+// varyPolicy restricts the request headers the stored responses can
+// vary on.
+type varyPolicy struct {
+	allow map[string]bool // if set, only these headers are allowed
+}
+
+// allows reports whether a stored response can vary on a header.
+func (v *varyPolicy) allows(name string) bool {
+	return v.allow == nil || v.allow[http.CanonicalHeaderKey(name)]
+}
+
+// WithVaryHeaders stores only the responses varying on the given
+// request headers, like Accept-Encoding, and none of the others. The
+// cache keys and the routing only depend on the URLs, but a response
+// varying on a header is served to the clients sending the same value,
+// so that a vetted subset of headers protects a pool exposed beyond
+// trusted clients from cache poisoning and from variants flooding the
+// cache. Defaults to any header.
+func WithVaryHeaders(headers ...string) func(*Peer) {
+	return func(p *Peer) {
+		p.vary.allow = headerSet(p.vary.allow, headers)
+	}
+}
+
+func headerSet(set map[string]bool, headers []string) map[string]bool {
+	if set == nil {
+		set = make(map[string]bool, len(headers))
+	}
+	for _, h := range headers {
+		set[http.CanonicalHeaderKey(h)] = true
+	}
+	return set
+}
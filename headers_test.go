@@ -0,0 +1,118 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestHeaders(t *testing.T) {
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		var got []string
+		for k := range req.Header {
+			if k != "X-Forwarded-For" && k != "User-Agent" { // set by the proxy
+				got = append(got, k)
+			}
+		}
+		sort.Strings(got)
+		res := okResponse()
+		res.Header.Set("X-Got", strings.Join(got, ","))
+		return res, nil
+	})
+
+	testCases := []struct {
+		name    string
+		options []func(*Peer)
+		want    string
+	}{
+		{"all", nil, "Accept,Authorization,Cookie"},
+		{"deny", []func(*Peer){WithDeniedHeaders("cookie", "Authorization")}, "Accept"},
+		{"allow", []func(*Peer){WithAllowedHeaders("authorization")}, "Authorization"},
+		{"allow and deny", []func(*Peer){WithAllowedHeaders("Accept", "Cookie"), WithDeniedHeaders("Cookie")}, "Accept"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			peer := NewPeer("http://self.com:3000", append([]func(*Peer){WithPeerTransport(origin)}, tC.options...)...)
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape("http://cdn.com/jquery.js?"+tC.name), nil)
+			req.Header.Set("Accept", "*/*")
+			req.Header.Set("Authorization", "Bearer token")
+			req.Header.Set("Cookie", "session=1")
+			peer.Handler().ServeHTTP(rr, req)
+
+			if got := rr.Header().Get("X-Got"); got != tC.want {
+				t.Errorf("unexpected headers: got %q, want %q", got, tC.want)
+			}
+		})
+	}
+}
+
+func TestVaryHeaders(t *testing.T) {
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("Vary", strings.TrimPrefix(req.URL.Path, "/"))
+		return res, nil
+	})
+
+	testCases := []struct {
+		name    string
+		options []func(*Peer)
+		vary    string
+		stored  bool
+	}{
+		{"any", nil, "X-Forwarded-Host", true},
+		{"allowed", []func(*Peer){WithVaryHeaders("accept-encoding")}, "Accept-Encoding", true},
+		{"not allowed", []func(*Peer){WithVaryHeaders("Accept-Encoding")}, "X-Forwarded-Host", false},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			peer := NewPeer("http://self.com:3000", append([]func(*Peer){WithPeerTransport(origin)}, tC.options...)...)
+
+			u := "http://cdn.com/" + tC.vary
+			for i := 0; i < 2; i++ {
+				req := httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape(u), nil)
+				peer.Handler().ServeHTTP(httptest.NewRecorder(), req)
+			}
+			if probe, _ := peer.Probe(u); probe.Cached != tC.stored {
+				t.Errorf("unexpected stored response varying on %s: got %v, want %v", tC.vary, probe.Cached, tC.stored)
+			}
+		})
+	}
+}
+
+func TestNormalizedEscapes(t *testing.T) {
+	var fetched []string
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		fetched = append(fetched, req.URL.String())
+		return okResponse(), nil
+	})
+	peer := NewPeer("http://self.com:3000", WithPeerTransport(origin))
+
+	for _, u := range []string{"http://cdn.com/%7ejquery.js?v=%e2%82%ac", "http://cdn.com/~jquery.js?v=%E2%82%AC"} {
+		req := httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape(u), nil)
+		peer.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if want := []string{"http://cdn.com/~jquery.js?v=%E2%82%AC"}; len(fetched) != 1 || fetched[0] != want[0] {
+		t.Errorf("unexpected origin fetches: got %q, want %q", fetched, want)
+	}
+}
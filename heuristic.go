@@ -0,0 +1,113 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// heuristic is how long responses without explicit freshness stay
+// fresh, as a fraction of the time since they were last modified.
+type heuristic struct {
+	fraction float64       // 0 to disable
+	max      time.Duration // cap, 0 for none
+}
+
+// defaultHeuristic is the usual heuristic of caches (RFC 7234, 4.2.2).
+var defaultHeuristic = heuristic{0.1, 24 * time.Hour}
+
+// heuristicStatuses are the statuses cacheable by default (RFC 7231, 6.1).
+var heuristicStatuses = map[int]bool{
+	200: true, 203: true, 204: true, 206: true, 300: true, 301: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// lifetime returns the heuristic freshness lifetime of a response, 0 if
+// it has explicit freshness or can't be given one.
+func (h heuristic) lifetime(res *http.Response, now time.Time) time.Duration {
+	if h.fraction <= 0 || !heuristicStatuses[res.StatusCode] {
+		return 0
+	}
+	if _, ok := res.Header["Expires"]; ok {
+		return 0
+	}
+	for _, directive := range strings.Split(res.Header.Get("Cache-Control"), ",") {
+		name := strings.ToLower(strings.TrimSpace(directive))
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name = name[:i]
+		}
+		switch name {
+		case "max-age", "s-maxage", "no-cache", "no-store", "private":
+			return 0
+		}
+	}
+
+	modified, err := http.ParseTime(res.Header.Get("Last-Modified"))
+	if err != nil {
+		return 0
+	}
+	date, err := http.ParseTime(res.Header.Get("Date"))
+	if err != nil {
+		date = now
+	}
+	lifetime := time.Duration(float64(date.Sub(modified)) * h.fraction)
+	if h.max > 0 && lifetime > h.max {
+		lifetime = h.max
+	}
+	return lifetime
+}
+
+// heuristicTransport gives the origin responses without explicit
+// freshness a heuristic lifetime, as a max-age directive for the cache
+// only. It sits behind the cache.
+type heuristicTransport struct {
+	heuristic *heuristic
+	transport http.RoundTripper
+}
+
+func (t *heuristicTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	lifetime := t.heuristic.lifetime(res, time.Now())
+	if lifetime < time.Second {
+		return res, nil
+	}
+	cc := res.Header.Get("Cache-Control")
+	if cc != "" {
+		cc += ", "
+	}
+	cacheOnly(res.Header, cc+"max-age="+strconv.Itoa(int(lifetime.Seconds())))
+	return res, nil
+}
+
+// WithHeuristicFreshness lets the peer cache the responses without
+// explicit freshness (a max-age directive or an Expires header) for
+// fraction of the time since they were last modified, capped to max
+// unless max is 0. A fraction of 0 disables heuristic caching: such
+// responses are then stored but revalidated on every request.
+// Defaults to 0.1 and 24 hours.
+func WithHeuristicFreshness(fraction float64, max time.Duration) func(*Peer) {
+	return func(p *Peer) {
+		p.heuristic = heuristic{fraction, max}
+	}
+}
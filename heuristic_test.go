@@ -0,0 +1,92 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestHeuristicLifetime(t *testing.T) {
+	now := time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC)
+	tenDaysAgo := now.Add(-240 * time.Hour).Format(http.TimeFormat)
+
+	testCases := []struct {
+		desc      string
+		heuristic heuristic
+		status    int
+		header    http.Header
+		want      time.Duration
+	}{
+		{"default", defaultHeuristic, 200, http.Header{"Last-Modified": {tenDaysAgo}}, 24 * time.Hour},
+		{"uncapped", heuristic{0.1, 0}, 200, http.Header{"Last-Modified": {tenDaysAgo}}, 24 * time.Hour},
+		{"fraction", heuristic{0.05, 0}, 200, http.Header{"Last-Modified": {tenDaysAgo}}, 12 * time.Hour},
+		{"cap", heuristic{0.1, time.Hour}, 200, http.Header{"Last-Modified": {tenDaysAgo}}, time.Hour},
+		{"disabled", heuristic{0, 0}, 200, http.Header{"Last-Modified": {tenDaysAgo}}, 0},
+		{"not modified", defaultHeuristic, 200, http.Header{}, 0},
+		{"expires", defaultHeuristic, 200, http.Header{"Last-Modified": {tenDaysAgo}, "Expires": {"0"}}, 0},
+		{"max-age", defaultHeuristic, 200, http.Header{"Last-Modified": {tenDaysAgo}, "Cache-Control": {"public, max-age=60"}}, 0},
+		{"no-cache", defaultHeuristic, 200, http.Header{"Last-Modified": {tenDaysAgo}, "Cache-Control": {"No-Cache"}}, 0},
+		{"public", defaultHeuristic, 200, http.Header{"Last-Modified": {tenDaysAgo}, "Cache-Control": {"public"}}, 24 * time.Hour},
+		{"status", defaultHeuristic, 302, http.Header{"Last-Modified": {tenDaysAgo}}, 0},
+	}
+	for _, tC := range testCases {
+		res := &http.Response{StatusCode: tC.status, Header: tC.header}
+		if got := tC.heuristic.lifetime(res, now); got != tC.want {
+			t.Errorf("%s: unexpected lifetime: got %v, want %v", tC.desc, got, tC.want)
+		}
+	}
+}
+
+func TestHeuristicFreshness(t *testing.T) {
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header = http.Header{
+			"Date":          {time.Now().UTC().Format(http.TimeFormat)},
+			"Last-Modified": {time.Now().Add(-240 * time.Hour).UTC().Format(http.TimeFormat)},
+		}
+		return res, nil
+	})
+
+	testCases := []struct {
+		desc       string
+		peer       *Peer
+		xFromCache string
+	}{
+		{"default", NewPeer("http://self.com:3000", WithPeerTransport(origin)), "1"},
+		{"disabled", NewPeer("http://self.com:3000", WithPeerTransport(origin), WithHeuristicFreshness(0, 0)), ""},
+	}
+	for _, tC := range testCases {
+		for i, want := range []string{"", tC.xFromCache} {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/proxy?q="+url.QueryEscape("http://cdn.com/jquery.js"), nil)
+			tC.peer.Handler().ServeHTTP(rr, req)
+
+			if xFromCache := rr.HeaderMap.Get(httpcache.XFromCache); xFromCache != want {
+				t.Errorf("%s: request %d: unexpected %q header: got %q, want %q", tC.desc, i, httpcache.XFromCache, xFromCache, want)
+			}
+			if cc := rr.HeaderMap.Get("Cache-Control"); cc != "" {
+				t.Errorf("%s: request %d: unexpected Cache-Control: got %q, want none", tC.desc, i, cc)
+			}
+		}
+	}
+}
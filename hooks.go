@@ -0,0 +1,99 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+// CacheStatus tells how a response was served, see RoundTripDone.
+type CacheStatus int
+
+const (
+	// CacheNone is the status of the requests failing or sent directly
+	// to the origins.
+	CacheNone CacheStatus = iota
+	// CacheMiss is the status of the responses fetched from the origins
+	// by a peer.
+	CacheMiss
+	// CacheHit is the status of the responses served from the cache of
+	// a peer.
+	CacheHit
+)
+
+var cacheStatusNames = [...]string{"none", "miss", "hit"}
+
+func (s CacheStatus) String() string {
+	if s < 0 || int(s) >= len(cacheStatusNames) {
+		return "unknown"
+	}
+	return cacheStatusNames[s]
+}
+
+// RoundTripDone describes a request routed by a Client, see
+// ClientHooks.
+type RoundTripDone struct {
+	Request  *http.Request
+	Peer     string        // base URL of the last peer tried, "" if sent directly to the origin
+	Duration time.Duration // time to get the response headers, failovers included
+	Cache    CacheStatus
+	Err      error
+}
+
+// ClientHooks are functions called by a Client while routing requests,
+// so that applications can record their own metrics. They are called
+// synchronously and must be safe for concurrent use.
+type ClientHooks struct {
+	// OnSelectPeer is called with each peer a request is about to be
+	// sent to, the next ones on failover.
+	OnSelectPeer func(req *http.Request, peer string)
+
+	// OnRoundTripDone is called once the response headers of a request
+	// are received, or once it failed.
+	OnRoundTripDone func(RoundTripDone)
+}
+
+// WithHooks sets the hooks called by the client while routing requests.
+// Defaults to none.
+func WithHooks(h ClientHooks) func(*Client) {
+	return func(c *Client) {
+		c.hooks = h
+	}
+}
+
+// selectPeer calls the OnSelectPeer hook, if any.
+func (h *ClientHooks) selectPeer(req *http.Request, peer string) {
+	if h.OnSelectPeer != nil {
+		h.OnSelectPeer(req, peer)
+	}
+}
+
+// cacheStatus returns the cache status of a response routed through the
+// pool.
+func cacheStatus(res *http.Response) CacheStatus {
+	switch {
+	case res == nil:
+		return CacheNone
+	case res.Header.Get(httpcache.XFromCache) != "":
+		return CacheHit
+	default:
+		return CacheMiss
+	}
+}
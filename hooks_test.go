@@ -0,0 +1,89 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestClientHooks(t *testing.T) {
+	hash := newHashMock().
+		with("http://a.com:3000", 0).
+		with("http://b.com:3000", 1).
+		with("http://some.url/res-a.js", 0)
+
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host == "a.com:3000" {
+			return nil, errors.New("connection refused")
+		}
+		res := okResponse()
+		if req.URL.Host == "b.com:3000" {
+			res.Header.Set(httpcache.XFromCache, "1")
+		}
+		return res, nil
+	})
+
+	testCases := []struct {
+		name     string
+		url      string
+		failover int
+		selected string
+		peer     string
+		cache    CacheStatus
+		err      bool
+	}{
+		{"failed", "http://some.url/res-a.js", 0, "http://a.com:3000", "http://a.com:3000", CacheNone, true},
+		{"failover", "http://some.url/res-a.js", 1, "http://a.com:3000 http://b.com:3000", "http://b.com:3000", CacheHit, false},
+		{"bypassed", "http://localhost/res-a.js", 0, "", "", CacheNone, false},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			var selected []string
+			var done []RoundTripDone
+			client := NewClient(
+				WithPool("http://a.com:3000", "http://b.com:3000"),
+				WithHashFn(hash.fn),
+				WithClientTransport(transport),
+				WithDirectTransport(transport),
+				WithFailover(tC.failover),
+				WithBypassPrivate(),
+				WithHooks(ClientHooks{
+					OnSelectPeer:    func(req *http.Request, peer string) { selected = append(selected, peer) },
+					OnRoundTripDone: func(d RoundTripDone) { done = append(done, d) },
+				}),
+			)
+
+			req, _ := http.NewRequest("GET", tC.url, nil)
+			client.RoundTrip(req)
+
+			if got := strings.Join(selected, " "); got != tC.selected {
+				t.Errorf("unexpected selected peers: got %q, want %q", got, tC.selected)
+			}
+			if len(done) != 1 {
+				t.Fatalf("unexpected round trips done: got %d, want 1", len(done))
+			}
+			if d := done[0]; d.Request != req || d.Peer != tC.peer || d.Cache != tC.cache || (d.Err != nil) != tC.err {
+				t.Errorf("unexpected round trip: got %+v, want peer %q, cache %v, error %v", d, tC.peer, tC.cache, tC.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package forwardcache
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+// WithOwnerLeases collapses the origin fetches of a resource across the
+// pool for ttl, so that the peers fetching resources they don't own, as
+// with the LeastLoaded or BoundedLoad strategies or on failover, don't
+// all reach its origin: before fetching an origin, a peer asks the owner
+// of the resource for a lease on it (see protocol.LeaseURL). The owner
+// grants it unless another peer holds it, in which case the request is
+// forwarded to the holder, which serves it from its cache or its own
+// fetch. The owner takes leases on its own fetches the same way, and the
+// peers failing to get a lease fetch the origin. All the peers of the
+// pool should use it, along with WithRequestCoalescing so that the
+// holder collapses the requests forwarded while it fetches.
+// Defaults to no leases.
+func WithOwnerLeases(ttl time.Duration) func(*Peer) {
+	return func(p *Peer) {
+		p.leaseTTL = ttl
+	}
+}
+
+// ownerLeases takes the leases on the origin fetches of a peer.
+type ownerLeases struct {
+	table  *leaseTable // of the resources owned, nil if disabled
+	self   string
+	client *Client
+}
+
+// holder returns the peer holding the lease on fetching resource, or ""
+// if it couldn't be known.
+func (l *ownerLeases) holder(ctx context.Context, resource string) string {
+	owner := l.client.Owner(resource)
+	if owner == "" || owner == l.self {
+		return l.table.acquire(resource, l.self, time.Now())
+	}
+	holder, err := l.client.lease(ctx, owner, resource, l.self)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("forwardcache: %v", err)
+		}
+		return ""
+	}
+	return holder
+}
+
+// leaseTable holds the leases granted on the resources owned by a peer.
+type leaseTable struct {
+	ttl    time.Duration
+	mu     sync.Mutex
+	leases map[string]lease
+	sweep  int // size of leases at which the expired ones are deleted
+}
+
+type lease struct {
+	holder  string
+	expires time.Time
+}
+
+func newLeaseTable(ttl time.Duration) *leaseTable {
+	return &leaseTable{ttl: ttl, leases: make(map[string]lease)}
+}
+
+// acquire grants the lease on resource to holder unless another peer
+// holds it, and returns the peer holding it.
+func (t *leaseTable) acquire(resource, holder string, now time.Time) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if l, ok := t.leases[resource]; ok && now.Before(l.expires) {
+		return l.holder
+	}
+	if len(t.leases) >= t.sweep {
+		for k, l := range t.leases {
+			if !now.Before(l.expires) {
+				delete(t.leases, k)
+			}
+		}
+		t.sweep = 2*len(t.leases) + 64
+	}
+	t.leases[resource] = lease{holder, now.Add(t.ttl)}
+	return holder
+}
+
+type leaseKey struct{}
+
+// withLease returns a copy of req forwarded by another peer to the
+// holder of its lease, fetched without asking for a lease again.
+func withLease(req *http.Request) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), leaseKey{}, true))
+}
+
+// leaseTransport fetches the origins once the peer holds their lease,
+// forwarding the requests to the holder otherwise.
+type leaseTransport struct {
+	leases    *ownerLeases
+	transport http.RoundTripper
+}
+
+func (t *leaseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	l := t.leases
+	if l.table == nil || (req.Method != "GET" && req.Method != "HEAD") || req.Context().Value(leaseKey{}) != nil {
+		return t.transport.RoundTrip(req)
+	}
+
+	resource := req.URL.String()
+	holder := l.holder(req.Context(), resource)
+	if holder == "" || holder == l.self {
+		return t.transport.RoundTrip(req)
+	}
+	explain(req, "lease held by %s", holder)
+	res, err := l.client.fetchLeased(req, holder, resource, l.self)
+	if err != nil {
+		if req.Context().Err() != nil {
+			return nil, err
+		}
+		log.Printf("forwardcache: %v", err)
+		return t.transport.RoundTrip(req) // the holder may be gone
+	}
+	res.Header.Del(httpcache.XFromCache) // fetched by this peer's cache
+	return res, nil
+}
+
+// serveLease grants the lease on fetching resource to the peer of the
+// pool asking for it, replying 204 No Content, or replies 409 Conflict
+// with the peer holding it.
+func (p *proxy) serveLease(w http.ResponseWriter, req *http.Request, resource string) {
+	if p.leases.table == nil {
+		http.Error(w, "leases disabled", http.StatusNotImplemented)
+		return
+	}
+	asker := req.Header.Get(protocol.LeaseHolderHeader)
+	if !p.leases.client.inPool(asker) {
+		http.Error(w, "unknown lease holder", http.StatusBadRequest)
+		return
+	}
+
+	holder := p.leases.table.acquire(resource, asker, time.Now())
+	if holder == asker {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set(protocol.LeaseHolderHeader, holder)
+	w.WriteHeader(http.StatusConflict)
+}
+
+// inPool reports whether peer is a peer of the pool.
+func (c *Client) inPool(peer string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	_, ok := c.bases[peer]
+	return ok
+}
+
+// lease asks owner for the lease on fetching resource for self and
+// returns the peer holding it.
+func (c *Client) lease(ctx context.Context, owner, resource, self string) (string, error) {
+	u, err := protocol.LeaseURL(owner, c.path, resource)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(protocol.LeaseHolderHeader, self)
+	if c.apiKey != "" {
+		req.Header.Set(protocol.APIKeyHeader, c.apiKey)
+	}
+	if c.secret != nil {
+		protocol.SignRequest(req, c.secret, resource, time.Now())
+	}
+
+	res, err := c.transport.RoundTrip(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusNoContent:
+		return self, nil
+	case http.StatusConflict:
+		if holder := res.Header.Get(protocol.LeaseHolderHeader); holder != "" {
+			return holder, nil
+		}
+	}
+	return "", fmt.Errorf("forwardcache: getting the lease on %s from %s: %s", resource, owner, res.Status)
+}
+
+// fetchLeased forwards req for resource to holder, the peer holding its
+// lease.
+func (c *Client) fetchLeased(req *http.Request, holder, resource, self string) (*http.Response, error) {
+	u := c.peerHandlerURL(holder, resource)
+	fwd, err := http.NewRequest(req.Method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	fwd = fwd.WithContext(req.Context())
+	fwd.Header.Set(protocol.LeaseHolderHeader, self)
+	if c.apiKey != "" {
+		fwd.Header.Set(protocol.APIKeyHeader, c.apiKey)
+	}
+	if c.secret != nil {
+		protocol.SignRequest(fwd, c.secret, resource, time.Now())
+	}
+	return c.transport.RoundTrip(fwd)
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package forwardcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+func TestLeaseTable(t *testing.T) {
+	table := newLeaseTable(time.Minute)
+	now := time.Now()
+
+	testCases := []struct {
+		name   string
+		holder string
+		at     time.Time
+		want   string
+	}{
+		{"granted", "http://a.com", now, "http://a.com"},
+		{"renewed by holder", "http://a.com", now.Add(time.Second), "http://a.com"},
+		{"held by another", "http://b.com", now.Add(time.Second), "http://a.com"},
+		{"expired", "http://b.com", now.Add(time.Minute), "http://b.com"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			if got := table.acquire("http://cdn.com/a.js", tC.holder, tC.at); got != tC.want {
+				t.Errorf("unexpected holder: got %q, want %q", got, tC.want)
+			}
+		})
+	}
+}
+
+func TestOwnerLeases(t *testing.T) {
+	var fetches int32
+	origin := WithPeerTransport(roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		atomic.AddInt32(&fetches, 1)
+		return okResponse(), nil
+	}))
+	secret := WithClusterSecret([]byte("s3cr3t"))
+
+	handlers := make([]http.Handler, 3)
+	var pool []string
+	for i := range handlers {
+		i := i
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			handlers[i].ServeHTTP(w, req)
+		}))
+		defer server.Close()
+		pool = append(pool, server.URL)
+	}
+	var peers []*Peer
+	for i, self := range pool {
+		peer := NewPeer(self, secret, origin, WithOwnerLeases(time.Minute))
+		defer peer.Close()
+		peer.SetPool(pool...)
+		handlers[i] = peer.Handler()
+		peers = append(peers, peer)
+	}
+
+	u := "http://cdn.com/a.js"
+	owner := peers[0]
+	for _, peer := range peers {
+		if peer.Owner(u) == peer.self {
+			owner = peer
+		}
+	}
+	var order []*Peer // the owner last, once a non-owner holds the lease
+	for _, peer := range peers {
+		if peer != owner {
+			order = append(order, peer)
+		}
+	}
+	order = append(order, owner)
+
+	for i, peer := range order {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape(u), nil)
+		peer.Handler().ServeHTTP(res, req)
+		if res.Code != http.StatusOK || res.Body.String() != "OK" {
+			t.Errorf("unexpected response of peer %d: got %d %q, want 200 %q", i, res.Code, res.Body.String(), "OK")
+		}
+	}
+	if got := atomic.LoadInt32(&fetches); got != 1 {
+		t.Errorf("unexpected origin fetches: got %d, want 1", got)
+	}
+}
+
+func TestServeLease(t *testing.T) {
+	secret := []byte("s3cr3t")
+	pool := []string{"http://a.com", "http://self.com:3000"}
+	leased := NewPeer("http://self.com:3000", WithClusterSecret(secret), WithOwnerLeases(time.Minute))
+	leased.SetPool(pool...)
+	disabled := NewPeer("http://self.com:3000", WithClusterSecret(secret))
+	disabled.SetPool(pool...)
+
+	resource := "http://cdn.com/a.js"
+	testCases := []struct {
+		name   string
+		peer   *Peer
+		holder string
+		sign   bool
+		want   int
+		held   string
+	}{
+		{"unsigned", leased, "http://a.com", false, http.StatusUnauthorized, ""},
+		{"unknown holder", leased, "http://b.com", true, http.StatusBadRequest, ""},
+		{"granted", leased, "http://a.com", true, http.StatusNoContent, ""},
+		{"renewed", leased, "http://a.com", true, http.StatusNoContent, ""},
+		{"held", leased, "http://self.com:3000", true, http.StatusConflict, "http://a.com"},
+		{"disabled", disabled, "http://a.com", true, http.StatusNotImplemented, ""},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			u, _ := protocol.LeaseURL("http://self.com:3000", protocol.DefaultPath, resource)
+			req := httptest.NewRequest("GET", u.String(), nil)
+			req.Header.Set(protocol.LeaseHolderHeader, tC.holder)
+			if tC.sign {
+				protocol.SignRequest(req, secret, resource, time.Now())
+			}
+			res := httptest.NewRecorder()
+			tC.peer.Handler().ServeHTTP(res, req)
+
+			if res.Code != tC.want {
+				t.Errorf("unexpected status: got %d, want %d", res.Code, tC.want)
+			}
+			if got := res.Header().Get(protocol.LeaseHolderHeader); got != tC.held {
+				t.Errorf("unexpected holder: got %q, want %q", got, tC.held)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// limits are the rate limits of a Client, global and per peer.
+type limits struct {
+	global    *tokenBucket
+	peerRate  float64
+	peerBurst int
+	mu        sync.Mutex // guards peers
+	peers     map[string]*tokenBucket
+}
+
+func (l *limits) peer(peer string) *tokenBucket {
+	if l.peerRate <= 0 {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.peers[peer]
+	if !ok {
+		if l.peers == nil {
+			l.peers = make(map[string]*tokenBucket)
+		}
+		b = newTokenBucket(l.peerRate, l.peerBurst)
+		l.peers[peer] = b
+	}
+	return b
+}
+
+// forget drops the rate limits of peers removed from the pool.
+func (l *limits) forget(peers []string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, peer := range peers {
+		delete(l.peers, peer)
+	}
+}
+
+// wait waits for the global and the peer's rate limits.
+func (l *limits) wait(ctx context.Context, peer string, stats *ClientStats) error {
+	var waited time.Duration
+	for _, b := range []*tokenBucket{l.global, l.peer(peer)} {
+		if b == nil {
+			continue
+		}
+		d, err := b.wait(ctx)
+		if err != nil {
+			return err
+		}
+		waited += d
+	}
+
+	if waited > 0 {
+		stats.RateLimited.Add(1)
+		stats.LimiterWait.Observe(waited.Seconds())
+	}
+	return nil
+}
+
+// WithRateLimit limits the rate of requests the client makes to the
+// pool to rate requests per second, with bursts of burst requests.
+// Requests over the limit wait, or fail if their context is done
+// first. Defaults to no limit.
+func WithRateLimit(rate float64, burst int) func(*Client) {
+	return func(c *Client) {
+		c.limits.global = newTokenBucket(rate, burst)
+	}
+}
+
+// WithPeerRateLimit limits the rate of requests the client makes to
+// each peer to rate requests per second, with bursts of burst requests.
+// Requests over the limit wait, or fail if their context is done
+// first. Defaults to no limit.
+func WithPeerRateLimit(rate float64, burst int) func(*Client) {
+	return func(c *Client) {
+		c.limits.peerRate = rate
+		c.limits.peerBurst = burst
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClientRateLimit(t *testing.T) {
+	hash := newHashMock().
+		with("http://a.com:3000", 0).
+		with("http://b.com:3000", 1).
+		with("http://some.url/res-a.js", 0).
+		with("http://some.url/res-b.js", 1)
+
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return okResponse(), nil
+	})
+
+	client := NewClient(
+		WithPool("http://a.com:3000", "http://b.com:3000"),
+		WithHashFn(hash.fn),
+		WithClientTransport(transport),
+		WithPeerRateLimit(0.001, 1),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	testCases := []struct {
+		url string
+		err error
+	}{
+		{"http://some.url/res-a.js", nil},
+		{"http://some.url/res-b.js", nil},
+		{"http://some.url/res-a.js", context.DeadlineExceeded},
+		{"http://some.url/res-b.js", context.DeadlineExceeded},
+	}
+	for _, tC := range testCases {
+		req, _ := http.NewRequest("GET", tC.url, nil)
+		if _, err := client.RoundTrip(req.WithContext(ctx)); err != tC.err {
+			t.Errorf("unexpected error for %q: got %v, want %v", tC.url, err, tC.err)
+		}
+	}
+
+	client = NewClient(
+		WithPool("http://a.com:3000", "http://b.com:3000"),
+		WithClientTransport(transport),
+		WithRateLimit(20, 1),
+	)
+	for i := 0; i < 3; i++ {
+		req, _ := http.NewRequest("GET", "http://some.url/res-a.js", nil)
+		if _, err := client.RoundTrip(req); err != nil {
+			t.Fatalf("unexpected error: got %q, want <nil>", err)
+		}
+	}
+
+	stats := client.ClientStats().Snapshot()
+	if stats.Requests != 3 || stats.RateLimited != 2 || stats.LimiterWait.Count != 2 {
+		t.Errorf("unexpected stats: got %+v", stats)
+	}
+	if stats.LimiterWait.Sum <= 0 {
+		t.Errorf("unexpected limiter wait: got %f, want > 0", stats.LimiterWait.Sum)
+	}
+}
@@ -0,0 +1,243 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mikegleasonjr/forwardcache/consistenthash"
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+// PeerLoad is the load a peer reports, see protocol.LoadURL.
+type PeerLoad struct {
+	InFlight int64 `json:"inFlight"` // requests for origins being served
+	CPUs     int   `json:"cpus"`
+	Capacity int64 `json:"capacity,omitempty"` // of the cache in bytes, 0 if unknown
+}
+
+// perCPU returns the requests in flight per CPU.
+func (l PeerLoad) perCPU() float64 {
+	if l.CPUs < 1 {
+		return float64(l.InFlight)
+	}
+	return float64(l.InFlight) / float64(l.CPUs)
+}
+
+// serveLoad reports the load of the peer, see protocol.LoadURL. With a
+// cluster secret, the requests must be signed.
+func (p *proxy) serveLoad(w http.ResponseWriter, req *http.Request) {
+	if p.auth.secret != nil && req.Header.Get(protocol.SignatureHeader) == "" {
+		p.stats.Unauthorized.Add(1)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(PeerLoad{InFlight: p.inFlight.Get(), CPUs: runtime.NumCPU(), Capacity: p.capacity})
+}
+
+// fetchLoad gets the load reported by a peer.
+func (c *Client) fetchLoad(ctx context.Context, peer string) (PeerLoad, error) {
+	var load PeerLoad
+	u, err := protocol.LoadURL(peer, c.path)
+	if err != nil {
+		return load, err
+	}
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return load, err
+	}
+	req = req.WithContext(ctx)
+	if c.apiKey != "" {
+		req.Header.Set(protocol.APIKeyHeader, c.apiKey)
+	}
+	if c.secret != nil {
+		protocol.SignRequest(req, c.secret, "1", time.Now())
+	}
+
+	res, err := c.transport.RoundTrip(req)
+	if err != nil {
+		return load, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return load, fmt.Errorf("forwardcache: getting the load of %s: %s", peer, res.Status)
+	}
+	err = json.NewDecoder(res.Body).Decode(&load)
+	return load, err
+}
+
+// LeastLoaded chooses the peers with fallback, but prefers the least
+// loaded of the first replicas peers of a resource (its owner and the
+// next peers to fail over to), so that requests are spread away from
+// busy peers while a resource is cached by few of them. The loads are
+// the requests in flight per CPU the peers report (see
+// protocol.LoadURL), fetched in the background at most every interval
+// when requests are made. The peers whose load is unknown come last.
+// replicas is at least 1, 1 routing like fallback.
+func LeastLoaded(fallback Strategy, replicas int, interval time.Duration) Strategy {
+	if replicas < 1 {
+		replicas = 1
+	}
+	return &leastLoaded{
+		fallback: fallback,
+		replicas: replicas,
+		reports:  &loadReports{interval: interval},
+	}
+}
+
+type leastLoaded struct {
+	fallback Strategy
+	replicas int
+	peers    []string
+	reports  *loadReports // shared by the strategies of all the pools
+}
+
+func (s *leastLoaded) WithPool(peers, added, removed []string) Strategy {
+	return s.with(s.fallback.WithPool(peers, added, removed), peers)
+}
+
+// with returns the strategy with a fallback for a pool.
+func (s *leastLoaded) with(fallback Strategy, peers []string) Strategy {
+	return &leastLoaded{
+		fallback: fallback,
+		replicas: s.replicas,
+		peers:    append([]string(nil), peers...),
+		reports:  s.reports,
+	}
+}
+
+func (s *leastLoaded) Peers(dst []string, key string, n int) []string {
+	s.reports.refresh(s.peers)
+	m := n
+	if m < s.replicas {
+		m = s.replicas
+	}
+	candidates := s.fallback.Peers(nil, key, m)
+	replicas := candidates
+	if len(replicas) > s.replicas {
+		replicas = replicas[:s.replicas]
+	}
+	loads := s.reports.get(replicas)
+	sort.Stable(byLoad{replicas, loads})
+
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return append(dst, candidates...)
+}
+
+func (s *leastLoaded) bind(c *Client) {
+	s.reports.fetch = c.fetchLoad
+	if b, ok := s.fallback.(clientStrategy); ok {
+		b.bind(c)
+	}
+}
+
+func (s *leastLoaded) ring() *consistenthash.Map {
+	if r, ok := s.fallback.(ringStrategy); ok {
+		return r.ring()
+	}
+	return nil
+}
+
+func (s *leastLoaded) withRing(ring *consistenthash.Map, peers []string) Strategy {
+	if r, ok := s.fallback.(ringStrategy); ok {
+		return s.with(r.withRing(ring, peers), peers)
+	}
+	return s.with(&consistentHash{ring}, peers)
+}
+
+// byLoad sorts peers by increasing load.
+type byLoad struct {
+	peers []string
+	loads []float64
+}
+
+func (b byLoad) Len() int           { return len(b.peers) }
+func (b byLoad) Less(i, j int) bool { return b.loads[i] < b.loads[j] }
+func (b byLoad) Swap(i, j int) {
+	b.peers[i], b.peers[j] = b.peers[j], b.peers[i]
+	b.loads[i], b.loads[j] = b.loads[j], b.loads[i]
+}
+
+// loadReports are the last loads reported by the peers of a pool.
+type loadReports struct {
+	interval   time.Duration
+	fetch      func(ctx context.Context, peer string) (PeerLoad, error)
+	mu         sync.Mutex // guards the fields below
+	loads      map[string]float64
+	fetched    time.Time
+	refreshing bool
+}
+
+// get returns the loads of peers, +Inf for the unknown ones.
+func (r *loadReports) get(peers []string) []float64 {
+	loads := make([]float64, len(peers))
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, peer := range peers {
+		load, ok := r.loads[peer]
+		if !ok {
+			load = math.Inf(1)
+		}
+		loads[i] = load
+	}
+	return loads
+}
+
+// refresh fetches the loads of peers in the background if they are
+// older than the interval and not being fetched already.
+func (r *loadReports) refresh(peers []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fetch == nil || r.refreshing || time.Since(r.fetched) < r.interval {
+		return
+	}
+	r.refreshing = true
+	go func() {
+		loads := make(map[string]float64, len(peers))
+		for _, peer := range peers {
+			ctx, cancel := context.WithTimeout(context.Background(), r.timeout())
+			if load, err := r.fetch(ctx, peer); err == nil {
+				loads[peer] = load.perCPU()
+			}
+			cancel()
+		}
+
+		r.mu.Lock()
+		r.loads, r.fetched, r.refreshing = loads, time.Now(), false
+		r.mu.Unlock()
+	}()
+}
+
+// timeout returns how long fetching the load of a peer can take.
+func (r *loadReports) timeout() time.Duration {
+	if r.interval < time.Second {
+		return time.Second
+	}
+	return r.interval
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+func TestServeLoad(t *testing.T) {
+	testCases := []struct {
+		name    string
+		options []func(*Peer)
+		status  int
+	}{
+		{"open", nil, http.StatusOK},
+		{"unsigned", []func(*Peer){WithClusterSecret([]byte("secret"))}, http.StatusUnauthorized},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			peer := NewPeer("http://self.com:3000", tC.options...)
+
+			u, _ := protocol.LoadURL("http://self.com:3000", protocol.DefaultPath)
+			rr := httptest.NewRecorder()
+			peer.Handler().ServeHTTP(rr, httptest.NewRequest("GET", u.String(), nil))
+
+			if rr.Code != tC.status {
+				t.Fatalf("unexpected status: got %d, want %d", rr.Code, tC.status)
+			}
+			if rr.Code != http.StatusOK {
+				return
+			}
+			var load PeerLoad
+			if err := json.NewDecoder(rr.Body).Decode(&load); err != nil {
+				t.Fatalf("unexpected error decoding the load: %v", err)
+			}
+			if want := (PeerLoad{InFlight: 0, CPUs: runtime.NumCPU()}); load != want {
+				t.Errorf("unexpected load: got %+v, want %+v", load, want)
+			}
+		})
+	}
+}
+
+func TestLeastLoaded(t *testing.T) {
+	loads := map[string]int64{"a.com:3000": 8, "b.com:3000": 1, "c.com:3000": 4}
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Query().Get(protocol.LoadParam) == "" {
+			return okResponse(), nil
+		}
+		body := fmt.Sprintf(`{"inFlight":%d,"cpus":1}`, loads[req.URL.Host])
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+	})
+
+	testCases := []struct {
+		name     string
+		replicas int
+		want     string // the first peer of every resource, "" for the fallback's
+	}{
+		{"owner only", 1, ""},
+		{"all replicas", 3, "http://b.com:3000"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			strategy := LeastLoaded(ConsistentHash(protocol.DefaultReplicas, protocol.Hash), tC.replicas, time.Hour)
+			client := NewClient(WithPool(strategyPool...), WithClientTransport(transport), WithStrategy(strategy))
+			fallback := NewClient(WithPool(strategyPool...))
+
+			reports := strategy.(*leastLoaded).reports
+			client.Owner("http://some.url/res.js") // starts fetching the loads
+			for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+				reports.mu.Lock()
+				fetched := !reports.fetched.IsZero()
+				reports.mu.Unlock()
+				if fetched {
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("unexpected loads: never fetched")
+				}
+			}
+
+			for i := 0; i < 20; i++ {
+				u := fmt.Sprintf("http://some.url/res-%d.js", i)
+				want := tC.want
+				if want == "" {
+					want = fallback.Owner(u)
+				}
+				if got := client.Owner(u); got != want {
+					t.Errorf("unexpected owner of %q: got %q, want %q", u, got, want)
+				}
+			}
+		})
+	}
+}
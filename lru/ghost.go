@@ -0,0 +1,67 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lru
+
+import "container/list"
+
+// ghost is an LRU index of evicted keys and their sizes. It is not
+// safe for concurrent access, the Cache guards it.
+type ghost struct {
+	cap   int
+	size  int
+	items map[string]*list.Element
+	list  *list.List
+}
+
+type ghostItem struct {
+	key  string
+	size int
+}
+
+func newGhost(cap int) *ghost {
+	return &ghost{
+		cap:   cap,
+		items: make(map[string]*list.Element),
+		list:  list.New(),
+	}
+}
+
+func (g *ghost) contains(key string) bool {
+	_, ok := g.items[key]
+	return ok
+}
+
+func (g *ghost) add(key string, size int) {
+	g.remove(key)
+	if size > g.cap {
+		return
+	}
+
+	g.items[key] = g.list.PushFront(&ghostItem{key, size})
+	g.size += size
+	for g.size > g.cap {
+		g.remove(g.list.Back().Value.(*ghostItem).key)
+	}
+}
+
+func (g *ghost) remove(key string) {
+	if e, ok := g.items[key]; ok {
+		delete(g.items, key)
+		g.list.Remove(e)
+		g.size -= e.Value.(*ghostItem).size
+	}
+}
@@ -24,16 +24,54 @@ import (
 	"github.com/gregjones/httpcache"
 )
 
+// MultiCache is a cache able to store and delete several keys at once,
+// like remote backends supporting batches. Cache is a MultiCache.
+type MultiCache interface {
+	httpcache.Cache
+	SetMulti(items map[string][]byte)
+	DeleteMulti(keys []string)
+}
+
 // Cache is an LRU cache. It is safe for concurrent access.
 // It itself uses a cache for its underlying storage.
 type Cache struct {
 	c     httpcache.Cache
 	mu    sync.Mutex
-	cap   int
+	cap   int // remaining capacity
+	size  int // total capacity
 	items map[string]*cacheItem
 	list  *list.List
+	ghost *ghost
+	stats Stats
+
+	overhead  int  // bytes counted per entry, see WithEntryOverhead
+	keys      bool // whether the length of the keys is counted
+	oversized OversizedPolicy
+	cost      func(resp []byte) int
 }
 
+// Stats are statistics about the cache.
+type Stats struct {
+	Hits      int64 // lookups of indexed keys
+	Misses    int64 // lookups of unknown keys
+	Evictions int64 // keys evicted to make room for new ones
+	GhostHits int64 // misses that would have been hits with the ghost capacity
+	Oversized int64 // values not stored because they are larger than the capacity
+}
+
+// OversizedPolicy is what a Cache does with values larger than its
+// whole capacity.
+type OversizedPolicy int
+
+const (
+	// RejectOversized doesn't store them. The other entries are kept
+	// and a previous value of the key is removed.
+	RejectOversized OversizedPolicy = iota
+	// EvictForOversized evicts all the other entries and stores them,
+	// leaving the cache over its capacity until the next Set.
+	EvictForOversized
+)
+
 type cacheItem struct {
 	key     string
 	size    int
@@ -45,9 +83,14 @@ func (c *Cache) Get(key string) (resp []byte, ok bool) {
 	c.mu.Lock()
 	item, ok := c.items[key]
 	if !ok {
+		c.stats.Misses++
+		if c.ghost != nil && c.ghost.contains(key) {
+			c.stats.GhostHits++
+		}
 		c.mu.Unlock()
 		return
 	}
+	c.stats.Hits++
 	c.list.MoveToFront(item.element)
 	c.mu.Unlock()
 	return c.c.Get(key)
@@ -55,32 +98,139 @@ func (c *Cache) Get(key string) (resp []byte, ok bool) {
 
 // Set adds or refreshes a value in the cache.
 func (c *Cache) Set(key string, resp []byte) {
-	victims := []string{} // to prevent lock contention of slow storage
-	var added int
+	c.mu.Lock()
+	if c.reject(key, resp) {
+		c.mu.Unlock()
+		c.c.Delete(key)
+		return
+	}
+	c.add(key, resp)
+	victims := c.evict(1)
+	c.mu.Unlock()
 
+	c.deleteMulti(victims)
+	c.c.Set(key, resp)
+}
+
+// SetMulti adds or refreshes several values in the cache, taking the
+// lock once and storing them with a single call if the underlying
+// cache is a MultiCache. If they don't all fit, which ones are kept
+// is unspecified.
+func (c *Cache) SetMulti(items map[string][]byte) {
+	var rejected []string
 	c.mu.Lock()
+	for key, resp := range items {
+		if c.reject(key, resp) {
+			rejected = append(rejected, key)
+			continue
+		}
+		c.add(key, resp)
+	}
+	victims := c.evict(1)
+	c.mu.Unlock()
+
+	victims = append(victims, rejected...)
+	stored := items
+	for _, key := range victims {
+		if _, ok := items[key]; ok && len(stored) == len(items) {
+			stored = make(map[string][]byte, len(items))
+			for k, v := range items {
+				stored[k] = v
+			}
+		}
+		delete(stored, key)
+	}
+
+	c.deleteMulti(victims)
+	if m, ok := c.c.(MultiCache); ok {
+		m.SetMulti(stored)
+		return
+	}
+	for key, resp := range stored {
+		c.c.Set(key, resp)
+	}
+}
+
+// reject tells whether a value is not stored because of the oversized
+// policy, forgetting the previous value of the key if so, the cache
+// being locked.
+func (c *Cache) reject(key string, resp []byte) bool {
+	if c.oversized != RejectOversized || c.sizeOf(key, resp) <= c.size {
+		return false
+	}
+	c.stats.Oversized++
+	if item, exists := c.items[key]; exists {
+		c.purge(item)
+	}
+	return true
+}
+
+// add indexes a value, the cache being locked.
+func (c *Cache) add(key string, resp []byte) {
+	var added int
+	size := c.sizeOf(key, resp)
 	if item, exists := c.items[key]; exists {
 		c.list.MoveToFront(item.element)
-		added = len(resp) - item.size
-		item.size = len(resp)
+		added = size - item.size
+		item.size = size
 	} else {
-		item := &cacheItem{key: key, size: len(resp)}
+		if c.ghost != nil {
+			c.ghost.remove(key)
+		}
+		item := &cacheItem{key: key, size: size}
 		item.element = c.list.PushFront(item)
 		c.items[key] = item
 		added = item.size
 	}
 	c.cap -= added
-	for c.cap < 0 && c.list.Len() > 1 {
+}
+
+// sizeOf returns the bytes counted for an entry.
+func (c *Cache) sizeOf(key string, resp []byte) int {
+	size := len(resp)
+	if c.cost != nil {
+		size = c.cost(resp)
+	}
+	if c.keys {
+		return len(key) + c.overhead + size
+	}
+	return size
+}
+
+// evict evicts the least recently used items until the cache is within
+// its capacity or only keep items are left, and returns their keys.
+func (c *Cache) evict(keep int) []string {
+	var victims []string // deleted once unlocked, to prevent lock contention of slow storage
+	for c.cap < 0 && c.list.Len() > keep {
 		item := c.list.Back().Value.(*cacheItem)
 		victims = append(victims, item.key)
 		c.purge(item)
+		c.stats.Evictions++
+		if c.ghost != nil {
+			c.ghost.add(item.key, item.size)
+		}
 	}
+	return victims
+}
+
+// Resize changes the capacity of the cache to cap bytes, evicting
+// the least recently used items if needed.
+func (c *Cache) Resize(cap int) {
+	c.mu.Lock()
+	c.cap += cap - c.size
+	c.size = cap
+	victims := c.evict(0)
 	c.mu.Unlock()
 
-	for _, key := range victims {
-		c.c.Delete(key)
-	}
-	c.c.Set(key, resp)
+	c.deleteMulti(victims)
+}
+
+// Capacity returns the capacity of the cache in bytes.
+func (c *Cache) Capacity() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.size
 }
 
 // Delete removes the provided key from the cache.
@@ -94,19 +244,108 @@ func (c *Cache) Delete(key string) {
 	c.c.Delete(key)
 }
 
+// DeleteMulti removes several keys from the cache, taking the lock once
+// and deleting them with a single call if the underlying cache is a
+// MultiCache.
+func (c *Cache) DeleteMulti(keys []string) {
+	c.mu.Lock()
+	for _, key := range keys {
+		if item, exists := c.items[key]; exists {
+			c.purge(item)
+		}
+	}
+	c.mu.Unlock()
+
+	c.deleteMulti(keys)
+}
+
+// deleteMulti deletes keys from the underlying cache.
+func (c *Cache) deleteMulti(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	if m, ok := c.c.(MultiCache); ok {
+		m.DeleteMulti(keys)
+		return
+	}
+	for _, key := range keys {
+		c.c.Delete(key)
+	}
+}
+
 func (c *Cache) purge(item *cacheItem) {
 	delete(c.items, item.key)
 	c.list.Remove(item.element)
 	c.cap += item.size
 }
 
+// Stats returns the statistics of the cache.
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.stats
+}
+
 // New creates a new Cache with c as its underlying storage
 // and a capacity of cap bytes.
-func New(c httpcache.Cache, cap int) httpcache.Cache {
-	return &Cache{
+func New(c httpcache.Cache, cap int, options ...func(*Cache)) *Cache {
+	cache := &Cache{
 		c:     c,
 		cap:   cap,
+		size:  cap,
 		items: make(map[string]*cacheItem),
 		list:  list.New(),
 	}
+
+	for _, option := range options {
+		option(cache)
+	}
+
+	return cache
+}
+
+// WithGhost makes the cache remember the keys it evicted, up to
+// cap bytes worth of entries, without storing their values. Misses
+// on those keys are counted as ghost hits in the cache's Stats: the
+// hits the cache would have had with cap more bytes of capacity.
+// Defaults to no ghost.
+func WithGhost(cap int) func(*Cache) {
+	return func(c *Cache) {
+		c.ghost = newGhost(cap)
+	}
+}
+
+// DefaultEntryOverhead estimates the memory used by the index of the
+// cache and by a map based underlying cache for each entry, in bytes.
+const DefaultEntryOverhead = 160
+
+// WithEntryOverhead counts the length of the keys and overhead bytes per
+// entry (see DefaultEntryOverhead) against the capacity of the cache, in
+// addition to the length of the values, so that the capacity matches the
+// memory used more closely when the keys are long URLs.
+// Defaults to only counting the length of the values.
+func WithEntryOverhead(overhead int) func(*Cache) {
+	return func(c *Cache) {
+		c.keys = true
+		c.overhead = overhead
+	}
+}
+
+// WithOversizedPolicy sets what the cache does with values larger than
+// its whole capacity. Rejected values are counted in the cache's Stats.
+// Defaults to RejectOversized.
+func WithOversizedPolicy(policy OversizedPolicy) func(*Cache) {
+	return func(c *Cache) {
+		c.oversized = policy
+	}
+}
+
+// WithCost specifies the bytes counted for the values against the
+// capacity, for example for values standing for larger ones in
+// simulations. Defaults to their length.
+func WithCost(cost func(resp []byte) int) func(*Cache) {
+	return func(c *Cache) {
+		c.cost = cost
+	}
 }
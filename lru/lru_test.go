@@ -28,7 +28,7 @@ import (
 
 func TestSet(t *testing.T) {
 	cache := httpcache.NewMemoryCache()
-	lru := New(cache, 10)
+	lru := New(cache, 10, WithOversizedPolicy(EvictForOversized))
 	tests := []struct {
 		key     string
 		val     []byte
@@ -114,6 +114,33 @@ func TestDelete(t *testing.T) {
 	}
 }
 
+func TestResize(t *testing.T) {
+	cache := httpcache.NewMemoryCache()
+	lru := New(cache, 10)
+
+	lru.Set("key1", randBytes(4))
+	lru.Set("key2", randBytes(4))
+	lru.Resize(5)
+	if _, exists := cache.Get("key1"); exists {
+		t.Errorf("expected key '%s' to be evicted", "key1")
+	}
+	if _, exists := cache.Get("key2"); !exists {
+		t.Errorf("expected key '%s' to be found in cache", "key2")
+	}
+
+	lru.Resize(0)
+	if _, exists := cache.Get("key2"); exists {
+		t.Errorf("expected key '%s' to be evicted", "key2")
+	}
+
+	lru.Resize(10)
+	lru.Set("key3", randBytes(4))
+	lru.Set("key4", randBytes(4))
+	if _, exists := cache.Get("key3"); !exists || lru.Capacity() != 10 {
+		t.Errorf("expected key '%s' to be found in cache", "key3")
+	}
+}
+
 func TestRace(t *testing.T) {
 	var wg sync.WaitGroup
 	cache := httpcache.NewMemoryCache()
@@ -147,3 +174,169 @@ func randBytes(n int) []byte {
 	}
 	return b
 }
+
+func TestGhost(t *testing.T) {
+	cache := httpcache.NewMemoryCache()
+	lru := New(cache, 10, WithGhost(10))
+
+	lru.Set("key1", randBytes(5)) // key1
+	lru.Set("key2", randBytes(5)) // key2, key1
+	lru.Set("key3", randBytes(5)) // key3, key2 - ghost: key1
+	lru.Set("key4", randBytes(5)) // key4, key3 - ghost: key2, key1
+	lru.Set("key5", randBytes(5)) // key5, key4 - ghost: key3, key2
+
+	lru.Get("key1") // miss
+	lru.Get("key2") // ghost hit
+	lru.Get("key3") // ghost hit
+	lru.Get("key5") // hit
+
+	want := Stats{Hits: 1, Misses: 3, Evictions: 3, GhostHits: 2}
+	if got := lru.Stats(); got != want {
+		t.Errorf("unexpected stats: got %+v, want %+v", got, want)
+	}
+
+	lru.Set("key3", randBytes(5)) // key3, key5 - ghost: key4, key2
+	lru.Get("key3")               // hit
+	lru.Get("key4")               // ghost hit
+
+	want = Stats{Hits: 2, Misses: 4, Evictions: 4, GhostHits: 3}
+	if got := lru.Stats(); got != want {
+		t.Errorf("unexpected stats: got %+v, want %+v", got, want)
+	}
+}
+
+// multiCache counts the batches it receives.
+type multiCache struct {
+	*httpcache.MemoryCache
+	sets, deletes int
+}
+
+func (c *multiCache) SetMulti(items map[string][]byte) {
+	c.sets++
+	for k, v := range items {
+		c.Set(k, v)
+	}
+}
+
+func (c *multiCache) DeleteMulti(keys []string) {
+	c.deletes++
+	for _, k := range keys {
+		c.Delete(k)
+	}
+}
+
+func TestMulti(t *testing.T) {
+	backend := &multiCache{MemoryCache: httpcache.NewMemoryCache()}
+	lru := New(backend, 10)
+
+	lru.SetMulti(map[string][]byte{"key1": randBytes(4), "key2": randBytes(4)})
+	if backend.sets != 1 {
+		t.Errorf("unexpected number of batches stored: got %d, want %d", backend.sets, 1)
+	}
+	for _, key := range []string{"key1", "key2"} {
+		if _, exists := lru.Get(key); !exists {
+			t.Errorf("expected key '%s' to be found in cache", key)
+		}
+	}
+
+	// key1 and key2 are evicted in a single batch
+	lru.Set("key3", randBytes(10))
+	if backend.deletes != 1 {
+		t.Errorf("unexpected number of batches deleted: got %d, want %d", backend.deletes, 1)
+	}
+
+	lru.SetMulti(map[string][]byte{"key4": randBytes(4), "key5": randBytes(4)})
+	lru.DeleteMulti([]string{"key4", "key5"})
+	for _, key := range []string{"key3", "key4", "key5"} {
+		if _, exists := backend.Get(key); exists {
+			t.Errorf("unexpected key '%s' in cache", key)
+		}
+	}
+	if backend.deletes != 3 {
+		t.Errorf("unexpected number of batches deleted: got %d, want %d", backend.deletes, 3)
+	}
+
+	// a batch larger than the capacity only keeps what fits
+	lru.SetMulti(map[string][]byte{"key6": randBytes(6), "key7": randBytes(6)})
+	stored := 0
+	for _, key := range []string{"key6", "key7"} {
+		if _, exists := backend.Get(key); exists {
+			stored++
+		}
+	}
+	if stored != 1 {
+		t.Errorf("unexpected number of keys stored: got %d, want %d", stored, 1)
+	}
+}
+
+func TestOversized(t *testing.T) {
+	cache := httpcache.NewMemoryCache()
+	lru := New(cache, 10)
+
+	lru.Set("key1", randBytes(4))
+	lru.Set("key2", randBytes(4))
+	lru.Set("key2", randBytes(12))
+	lru.Set("key3", randBytes(11))
+	if _, exists := cache.Get("key1"); !exists {
+		t.Errorf("expected key '%s' to be found in cache", "key1")
+	}
+	for _, key := range []string{"key2", "key3"} {
+		if _, exists := cache.Get(key); exists {
+			t.Errorf("unexpected key '%s' in cache", key)
+		}
+	}
+
+	lru.SetMulti(map[string][]byte{"key4": randBytes(4), "key5": randBytes(20)})
+	for key, want := range map[string]bool{"key1": true, "key4": true, "key5": false} {
+		if _, exists := cache.Get(key); exists != want {
+			t.Errorf("unexpected presence of key '%s': got %t, want %t", key, exists, want)
+		}
+	}
+
+	// the capacity left is the one of the stored values
+	lru.Set("key6", randBytes(2))
+	if _, exists := cache.Get("key1"); !exists {
+		t.Errorf("expected key '%s' to be found in cache", "key1")
+	}
+	if got, want := lru.Stats().Oversized, int64(3); got != want {
+		t.Errorf("unexpected oversized count: got %d, want %d", got, want)
+	}
+}
+
+func TestEntryOverhead(t *testing.T) {
+	cache := httpcache.NewMemoryCache()
+	lru := New(cache, 30, WithEntryOverhead(6))
+
+	lru.Set("key1", randBytes(4)) // 4 + 6 + 4 bytes
+	lru.Set("key2", randBytes(4))
+	if _, exists := cache.Get("key1"); !exists {
+		t.Errorf("expected key '%s' to be found in cache", "key1")
+	}
+
+	lru.Set("key3", randBytes(4))
+	if _, exists := cache.Get("key1"); exists {
+		t.Errorf("expected key '%s' to be evicted", "key1")
+	}
+	if _, exists := cache.Get("key2"); !exists {
+		t.Errorf("expected key '%s' to be found in cache", "key2")
+	}
+}
+
+func TestCost(t *testing.T) {
+	cache := httpcache.NewMemoryCache()
+	lru := New(cache, 30, WithCost(func(resp []byte) int { return 10 * len(resp) }))
+
+	lru.Set("key1", randBytes(1)) // 10 bytes
+	lru.Set("key2", randBytes(2)) // 20 bytes
+	if _, exists := cache.Get("key1"); !exists {
+		t.Errorf("expected key '%s' to be found in cache", "key1")
+	}
+
+	lru.Set("key3", randBytes(1))
+	if _, exists := cache.Get("key1"); exists {
+		t.Errorf("expected key '%s' to be evicted", "key1")
+	}
+	if got, want := lru.Stats().Evictions, int64(1); got != want {
+		t.Errorf("unexpected evictions: got %d, want %d", got, want)
+	}
+}
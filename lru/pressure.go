@@ -0,0 +1,146 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lru
+
+import (
+	"io/ioutil"
+	"math"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Pressure shrinks the capacity of a Cache when the memory used by the
+// process approaches a limit, to prevent the process from being killed
+// for running out of memory, and grows it back to its original capacity
+// once the pressure is gone. Start it with WatchMemory.
+type Pressure struct {
+	cache    *Cache
+	capacity int     // the original capacity of the cache
+	limit    uint64  // bytes
+	high     float64 // ratio of the limit above which the cache shrinks
+	low      float64 // ratio of the limit below which the cache grows
+	used     func() uint64
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// WatchMemory checks the memory used by the process every interval and
+// resizes c accordingly. When the memory used exceeds 90% of limit, the
+// capacity of c is reduced in proportion to the excess. Once under 80%,
+// it grows back by 10% of its original capacity per check. A limit of 0
+// uses the memory limit of the process' cgroup or, if none, the Go
+// runtime's memory limit (GOMEMLIMIT); the cache is never resized if
+// none is set. Stop must be called to stop watching.
+func WatchMemory(c *Cache, limit uint64, interval time.Duration) *Pressure {
+	if limit == 0 {
+		limit = memoryLimit()
+	}
+
+	p := &Pressure{
+		cache:    c,
+		capacity: c.Capacity(),
+		limit:    limit,
+		high:     0.9,
+		low:      0.8,
+		used:     memoryUsed,
+		stop:     make(chan struct{}),
+	}
+	if limit > 0 {
+		go p.watch(interval)
+	}
+	return p
+}
+
+// Stop stops watching the memory and restores the original capacity.
+func (p *Pressure) Stop() {
+	p.once.Do(func() {
+		close(p.stop)
+		p.cache.Resize(p.capacity)
+	})
+}
+
+func (p *Pressure) watch(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			p.check()
+		case <-p.stop:
+			return
+		}
+	}
+}
+
+// check resizes the cache according to the memory used.
+func (p *Pressure) check() {
+	used := float64(p.used())
+	limit := float64(p.limit)
+	capacity := p.cache.Capacity()
+
+	switch {
+	case used > p.high*limit:
+		// frees the excess, assuming the cache holds it
+		capacity -= int(used - p.high*limit)
+		if capacity < 0 {
+			capacity = 0
+		}
+		p.cache.Resize(capacity)
+	case used < p.low*limit && capacity < p.capacity:
+		capacity += p.capacity / 10
+		if capacity > p.capacity {
+			capacity = p.capacity
+		}
+		p.cache.Resize(capacity)
+	}
+}
+
+// memoryUsed returns the memory obtained from the OS by the runtime and
+// not released yet, an estimate of the resident memory of the process.
+func memoryUsed() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.Sys - m.HeapReleased
+}
+
+// memoryLimit returns the memory limit of the process' cgroup (v2 or
+// v1), or the runtime's memory limit, or 0 if none is set.
+func memoryLimit() uint64 {
+	for _, path := range []string{
+		"/sys/fs/cgroup/memory.max",
+		"/sys/fs/cgroup/memory/memory.limit_in_bytes",
+	} {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		// "max" or a huge number when unlimited
+		if limit, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64); err == nil && limit < 1<<62 {
+			return limit
+		}
+	}
+
+	if limit := debug.SetMemoryLimit(-1); limit != math.MaxInt64 {
+		return uint64(limit)
+	}
+	return 0
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package lru
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestPressure(t *testing.T) {
+	lru := New(httpcache.NewMemoryCache(), 1000)
+	used := uint64(0)
+	p := WatchMemory(lru, 0, time.Hour)
+	p.limit, p.used = 10000, func() uint64 { return used }
+	defer p.Stop()
+
+	testCases := []struct {
+		used uint64
+		want int
+	}{
+		{5000, 1000}, // no pressure
+		{9500, 500},  // frees the 500 bytes above 9000
+		{9500, 0},
+		{8500, 0},   // between the watermarks
+		{7000, 100}, // grows by 10%
+		{7000, 200},
+	}
+	for _, tC := range testCases {
+		used = tC.used
+		p.check()
+		if got := lru.Capacity(); got != tC.want {
+			t.Errorf("unexpected capacity with %d bytes used: got %d, want %d", tC.used, got, tC.want)
+		}
+	}
+
+	p.Stop()
+	if got := lru.Capacity(); got != 1000 {
+		t.Errorf("unexpected capacity after stop: got %d, want %d", got, 1000)
+	}
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package memcached provides a cache storing responses in memcached
+// servers, sharded with consistent hashing. Responses larger than the
+// item size limit of memcached are stored in several chunks.
+package memcached
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/mikegleasonjr/forwardcache/consistenthash"
+	"github.com/mikegleasonjr/forwardcache/hashkey"
+)
+
+// DefaultChunkSize is the size of the largest value stored in a single
+// item, under the default 1MB item size limit of memcached leaving room
+// for the item's own overhead.
+const DefaultChunkSize = 1000 * 1000
+
+// chunked flags the items listing the chunks of a response.
+const chunked = 1
+
+// Cache is an httpcache.Cache storing responses in memcached servers.
+// Errors of the servers are treated as misses.
+type Cache struct {
+	client    *memcache.Client
+	chunkSize int
+	replicas  int
+	hashFn    consistenthash.Hash
+	timeout   time.Duration
+}
+
+// New creates a Cache sharding the responses over servers, given as
+// host:port addresses or unix socket paths.
+func New(servers []string, options ...func(*Cache)) (*Cache, error) {
+	c := &Cache{
+		chunkSize: DefaultChunkSize,
+		replicas:  50,
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	s, err := newSelector(servers, c.replicas, c.hashFn)
+	if err != nil {
+		return nil, err
+	}
+	c.client = memcache.NewFromSelector(s)
+	c.client.Timeout = c.timeout
+	return c, nil
+}
+
+// WithChunkSize specifies the size of the largest value stored in
+// a single item. It must be under the item size limit of the servers.
+// Defaults to DefaultChunkSize.
+func WithChunkSize(size int) func(*Cache) {
+	return func(c *Cache) {
+		c.chunkSize = size
+	}
+}
+
+// WithReplicas specifies the number of server replicas on the consistent hash.
+// Defaults to 50.
+func WithReplicas(r int) func(*Cache) {
+	return func(c *Cache) {
+		c.replicas = r
+	}
+}
+
+// WithHashFn specifies the hash function of the consistent hash.
+// Defaults to crc32.ChecksumIEEE.
+func WithHashFn(h consistenthash.Hash) func(*Cache) {
+	return func(c *Cache) {
+		c.hashFn = h
+	}
+}
+
+// WithTimeout specifies the read and write timeout of the connections
+// to the servers. Defaults to 100ms.
+func WithTimeout(d time.Duration) func(*Cache) {
+	return func(c *Cache) {
+		c.timeout = d
+	}
+}
+
+// Get returns the response stored for key.
+func (c *Cache) Get(key string) (resp []byte, ok bool) {
+	item, err := c.client.Get(hashkey.Hash(key))
+	if err != nil {
+		return nil, false
+	}
+	if item.Flags != chunked {
+		return item.Value, true
+	}
+
+	keys, ok := chunkKeys(item)
+	if !ok {
+		return nil, false
+	}
+	items, err := c.client.GetMulti(keys)
+	if err != nil {
+		return nil, false
+	}
+	for _, k := range keys {
+		chunk, ok := items[k]
+		if !ok {
+			// evicted by the servers
+			return nil, false
+		}
+		resp = append(resp, chunk.Value...)
+	}
+	return resp, true
+}
+
+// Set stores a response for key. Chunks are stored under keys unique to
+// each Set, so that concurrent Sets never mix their chunks.
+func (c *Cache) Set(key string, resp []byte) {
+	name := hashkey.Hash(key)
+	if len(resp) <= c.chunkSize {
+		c.client.Set(&memcache.Item{Key: name, Value: resp})
+		return
+	}
+
+	gen := strconv.FormatInt(time.Now().UnixNano(), 36)
+	n := (len(resp) + c.chunkSize - 1) / c.chunkSize
+	for i := 0; i < n; i++ {
+		end := (i + 1) * c.chunkSize
+		if end > len(resp) {
+			end = len(resp)
+		}
+		chunk := &memcache.Item{Key: chunkKey(name, gen, i), Value: resp[i*c.chunkSize : end]}
+		if err := c.client.Set(chunk); err != nil {
+			return
+		}
+	}
+	// the chunks are listed once they are all stored
+	c.client.Set(&memcache.Item{Key: name, Value: []byte(gen + " " + strconv.Itoa(n)), Flags: chunked})
+}
+
+// Delete removes the response stored for key.
+func (c *Cache) Delete(key string) {
+	name := hashkey.Hash(key)
+	item, err := c.client.Get(name)
+	c.client.Delete(name)
+	if err != nil || item.Flags != chunked {
+		return
+	}
+	keys, _ := chunkKeys(item)
+	for _, k := range keys {
+		c.client.Delete(k)
+	}
+}
+
+// chunkKeys returns the keys of the chunks listed by an item.
+func chunkKeys(item *memcache.Item) ([]string, bool) {
+	fields := strings.Fields(string(item.Value))
+	if len(fields) != 2 {
+		return nil, false
+	}
+	n, err := strconv.Atoi(fields[1])
+	if err != nil || n < 0 {
+		return nil, false
+	}
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = chunkKey(item.Key, fields[0], i)
+	}
+	return keys, true
+}
+
+func chunkKey(name, gen string, i int) string {
+	return name + ":" + gen + ":" + strconv.Itoa(i)
+}
+
+// selector picks the servers of the keys on a consistent hash.
+type selector struct {
+	ring  *consistenthash.Map
+	addrs map[string]net.Addr
+	order []net.Addr
+}
+
+func newSelector(servers []string, replicas int, fn consistenthash.Hash) (*selector, error) {
+	s := &selector{
+		ring:  consistenthash.New(replicas, fn),
+		addrs: make(map[string]net.Addr, len(servers)),
+	}
+	for _, server := range servers {
+		var addr net.Addr
+		var err error
+		if strings.Contains(server, "/") {
+			addr, err = net.ResolveUnixAddr("unix", server)
+		} else {
+			addr, err = net.ResolveTCPAddr("tcp", server)
+		}
+		if err != nil {
+			return nil, err
+		}
+		s.addrs[server] = addr
+		s.order = append(s.order, addr)
+		s.ring.Add(server)
+	}
+	return s, nil
+}
+
+func (s *selector) PickServer(key string) (net.Addr, error) {
+	// the chunks of a response are spread over the servers
+	server := s.ring.Get(key)
+	if server == "" {
+		return nil, memcache.ErrNoServers
+	}
+	return s.addrs[server], nil
+}
+
+func (s *selector) Each(f func(net.Addr) error) error {
+	for _, addr := range s.order {
+		if err := f(addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
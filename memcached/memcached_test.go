@@ -0,0 +1,178 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package memcached
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// server is a memcached server supporting the commands used by Cache.
+type server struct {
+	l     net.Listener
+	mu    sync.Mutex
+	items map[string]item
+}
+
+type item struct {
+	flags string
+	value []byte
+}
+
+func newServer(t *testing.T) *server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &server{l: l, items: make(map[string]item)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *server) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			return
+		}
+
+		s.mu.Lock()
+		switch fields[0] {
+		case "get", "gets":
+			for _, key := range fields[1:] {
+				if it, ok := s.items[key]; ok {
+					fmt.Fprintf(conn, "VALUE %s %s %d 0\r\n%s\r\n", key, it.flags, len(it.value), it.value)
+				}
+			}
+			io.WriteString(conn, "END\r\n")
+		case "set":
+			size, _ := strconv.Atoi(fields[4])
+			value := make([]byte, size+2)
+			io.ReadFull(r, value)
+			s.items[fields[1]] = item{fields[2], value[:size]}
+			io.WriteString(conn, "STORED\r\n")
+		case "delete":
+			if _, ok := s.items[fields[1]]; ok {
+				delete(s.items, fields[1])
+				io.WriteString(conn, "DELETED\r\n")
+			} else {
+				io.WriteString(conn, "NOT_FOUND\r\n")
+			}
+		default:
+			io.WriteString(conn, "ERROR\r\n")
+		}
+		s.mu.Unlock()
+	}
+}
+
+func (s *server) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.items)
+}
+
+func TestCache(t *testing.T) {
+	s1, s2 := newServer(t), newServer(t)
+	defer s1.l.Close()
+	defer s2.l.Close()
+
+	cache, err := New([]string{s1.l.Addr().String(), s2.l.Addr().String()}, WithChunkSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(map[string][]byte)
+	for i := 0; i < 20; i++ {
+		key := "http://example.com/" + strconv.Itoa(i)
+		values[key] = randBytes(i * 30)
+		cache.Set(key, values[key])
+	}
+	for key, want := range values {
+		if val, ok := cache.Get(key); !ok || !bytes.Equal(val, want) {
+			t.Errorf("unexpected value for '%s': got %d bytes, want %d", key, len(val), len(want))
+		}
+	}
+	if s1.len() == 0 || s2.len() == 0 {
+		t.Errorf("unexpected sharding: got %d and %d items", s1.len(), s2.len())
+	}
+
+	for key := range values {
+		cache.Delete(key)
+		if _, ok := cache.Get(key); ok {
+			t.Errorf("unexpected key '%s' in cache", key)
+		}
+	}
+	if n := s1.len() + s2.len(); n != 0 {
+		t.Errorf("unexpected items left: got %d, want %d", n, 0)
+	}
+}
+
+func TestMissingChunk(t *testing.T) {
+	s := newServer(t)
+	defer s.l.Close()
+
+	cache, _ := New([]string{s.l.Addr().String()}, WithChunkSize(10))
+	cache.Set("key", randBytes(25))
+	s.mu.Lock()
+	for key := range s.items {
+		if strings.HasSuffix(key, ":1") {
+			delete(s.items, key)
+		}
+	}
+	s.mu.Unlock()
+
+	if _, ok := cache.Get("key"); ok {
+		t.Errorf("unexpected key '%s' in cache", "key")
+	}
+}
+
+func TestNoServers(t *testing.T) {
+	cache, _ := New(nil)
+	cache.Set("key", randBytes(10))
+	if _, ok := cache.Get("key"); ok {
+		t.Errorf("unexpected key '%s' in cache", "key")
+	}
+}
+
+func randBytes(n int) []byte {
+	b := make([]byte, n)
+	rand.Read(b)
+	return b
+}
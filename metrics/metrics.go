@@ -0,0 +1,186 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metrics exposes the statistics of forwardcache peers and
+// clients to Prometheus.
+//
+//	peer := forwardcache.NewPeer("http://10.0.0.1:3000", metrics.WithMetrics(nil))
+//	http.Handle("/metrics", promhttp.Handler())
+//
+// The counters are those of forwardcache.Stats, per origin host, and of
+// forwardcache.ClientStats, per peer of the pool, read when Prometheus
+// scrapes them.
+package metrics
+
+import (
+	"github.com/mikegleasonjr/forwardcache"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "forwardcache"
+
+var (
+	originLabels = []string{"origin"}
+	peerLabels   = []string{"peer"}
+)
+
+// Collector is a prometheus.Collector of the statistics of a peer and
+// of its client, or of a client alone.
+type Collector struct {
+	stats       func() *forwardcache.Stats       // nil for a client alone
+	clientStats func() *forwardcache.ClientStats // nil for no client
+
+	requests        *prometheus.Desc
+	hits            *prometheus.Desc
+	misses          *prometheus.Desc
+	originFetches   *prometheus.Desc
+	originErrors    *prometheus.Desc
+	bytesFromOrigin *prometheus.Desc
+	bytesServed     *prometheus.Desc
+	shed            *prometheus.Desc
+	originLatency   *prometheus.Desc
+	objectSize      *prometheus.Desc
+
+	peerRequests *prometheus.Desc
+	peerErrors   *prometheus.Desc
+	retries      *prometheus.Desc
+	rateLimited  *prometheus.Desc
+	peerLatency  *prometheus.Desc
+	limiterWait  *prometheus.Desc
+}
+
+func newCollector(stats func() *forwardcache.Stats, clientStats func() *forwardcache.ClientStats) *Collector {
+	return &Collector{
+		stats:       stats,
+		clientStats: clientStats,
+
+		requests:        desc("requests_total", "Requests served by the peer.", originLabels),
+		hits:            desc("hits_total", "Requests served from the cache.", originLabels),
+		misses:          desc("misses_total", "Requests not served from the cache.", originLabels),
+		originFetches:   desc("origin_fetches_total", "Requests made to the origins.", originLabels),
+		originErrors:    desc("origin_errors_total", "Origin fetches that failed or returned a 5xx.", originLabels),
+		bytesFromOrigin: desc("origin_bytes_total", "Bytes fetched from the origins.", originLabels),
+		bytesServed:     desc("served_bytes_total", "Bytes served to the clients.", originLabels),
+		shed:            desc("shed_total", "Requests shed by the peer under load.", nil),
+		originLatency:   desc("origin_latency_seconds", "Time to get response headers from the origins.", nil),
+		objectSize:      desc("object_size_bytes", "Sizes of the objects stored in the cache.", nil),
+
+		peerRequests: desc("client_requests_total", "Requests made to the peers of the pool.", peerLabels),
+		peerErrors:   desc("client_errors_total", "Requests to the peers of the pool that failed or got a 5xx.", peerLabels),
+		retries:      desc("client_retries_total", "Requests retried on another peer.", nil),
+		rateLimited:  desc("client_rate_limited_total", "Requests delayed by a rate limit.", nil),
+		peerLatency:  desc("client_peer_latency_seconds", "Time to get response headers from the peers.", nil),
+		limiterWait:  desc("client_limiter_wait_seconds", "Time spent waiting for rate limits.", nil),
+	}
+}
+
+func desc(name, help string, labels []string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(namespace, "", name), help, labels, nil)
+}
+
+// NewCollector returns a Collector of the statistics of a peer and of
+// its client, read from the peer at every scrape.
+func NewCollector(p *forwardcache.Peer) *Collector {
+	return newCollector(p.Stats, func() *forwardcache.ClientStats {
+		return p.ClientStats()
+	})
+}
+
+// NewClientCollector returns a Collector of the statistics of a client
+// that is not a peer: the requests and errors per peer of the pool, the
+// retries, the rate limits and the latency of the peers. The counters
+// about the routing of the requests (RingEpoch, Rerouted, Bypassed,
+// Secondary and Delegated) are left out, see forwardcache.ClientStats.
+func NewClientCollector(c *forwardcache.Client) *Collector {
+	return newCollector(nil, c.ClientStats)
+}
+
+// WithMetrics registers a Collector of the peer with reg, or with
+// prometheus.DefaultRegisterer if reg is nil. The processes running
+// several peers register them with distinct labels, using
+// prometheus.WrapRegistererWith.
+func WithMetrics(reg prometheus.Registerer) func(*forwardcache.Peer) {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	return func(p *forwardcache.Peer) {
+		reg.MustRegister(NewCollector(p))
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	if c.stats != nil {
+		ch <- c.requests
+		ch <- c.hits
+		ch <- c.misses
+		ch <- c.originFetches
+		ch <- c.originErrors
+		ch <- c.bytesFromOrigin
+		ch <- c.bytesServed
+		ch <- c.shed
+		ch <- c.originLatency
+		ch <- c.objectSize
+	}
+	ch <- c.peerRequests
+	ch <- c.peerErrors
+	ch <- c.retries
+	ch <- c.rateLimited
+	ch <- c.peerLatency
+	ch <- c.limiterWait
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	if c.stats != nil {
+		stats := c.stats()
+		for host, o := range stats.Origins() {
+			requests, hits := o.Requests.Get(), o.Hits.Get()
+			counter(ch, c.requests, requests, host)
+			counter(ch, c.hits, hits, host)
+			counter(ch, c.misses, requests-hits, host)
+			counter(ch, c.originFetches, o.OriginFetches.Get(), host)
+			counter(ch, c.originErrors, o.OriginErrors.Get(), host)
+			counter(ch, c.bytesFromOrigin, o.BytesFromOrigin.Get(), host)
+			counter(ch, c.bytesServed, o.BytesServed.Get(), host)
+		}
+		counter(ch, c.shed, stats.Shed.Get())
+		histogram(ch, c.originLatency, stats.OriginLatency.Snapshot())
+		histogram(ch, c.objectSize, stats.ObjectSize.Snapshot())
+	}
+
+	stats := c.clientStats()
+	for peer, p := range stats.Peers() {
+		counter(ch, c.peerRequests, p.Requests.Get(), peer)
+		counter(ch, c.peerErrors, p.Errors.Get(), peer)
+	}
+	counter(ch, c.retries, stats.Retries.Get())
+	counter(ch, c.rateLimited, stats.RateLimited.Get())
+	histogram(ch, c.peerLatency, stats.PeerLatency.Snapshot())
+	histogram(ch, c.limiterWait, stats.LimiterWait.Snapshot())
+}
+
+func counter(ch chan<- prometheus.Metric, desc *prometheus.Desc, n int64, labels ...string) {
+	ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(n), labels...)
+}
+
+func histogram(ch chan<- prometheus.Metric, desc *prometheus.Desc, h forwardcache.HistogramSnapshot) {
+	buckets := make(map[float64]uint64, len(h.Buckets))
+	for _, b := range h.Buckets {
+		buckets[b.UpperBound] = uint64(b.Count)
+	}
+	ch <- prometheus.MustNewConstHistogram(desc, uint64(h.Count), h.Sum, buckets)
+}
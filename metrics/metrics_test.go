@@ -0,0 +1,124 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/mikegleasonjr/forwardcache/forwardcachetest"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCollector(t *testing.T) {
+	origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/error" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("hello"))
+	})
+
+	peers := prometheus.NewRegistry()
+	pool := forwardcachetest.NewPool(1, origin, WithMetrics(peers))
+	defer pool.Close()
+
+	clients := prometheus.NewRegistry()
+	client := pool.Client()
+	clients.MustRegister(NewClientCollector(client))
+
+	for _, u := range []string{"http://a.com/res.js", "http://a.com/res.js", "http://a.com/error"} {
+		res, err := client.HTTPClient().Get(u)
+		if err != nil {
+			t.Fatalf("unexpected error: got %q, want <nil>", err)
+		}
+		ioutil.ReadAll(res.Body)
+		res.Body.Close()
+	}
+
+	peer := pool.URLs()[0]
+	testCases := []struct {
+		registry *prometheus.Registry
+		name     string
+		label    string
+		want     float64
+	}{
+		{peers, "forwardcache_requests_total", "a.com", 3},
+		{peers, "forwardcache_hits_total", "a.com", 1},
+		{peers, "forwardcache_misses_total", "a.com", 2},
+		{peers, "forwardcache_origin_fetches_total", "a.com", 2},
+		{peers, "forwardcache_origin_errors_total", "a.com", 1},
+		{peers, "forwardcache_served_bytes_total", "a.com", 10},
+		{peers, "forwardcache_origin_latency_seconds", "", 2},
+		{clients, "forwardcache_client_requests_total", peer, 3},
+		{clients, "forwardcache_client_errors_total", peer, 1},
+		{clients, "forwardcache_client_peer_latency_seconds", "", 3},
+		{clients, "forwardcache_client_rate_limited_total", "", 0},
+		{clients, "forwardcache_client_limiter_wait_seconds", "", 0},
+	}
+	for _, tC := range testCases {
+		if got := value(t, tC.registry, tC.name, tC.label); got != tC.want {
+			t.Errorf("unexpected %s{%q}: got %v, want %v", tC.name, tC.label, got, tC.want)
+		}
+	}
+
+	families, _ := clients.Gather()
+	for _, f := range families {
+		if f.GetName() == "forwardcache_requests_total" {
+			t.Errorf("unexpected peer metric collected for a client: %s", f.GetName())
+		}
+	}
+}
+
+// value returns the value of the counter, or the count of the histogram,
+// named name and having label as the value of its only label if any.
+func value(t *testing.T, reg *prometheus.Registry, name, label string) float64 {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatalf("unexpected error: got %q, want <nil>", err)
+	}
+	for _, f := range families {
+		if f.GetName() != name {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			if !hasLabel(m, label) {
+				continue
+			}
+			if h := m.GetHistogram(); h != nil {
+				return float64(h.GetSampleCount())
+			}
+			return m.GetCounter().GetValue()
+		}
+	}
+	return -1
+}
+
+func hasLabel(m *dto.Metric, label string) bool {
+	if label == "" {
+		return len(m.GetLabel()) == 0
+	}
+	for _, l := range m.GetLabel() {
+		if l.GetValue() == label {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+// microCache caches the successful responses of dynamic URLs for a
+// short time whatever their Cache-Control, letting a single request
+// per URL reach the origin at a time.
+type microCache struct {
+	ttl      time.Duration // 0 to disable
+	patterns []string      // of the URLs, see matchKey
+	matchers []func(u *url.URL) bool
+	coalesce bool // to collapse the requests of all the URLs
+	failure  CollapsedFailure
+	mu       sync.Mutex
+	fetches  map[string]*collapsedFetch
+}
+
+// collapsedFetch is the origin fetch of a micro-cached URL the
+// concurrent requests wait for.
+type collapsedFetch struct {
+	done   chan struct{} // closed once the response is stored, or the fetch failed
+	failed bool          // set before done is closed
+}
+
+// CollapsedFailure tells what the requests waiting for the origin fetch
+// of a micro-cached URL do when it fails, or its body is truncated, see
+// WithCollapsedFailure.
+type CollapsedFailure int
+
+const (
+	// RetryCollapsedFailure makes the waiting requests fetch the origin
+	// again, once, collapsed again.
+	RetryCollapsedFailure CollapsedFailure = iota
+	// PropagateCollapsedFailure makes the waiting requests fail too.
+	PropagateCollapsedFailure
+)
+
+var errCollapsedFetch = errors.New("collapsed origin fetch failed")
+
+// matches reports whether the responses for a URL are micro-cached.
+func (m *microCache) matches(u *url.URL) bool {
+	if m.ttl < time.Second {
+		return false
+	}
+	key := u.String()
+	for _, pattern := range m.patterns {
+		if matchKey(pattern, key) {
+			return true
+		}
+	}
+	for _, match := range m.matchers {
+		if match(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// collapses reports whether the concurrent requests for a URL are
+// collapsed.
+func (m *microCache) collapses(u *url.URL) bool {
+	return m.coalesce || m.matches(u)
+}
+
+// Freshness returns the micro-caching TTL as the lifetime of the
+// successful responses of micro-cached URLs.
+func (m *microCache) Freshness(req *http.Request, res *http.Response, lifetime time.Duration) time.Duration {
+	if res.StatusCode != http.StatusOK || !m.matches(req.URL) {
+		return lifetime
+	}
+	return m.ttl
+}
+
+// collapsingTransport makes the concurrent GET requests for a
+// micro-cached URL wait for the first one to be served, so that they
+// are served from the cache instead of all reaching the origin at once
+// (dogpile protection). It sits in front of the cache. The requests for
+// the other URLs, when coalesced, wait for the first one likewise, then
+// reach the origin at once if its response wasn't stored.
+type collapsingTransport struct {
+	micro     *microCache
+	transport http.RoundTripper
+}
+
+func (t *collapsingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet || !t.micro.collapses(req.URL) {
+		return t.transport.RoundTrip(req)
+	}
+	micro := t.micro.matches(req.URL)
+
+	key := req.URL.String()
+	var fetch *collapsedFetch
+	retried := false
+	for {
+		t.micro.mu.Lock()
+		var ok bool
+		if fetch, ok = t.micro.fetches[key]; !ok {
+			if t.micro.fetches == nil {
+				t.micro.fetches = make(map[string]*collapsedFetch)
+			}
+			fetch = &collapsedFetch{done: make(chan struct{})}
+			t.micro.fetches[key] = fetch
+			t.micro.mu.Unlock()
+			break
+		}
+		t.micro.mu.Unlock()
+
+		select {
+		case <-fetch.done:
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+		if fetch.failed {
+			if t.micro.failure == PropagateCollapsedFailure || retried {
+				return nil, errCollapsedFetch
+			}
+			retried = true
+		} else if !micro {
+			return t.transport.RoundTrip(req) // served from the cache, if stored
+		}
+	}
+
+	var once sync.Once
+	done := func(err error) {
+		once.Do(func() {
+			// the fetches canceled by their clients don't fail the others
+			fetch.failed = err != nil && req.Context().Err() == nil
+			t.micro.mu.Lock()
+			delete(t.micro.fetches, key)
+			t.micro.mu.Unlock()
+			close(fetch.done)
+		})
+	}
+	res, err := t.transport.RoundTrip(req)
+	if err != nil || res.Header.Get(httpcache.XFromCache) != "" {
+		done(err)
+		return res, err
+	}
+	res.Body = &collapsedBody{ReadCloser: res.Body, done: done}
+	return res, nil
+}
+
+// collapsedBody ends a collapsed fetch once its body is read to the end,
+// or failed if it is truncated or closed early.
+type collapsedBody struct {
+	io.ReadCloser
+	done func(err error)
+}
+
+func (b *collapsedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.done(nil)
+	} else if err != nil {
+		b.done(err)
+	}
+	return n, err
+}
+
+func (b *collapsedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.done(errCollapsedFetch) // no-op once read to the end
+	return err
+}
+
+// WithMicroCache caches the 200 responses of the URLs matching one of
+// the patterns for ttl (a few seconds) whatever their Cache-Control,
+// for peers fronting dynamic APIs rather than static assets. Patterns
+// starting with a slash match the path and query of the URLs, the
+// others the whole URL, and stars match any sequence of characters,
+// like "/api/*". The concurrent requests for such a URL wait for the
+// first one instead of all reaching the origin. A waiting request
+// canceled by its client returns at once, and the waiting requests
+// fetch the origin again if the first one is canceled. Clients still get the
+// Cache-Control of the origin. Beware of micro-caching personalized
+// responses. Defaults to no micro-caching.
+func WithMicroCache(ttl time.Duration, patterns ...string) func(*Peer) {
+	return func(p *Peer) {
+		p.microTTL = ttl
+		p.microPatterns = patterns
+	}
+}
+
+// WithRequestCoalescing makes the concurrent GET requests for any URL
+// wait for the first one to be served instead of all reaching the
+// origin, like the requests of the micro-cached URLs (see
+// WithMicroCache) but without changing how long the responses are
+// stored. The waiting requests are then served from the cache, or
+// reach the origin if the response of the first one wasn't stored, the
+// responses that can't be stored not being shared by clients. See
+// WithCollapsedFailure for the failures of the first request.
+// Defaults to collapsing the requests of the micro-cached URLs only.
+func WithRequestCoalescing() func(*Peer) {
+	return func(p *Peer) {
+		p.coalesce = true
+	}
+}
+
+// WithCollapsedFailure specifies what the requests waiting for the
+// origin fetch of a micro-cached URL do when it fails, or its body is
+// truncated, see WithMicroCache. A fetch canceled by its client doesn't
+// fail the waiting requests, which fetch the origin again.
+// Defaults to RetryCollapsedFailure.
+func WithCollapsedFailure(f CollapsedFailure) func(*Peer) {
+	return func(p *Peer) {
+		p.microFailure = f
+	}
+}
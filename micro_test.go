@@ -0,0 +1,232 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestMicroCache(t *testing.T) {
+	var mu sync.Mutex
+	fetches := make(map[string]int)
+	unblock := make(chan struct{})
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		fetches[req.URL.Path]++
+		mu.Unlock()
+		<-unblock
+		res := okResponse()
+		res.Header = http.Header{"Cache-Control": {"private, no-store"}}
+		return res, nil
+	})
+	proxy := newProxy("/p", httpcache.NewMemoryCache(), origin, DefaultBufferPool)
+	proxy.micro.ttl = 5 * time.Second
+	proxy.micro.patterns = []string{"/api/*"}
+
+	get := func(path string) {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/p?q="+url.QueryEscape("http://app.com"+path), nil)
+		proxy.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || rr.Body.String() != "OK" {
+			t.Errorf("unexpected response: got %d %q, want %d %q", rr.Code, rr.Body.String(), http.StatusOK, "OK")
+		}
+		if cc := rr.Header().Get("Cache-Control"); cc != "private, no-store" {
+			t.Errorf("unexpected Cache-Control: got %q, want %q", cc, "private, no-store")
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			get("/api/items")
+		}()
+		go func() {
+			defer wg.Done()
+			get("/account")
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(unblock)
+	wg.Wait()
+
+	if got := fetches["/api/items"]; got != 1 {
+		t.Errorf("unexpected fetches of a micro-cached URL: got %d, want %d", got, 1)
+	}
+	if got := fetches["/account"]; got != 10 {
+		t.Errorf("unexpected fetches of another URL: got %d, want %d", got, 10)
+	}
+	if got := proxy.stats.Hits.Get(); got != 9 {
+		t.Errorf("unexpected hits: got %d, want %d", got, 9)
+	}
+}
+
+func TestRequestCoalescing(t *testing.T) {
+	var mu sync.Mutex
+	fetches := make(map[string]int)
+	unblock := make(chan struct{})
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		fetches[req.URL.Path]++
+		mu.Unlock()
+		<-unblock
+		res := okResponse()
+		res.Header = http.Header{"Cache-Control": {"max-age=60"}}
+		if req.URL.Path == "/account" {
+			res.Header.Set("Cache-Control", "no-store")
+		}
+		return res, nil
+	})
+	proxy := newProxy("/p", httpcache.NewMemoryCache(), origin, DefaultBufferPool)
+	proxy.micro.coalesce = true
+
+	get := func(path string) {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/p?q="+url.QueryEscape("http://app.com"+path), nil)
+		proxy.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK || rr.Body.String() != "OK" {
+			t.Errorf("unexpected response: got %d %q, want %d %q", rr.Code, rr.Body.String(), http.StatusOK, "OK")
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			get("/app.js")
+		}()
+		go func() {
+			defer wg.Done()
+			get("/account")
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(unblock)
+	wg.Wait()
+
+	if got := fetches["/app.js"]; got != 1 {
+		t.Errorf("unexpected fetches of a stored URL: got %d, want %d", got, 1)
+	}
+	if got := fetches["/account"]; got != 10 {
+		t.Errorf("unexpected fetches of a URL not stored: got %d, want %d", got, 10)
+	}
+	if got := proxy.stats.Hits.Get(); got != 9 {
+		t.Errorf("unexpected hits: got %d, want %d", got, 9)
+	}
+}
+
+// truncatedBody returns its content then fails like a connection lost
+// mid-body.
+type truncatedBody struct {
+	body string
+}
+
+func (b *truncatedBody) Read(p []byte) (int, error) {
+	if b.body == "" {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := copy(p, b.body)
+	b.body = b.body[n:]
+	return n, nil
+}
+
+func (b *truncatedBody) Close() error { return nil }
+
+func TestCollapsedFailure(t *testing.T) {
+	testCases := []struct {
+		name    string
+		failure CollapsedFailure
+		status  int
+		fetches int
+	}{
+		{"retry", RetryCollapsedFailure, http.StatusOK, 2},
+		{"propagate", PropagateCollapsedFailure, http.StatusBadGateway, 1},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var fetches int
+			started, unblock := make(chan struct{}), make(chan struct{})
+			origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				mu.Lock()
+				fetches++
+				first := fetches == 1
+				mu.Unlock()
+				res := okResponse()
+				if first {
+					close(started)
+					<-unblock
+					res.Body = &truncatedBody{"O"}
+				}
+				return res, nil
+			})
+			proxy := newProxy("/p", httpcache.NewMemoryCache(), origin, DefaultBufferPool)
+			proxy.micro.ttl = 5 * time.Second
+			proxy.micro.patterns = []string{"/api/*"}
+			proxy.micro.failure = tC.failure
+
+			get := func() int {
+				defer func() {
+					if err := recover(); err != nil && err != http.ErrAbortHandler {
+						panic(err)
+					}
+				}()
+				rr := httptest.NewRecorder()
+				req := httptest.NewRequest("GET", "/p?q="+url.QueryEscape("http://app.com/api/items"), nil)
+				proxy.ServeHTTP(rr, req)
+				return rr.Code
+			}
+
+			go get()
+			<-started
+
+			var wg sync.WaitGroup
+			codes := make([]int, 3)
+			for i := range codes {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					codes[i] = get()
+				}(i)
+			}
+			time.Sleep(10 * time.Millisecond)
+			close(unblock)
+			wg.Wait()
+
+			for i, code := range codes {
+				if code != tC.status {
+					t.Errorf("unexpected status of waiting request %d: got %d, want %d", i, code, tC.status)
+				}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if fetches != tC.fetches {
+				t.Errorf("unexpected origin fetches: got %d, want %d", fetches, tC.fetches)
+			}
+		})
+	}
+}
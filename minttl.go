@@ -0,0 +1,61 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"time"
+)
+
+// minTTL keeps the origin responses fresh for at least a minimum time,
+// so that bursts of requests for short-lived responses are absorbed.
+type minTTL time.Duration // 0 to disable
+
+// Freshness returns the lifetime of a response fresh for at least the
+// minimum time from now.
+func (m *minTTL) Freshness(req *http.Request, res *http.Response, lifetime time.Duration) time.Duration {
+	min := time.Duration(*m)
+	if min < time.Second || !cacheableStatuses[res.StatusCode] {
+		return lifetime
+	}
+
+	cc := parseDirectives(res.Header["Cache-Control"])
+	if cc.has("no-store") || cc.has("no-cache") || cc.has("private") {
+		return lifetime
+	}
+	age, ok := currentAge(res.Header, time.Now())
+	if !ok {
+		age = 0
+	}
+	if lifetime-age >= min {
+		return lifetime
+	}
+	return age + min
+}
+
+// WithMinTTL keeps the cacheable responses fresh in the peer's cache
+// for at least d (like 1 to 5 seconds), even when they expire sooner or
+// have no explicit freshness, so that a burst of requests is served by
+// a single origin fetch (micro-caching). Responses with no-store,
+// no-cache or private directives are left alone, and clients still get
+// the Cache-Control of the origin. Durations under a second disable it.
+// Defaults to 0.
+func WithMinTTL(d time.Duration) func(*Peer) {
+	return func(p *Peer) {
+		p.minTTL = d
+	}
+}
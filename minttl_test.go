@@ -0,0 +1,73 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestMinTTL(t *testing.T) {
+	testCases := []struct {
+		desc     string
+		min      time.Duration
+		header   http.Header
+		wantHits int64
+	}{
+		{"max-age=0", 5 * time.Second, http.Header{"Cache-Control": {"public, max-age=0"}}, 2},
+		{"short s-maxage", 5 * time.Second, http.Header{"Cache-Control": {"s-maxage=1, max-age=1"}}, 2},
+		{"expired", 5 * time.Second, http.Header{"Expires": {"0"}}, 2},
+		{"no freshness", 5 * time.Second, http.Header{}, 2},
+		{"aged", 5 * time.Second, http.Header{"Cache-Control": {"max-age=60"}, "Age": {"58"}}, 2},
+		{"no-store", 5 * time.Second, http.Header{"Cache-Control": {"no-store"}}, 0},
+		{"no-cache", 5 * time.Second, http.Header{"Cache-Control": {"no-cache"}}, 0},
+		{"private", 5 * time.Second, http.Header{"Cache-Control": {"private, max-age=0"}}, 0},
+		{"disabled", 0, http.Header{"Cache-Control": {"max-age=0"}}, 0},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				res := okResponse()
+				res.Header = http.Header{"Date": {time.Now().UTC().Format(http.TimeFormat)}}
+				for k, v := range tC.header {
+					res.Header[k] = v
+				}
+				return res, nil
+			})
+			proxy := newProxy("/p", httpcache.NewMemoryCache(), origin, DefaultBufferPool)
+			proxy.minTTL = tC.min
+
+			for i := 0; i < 3; i++ {
+				rr := httptest.NewRecorder()
+				req := httptest.NewRequest("GET", "/p?q="+url.QueryEscape("http://api.com/items"), nil)
+				proxy.ServeHTTP(rr, req)
+
+				if cc := rr.Header().Get("Cache-Control"); cc != tC.header.Get("Cache-Control") {
+					t.Errorf("unexpected Cache-Control: got %q, want %q", cc, tC.header.Get("Cache-Control"))
+				}
+			}
+			if got := proxy.stats.Hits.Get(); got != tC.wantHits {
+				t.Errorf("unexpected hits: got %d, want %d", got, tC.wantHits)
+			}
+		})
+	}
+}
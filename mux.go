@@ -0,0 +1,198 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Route is an endpoint of a peer served by its Mux.
+type Route string
+
+// The routes of a Mux, under its prefix.
+const (
+	RouteProxy   Route = "proxy"   // the proxy endpoint, at the path of the peer (see WithPath)
+	RouteHealth  Route = "health"  // GET /health, 200 while the peer is open
+	RouteAdmin   Route = "admin"   // /admin/..., the administrative API (see Peer.AdminHandler)
+	RouteMetrics Route = "metrics" // GET /metrics, the peer's Stats and ClientStats as JSON
+	RouteDebug   Route = "debug"   // /debug/pprof/..., the runtime profiles (see runtime/pprof)
+)
+
+// Mux is an http.Handler serving all the endpoints of a peer under one
+// prefix, the directory of the peer's path: with WithPath("/fc/proxy"),
+// it serves /fc/proxy, /fc/health, /fc/admin/ and so on. Each route can
+// be enabled and protected individually. The proxy and health routes
+// are enabled by default, the other ones must be enabled explicitly.
+type Mux struct {
+	prefix string
+	routes []*route // in matching order
+}
+
+type route struct {
+	name    Route
+	path    string // matched exactly, or as a prefix if it ends with a slash
+	handler http.Handler
+	enabled bool
+	auth    func(*http.Request) bool // nil if unauthenticated
+}
+
+// Mux returns a Mux serving the endpoints of the peer.
+func (p *Peer) Mux(options ...func(*Mux)) *Mux {
+	prefix := strings.TrimSuffix(path.Dir(p.Client.path), "/")
+	m := &Mux{prefix: prefix}
+	m.routes = []*route{
+		{name: RouteProxy, path: p.Client.path, handler: p.Handler(), enabled: true},
+		{name: RouteHealth, path: prefix + "/health", handler: http.HandlerFunc(p.serveHealth), enabled: true},
+		{name: RouteAdmin, path: prefix + "/admin/", handler: http.StripPrefix(prefix+"/admin", p.AdminHandler())},
+		{name: RouteMetrics, path: prefix + "/metrics", handler: http.HandlerFunc(p.serveMetrics)},
+		{name: RouteDebug, path: prefix + "/debug/pprof/", handler: http.StripPrefix(prefix+"/debug/pprof/", http.HandlerFunc(serveProfile))},
+	}
+
+	for _, option := range options {
+		option(m)
+	}
+
+	return m
+}
+
+// WithRoute enables or disables a route of a Mux.
+func WithRoute(r Route, enabled bool) func(*Mux) {
+	return func(m *Mux) {
+		if rt := m.route(r); rt != nil {
+			rt.enabled = enabled
+		}
+	}
+}
+
+// WithRouteAuth protects a route of a Mux, the requests for which auth
+// returns false being rejected with 401 Unauthorized. The proxy route
+// authenticates its clients independently, see WithAPIKey.
+func WithRouteAuth(r Route, auth func(*http.Request) bool) func(*Mux) {
+	return func(m *Mux) {
+		if rt := m.route(r); rt != nil {
+			rt.auth = auth
+		}
+	}
+}
+
+func (m *Mux) route(r Route) *route {
+	for _, rt := range m.routes {
+		if rt.name == r {
+			return rt
+		}
+	}
+	return nil
+}
+
+// ServeHTTP serves a request with the route of its path.
+func (m *Mux) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	for _, rt := range m.routes {
+		if !rt.enabled || !rt.matches(req.URL.Path) {
+			continue
+		}
+		if rt.auth != nil && !rt.auth(req) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		rt.handler.ServeHTTP(w, req)
+		return
+	}
+	http.NotFound(w, req)
+}
+
+func (rt *route) matches(p string) bool {
+	if strings.HasSuffix(rt.path, "/") {
+		return strings.HasPrefix(p, rt.path)
+	}
+	return p == rt.path
+}
+
+// serveHealth replies 200 OK while the peer is open, 503 Service
+// Unavailable while it bootstraps or is a standby and once it is closed.
+func (p *Peer) serveHealth(w http.ResponseWriter, req *http.Request) {
+	select {
+	case <-p.done:
+		http.Error(w, "closed", http.StatusServiceUnavailable)
+	default:
+		if p.bootstrapping.Get() > 0 {
+			http.Error(w, "bootstrapping", http.StatusServiceUnavailable)
+			return
+		}
+		if p.standby.active() {
+			http.Error(w, "standby", http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok\n")
+	}
+}
+
+// serveMetrics serves the statistics of the peer and of its client.
+func (p *Peer) serveMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, `{"peer":`+p.Stats().String()+`,"client":`+p.ClientStats().String()+"}")
+}
+
+// serveProfile serves the runtime profile named by the path, the CPU
+// profile being "profile" and lasting seconds (30 by default), or the
+// list of the profiles. Like with net/http/pprof, debug=1 serves the
+// profiles as text.
+func serveProfile(w http.ResponseWriter, req *http.Request) {
+	name := req.URL.Path
+	debug, _ := strconv.Atoi(req.FormValue("debug"))
+	switch name {
+	case "":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "profile")
+		for _, p := range pprof.Profiles() {
+			fmt.Fprintln(w, p.Name())
+		}
+	case "profile":
+		seconds, err := strconv.Atoi(req.FormValue("seconds"))
+		if err != nil || seconds <= 0 {
+			seconds = 30
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := pprof.StartCPUProfile(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		select {
+		case <-time.After(time.Duration(seconds) * time.Second):
+		case <-req.Context().Done():
+		}
+		pprof.StopCPUProfile()
+	default:
+		p := pprof.Lookup(name)
+		if p == nil {
+			http.NotFound(w, req)
+			return
+		}
+		if debug > 0 {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		p.WriteTo(w, debug)
+	}
+}
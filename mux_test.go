@@ -0,0 +1,89 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMux(t *testing.T) {
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return okResponse(), nil
+	})
+	peer := NewPeer("http://a.com:3000",
+		WithPeerTransport(origin),
+		WithClient(NewClient(WithPool("http://a.com:3000"), WithPath("/fc/proxy"))),
+	)
+	defer peer.Close()
+
+	operator := func(req *http.Request) bool { return req.Header.Get("X-Operator") == "1" }
+	mux := peer.Mux(
+		WithRoute(RouteMetrics, true),
+		WithRoute(RouteAdmin, true),
+		WithRouteAuth(RouteAdmin, operator),
+		WithRoute(RouteDebug, true),
+		WithRouteAuth(RouteDebug, operator),
+	)
+
+	testCases := []struct {
+		target   string
+		operator bool
+		status   int
+	}{
+		{"/fc/proxy?q=http%3A%2F%2Fsome.url%2Fres.js", false, http.StatusOK},
+		{"/fc/health", false, http.StatusOK},
+		{"/fc/metrics", false, http.StatusOK},
+		{"/fc/admin/stats", false, http.StatusUnauthorized},
+		{"/fc/admin/stats", true, http.StatusOK},
+		{"/fc/debug/pprof/", false, http.StatusUnauthorized},
+		{"/fc/debug/pprof/", true, http.StatusOK},
+		{"/fc/debug/pprof/goroutine?debug=1", true, http.StatusOK},
+		{"/fc/debug/pprof/unknown", true, http.StatusNotFound},
+		{"/proxy?q=http%3A%2F%2Fsome.url%2Fres.js", false, http.StatusNotFound},
+		{"/fc/unknown", false, http.StatusNotFound},
+	}
+	for _, tC := range testCases {
+		req := httptest.NewRequest("GET", tC.target, nil)
+		if tC.operator {
+			req.Header.Set("X-Operator", "1")
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if got := rec.Code; got != tC.status {
+			t.Errorf("unexpected status of %s: got %d, want %d", tC.target, got, tC.status)
+		}
+	}
+
+	// disabled by default or explicitly
+	mux = peer.Mux(WithRoute(RouteHealth, false))
+	for _, target := range []string{"/fc/health", "/fc/metrics", "/fc/admin/stats", "/fc/debug/pprof/"} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+		if got, want := rec.Code, http.StatusNotFound; got != want {
+			t.Errorf("unexpected status of disabled %s: got %d, want %d", target, got, want)
+		}
+	}
+
+	peer.Close()
+	rec := httptest.NewRecorder()
+	peer.Mux().ServeHTTP(rec, httptest.NewRequest("GET", "/fc/health", nil))
+	if got, want := rec.Code, http.StatusServiceUnavailable; got != want {
+		t.Errorf("unexpected status of a closed peer's health: got %d, want %d", got, want)
+	}
+}
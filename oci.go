@@ -0,0 +1,289 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ociMaxRedirects is the number of redirects followed to fetch a blob.
+const ociMaxRedirects = 5
+
+var errRegistryToken = errors.New("registry token request failed")
+
+// ociPath parses the path of a registry URL addressing a blob or a
+// manifest, like /v2/library/alpine/manifests/latest, or the tags of a
+// repository, like /v2/library/alpine/tags/list.
+func ociPath(path string) (name, kind, ref string, ok bool) {
+	if !strings.HasPrefix(path, "/v2/") {
+		return "", "", "", false
+	}
+	parts := strings.Split(path[len("/v2/"):], "/")
+	if n := len(parts); n >= 3 && parts[n-3] != "" {
+		name, kind, ref = strings.Join(parts[:n-2], "/"), parts[n-2], parts[n-1]
+		if (kind == "blobs" || kind == "manifests") && ref != "" {
+			return name, kind, ref, true
+		}
+		if kind == "tags" && ref == "list" {
+			return name, kind, ref, true
+		}
+	}
+	return "", "", "", false
+}
+
+// ociFreshness keeps the blobs and the manifests by digest fresh for a
+// year, and the manifests by tag and the tag lists fresh for at most
+// manifestTTL.
+func ociFreshness(manifestTTL time.Duration) FreshnessPolicy {
+	return FreshnessFunc(func(req *http.Request, res *http.Response, lifetime time.Duration) time.Duration {
+		_, kind, ref, ok := ociPath(req.URL.Path)
+		if !ok {
+			return lifetime
+		}
+		if _, err := ParseDigest(ref); err == nil || kind == "blobs" {
+			if res.StatusCode == http.StatusOK {
+				return immutableTTL
+			}
+			return lifetime
+		}
+		if lifetime > manifestTTL {
+			return manifestTTL
+		}
+		return lifetime
+	})
+}
+
+// registryToken is a bearer token of a registry.
+type registryToken struct {
+	token   string
+	expires time.Time
+}
+
+// registryAuth is the token authentication of registries, done by the
+// peer for the clients. The tokens are reused until they expire.
+type registryAuth struct {
+	enabled bool
+	mu      sync.Mutex
+	tokens  map[string]registryToken // by host and scope
+}
+
+func (a *registryAuth) token(key string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	t, ok := a.tokens[key]
+	if !ok || time.Now().After(t.expires) {
+		return "", false
+	}
+	return t.token, true
+}
+
+func (a *registryAuth) setToken(key string, t registryToken) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.tokens == nil {
+		a.tokens = make(map[string]registryToken)
+	}
+	a.tokens[key] = t
+}
+
+// registryTransport authenticates the requests of registries with the
+// bearer tokens they challenge for, and follows the redirects of the
+// blobs, usually to a storage service, so that the blobs are stored
+// under their registry URL. It sits behind the cache, so the requests
+// of the clients are stored as unauthenticated ones.
+type registryTransport struct {
+	auth      *registryAuth
+	transport http.RoundTripper
+}
+
+func (t *registryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	name, kind, _, ok := ociPath(req.URL.Path)
+	if !t.auth.enabled || !ok || strings.HasPrefix(req.Header.Get("Authorization"), "Bearer ") {
+		return t.transport.RoundTrip(req)
+	}
+
+	key := req.URL.Host + " repository:" + name + ":pull"
+	out := req
+	if token, ok := t.auth.token(key); ok {
+		out = bearer(req, token)
+	}
+	res, err := t.transport.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusUnauthorized {
+		challenge, ok := parseChallenge(res.Header.Get("Www-Authenticate"))
+		if !ok {
+			return res, nil
+		}
+		token, err := t.fetchToken(req, challenge)
+		if err != nil {
+			explain(req, "registry: %v", err)
+			return res, nil
+		}
+		res.Body.Close()
+		t.auth.setToken(key, token)
+		explain(req, "registry: authenticated with a token of %s", challenge["realm"])
+		out = bearer(req, token.token)
+		if res, err = t.transport.RoundTrip(out); err != nil {
+			return nil, err
+		}
+	}
+	if kind == "blobs" && req.Method == http.MethodGet {
+		return t.follow(out, res)
+	}
+	return res, nil
+}
+
+// follow follows the redirects of a blob response, without the
+// registry's credentials if they lead to another host.
+func (t *registryTransport) follow(req *http.Request, res *http.Response) (*http.Response, error) {
+	for i := 0; i < ociMaxRedirects; i++ {
+		switch res.StatusCode {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		default:
+			return res, nil
+		}
+		u, err := req.URL.Parse(res.Header.Get("Location"))
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" {
+			return res, nil
+		}
+		res.Body.Close()
+		next := req.Clone(req.Context())
+		next.URL, next.Host = u, ""
+		if u.Host != req.URL.Host {
+			delete(next.Header, "Authorization")
+		}
+		explain(req, "registry: blob redirected to %s", u.Host)
+		if res, err = t.transport.RoundTrip(next); err != nil {
+			return nil, err
+		}
+		req = next
+	}
+	return res, nil
+}
+
+// fetchToken fetches a token from the realm of a challenge, with the
+// credentials of the peer for the registry, if any.
+func (t *registryTransport) fetchToken(req *http.Request, challenge map[string]string) (registryToken, error) {
+	u, err := url.Parse(challenge["realm"])
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" {
+		return registryToken{}, errRegistryToken
+	}
+	q := u.Query()
+	for _, name := range []string{"service", "scope"} {
+		if v := challenge[name]; v != "" {
+			q.Set(name, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+
+	out, err := http.NewRequestWithContext(req.Context(), http.MethodGet, u.String(), nil)
+	if err != nil {
+		return registryToken{}, err
+	}
+	if v := req.Header.Get("Authorization"); strings.HasPrefix(v, "Basic ") {
+		out.Header.Set("Authorization", v)
+	}
+	res, err := t.transport.RoundTrip(out)
+	if err != nil {
+		return registryToken{}, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return registryToken{}, errRegistryToken
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		return registryToken{}, err
+	}
+	token := registryToken{token: body.Token, expires: time.Now().Add(60 * time.Second)}
+	if token.token == "" {
+		token.token = body.AccessToken
+	}
+	if token.token == "" {
+		return registryToken{}, errRegistryToken
+	}
+	if body.ExpiresIn > 0 {
+		token.expires = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	}
+	return token, nil
+}
+
+// bearer returns a copy of a request authenticated with a token.
+func bearer(req *http.Request, token string) *http.Request {
+	out := req.Clone(req.Context())
+	out.Header.Set("Authorization", "Bearer "+token)
+	return out
+}
+
+// parseChallenge parses a bearer challenge, like
+// `Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`.
+func parseChallenge(h string) (map[string]string, bool) {
+	i := strings.IndexByte(h, ' ')
+	if i < 0 || !strings.EqualFold(h[:i], "Bearer") {
+		return nil, false
+	}
+	params := make(map[string]string)
+	for _, param := range splitQuoted(h[i+1:]) {
+		if j := strings.IndexByte(param, '='); j > 0 {
+			params[strings.ToLower(strings.TrimSpace(param[:j]))] = strings.Trim(strings.TrimSpace(param[j+1:]), `"`)
+		}
+	}
+	return params, params["realm"] != ""
+}
+
+// OCIRegistry bundles the options caching container registries (the
+// OCI distribution API):
+//
+//   - the blobs and the manifests by digest, immutable, stay fresh for a
+//     year, while the manifests by tag and the tag lists stay fresh for
+//     at most manifestTTL (WithFreshness)
+//   - the bodies are verified against their digest, and the blobs of
+//     other repositories with the same digest are served from the cache
+//     (WithChecksums)
+//   - the token authentication the registries challenge for is done by
+//     the peer, with the credentials set by WithOriginCredentials for
+//     the registries needing any, so that the responses are stored as
+//     unauthenticated ones for the clients
+//   - the redirects of the blobs, to the storage services of the
+//     registries, are followed by the peer so that they are stored
+//
+// The registries must be fetched through the peer with their URLs, like
+// https://registry-1.docker.io/v2/library/alpine/manifests/latest.
+func OCIRegistry(manifestTTL time.Duration) func(*Peer) {
+	options := []func(*Peer){
+		WithFreshness(ociFreshness(manifestTTL)),
+		WithChecksums(OCIDigest()),
+	}
+	return func(p *Peer) {
+		for _, option := range options {
+			option(p)
+		}
+		p.registry = true
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestOCIPath(t *testing.T) {
+	testCases := []struct {
+		path            string
+		name, kind, ref string
+		ok              bool
+	}{
+		{"/v2/library/alpine/manifests/latest", "library/alpine", "manifests", "latest", true},
+		{"/v2/alpine/blobs/" + helloSHA256, "alpine", "blobs", helloSHA256, true},
+		{"/v2/library/alpine/tags/list", "library/alpine", "tags", "list", true},
+		{"/v2/alpine/blobs/uploads/", "", "", "", false},
+		{"/v2/", "", "", "", false},
+		{"/v2//manifests/latest", "", "", "", false},
+		{"/v1/alpine/manifests/latest", "", "", "", false},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.path, func(t *testing.T) {
+			name, kind, ref, ok := ociPath(tC.path)
+			if name != tC.name || kind != tC.kind || ref != tC.ref || ok != tC.ok {
+				t.Errorf("unexpected path: got %q %q %q %v, want %q %q %q %v", name, kind, ref, ok, tC.name, tC.kind, tC.ref, tC.ok)
+			}
+		})
+	}
+}
+
+func TestOCIFreshness(t *testing.T) {
+	testCases := []struct {
+		path     string
+		status   int
+		lifetime time.Duration
+		want     time.Duration
+	}{
+		{"/v2/alpine/blobs/" + helloSHA256, http.StatusOK, 0, immutableTTL},
+		{"/v2/alpine/blobs/" + helloSHA256, http.StatusNotFound, 0, 0},
+		{"/v2/alpine/manifests/" + helloSHA256, http.StatusOK, time.Minute, immutableTTL},
+		{"/v2/alpine/manifests/latest", http.StatusOK, time.Hour, time.Minute},
+		{"/v2/alpine/manifests/latest", http.StatusOK, time.Second, time.Second},
+		{"/v2/alpine/tags/list", http.StatusOK, time.Hour, time.Minute},
+		{"/index.html", http.StatusOK, time.Hour, time.Hour},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.path, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "http://registry.io"+tC.path, nil)
+			got := ociFreshness(time.Minute).Freshness(req, &http.Response{StatusCode: tC.status}, tC.lifetime)
+			if got != tC.want {
+				t.Errorf("unexpected lifetime: got %s, want %s", got, tC.want)
+			}
+		})
+	}
+}
+
+func TestParseChallenge(t *testing.T) {
+	params, ok := parseChallenge(`Bearer realm="https://auth.io/token",service="registry.io",scope="repository:library/alpine:pull,push"`)
+	if !ok {
+		t.Fatalf("unexpected invalid challenge")
+	}
+	want := map[string]string{"realm": "https://auth.io/token", "service": "registry.io", "scope": "repository:library/alpine:pull,push"}
+	for name, v := range want {
+		if params[name] != v {
+			t.Errorf("unexpected %s: got %q, want %q", name, params[name], v)
+		}
+	}
+	if _, ok := parseChallenge(`Basic realm="registry"`); ok {
+		t.Errorf("unexpected valid basic challenge")
+	}
+}
+
+func TestOCIRegistry(t *testing.T) {
+	tokens, fetches := 0, 0
+	registry := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		fetches++
+		res := &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Request: req}
+		body := ""
+		switch {
+		case req.URL.Host == "auth.io":
+			tokens++
+			if got, want := req.URL.Query().Get("scope"), "repository:library/alpine:pull"; got != want {
+				t.Errorf("unexpected token scope: got %q, want %q", got, want)
+			}
+			body = `{"token": "tok", "expires_in": 300}`
+		case req.URL.Host == "storage.io":
+			if _, ok := req.Header["Authorization"]; ok {
+				t.Errorf("unexpected registry credentials sent to the storage")
+			}
+			body = "hello"
+		case req.Header.Get("Authorization") != "Bearer tok":
+			res.StatusCode = http.StatusUnauthorized
+			res.Header.Set("Www-Authenticate", `Bearer realm="https://auth.io/token",service="registry.io",scope="repository:library/alpine:pull"`)
+		case strings.Contains(req.URL.Path, "/blobs/"):
+			res.StatusCode = http.StatusTemporaryRedirect
+			res.Header.Set("Location", "https://storage.io/blob?signature=1")
+		default:
+			res.Header.Set("Cache-Control", "max-age=3600")
+			body = "manifest"
+		}
+		res.Body = ioutil.NopCloser(strings.NewReader(body))
+		res.ContentLength = int64(len(body))
+		return res, nil
+	})
+	peer := NewPeer("http://self.com:3000", WithPeerTransport(registry), OCIRegistry(time.Minute))
+
+	testCases := []struct {
+		path       string
+		body       string
+		xFromCache string
+	}{
+		{"/v2/library/alpine/manifests/latest", "manifest", ""},
+		{"/v2/library/alpine/blobs/" + helloSHA256, "hello", ""},
+		{"/v2/library/alpine/manifests/latest", "manifest", "1"},
+		{"/v2/library/alpine/blobs/" + helloSHA256, "hello", "1"},
+	}
+	for i, tC := range testCases {
+		rr := httptest.NewRecorder()
+		peer.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape("https://registry.io"+tC.path), nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("request %d: unexpected status: got %d, want %d", i, rr.Code, http.StatusOK)
+		}
+		if got := rr.Body.String(); got != tC.body {
+			t.Errorf("request %d: unexpected body: got %q, want %q", i, got, tC.body)
+		}
+		if got := rr.Header().Get(httpcache.XFromCache); got != tC.xFromCache {
+			t.Errorf("request %d: unexpected %q header: got %q, want %q", i, httpcache.XFromCache, got, tC.xFromCache)
+		}
+	}
+	if tokens != 1 {
+		t.Errorf("unexpected token fetches: got %d, want %d", tokens, 1)
+	}
+	if fetches != 5 {
+		t.Errorf("unexpected fetches: got %d, want %d", fetches, 5)
+	}
+}
@@ -0,0 +1,37 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+// SetOffline switches the peer to the offline mode, for air-gapped
+// deployments or when the network to the origins is down: the peer
+// serves any stored response, however stale, without revalidating it
+// with the origin, and only fetches the origins on misses. Purged
+// responses are not served. The mode is also switched with the admin
+// API.
+func (p *Peer) SetOffline(offline bool) {
+	var v int64
+	if offline {
+		v = 1
+	}
+	p.handler.offline.Set(v)
+}
+
+// Offline reports whether the peer is in the offline mode, see
+// SetOffline.
+func (p *Peer) Offline() bool {
+	return p.handler.offline.Get() != 0
+}
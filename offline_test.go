@@ -0,0 +1,78 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestOffline(t *testing.T) {
+	var down bool
+	origin := roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		if down {
+			return nil, errors.New("network unreachable")
+		}
+		res := okResponse()
+		res.Header.Del("Expires")
+		res.Header.Set("Cache-Control", "max-age=0, must-revalidate")
+		return res, nil
+	})
+	peer := NewPeer("http://self.com:3000", WithPeerTransport(origin))
+
+	testCases := []struct {
+		offline    string // admin query, none to keep the mode
+		down       bool
+		status     int
+		xFromCache string
+	}{
+		{"", false, http.StatusOK, ""},
+		{"", true, http.StatusBadGateway, ""},
+		{"enabled=1", true, http.StatusOK, "1"},
+		{"enabled=0", true, http.StatusBadGateway, ""},
+	}
+	for i, tC := range testCases {
+		if tC.offline != "" {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/offline?"+tC.offline, nil)
+			peer.AdminHandler().ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("unexpected status switching the offline mode: got %d, want %d", rr.Code, http.StatusOK)
+			}
+		}
+		down = tC.down
+
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/proxy?q="+url.QueryEscape("http://cdn.com/jquery.js"), nil)
+		peer.Handler().ServeHTTP(rr, req)
+		if rr.Code != tC.status {
+			t.Errorf("unexpected status of request %d: got %d, want %d", i, rr.Code, tC.status)
+		}
+		if got := rr.HeaderMap.Get(httpcache.XFromCache); got != tC.xFromCache {
+			t.Errorf("unexpected %s header of request %d: got %q, want %q", httpcache.XFromCache, i, got, tC.xFromCache)
+		}
+	}
+
+	if peer.Offline() {
+		t.Errorf("unexpected offline mode: got true, want false")
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+const defaultMaxOriginLength = 4096
+
+var (
+	errOriginMissing  = errors.New("missing origin URL")
+	errOriginTooLong  = errors.New("origin URL too long")
+	errOriginMalform  = errors.New("malformed origin URL")
+	errOriginRelative = errors.New("origin URL must be absolute")
+	errOriginScheme   = errors.New("origin URL scheme not allowed")
+	errOriginUserinfo = errors.New("origin URL must not contain user info")
+)
+
+// originPolicy validates the origin URLs requested through a peer.
+type originPolicy struct {
+	strict     bool
+	maxLength  int
+	schemes    []string
+	restricted bool // schemes are enforced outside of strict mode
+}
+
+func defaultOriginPolicy() originPolicy {
+	return originPolicy{
+		maxLength: defaultMaxOriginLength,
+		schemes:   []string{"http", "https"},
+	}
+}
+
+// parse parses and validates an origin URL. Outside of strict mode,
+// only malformed URLs are rejected.
+func (o *originPolicy) parse(rawurl string) (*url.URL, error) {
+	if rawurl == "" {
+		return nil, errOriginMissing
+	}
+
+	if o.strict && len(rawurl) > o.maxLength {
+		return nil, errOriginTooLong
+	}
+
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, errOriginMalform
+	}
+
+	if err := o.validate(u); err != nil {
+		return nil, err
+	}
+
+	return normalizeEscapes(u), nil
+}
+
+// normalizeEscapes returns u, or a copy of u if the percent-encoding of
+// its path or query isn't normalized (see protocol.NormalizeEscapes), so
+// that the requests for equivalent URLs share their stored responses.
+func normalizeEscapes(u *url.URL) *url.URL {
+	escaped := u.EscapedPath()
+	p, q := protocol.NormalizeEscapes(escaped), protocol.NormalizeEscapes(u.RawQuery)
+	if p == escaped && q == u.RawQuery {
+		return u
+	}
+
+	cpy := *u
+	if p != escaped {
+		path, err := url.PathUnescape(p)
+		if err != nil {
+			return u
+		}
+		cpy.Path, cpy.RawPath = path, p
+	}
+	cpy.RawQuery = q
+	return &cpy
+}
+
+// validate validates an origin URL.
+func (o *originPolicy) validate(u *url.URL) error {
+	if o.restricted && !o.allowed(u.Scheme) {
+		return errOriginScheme
+	}
+
+	if !o.strict {
+		return nil
+	}
+
+	if !u.IsAbs() || u.Host == "" {
+		return errOriginRelative
+	}
+
+	if !o.allowed(u.Scheme) {
+		return errOriginScheme
+	}
+
+	if u.User != nil {
+		return errOriginUserinfo
+	}
+
+	return nil
+}
+
+func (o *originPolicy) allowed(scheme string) bool {
+	for _, s := range o.schemes {
+		if s == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+// WithStrictOrigins hardens the validation of the origin URLs requested
+// through the peer: they must be absolute URLs of an allowed scheme
+// (http and https unless WithAllowedSchemes is used), without user info
+// and of at most 4096 bytes. Requests for invalid origins are
+// rejected with a 400 Bad Request and the reason, instead of a 502 Bad
+// Gateway. Defaults to only rejecting malformed URLs.
+func WithStrictOrigins() func(*Peer) {
+	return func(p *Peer) {
+		p.origins.strict = true
+	}
+}
+
+// WithMaxOriginLength sets the maximum length of the origin URLs
+// in strict mode. Defaults to 4096.
+func WithMaxOriginLength(n int) func(*Peer) {
+	return func(p *Peer) {
+		p.origins.maxLength = n
+	}
+}
+
+// WithAllowedSchemes restricts the schemes of the origin URLs requested
+// through the peer, for example to refuse plain-http origins. Requests
+// for other schemes are rejected with a 400 Bad Request, in strict mode
+// or not. Defaults to any scheme, or http and https in strict mode.
+func WithAllowedSchemes(schemes ...string) func(*Peer) {
+	return func(p *Peer) {
+		p.origins.schemes = schemes
+		p.origins.restricted = true
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"sync"
+)
+
+// originLimits limits the concurrent fetches of a peer per origin host.
+type originLimits struct {
+	max   int // per host, 0 for no limit
+	mu    sync.Mutex
+	hosts map[string]*originSlots
+}
+
+// originSlots are the fetch slots of a host, forgotten once unused.
+type originSlots struct {
+	scheduler *scheduler
+	users     int // fetches holding or waiting for a slot
+}
+
+// acquire waits for a slot to fetch from a host, by priority. The
+// returned function releases it.
+func (l *originLimits) acquire(req *http.Request) (release func(), queued bool, err error) {
+	l.mu.Lock()
+	if l.hosts == nil {
+		l.hosts = make(map[string]*originSlots)
+	}
+	slots, ok := l.hosts[req.URL.Host]
+	if !ok {
+		slots = &originSlots{scheduler: newScheduler(l.max, math.MaxInt32)}
+		l.hosts[req.URL.Host] = slots
+	}
+	slots.users++
+	queued = slots.users > l.max
+	l.mu.Unlock()
+
+	done := func() {
+		l.mu.Lock()
+		if slots.users--; slots.users == 0 {
+			delete(l.hosts, req.URL.Host)
+		}
+		l.mu.Unlock()
+	}
+	if err := slots.scheduler.acquire(req.Context(), requestPriority(req)); err != nil {
+		done()
+		return nil, queued, err
+	}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			slots.scheduler.release()
+			done()
+		})
+	}, queued, nil
+}
+
+// originLimitTransport queues the fetches to an origin host beyond its
+// limit of concurrent fetches, the connection being held until the body
+// of a response is read or closed. It sits behind the cache.
+type originLimitTransport struct {
+	limits    *originLimits
+	stats     *Stats
+	transport http.RoundTripper
+}
+
+func (t *originLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.limits.max <= 0 {
+		return t.transport.RoundTrip(req)
+	}
+
+	release, queued, err := t.limits.acquire(req)
+	if queued {
+		t.stats.OriginQueued.Add(1)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res, err := t.transport.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	res.Body = &releasingBody{res.Body, release}
+	return res, nil
+}
+
+// releasingBody calls release once read to the end or closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err == io.EOF {
+		b.release()
+	}
+	return n, err
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+// WithMaxOriginConnections limits the concurrent fetches of the peer
+// to each origin host to n, so that a storm of misses on one origin
+// can't exhaust the sockets of the peer and slow the others down.
+// Excess fetches are queued, interactive ones first, until their
+// request is canceled. Defaults to no limit.
+func WithMaxOriginConnections(n int) func(*Peer) {
+	return func(p *Peer) {
+		p.originLimit = n
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestMaxOriginConnections(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := make(map[string]int), make(map[string]int)
+	unblock := make(chan struct{})
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		inFlight[req.URL.Host]++
+		if inFlight[req.URL.Host] > maxInFlight[req.URL.Host] {
+			maxInFlight[req.URL.Host] = inFlight[req.URL.Host]
+		}
+		mu.Unlock()
+		if req.URL.Host == "slow.com" {
+			<-unblock
+		}
+		mu.Lock()
+		inFlight[req.URL.Host]--
+		mu.Unlock()
+		return okResponse(), nil
+	})
+	proxy := newProxy("/p", httpcache.NewMemoryCache(), origin, DefaultBufferPool)
+	proxy.originLimit.max = 2
+
+	get := func(u string) int {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/p?q="+url.QueryEscape(u), nil)
+		proxy.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			get("http://slow.com/" + string(rune('a'+i)))
+		}(i)
+	}
+
+	for deadline := time.Now().Add(time.Second); proxy.stats.OriginQueued.Get() < 3 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	// other origins are not held back by the queued fetches
+	for i := 0; i < 3; i++ {
+		if code := get("http://fast.com/" + string(rune('a'+i))); code != http.StatusOK {
+			t.Errorf("unexpected status: got %d, want %d", code, http.StatusOK)
+		}
+	}
+	close(unblock)
+	wg.Wait()
+
+	if got := maxInFlight["slow.com"]; got != 2 {
+		t.Errorf("unexpected concurrent fetches: got %d, want %d", got, 2)
+	}
+	if got := proxy.stats.OriginQueued.Get(); got != 3 {
+		t.Errorf("unexpected queued fetches: got %d, want %d", got, 3)
+	}
+	proxy.originLimit.mu.Lock()
+	if n := len(proxy.originLimit.hosts); n != 0 {
+		t.Errorf("unexpected hosts tracked: got %d, want %d", n, 0)
+	}
+	proxy.originLimit.mu.Unlock()
+}
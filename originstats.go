@@ -0,0 +1,222 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import "time"
+
+const (
+	// maxOrigins is the number of origin hosts whose requests are
+	// counted separately, OtherOrigins included.
+	maxOrigins = 256
+
+	// originGrace is how long a newly seen origin host is counted
+	// separately before it can be folded into OtherOrigins, so that it
+	// gets a chance to establish itself, and how long the counters of
+	// a folded host are watched for late increments.
+	originGrace = time.Minute
+
+	// originHalfLife is how often the request counts the folds are
+	// decided on are halved, so that the hosts no longer requested
+	// are folded before the ones requested now.
+	originHalfLife = time.Hour
+)
+
+// OtherOrigins is the host the origins not counted separately are
+// counted under, see Stats.Origin.
+const OtherOrigins = "(other)"
+
+// trackedOrigin are the counters of an origin host counted separately.
+type trackedOrigin struct {
+	*Counters
+	added time.Time
+	base  int64   // requests when last aged
+	score float64 // decayed requests before base
+}
+
+// activity returns the decayed request count of the origin.
+func (o *trackedOrigin) activity() float64 {
+	n := o.Requests.Get() - o.base
+	if n < 0 {
+		n = o.Requests.Get() // reset since last aged
+	}
+	return o.score + float64(n)
+}
+
+// age halves the decayed request count of the origin.
+func (o *trackedOrigin) age() {
+	o.score = o.activity() / 2
+	o.base = o.Requests.Get()
+}
+
+// retiredOrigin are the counters of a host folded into OtherOrigins,
+// which the requests in flight for the host and its connections may
+// still update. They are folded again until they are no longer used.
+type retiredOrigin struct {
+	*Counters
+	folded []int64 // the values folded so far, see Counters.fields
+	at     time.Time
+}
+
+// fold adds the increments made to the retired counters since they
+// were last folded to other, and reports whether there were any.
+func (r *retiredOrigin) fold(other *Counters) bool {
+	changed := false
+	dst := other.fields()
+	for i, f := range r.fields() {
+		if v := f.Get(); v != r.folded[i] {
+			dst[i].Add(v - r.folded[i])
+			r.folded[i] = v
+			changed = true
+		}
+	}
+	return changed
+}
+
+// fields returns the counters and gauges of c, in a fixed order.
+func (c *Counters) fields() []*AtomicInt {
+	return []*AtomicInt{
+		&c.Requests, &c.Hits, &c.OriginFetches, &c.OriginErrors, &c.BytesFromOrigin, &c.BytesServed,
+		&c.SlowOrigins, &c.LargeResponses, &c.Dials, &c.ReusedConns, &c.IdleConns, &c.HandshakeTime,
+	}
+}
+
+// Origin returns the counters of an origin host. Only maxOrigins hosts
+// are counted separately, so that the hosts coming from the clients
+// can't grow the stats without bound: when a new host is seen, the
+// least requested host lately, among those seen more than a minute ago,
+// is folded into OtherOrigins to make room, and the new host is counted
+// under OtherOrigins if none can be folded. The requests in flight for
+// a folded host are counted under OtherOrigins when they complete.
+func (s *Stats) Origin(host string) *Counters {
+	s.mu.RLock()
+	o, ok := s.origins[host]
+	s.mu.RUnlock()
+	if ok {
+		return o.Counters
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o, ok = s.origins[host]; ok {
+		return o.Counters
+	}
+	if s.origins == nil {
+		s.origins = make(map[string]*trackedOrigin)
+	}
+	now := s.clock()
+	n := len(s.origins)
+	if _, ok := s.origins[OtherOrigins]; !ok {
+		n++ // keeping room for OtherOrigins
+	}
+	if host != OtherOrigins && n >= maxOrigins && !s.forgetOrigin(now) {
+		return s.other(now)
+	}
+	o = &trackedOrigin{Counters: new(Counters), added: now}
+	s.origins[host] = o
+	return o.Counters
+}
+
+// Origins returns the counters of the origin hosts counted separately
+// and of OtherOrigins, see Stats.Origin.
+func (s *Stats) Origins() map[string]*Counters {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.foldRetired(s.clock())
+	origins := make(map[string]*Counters, len(s.origins))
+	for host, o := range s.origins {
+		origins[host] = o.Counters
+	}
+	return origins
+}
+
+// forgetOrigin folds the origin host requested the least lately, and
+// out of its grace period, into OtherOrigins. It reports false if there
+// is none, the stats being locked.
+func (s *Stats) forgetOrigin(now time.Time) bool {
+	if now.Sub(s.aged) >= originHalfLife {
+		for _, o := range s.origins {
+			o.age()
+		}
+		s.aged = now
+	}
+
+	var least *trackedOrigin
+	var host string
+	for h, o := range s.origins {
+		if h == OtherOrigins || now.Sub(o.added) < originGrace {
+			continue
+		}
+		if least == nil || o.activity() < least.activity() {
+			least, host = o, h
+		}
+	}
+	if least == nil {
+		return false
+	}
+
+	delete(s.origins, host)
+	s.retired = append(s.retired, &retiredOrigin{
+		Counters: least.Counters,
+		folded:   make([]int64, len(least.fields())),
+		at:       now,
+	})
+	s.foldRetired(now)
+	return true
+}
+
+// foldRetired folds the increments made to the counters of the folded
+// hosts into OtherOrigins, and forgets the counters that were no longer
+// used during their grace period, the stats being locked. At most
+// maxOrigins are kept, the oldest being forgotten first.
+func (s *Stats) foldRetired(now time.Time) {
+	if len(s.retired) == 0 {
+		return
+	}
+	other := s.other(now)
+	kept := s.retired[:0]
+	for _, r := range s.retired {
+		if r.fold(other) || r.IdleConns.Get() != 0 || now.Sub(r.at) < originGrace {
+			kept = append(kept, r)
+		}
+	}
+	if n := len(kept) - maxOrigins; n > 0 {
+		kept = kept[:copy(kept, kept[n:])]
+	}
+	for i := len(kept); i < len(s.retired); i++ {
+		s.retired[i] = nil
+	}
+	s.retired = kept
+}
+
+// other returns the counters of OtherOrigins, the stats being locked.
+func (s *Stats) other(now time.Time) *Counters {
+	o, ok := s.origins[OtherOrigins]
+	if !ok {
+		o = &trackedOrigin{Counters: new(Counters), added: now}
+		s.origins[OtherOrigins] = o
+	}
+	return o.Counters
+}
+
+// clock returns the current time.
+func (s *Stats) clock() time.Time {
+	if s.now != nil {
+		return s.now()
+	}
+	return time.Now()
+}
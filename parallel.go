@@ -0,0 +1,162 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// parallelBody reads a body stored in chunks, its first chunk read from
+// the response and the others fetched from the origin with range
+// requests, a few at once, ahead of the reads. It falls back to reading
+// the response sequentially once a range request fails.
+type parallelBody struct {
+	t          *cacheTransport
+	req        *http.Request
+	body       io.ReadCloser // of the response
+	offset     int64         // of the next byte of body
+	validator  string
+	m          manifest
+	fetched    []chan error // closed once a chunk is fetched and stored, nil for the first one
+	cancel     context.CancelFunc
+	sequential bool
+	i          int64 // of the next chunk
+	buf        []byte
+	done       func() // called once the body is read whole
+	ended      bool
+}
+
+// fetchParallel returns the body of a response stored in chunks fetched
+// in parallel from the origin, calling done once it is read whole.
+func (t *cacheTransport) fetchParallel(req *http.Request, body io.ReadCloser, validator string, m manifest, done func()) io.ReadCloser {
+	ctx, cancel := context.WithCancel(req.Context())
+	b := &parallelBody{t: t, req: req, body: body, validator: validator, m: m, fetched: make([]chan error, m.count()), cancel: cancel, done: done}
+	for i := range b.fetched[1:] {
+		b.fetched[i+1] = make(chan error, 1)
+	}
+
+	fetch := req.WithContext(ctx)
+	key := req.URL.String()
+	go func() {
+		sem := make(chan struct{}, t.chunks.parallel)
+		for i := int64(1); i < m.count(); i++ {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				for ; i < m.count(); i++ {
+					b.fetched[i] <- ctx.Err()
+				}
+				return
+			}
+			go func(i int64) {
+				defer func() { <-sem }()
+				data, err := t.fetchChunk(fetch, validator, m, i)
+				if err == nil {
+					t.setChunk(m.key(key, i), data)
+				}
+				b.fetched[i] <- err
+			}(i)
+		}
+	}()
+	explain(req, "fetching %d chunks at once with range requests", t.chunks.parallel)
+	return b
+}
+
+func (b *parallelBody) Read(p []byte) (int, error) {
+	if len(b.buf) == 0 {
+		if b.i == b.m.count() {
+			if !b.ended {
+				b.ended = true
+				b.done()
+			}
+			return 0, io.EOF
+		}
+		data, err := b.next()
+		if err == io.ErrUnexpectedEOF && b.t.stats != nil {
+			b.t.stats.Truncated.Add(1)
+		}
+		if err != nil {
+			return 0, err
+		}
+		b.buf, b.i = data, b.i+1
+	}
+	n := copy(p, b.buf)
+	b.buf = b.buf[n:]
+	return n, nil
+}
+
+// next returns the next chunk, fetched in parallel unless it is the
+// first one or a range request failed.
+func (b *parallelBody) next() ([]byte, error) {
+	key := b.m.key(b.req.URL.String(), b.i)
+	if b.i > 0 && !b.sequential {
+		err := <-b.fetched[b.i]
+		if err == nil {
+			if data, ok := b.t.getChunk(key); ok {
+				return data, nil
+			}
+			data, err := b.t.fetchChunk(b.req, b.validator, b.m, b.i) // evicted already
+			if err == nil {
+				b.t.setChunk(key, data)
+				return data, nil
+			}
+		}
+		explain(b.req, "range request for chunk %d failed, reading the response sequentially: %v", b.i, err)
+		b.sequential = true
+		b.cancel()
+	}
+
+	first, last := b.m.bounds(b.i)
+	if _, err := io.CopyN(ioutil.Discard, b.body, first-b.offset); err != nil {
+		return nil, unexpected(err)
+	}
+	data := make([]byte, last-first+1)
+	if _, err := io.ReadFull(b.body, data); err != nil {
+		return nil, unexpected(err)
+	}
+	b.offset = last + 1
+	b.t.setChunk(key, data)
+	return data, nil
+}
+
+func (b *parallelBody) Close() error {
+	b.cancel()
+	return b.body.Close()
+}
+
+// unexpected returns the error of a body ending early.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// WithParallelFetch makes the peer fetch the chunks of the responses
+// stored in chunks with WithChunkedStorage from their origins with
+// concurrency range requests at once, ahead of the reads of the clients,
+// when the origins support range requests. The responses are read
+// sequentially once a range request fails. Defaults to reading the
+// responses sequentially.
+func WithParallelFetch(concurrency int) func(*Peer) {
+	return func(p *Peer) {
+		p.chunking.parallel = concurrency
+	}
+}
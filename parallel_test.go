@@ -0,0 +1,106 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParallelFetch(t *testing.T) {
+	const body = "0123456789"
+
+	testCases := []struct {
+		name     string
+		ranges   bool // supported by the origin
+		parallel int
+		fetches  int // 0 if it depends on the scheduling
+		most     int // range requests at once
+	}{
+		{"parallel", true, 2, 5, 2},
+		{"capped", true, 3, 5, 3},
+		{"sequential", true, 1, 1, 0},
+		{"ranges unsupported", false, 3, 0, 3},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			var mu sync.Mutex
+			fetches, inFlight, most := 0, 0, 0
+			origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				mu.Lock()
+				fetches++
+				rng := req.Header.Get("Range")
+				if rng != "" {
+					if inFlight++; inFlight > most {
+						most = inFlight
+					}
+				}
+				mu.Unlock()
+				time.Sleep(10 * time.Millisecond)
+				mu.Lock()
+				if rng != "" {
+					inFlight--
+				}
+				mu.Unlock()
+
+				res := &http.Response{
+					StatusCode: http.StatusOK,
+					Header:     http.Header{"Cache-Control": {"max-age=60"}, "Etag": {`"v1"`}, "Accept-Ranges": {"bytes"}},
+					Request:    req,
+				}
+				b := body
+				if first, last, ok := parseRange(rng, int64(len(body))); ok && tC.ranges {
+					res.StatusCode = http.StatusPartialContent
+					res.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, len(body)))
+					b = body[first : last+1]
+				}
+				res.ContentLength = int64(len(b))
+				res.Header.Set("Content-Length", strconv.Itoa(len(b)))
+				res.Body = ioutil.NopCloser(strings.NewReader(b))
+				return res, nil
+			})
+			cache := &mapCache{items: make(map[string][]byte)}
+			peer := NewPeer("http://self.com:3000", WithCache(cache), WithPeerTransport(origin), WithChunkedStorage(5, 2), WithParallelFetch(tC.parallel))
+			target := "/proxy?q=" + url.QueryEscape("http://cdn.com/video.mp4")
+
+			for i := 0; i < 2; i++ {
+				rr := httptest.NewRecorder()
+				peer.Handler().ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+				if got := rr.Body.String(); got != body {
+					t.Fatalf("unexpected body of request %d: got %q, want %q", i, got, body)
+				}
+			}
+			if tC.fetches > 0 && fetches != tC.fetches {
+				t.Errorf("unexpected origin fetches: got %d, want %d", fetches, tC.fetches)
+			}
+			if most != tC.most {
+				t.Errorf("unexpected range requests at once: got %d, want %d", most, tC.most)
+			}
+			if got := len(cache.chunks()); got != 5 {
+				t.Errorf("unexpected chunks: got %d, want 5", got)
+			}
+		})
+	}
+}
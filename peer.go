@@ -16,27 +16,33 @@ limitations under the License.
 
 // Package forwardcache provides a forward caching proxy that works
 // across a set of peer processes. In simple terms, it is a distributed
-// cache for HEAD and GET requests. It follows the HTTP RFC so it will
-// only cache cacheable responses (like browsers do).
+// cache for HEAD and GET requests.
 //
 // When an http request is made, a peer is chosen to handle the request
 // according to the requested url's canonical owner.
 //
-// If the content is cacheable as per the HTTP RFC, it will get cached
-// on the peer and the response is then returned to the client. (thanks
-// to github.com/gregjones/httpcache)
+// The peer caches the response as a shared cache following the HTTP RFC
+// (RFC 7234) with its own caching transport, honoring Cache-Control,
+// Vary and validators, and the response is then returned to the client.
+// The responses are stored in an httpcache.Cache (from
+// github.com/gregjones/httpcache), in memory by default.
 //
 // Note that the peers are not real HTTP proxies. They are themselves
 // querying the origin servers and copying the response back to clients.
 // It has the benefit of being able to cache TLS requests.
 //
-// The result is that it is only suitable for use as a distributed 'private'
-// cache since the requests are fully intercepted by the peers.
+// Since the requests are fully intercepted by the peers, the responses
+// served to a client can be the ones fetched for another client: the
+// responses marked private are not cached.
 package forwardcache
 
 import (
+	"log"
 	"net/http"
 	"net/http/httputil"
+	"net/url"
+	"sync"
+	"time"
 
 	"github.com/gregjones/httpcache"
 )
@@ -46,11 +52,56 @@ import (
 // belongs to it.
 type Peer struct {
 	*Client
-	handler   *proxy
-	self      string
-	cache     httpcache.Cache
-	transport http.RoundTripper
-	buffers   httputil.BufferPool
+	handler       *proxy
+	self          string
+	cache         httpcache.Cache
+	transport     http.RoundTripper
+	dialer        originDialer
+	buffers       httputil.BufferPool
+	flush         flushPolicy
+	thresholds    thresholds
+	heuristic     heuristic
+	minTTL        time.Duration
+	microTTL      time.Duration
+	microPatterns []string
+	microMatchers []func(u *url.URL) bool
+	microFailure  CollapsedFailure
+	coalesce      bool
+	leaseTTL      time.Duration
+	freshness     []FreshnessPolicy
+	origins       originPolicy
+	originLimit   int
+	recorder      *Recorder
+	scheduler     *scheduler
+	bulk          *lane
+	headers       headerPolicy
+	vary          varyPolicy
+	forwarded     forwardedPolicy
+	userAgent     userAgentPolicy
+	credentials   credentials
+	admission     AdmissionPolicy
+	transforms    []Transform
+	checksums     []Checksum
+	registry      bool
+	clientCC      cacheControlRules
+	compression   compression
+	chunking      chunking
+	maxObject     int64
+	redactor      *Redactor
+	auth          clientAuth
+	explain       bool
+	epoch         *AtomicInt
+	purges        *purgeCache
+	purgeTTL      time.Duration
+	bootstrapping AtomicInt
+	standby       *standby
+	statsFile     *statsFile
+	reports       *reporter
+	capacity      int64
+	weights       Schedule
+	schedules     []scheduledPurge
+	done          chan struct{}
+	close         sync.Once
 }
 
 // NewPeer creates a Peer.
@@ -62,33 +113,126 @@ func NewPeer(self string, options ...func(*Peer)) *Peer {
 		self:      self,
 		transport: http.DefaultTransport,
 		cache:     httpcache.NewMemoryCache(),
+		origins:   defaultOriginPolicy(),
+		heuristic: defaultHeuristic,
+		buffers:   DefaultBufferPool,
+		redactor:  NewRedactor(),
+		purgeTTL:  DefaultPurgeRetention,
+		maxObject: DefaultMaxObjectSize,
+		done:      make(chan struct{}),
 	}
 
 	for _, option := range options {
 		option(p)
 	}
 
-	p.handler = newProxy(p.Client.path, p.cache, p.transport, p.buffers)
+	p.transport = p.dialer.apply(p.transport)
+	if p.recorder != nil {
+		p.transport = p.recorder.transport("origin", p.transport, p.redactor)
+		p.Client.transport = p.recorder.transport("peer", p.Client.transport, p.redactor)
+	}
+
+	cache := p.cache
+	if p.epoch != nil {
+		cache = withEpoch(cache, p.epoch)
+	}
+	p.purges = newPurgeCache(cache, p.purgeTTL)
+	p.handler = newProxy(p.Client.path, p.purges.cache(), p.transport, p.buffers)
+	p.handler.purges = p.purges
+	p.handler.flush = p.flush
+	p.handler.thresholds = p.thresholds
+	p.handler.thresholds.redactor = p.redactor
+	p.handler.origins = p.origins
+	p.handler.originLimit.max = p.originLimit
+	if p.leaseTTL > 0 {
+		p.handler.leases = ownerLeases{newLeaseTable(p.leaseTTL), p.self, p.Client}
+	}
+	p.handler.heuristic = p.heuristic
+	p.handler.minTTL = p.minTTL
+	p.handler.micro.ttl = p.microTTL
+	p.handler.micro.patterns = p.microPatterns
+	p.handler.micro.matchers = p.microMatchers
+	p.handler.micro.failure = p.microFailure
+	p.handler.micro.coalesce = p.coalesce
+	p.handler.freshness = p.freshness
+	p.handler.scheduler = p.scheduler
+	p.handler.bulk = p.bulk
+	p.handler.headers = p.headers
+	p.handler.vary = p.vary
+	p.handler.forwarded = p.forwarded
+	p.handler.userAgent = p.userAgent
+	p.handler.credentials = p.credentials
+	p.handler.admission = p.admission
+	p.handler.transforms = p.transforms
+	p.handler.checksums = p.checksums
+	p.handler.registry.enabled = p.registry
+	p.handler.clientCC = p.clientCC
+	p.handler.compression = p.compression
+	p.handler.compression.stats = p.handler.stats
+	p.handler.chunking = p.chunking
+	p.handler.maxObject = p.maxObject
+	p.handler.auth = p.auth
+	p.handler.explain = p.explain
+	p.handler.standby = p.standby
+	p.handler.capacity = p.capacity
+	if c, ok := p.cache.(capacityCache); ok && p.capacity == 0 {
+		p.handler.capacity = int64(c.Capacity())
+	}
+	p.Client.secret = p.auth.secret
+	p.handler.stats.PeerLatency = p.Client.stats.PeerLatency
+
+	for _, s := range p.schedules {
+		go p.runSchedule(s)
+	}
+	if p.standby != nil {
+		go p.runStandby()
+	}
+	if p.statsFile != nil {
+		if err := p.statsFile.load(p.handler.stats); err != nil {
+			log.Printf("forwardcache: loading stats: %v", err)
+		}
+		go p.runStatsFile()
+	}
+	if p.reports != nil {
+		p.reports.mark(p.handler.stats, time.Now())
+		go p.runReports()
+	}
+	if p.weights != nil {
+		go p.runRebalance()
+	}
 	return p
 }
 
+// Close stops the background work of the peer started by NewPeer, like
+// the scheduled purges, the standby replication, the reports and the
+// rebalancing, and saves its stats file if it has one.
+func (p *Peer) Close() error {
+	var err error
+	p.close.Do(func() {
+		close(p.done)
+		if p.statsFile != nil {
+			err = p.statsFile.save(p.Stats())
+		}
+	})
+	return err
+}
+
 // Handler returns an http.Handler to be registered using http.Handle
 // for the local Peer to serve requests.
 func (p *Peer) Handler() http.Handler {
 	return p.handler
 }
 
+// Stats returns the statistics of the requests served by the peer.
+func (p *Peer) Stats() *Stats {
+	return p.handler.stats
+}
+
 // RoundTrip makes the request go through one of the peer using its internal
 // Client. If the local peer is targeted, it uses the local handler directly.
 // Since Peer implements the Roundtripper interface, it can be used as a transport.
 func (p *Peer) RoundTrip(req *http.Request) (*http.Response, error) {
-	peer := p.Client.choosePeer(req.URL.String())
-
-	if peer == p.self {
-		return p.handler.Transport.RoundTrip(req)
-	}
-
-	return p.Client.roundTripTo(peer, req)
+	return p.Client.route(req, p.self, p.handler.roundTrip)
 }
 
 // WithClient lets you configure a custom pool client.
@@ -110,22 +254,32 @@ func WithPeerTransport(t http.RoundTripper) func(*Peer) {
 	}
 }
 
-// WithBufferPool lets you configure a custom buffer pool.
-// Defaults to not using a buffer pool.
+// WithBufferPool lets you configure a custom buffer pool used to copy
+// the responses to the clients. A nil pool allocates a buffer per
+// response. Defaults to DefaultBufferPool.
 func WithBufferPool(b httputil.BufferPool) func(*Peer) {
 	return func(p *Peer) {
 		p.buffers = b
 	}
 }
 
-// WithDefaultBufferPool lets you use the default 32k buffer pool.
-// Defaults to not using a buffer pool.
-func WithDefaultBufferPool(b httputil.BufferPool) func(*Peer) {
+// WithDefaultBufferPool lets you use the default buffer pool, handing out
+// 4k, 32k or 256k buffers depending on the size of the responses.
+// It is the default.
+func WithDefaultBufferPool() func(*Peer) {
 	return func(p *Peer) {
 		p.buffers = DefaultBufferPool
 	}
 }
 
+// WithBufferSizes lets you use a buffer pool of its own, with a size
+// class per size (see NewTieredBufferPool).
+func WithBufferSizes(sizes ...int) func(*Peer) {
+	return func(p *Peer) {
+		p.buffers = NewTieredBufferPool(sizes...)
+	}
+}
+
 // WithCache lets you use a custom httpcache.Cache.
 // Defaults to httpcache.MemoryCache.
 func WithCache(c httpcache.Cache) func(*Peer) {
@@ -133,3 +287,19 @@ func WithCache(c httpcache.Cache) func(*Peer) {
 		p.cache = c
 	}
 }
+
+// DefaultMaxObjectSize is the default size of the largest body stored
+// whole, see WithMaxObjectSize.
+const DefaultMaxObjectSize = 64 << 20
+
+// WithMaxObjectSize limits the size of the bodies stored whole, which
+// are kept in memory while they are read, to n bytes. The larger
+// responses are served without being stored, unless they are stored in
+// chunks (see WithChunkedStorage). Zero or less stores the bodies
+// whatever their size.
+// Defaults to DefaultMaxObjectSize.
+func WithMaxObjectSize(n int64) func(*Peer) {
+	return func(p *Peer) {
+		p.maxObject = n
+	}
+}
@@ -73,6 +73,10 @@ func TestPeer(t *testing.T) {
 			}
 		})
 	}
+
+	if got, want := peer.Stats().PeerLatency.Snapshot().Count, int64(1); got != want {
+		t.Errorf("unexpected number of peer latencies: got %d, want %d", got, want)
+	}
 }
 
 func ExampleNewPeer() {
@@ -0,0 +1,80 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// Prefetch warms the caches of the pool by fetching urls with the bulk
+// priority and discarding the responses. At most concurrency urls are
+// fetched at the same time. Prefetching stops when ctx is done. It
+// returns the first error encountered, if any.
+func (c *Client) Prefetch(ctx context.Context, concurrency int, urls ...string) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	ctx = WithPriority(ctx, PriorityBulk)
+
+	var (
+		wg    sync.WaitGroup
+		once  sync.Once
+		first error
+		sem   = make(chan struct{}, concurrency)
+	)
+
+	for _, u := range urls {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			once.Do(func() { first = ctx.Err() })
+			return first
+		}
+
+		wg.Add(1)
+		go func(u string) {
+			defer func() { <-sem; wg.Done() }()
+			if err := c.prefetch(ctx, u); err != nil {
+				once.Do(func() { first = err })
+			}
+		}(u)
+	}
+
+	wg.Wait()
+	return first
+}
+
+func (c *Client) prefetch(ctx context.Context, u string) error {
+	req, err := http.NewRequest("GET", u, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	_, err = io.Copy(ioutil.Discard, res.Body)
+	return err
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+func TestPrefetch(t *testing.T) {
+	var (
+		mu        sync.Mutex
+		fetched   []string
+		inflight  int
+		maxFlight int
+	)
+
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		mu.Lock()
+		inflight++
+		if inflight > maxFlight {
+			maxFlight = inflight
+		}
+		fetched = append(fetched, req.Header.Get(protocol.PriorityHeader))
+		mu.Unlock()
+
+		defer func() {
+			mu.Lock()
+			inflight--
+			mu.Unlock()
+		}()
+
+		if strings.Contains(req.URL.RawQuery, "broken") {
+			return nil, errors.New("connection refused")
+		}
+		return okResponse(), nil
+	})
+
+	client := NewClient(WithPool("http://a.com:3000"), WithClientTransport(transport))
+	err := client.Prefetch(context.Background(), 2,
+		"http://cdn.com/a.js",
+		"http://cdn.com/b.js",
+		"http://cdn.com/broken.js",
+		"http://cdn.com/c.js",
+	)
+
+	if err == nil || err.Error() != "connection refused" {
+		t.Errorf("unexpected error: got %v, want %q", err, "connection refused")
+	}
+	if len(fetched) != 4 {
+		t.Errorf("unexpected number of prefetched urls: got %d, want %d", len(fetched), 4)
+	}
+	for _, p := range fetched {
+		if p != "bulk" {
+			t.Errorf("unexpected priority: got %q, want %q", p, "bulk")
+		}
+	}
+	if maxFlight > 2 {
+		t.Errorf("too many concurrent prefetches: got %d, want <= %d", maxFlight, 2)
+	}
+}
@@ -0,0 +1,196 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxProbedURLs is the number of URLs whose requests are recorded for
+// Peer.Probe, the least recently requested ones being forgotten.
+const maxProbedURLs = 10000
+
+// URLProbe is what a peer knows about a URL, see Peer.Probe.
+type URLProbe struct {
+	URL          string    `json:"url"`
+	Owner        string    `json:"owner"`                  // base URL of the peer owning the URL
+	Self         bool      `json:"self"`                   // whether the probed peer owns the URL
+	Cached       bool      `json:"cached"`                 // whether a response is stored
+	Fresh        bool      `json:"fresh"`                  // whether the stored response is fresh
+	Age          float64   `json:"age"`                    // age of the stored response in seconds
+	TTL          float64   `json:"ttl"`                    // seconds the stored response stays fresh, negative once stale
+	Size         int       `json:"size"`                   // bytes of the stored response
+	Requests     int64     `json:"requests"`               // requests served by the peer
+	Hits         int64     `json:"hits"`                   // requests served from the cache
+	OriginStatus int       `json:"originStatus,omitempty"` // status of the last origin fetch, 0 if it failed
+	OriginFetch  time.Time `json:"originFetch"`            // time of the last origin fetch
+	OriginError  string    `json:"originError,omitempty"`  // error of the last origin fetch
+}
+
+var errProbeURL = errors.New("probed URL must be absolute")
+
+// Probe reports what the peer knows about a URL: its owner, the
+// response stored for it by the peer and the recent requests the peer
+// served for it, answering why a URL is not cached. The requests are
+// recorded for the most recently requested URLs only.
+func (p *Peer) Probe(rawurl string) (URLProbe, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return URLProbe{}, err
+	}
+	if !u.IsAbs() {
+		return URLProbe{}, errProbeURL
+	}
+
+	key := u.String()
+	probe := URLProbe{URL: key, Owner: p.Owner(key)}
+	probe.Self = probe.Owner == p.self
+
+	if dump, ok := p.purges.cache().Get(key); ok {
+		if res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(dump)), nil); err == nil {
+			res.Body.Close()
+			s := newStored(res, nil, time.Now())
+			probe.Cached = true
+			probe.Size = len(dump)
+			probe.Age = s.age.Seconds()
+			probe.TTL = (s.lifetime - s.age).Seconds()
+			probe.Fresh = s.age < s.lifetime
+		}
+	}
+
+	if r, ok := p.Stats().urls.get(key); ok {
+		probe.Requests, probe.Hits = r.requests, r.hits
+		probe.OriginStatus, probe.OriginFetch, probe.OriginError = r.status, r.fetched, r.err
+	}
+	return probe, nil
+}
+
+// urlStats records the requests served for the most recently requested
+// URLs.
+type urlStats struct {
+	mu      sync.Mutex
+	records map[string]*list.Element
+	list    *list.List // of *urlRecord, most recently requested first
+}
+
+type urlRecord struct {
+	url      string
+	requests int64
+	hits     int64
+	status   int
+	fetched  time.Time
+	err      string
+}
+
+// record returns the record of a URL, creating it if needed, the stats
+// being locked.
+func (s *urlStats) record(url string) *urlRecord {
+	if el, ok := s.records[url]; ok {
+		s.list.MoveToFront(el)
+		return el.Value.(*urlRecord)
+	}
+	if s.records == nil {
+		s.records = make(map[string]*list.Element)
+		s.list = list.New()
+	}
+	if s.list.Len() >= maxProbedURLs {
+		delete(s.records, s.list.Remove(s.list.Back()).(*urlRecord).url)
+	}
+	r := &urlRecord{url: url}
+	s.records[url] = s.list.PushFront(r)
+	return r
+}
+
+// request records a request served for a URL.
+func (s *urlStats) request(url string, hit bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.record(url)
+	r.requests++
+	if hit {
+		r.hits++
+	}
+}
+
+// fetch records the status of an origin fetch of a URL, or its error.
+func (s *urlStats) fetch(url string, status int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	r := s.record(url)
+	r.status, r.fetched, r.err = status, time.Now(), ""
+	if err != nil {
+		r.err = err.Error()
+	}
+}
+
+// hottest returns the n most requested URLs, the most recently
+// requested first among equals.
+func (s *urlStats) hottest(n int) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]*urlRecord, 0, len(s.records))
+	if s.list != nil {
+		for el := s.list.Front(); el != nil; el = el.Next() {
+			records = append(records, el.Value.(*urlRecord))
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].requests > records[j].requests
+	})
+	if n < len(records) {
+		records = records[:n]
+	}
+	urls := make([]string, len(records))
+	for i, r := range records {
+		urls[i] = r.url
+	}
+	return urls
+}
+
+// snapshot returns a copy of the records.
+func (s *urlStats) snapshot() []urlRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]urlRecord, 0, len(s.records))
+	for _, el := range s.records {
+		records = append(records, *el.Value.(*urlRecord))
+	}
+	return records
+}
+
+// get returns a copy of the record of a URL.
+func (s *urlStats) get(url string) (urlRecord, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.records[url]; ok {
+		return *el.Value.(*urlRecord), true
+	}
+	return urlRecord{}, false
+}
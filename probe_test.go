@@ -0,0 +1,87 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/down.js" {
+			res := okResponse()
+			res.StatusCode = http.StatusServiceUnavailable
+			res.Header.Del("Expires")
+			return res, nil
+		}
+		return okResponse(), nil
+	})
+	peer := NewPeer("http://self.com:3000", WithPeerTransport(origin))
+	peer.SetPool("http://self.com:3000")
+
+	for _, u := range []string{
+		"http://cdn.com/jquery.js",
+		"http://cdn.com/jquery.js",
+		"http://cdn.com/jquery.js",
+		"http://cdn.com/down.js",
+	} {
+		req, _ := http.NewRequest("GET", "/proxy?q="+url.QueryEscape(u), nil)
+		peer.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	testCases := []struct {
+		url      string
+		cached   bool
+		requests int64
+		hits     int64
+		status   int
+	}{
+		{"http://cdn.com/jquery.js", true, 3, 2, http.StatusOK},
+		{"http://cdn.com/down.js", false, 1, 0, http.StatusServiceUnavailable},
+		{"http://cdn.com/unknown.js", false, 0, 0, 0},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.url, func(t *testing.T) {
+			probe, err := peer.Probe(tC.url)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if probe.Owner != "http://self.com:3000" || !probe.Self {
+				t.Errorf("unexpected owner: got %q (self %v), want %q", probe.Owner, probe.Self, "http://self.com:3000")
+			}
+			if probe.Cached != tC.cached {
+				t.Errorf("unexpected cached: got %v, want %v", probe.Cached, tC.cached)
+			}
+			if tC.cached && (!probe.Fresh || probe.TTL <= 0 || probe.Size == 0) {
+				t.Errorf("unexpected stored response: got fresh %v, ttl %v, size %d", probe.Fresh, probe.TTL, probe.Size)
+			}
+			if probe.Requests != tC.requests || probe.Hits != tC.hits {
+				t.Errorf("unexpected requests: got %d (%d hits), want %d (%d hits)", probe.Requests, probe.Hits, tC.requests, tC.hits)
+			}
+			if probe.OriginStatus != tC.status {
+				t.Errorf("unexpected origin status: got %d, want %d", probe.OriginStatus, tC.status)
+			}
+		})
+	}
+
+	if _, err := peer.Probe("/jquery.js"); err == nil {
+		t.Errorf("expected an error probing a relative URL")
+	}
+}
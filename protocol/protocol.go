@@ -0,0 +1,463 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package protocol documents the contract between clients and peers of
+// a pool so that clients can be written in other languages.
+//
+// A client owns a consistent hash of the peers' base URLs. Each peer is
+// added Replicas times to the ring, the i-th replica being hashed as
+// ReplicaKey(i, peer). A resource is owned by the first replica whose
+// hash is greater or equal to the hash of Key(resource), wrapping around
+// the ring. The default hash function is CRC-32 (IEEE). Pools can agree
+// on another key function, like NormalizedKey, so that equivalent URLs
+// have the same owner. Pools can also weigh the peers by the capacity
+// they report (see LoadURL), each peer being added WeightedReplicas
+// times instead.
+//
+// The owner is then queried with a GET (or HEAD) on PeerURL(owner, path,
+// resource). Requests can optionally be signed with SignRequest, the
+// signature being sent in the SignatureHeader header and the time it was
+// signed at in the TimestampHeader header, be given a priority class
+// in the PriorityHeader header, and carry an API key in the APIKeyHeader
+// header when the peers require one. The signature is the Sign of the
+// request's method, timestamp and resource, separated by newlines (see
+// SignedString), so that it cannot be used for another method or past
+// MaxSkew.
+//
+// The responses carrying a tag in their Surrogate-Key or Cache-Tag
+// header are purged from a peer with a PurgeMethod request on
+// PurgeURL(peer, path, tag, soft). Its signature signs the tag.
+//
+// The URLs a peer served the most are listed, one per line and the most
+// requested first, with a GET on HotURL(peer, path, n), so that a cold
+// peer can fetch the ones it owns from its siblings with only-if-cached
+// requests. Its signature signs n.
+//
+// The load of a peer is reported as a JSON object with a GET on
+// LoadURL(peer, path): its requests in flight ("inFlight"), its number
+// of CPUs ("cpus") and the capacity of its cache in bytes ("capacity",
+// absent if unknown), so that clients can prefer the least loaded of
+// the peers a resource can be fetched from and weigh the peers. Its
+// signature signs "1".
+package protocol
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash/crc32"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mikegleasonjr/forwardcache/consistenthash"
+)
+
+const (
+	// DefaultPath is the default path of the peers' proxy handler.
+	DefaultPath = "/proxy"
+
+	// DefaultReplicas is the default number of replicas per peer.
+	DefaultReplicas = 50
+
+	// QueryParam is the query parameter holding the resource's URL.
+	QueryParam = "q"
+
+	// SignatureHeader is the request header holding the signature
+	// of a signed request.
+	SignatureHeader = "X-Forwardcache-Signature"
+
+	// TimestampHeader is the request header holding the Unix time, in
+	// seconds, at which a request was signed.
+	TimestampHeader = "X-Forwardcache-Timestamp"
+
+	// MaxSkew is the largest difference allowed between the time a
+	// request was signed at and the time it is verified at.
+	MaxSkew = 5 * time.Minute
+
+	// PriorityHeader is the request header holding the priority class
+	// of a request, either "interactive" (the default) or "bulk".
+	PriorityHeader = "X-Forwardcache-Priority"
+
+	// APIKeyHeader is the request header holding the API key of
+	// the client, when peers require one.
+	APIKeyHeader = "X-Forwardcache-Key"
+
+	// ExplainHeader is the request header asking the peer to explain
+	// its caching decisions in the response headers of the same name,
+	// when the peer allows it.
+	ExplainHeader = "X-Forwardcache-Explain"
+
+	// PurgeMethod is the method of the requests purging tags.
+	PurgeMethod = "PURGE"
+
+	// TagParam is the query parameter holding the tag to purge.
+	TagParam = "tag"
+
+	// SoftParam is the query parameter set to 1 to mark the responses
+	// stale instead of deleting them.
+	SoftParam = "soft"
+
+	// HotParam is the query parameter holding the number of URLs to
+	// list, see HotURL.
+	HotParam = "hot"
+
+	// LoadParam is the query parameter asking a peer for its load.
+	LoadParam = "load"
+
+	// LeaseParam is the query parameter holding the URL of the resource
+	// a peer asks its owner a lease on, see LeaseURL.
+	LeaseParam = "lease"
+
+	// LeaseHolderHeader holds the peer asking for a lease in the lease
+	// requests, the peer holding it in their responses, and marks the
+	// requests forwarded to the holder of a lease.
+	LeaseHolderHeader = "X-Forwardcache-Lease-Holder"
+)
+
+// Hash is the default hash function of the ring.
+func Hash(data []byte) uint32 {
+	return crc32.ChecksumIEEE(data)
+}
+
+// ReplicaKey returns the key hashed to place the i-th replica of
+// a peer on the ring.
+func ReplicaKey(i int, peer string) string {
+	return strconv.Itoa(i) + peer
+}
+
+// KeyFunc returns the key hashed to find the owner of a resource.
+type KeyFunc func(resource *url.URL) string
+
+// Key returns the key hashed to find the owner of a resource.
+func Key(resource *url.URL) string {
+	return resource.String()
+}
+
+// NormalizedKey returns a canonical key of a resource: its lowercased
+// host without the default port, followed by its path without dot
+// segments and its query with the parameters sorted, their escapes
+// normalized (see NormalizeEscapes). The scheme and the fragment are
+// ignored.
+func NormalizedKey(resource *url.URL) string {
+	host := strings.ToLower(resource.Host)
+	if port := resource.Port(); port == "80" && resource.Scheme == "http" || port == "443" && resource.Scheme == "https" {
+		host = strings.TrimSuffix(host, ":"+port)
+	}
+
+	p := NormalizeEscapes(resource.EscapedPath())
+	if p == "" {
+		p = "/"
+	} else if clean := path.Clean(p); clean != p {
+		if strings.HasSuffix(p, "/") && clean != "/" {
+			clean += "/"
+		}
+		p = clean
+	}
+
+	key := host + p
+	if resource.RawQuery != "" {
+		query, err := url.ParseQuery(resource.RawQuery)
+		if err != nil {
+			return key + "?" + NormalizeEscapes(resource.RawQuery)
+		}
+		key += "?" + query.Encode()
+	}
+	return key
+}
+
+// NormalizeEscapes normalizes the percent-encoding of an escaped URL
+// component (RFC 3986, 6.2.2): the escaped unreserved characters are
+// decoded and the hexadecimal digits of the others uppercased, so that
+// equivalent URLs have the same key.
+func NormalizeEscapes(s string) string {
+	i := strings.IndexByte(s, '%')
+	if i < 0 {
+		return s
+	}
+
+	b := make([]byte, 0, len(s))
+	b = append(b, s[:i]...)
+	for ; i < len(s); i++ {
+		if s[i] != '%' || i+2 >= len(s) || !isHex(s[i+1]) || !isHex(s[i+2]) {
+			b = append(b, s[i])
+			continue
+		}
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if unreserved(c) {
+			b = append(b, c)
+		} else {
+			b = append(b, '%', upper(s[i+1]), upper(s[i+2]))
+		}
+		i += 2
+	}
+	return string(b)
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c <= '9':
+		return c - '0'
+	case c >= 'a':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+func upper(c byte) byte {
+	if 'a' <= c && c <= 'f' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
+
+// unreserved reports whether c is an unreserved character of URLs
+// (RFC 3986, 2.3).
+func unreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+// PeerURL returns the URL to query on peer to fetch resource.
+func PeerURL(peer, path, resource string) (*url.URL, error) {
+	u, err := url.Parse(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path = path
+	u.RawQuery = string(AppendQuery(nil, resource))
+
+	return u, nil
+}
+
+// PurgeURL returns the URL to query on peer to purge the responses
+// carrying tag.
+func PurgeURL(peer, path, tag string, soft bool) (*url.URL, error) {
+	u, err := url.Parse(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	q := url.Values{TagParam: {tag}}
+	if soft {
+		q.Set(SoftParam, "1")
+	}
+	u.Path = path
+	u.RawQuery = q.Encode()
+
+	return u, nil
+}
+
+// HotURL returns the URL to query on peer to list the n URLs it served
+// the most.
+func HotURL(peer, path string, n int) (*url.URL, error) {
+	u, err := url.Parse(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path = path
+	u.RawQuery = url.Values{HotParam: {strconv.Itoa(n)}}.Encode()
+
+	return u, nil
+}
+
+// WeightedReplicas returns the number of replicas of each peer of a
+// pool weighted by their capacities: replicas times the ratio of its
+// capacity to the mean capacity of the pool, rounded, and at least 1.
+// The peers of unknown capacity (0) count as the mean.
+func WeightedReplicas(replicas int, capacities map[string]int64) map[string]int {
+	var total, known int64
+	for _, c := range capacities {
+		if c > 0 {
+			total += c
+			known++
+		}
+	}
+	weighted := make(map[string]int, len(capacities))
+	for peer, c := range capacities {
+		n := replicas
+		if c > 0 {
+			n = int(math.Round(float64(replicas) * float64(c) * float64(known) / float64(total)))
+		}
+		if n < 1 {
+			n = 1
+		}
+		weighted[peer] = n
+	}
+	return weighted
+}
+
+// LoadURL returns the URL to query on peer to get its load.
+func LoadURL(peer, path string) (*url.URL, error) {
+	u, err := url.Parse(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path = path
+	u.RawQuery = url.Values{LoadParam: {"1"}}.Encode()
+
+	return u, nil
+}
+
+// LeaseURL returns the URL to query on peer, the owner of resource, for
+// a lease on fetching it from its origin.
+func LeaseURL(peer, path, resource string) (*url.URL, error) {
+	u, err := url.Parse(peer)
+	if err != nil {
+		return nil, err
+	}
+
+	u.Path = path
+	u.RawQuery = url.Values{LeaseParam: {resource}}.Encode()
+
+	return u, nil
+}
+
+// AppendQuery appends the query of the URL of resource on a peer to dst
+// and returns the extended buffer, see PeerURL. It lets clients build
+// peer URLs without allocating.
+func AppendQuery(dst []byte, resource string) []byte {
+	const hex = "0123456789ABCDEF"
+
+	dst = append(dst, QueryParam...)
+	dst = append(dst, '=')
+	for i := 0; i < len(resource); i++ {
+		switch c := resource[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			dst = append(dst, c)
+		case c == ' ':
+			dst = append(dst, '+')
+		default:
+			dst = append(dst, '%', hex[c>>4], hex[c&15])
+		}
+	}
+	return dst
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of resource using secret.
+func Sign(secret []byte, resource string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(resource))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify reports whether signature is a valid signature of resource.
+func Verify(secret []byte, resource, signature string) bool {
+	sig, err := hex.DecodeString(signature)
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(resource))
+	return hmac.Equal(sig, mac.Sum(nil))
+}
+
+// SignedString returns the string signed for a request with the given
+// method, timestamp and resource.
+func SignedString(method, timestamp, resource string) string {
+	return method + "\n" + timestamp + "\n" + resource
+}
+
+// SignRequest signs req for resource at t, setting its SignatureHeader
+// and TimestampHeader headers.
+func SignRequest(req *http.Request, secret []byte, resource string, t time.Time) {
+	timestamp := strconv.FormatInt(t.Unix(), 10)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, Sign(secret, SignedString(req.Method, timestamp, resource)))
+}
+
+// VerifyRequest reports whether req is validly signed for resource, at
+// a time within MaxSkew of now.
+func VerifyRequest(req *http.Request, secret []byte, resource string, now time.Time) bool {
+	timestamp := req.Header.Get(TimestampHeader)
+	sec, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	if skew := now.Sub(time.Unix(sec, 0)); skew > MaxSkew || skew < -MaxSkew {
+		return false
+	}
+	return Verify(secret, SignedString(req.Method, timestamp, resource), req.Header.Get(SignatureHeader))
+}
+
+// Vector is a test vector of the protocol. Implementations in other
+// languages can use vectors to validate their ring placement, URL
+// building and signing.
+type Vector struct {
+	Peers     []string `json:"peers"`
+	Replicas  int      `json:"replicas"`
+	Path      string   `json:"path"`
+	Secret    string   `json:"secret"`
+	Resource  string   `json:"resource"`
+	Owner     string   `json:"owner"`
+	PeerURL   string   `json:"peerUrl"`
+	Method    string   `json:"method"`
+	Timestamp string   `json:"timestamp"`
+	Signature string   `json:"signature"` // of the GET of PeerURL at Timestamp
+}
+
+// vectorTimestamp is the time the test vectors are signed at.
+const vectorTimestamp = "1514764800"
+
+// Vectors generates a test vector for each of the resources using the
+// default hash function.
+func Vectors(peers []string, replicas int, path, secret string, resources ...string) ([]Vector, error) {
+	ring := consistenthash.New(replicas, Hash)
+	ring.Add(peers...)
+
+	vectors := make([]Vector, 0, len(resources))
+	for _, r := range resources {
+		u, err := url.Parse(r)
+		if err != nil {
+			return nil, err
+		}
+
+		owner := ring.Get(Key(u))
+		query, err := PeerURL(owner, path, r)
+		if err != nil {
+			return nil, err
+		}
+
+		vectors = append(vectors, Vector{
+			Peers:     peers,
+			Replicas:  replicas,
+			Path:      path,
+			Secret:    secret,
+			Resource:  r,
+			Owner:     owner,
+			PeerURL:   query.String(),
+			Method:    http.MethodGet,
+			Timestamp: vectorTimestamp,
+			Signature: Sign([]byte(secret), SignedString(http.MethodGet, vectorTimestamp, r)),
+		})
+	}
+
+	return vectors, nil
+}
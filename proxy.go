@@ -17,24 +17,60 @@ limitations under the License.
 package forwardcache
 
 import (
-	"context"
+	"errors"
+	"io"
+	"log"
 	"net/http"
+	"net/http/httptrace"
 	"net/http/httputil"
+	"net/textproto"
 	"net/url"
+	"strings"
+	"time"
 
 	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/forwardcache/protocol"
 )
 
-type key int
-
-const originKey key = 1
-
 // proxy is the forward caching proxy on a peer, it uses
-// a cache that conforms to the HTTP RFC (thanks to
-// github.com/gregjones/httpcache)
+// a shared cache that conforms to the HTTP RFC (see cacheTransport)
 type proxy struct {
-	path string
-	*httputil.ReverseProxy
+	path        string
+	stats       *Stats
+	thresholds  thresholds
+	heuristic   heuristic
+	minTTL      time.Duration
+	micro       microCache
+	freshness   []FreshnessPolicy
+	origins     originPolicy
+	originLimit originLimits
+	leases      ownerLeases
+	scheduler   *scheduler
+	bulk        *lane
+	headers     headerPolicy
+	vary        varyPolicy
+	forwarded   forwardedPolicy
+	userAgent   userAgentPolicy
+	credentials credentials
+	admission   AdmissionPolicy
+	transforms  []Transform
+	checksums   []Checksum
+	registry    registryAuth
+	clientCC    cacheControlRules
+	compression compression
+	chunking    chunking
+	maxObject   int64 // bytes of the bodies stored whole, 0 if unlimited
+	auth        clientAuth
+	explain     bool
+	standby     *standby // nil unless the peer is a standby
+	offline     AtomicInt
+	inFlight    AtomicInt // requests for origins being served
+	capacity    int64     // of the cache in bytes, 0 if unknown
+	transport   http.RoundTripper
+	buffers     httputil.BufferPool
+	flush       flushPolicy
+	files       fileCache   // nil if the cache can't open files
+	purges      *purgeCache // nil if the cache can't be purged
 }
 
 // newProxy creates a proxy that serves requests on path using the
@@ -42,47 +78,427 @@ type proxy struct {
 // /path?q=originUrl where originUrl is the resource being
 // requested by the client.
 func newProxy(path string, cache httpcache.Cache, transport http.RoundTripper, buffers httputil.BufferPool) *proxy {
-	return &proxy{
-		path: path,
-		ReverseProxy: &httputil.ReverseProxy{
-			Transport: &httpcache.Transport{
-				Cache:               cache,
-				MarkCachedResponses: true,
-				Transport:           transport,
-			},
-			Director:   director,
-			BufferPool: buffers,
-		},
+	p := &proxy{
+		path:      path,
+		stats:     newStats(),
+		origins:   defaultOriginPolicy(),
+		heuristic: defaultHeuristic,
+		buffers:   buffers,
 	}
+	p.files, _ = cache.(fileCache)
+	if t, ok := transport.(*http.Transport); ok {
+		transport = trackConns(t)
+	}
+	transport = &connStatsTransport{p.stats, transport}
+	transport = &originStatsTransport{p.stats, &p.thresholds, transport}
+	transport = &originLimitTransport{&p.originLimit, p.stats, transport}
+	transport = &registryTransport{&p.registry, transport}
+	transport = &heuristicTransport{&p.heuristic, &surrogateTransport{transport}}
+	transport = &freshnessTransport{[]FreshnessPolicy{(*minTTL)(&p.minTTL), &p.micro}, &p.freshness, transport}
+	transport = &leaseTransport{&p.leases, transport}
+	p.transport = &statsTransport{
+		stats: p.stats,
+		transport: &collapsingTransport{&p.micro, &cacheTransport{
+			cache:       &sizeStatsCache{cache, p.stats},
+			credentials: &p.credentials,
+			admission:   &p.admission,
+			offline:     &p.offline,
+			vary:        &p.vary,
+			transforms:  &p.transforms,
+			checksums:   &p.checksums,
+			chunks:      &p.chunking,
+			maxObject:   &p.maxObject,
+			stats:       p.stats,
+			transport:   transport,
+		}},
+	}
+	return p
 }
 
 // ServeHTTP takes the url of the requested resource to be fetched on the
-// origin and puts in in the request's context to be used later by the proxy director.
+// origin and forwards the request to it, through the cache.
 func (p *proxy) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	if req.URL.Path != p.path {
 		w.WriteHeader(http.StatusBadGateway)
 		return
 	}
 
-	q := req.URL.Query().Get("q")
-	if q == "" {
-		w.WriteHeader(http.StatusBadGateway)
+	query, err := parseQuery(req.URL.RawQuery)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	signed, ok := p.authenticate(w, req, query)
+	if !ok {
 		return
 	}
 
-	origin, err := url.Parse(q)
+	if req.Method == protocol.PurgeMethod {
+		if !p.refuseUnsigned(w, signed, p.auth.secret == nil && p.auth.purges) {
+			p.servePurge(w, req, query)
+		}
+		return
+	}
+
+	if query.origin == "" {
+		if query.hot != "" {
+			if !p.refuseUnsigned(w, signed, p.auth.secret == nil && p.auth.hot) {
+				p.serveHot(w, query.hot)
+			}
+			return
+		}
+		if query.load != "" {
+			p.serveLoad(w, req)
+			return
+		}
+		if query.lease != "" {
+			if !p.refuseUnsigned(w, signed, p.auth.secret == nil) {
+				p.serveLease(w, req, query.lease)
+			}
+			return
+		}
+	}
+
+	if p.refuseStandby(w) {
+		return
+	}
+
+	origin, err := p.origins.parse(query.origin)
 	if err != nil {
+		if p.origins.strict || err == errOriginScheme {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+		return
+	}
+
+	p.inFlight.Add(1)
+	defer p.inFlight.Add(-1)
+
+	if priority := requestPriority(req); priority == PriorityBulk && p.bulk != nil {
+		if err := p.bulk.acquire(req.Context()); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer p.bulk.scheduler.release()
+	} else if p.scheduler != nil {
+		if err := p.scheduler.acquire(req.Context(), priority); err != nil {
+			p.stats.Shed.Add(1)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		defer p.scheduler.release()
+	}
+
+	p.forward(w, req, origin)
+}
+
+// roundTrip serves a request for an origin made by the local peer.
+func (p *proxy) roundTrip(req *http.Request) (*http.Response, error) {
+	if err := p.origins.validate(req.URL); err != nil {
+		return nil, err
+	}
+	if u := normalizeEscapes(req.URL); u != req.URL {
+		req = req.Clone(req.Context())
+		req.URL = u
+	}
+	if p.headers.enabled() || p.userAgent.enabled() || len(p.credentials) > 0 {
+		req = req.Clone(req.Context())
+		p.headers.apply(req.Header)
+		p.userAgent.apply(req.Header)
+		p.credentials.apply(req)
+	}
+	return p.transport.RoundTrip(req)
+}
+
+// forward fetches origin through the cache and copies the response.
+func (p *proxy) forward(w http.ResponseWriter, req *http.Request, origin *url.URL) {
+	if p.serveFile(w, req, origin) {
+		return
+	}
+
+	out := new(http.Request)
+	*out = *req // shares the request's context
+	out.URL = origin
+	out.Host = origin.Host
+	out.RequestURI = ""
+	out.Close = false
+	out.Header = req.Header.Clone()
+	if out.Header == nil {
+		out.Header = make(http.Header)
+	}
+	if req.ContentLength == 0 {
+		out.Body = nil // the transport would retry requests with a body
+	}
+
+	var explained *explanation
+	if p.explain && req.Header.Get(protocol.ExplainHeader) != "" {
+		explained = new(explanation)
+		out = withExplanation(out, explained)
+	}
+	if req.Header.Get(protocol.LeaseHolderHeader) != "" {
+		out = withLease(out)
+	}
+
+	p.rewrite(out)
+
+	res, err := p.transport.RoundTrip(relayInformational(w, out))
+	if err != nil {
+		if req.Context().Err() == nil {
+			log.Printf("http: proxy error: %v", err)
+		}
 		w.WriteHeader(http.StatusBadGateway)
 		return
 	}
+	defer res.Body.Close()
+
+	removeHopHeaders(res.Header)
+	p.clientCC.apply(origin, res.StatusCode, res.Header)
+	dst, size := http.ResponseWriter(w), res.ContentLength
+	gz := p.compression.compress(w, req, res)
+	if gz != nil {
+		defer gz.Close()
+		dst, size = gz, -1
+	}
+	h := w.Header()
+	for k, v := range res.Header {
+		h[k] = append(h[k], v...)
+	}
+	if explained != nil {
+		explained.annotate(h)
+	}
+	w.WriteHeader(res.StatusCode)
+
+	delay := p.flush.delay(res.Header, res.ContentLength)
+	if err := copyBody(dst, res.Body, size, p.buffers, delay); err != nil {
+		// aborts the response so that the client doesn't take a
+		// truncated body for a complete one
+		if req.Context().Err() == nil {
+			log.Printf("http: proxy error: %v", err)
+		}
+		panic(http.ErrAbortHandler)
+	}
+	if gz != nil {
+		gz.Close()
+	}
+
+	for k, v := range res.Trailer {
+		h[http.TrailerPrefix+k] = v
+	}
+}
+
+// rewrite modifies a request to be sent to the origin.
+func (p *proxy) rewrite(req *http.Request) {
+	removeHopHeaders(req.Header)
+	delete(req.Header, protocol.APIKeyHeader)
+	delete(req.Header, protocol.SignatureHeader)
+	delete(req.Header, protocol.ExplainHeader)
+	delete(req.Header, protocol.LeaseHolderHeader)
+	p.headers.apply(req.Header)
+	p.forwarded.apply(req)
+	p.userAgent.apply(req.Header)
+	p.credentials.apply(req)
+	if _, ok := req.Header["User-Agent"]; !ok {
+		req.Header.Set("User-Agent", "") // prevents the transport from adding its own
+	}
+}
+
+// relayInformational returns a copy of req relaying the informational
+// (1xx) responses it gets, like 103 Early Hints, to w before the final
+// response.
+func relayInformational(w http.ResponseWriter, req *http.Request) *http.Request {
+	trace := &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			relayed := http.Header(header).Clone()
+			removeHopHeaders(relayed)
+			h := w.Header()
+			for k, v := range relayed {
+				h[k] = v
+			}
+			w.WriteHeader(code)
+			// the headers of 1xx responses are not cleared once written
+			for k := range relayed {
+				delete(h, k)
+			}
+			return nil
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// copyBody copies a response body of size bytes (-1 if unknown) to the
+// client using a buffer of buffers, flushing at most delay after the
+// writes, after each write if delay is negative or never if it is 0
+// (see flushPolicy). It returns the read errors. Bodies implementing
+// io.WriterTo, like the files of disk caches which can be sent with
+// io.ReaderFrom (and sendfile), write themselves unless they are
+// flushed.
+func copyBody(w http.ResponseWriter, body io.Reader, size int64, buffers httputil.BufferPool, delay time.Duration) error {
+	if wt, ok := body.(io.WriterTo); ok && delay == 0 {
+		_, err := wt.WriteTo(w)
+		return err
+	}
+
+	var buf []byte
+	if sized, ok := buffers.(sizedBufferPool); ok {
+		buf = sized.GetSized(size)
+		defer buffers.Put(buf)
+	} else if buffers != nil {
+		buf = buffers.Get()
+		defer buffers.Put(buf)
+	}
+	if len(buf) == 0 {
+		buf = make([]byte, defaultBufferSize)
+	}
+
+	flusher, _ := w.(http.Flusher)
+	var dst io.Writer = w
+	if delay > 0 && flusher != nil {
+		d := &delayedFlusher{w: w, flusher: flusher, delay: delay}
+		defer d.stop()
+		dst = d
+	}
+	for {
+		n, err := body.Read(buf)
+		if n > 0 {
+			if _, werr := dst.Write(buf[:n]); werr != nil {
+				return nil // the client went away
+			}
+			if delay < 0 && flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
 
-	ctx := context.WithValue(req.Context(), originKey, origin)
-	p.ReverseProxy.ServeHTTP(w, req.WithContext(ctx))
+// hopHeaders are the hop-by-hop headers, they are not forwarded.
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
 }
 
-// director modifies the requested URL to the origin.
-func director(req *http.Request) {
-	origin := req.Context().Value(originKey).(*url.URL)
-	req.URL = origin
-	req.Host = origin.Host
+func removeHopHeaders(h http.Header) {
+	for _, f := range h["Connection"] {
+		for _, name := range strings.Split(f, ",") {
+			if name = textproto.TrimString(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		delete(h, name)
+	}
+}
+
+var errAmbiguousQuery = errors.New("ambiguous query: repeated parameter or ';' separator")
+
+// peerQuery holds the parameters of a request made to a peer.
+type peerQuery struct {
+	origin string // protocol.QueryParam
+	hot    string // protocol.HotParam
+	load   string // protocol.LoadParam
+	tag    string // protocol.TagParam
+	soft   string // protocol.SoftParam
+	lease  string // protocol.LeaseParam
+}
+
+// signed returns the parameter covered by the signature of a request.
+func (q peerQuery) signed(method string) string {
+	switch {
+	case method == protocol.PurgeMethod:
+		return q.tag
+	case q.origin != "":
+		return q.origin
+	case q.load != "":
+		return q.load
+	case q.lease != "":
+		return q.lease
+	}
+	return q.hot
+}
+
+// parseQuery parses the parameters of a request made to a peer without
+// allocating a map. The query is parsed once, the values authenticated
+// being the ones served, so a query that could be read differently, by
+// repeating a parameter or separating the pairs with ';', is rejected.
+func parseQuery(query string) (peerQuery, error) {
+	var q peerQuery
+	if strings.IndexByte(query, ';') >= 0 {
+		return q, errAmbiguousQuery
+	}
+
+	var seen uint8
+	for query != "" {
+		var param string
+		param, _, query = cutParam(query)
+		k, v, err := splitParam(param)
+		if err != nil {
+			return q, errAmbiguousQuery
+		}
+
+		var dst *string
+		var bit uint8
+		switch k {
+		case protocol.QueryParam:
+			dst, bit = &q.origin, 1
+		case protocol.HotParam:
+			dst, bit = &q.hot, 2
+		case protocol.LoadParam:
+			dst, bit = &q.load, 4
+		case protocol.TagParam:
+			dst, bit = &q.tag, 8
+		case protocol.SoftParam:
+			dst, bit = &q.soft, 16
+		case protocol.LeaseParam:
+			dst, bit = &q.lease, 32
+		default:
+			continue
+		}
+		if seen&bit != 0 {
+			return q, errAmbiguousQuery
+		}
+		seen |= bit
+
+		if *dst, err = url.QueryUnescape(v); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+// cutParam cuts the first parameter off a raw query, at the first '&'
+// or ';', returning the separator found, if any.
+func cutParam(query string) (param, sep, rest string) {
+	if i := strings.IndexAny(query, "&;"); i >= 0 {
+		return query[:i], query[i : i+1], query[i+1:]
+	}
+	return query, "", ""
+}
+
+// splitParam returns the unescaped key and the raw value of a raw
+// query parameter.
+func splitParam(param string) (key, value string, err error) {
+	key = param
+	if i := strings.IndexByte(param, '='); i >= 0 {
+		key, value = param[:i], param[i+1:]
+	}
+	if strings.IndexByte(key, '%') >= 0 || strings.IndexByte(key, '+') >= 0 {
+		key, err = url.QueryUnescape(key)
+	}
+	return key, value, err
 }
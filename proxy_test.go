@@ -17,10 +17,13 @@ limitations under the License.
 package forwardcache
 
 import (
+	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/http/httptrace"
+	"net/textproto"
 	"net/url"
 	"strings"
 	"testing"
@@ -162,3 +165,165 @@ func okResponse() *http.Response {
 		},
 	}
 }
+
+func TestParseQuery(t *testing.T) {
+	testCases := []struct {
+		query string
+		want  peerQuery
+		err   bool
+	}{
+		{"q=" + url.QueryEscape("http://cdn.com/a.js?v=1&w=2"), peerQuery{origin: "http://cdn.com/a.js?v=1&w=2"}, false},
+		{"a=1&q=b+c", peerQuery{origin: "b c"}, false},
+		{"%71=e", peerQuery{origin: "e"}, false},
+		{"tag=t&soft=1", peerQuery{tag: "t", soft: "1"}, false},
+		{"lease=" + url.QueryEscape("http://cdn.com/a.js"), peerQuery{lease: "http://cdn.com/a.js"}, false},
+		{"q", peerQuery{}, false},
+		{"a=1&a=2", peerQuery{}, false},
+		{"", peerQuery{}, false},
+		{"q=b&q=d", peerQuery{}, true},
+		{"q=b&%71=d", peerQuery{}, true},
+		{"q=http://evil.internal/x;y&q=d", peerQuery{}, true},
+		{"a=1;q=d", peerQuery{}, true},
+		{"q=%zz", peerQuery{}, true},
+	}
+	for _, tC := range testCases {
+		got, err := parseQuery(tC.query)
+		if (err != nil) != tC.err {
+			t.Errorf("unexpected error for %q: got %v, want error %t", tC.query, err, tC.err)
+			continue
+		}
+		if err == nil && got != tC.want {
+			t.Errorf("unexpected parameters of %q: got %+v, want %+v", tC.query, got, tC.want)
+		}
+	}
+}
+
+func TestProxyHopHeaders(t *testing.T) {
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		for _, h := range []string{"Connection", "Keep-Alive", "X-Hop", "Proxy-Authorization"} {
+			if v := req.Header.Get(h); v != "" {
+				res.Header.Set("X-Got-"+h, v)
+			}
+		}
+		res.Header.Set("Connection", "X-Origin-Hop")
+		res.Header.Set("X-Origin-Hop", "1")
+		return res, nil
+	})
+	proxy := newProxy("/p", &noopCache{}, origin, DefaultBufferPool)
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/p?q="+url.QueryEscape("http://cdn.com/jquery.js"), nil)
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("Keep-Alive", "timeout=5")
+	req.Header.Set("X-Hop", "1")
+	req.Header.Set("Proxy-Authorization", "Basic dXNlcg==")
+	proxy.ServeHTTP(rr, req)
+
+	for k := range rr.Header() {
+		if strings.HasPrefix(k, "X-Got-") || k == "Connection" || k == "X-Origin-Hop" {
+			t.Errorf("unexpected hop-by-hop header: %s", k)
+		}
+	}
+	if req.Header.Get("X-Hop") != "1" {
+		t.Errorf("unexpected modified request")
+	}
+}
+
+// writerToBody is a body implementing io.WriterTo, failing on Read.
+type writerToBody struct {
+	*strings.Reader
+	writes int
+}
+
+func (b *writerToBody) Read([]byte) (int, error) { return 0, errors.New("unexpected read") }
+func (b *writerToBody) Close() error             { return nil }
+func (b *writerToBody) WriteTo(w io.Writer) (int64, error) {
+	b.writes++
+	return b.Reader.WriteTo(w)
+}
+
+func TestProxyWriterTo(t *testing.T) {
+	body := &writerToBody{Reader: strings.NewReader("OK")}
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.ContentLength = 2
+		res.Body = body
+		return res, nil
+	})
+	proxy := newProxy("/p", &noopCache{}, origin, DefaultBufferPool)
+	proxy.transport = &statsTransport{proxy.stats, origin} // like a cache serving files
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/p?q="+url.QueryEscape("http://cdn.com/jquery.js"), nil)
+	proxy.ServeHTTP(rr, req)
+
+	if rr.Body.String() != "OK" {
+		t.Errorf("unexpected body: got %q, want %q", rr.Body.String(), "OK")
+	}
+	if body.writes != 1 {
+		t.Errorf("unexpected number of WriteTo calls: got %d, want %d", body.writes, 1)
+	}
+	if got := proxy.stats.BytesServed.Get(); got != 2 {
+		t.Errorf("unexpected bytes served: got %d, want %d", got, 2)
+	}
+}
+
+func TestProxyTrailersAndEarlyHints(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", "</app.css>; rel=preload")
+		w.WriteHeader(http.StatusEarlyHints)
+		w.Header().Del("Link")
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, "body")
+		w.(http.Flusher).Flush()
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	defer origin.Close()
+
+	var peer *Peer
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer.Handler().ServeHTTP(w, r)
+	}))
+	defer server.Close()
+	peer = NewPeer(server.URL)
+	peer.SetPool(server.URL)
+	client := NewClient(WithPool(server.URL))
+
+	for i, wantHints := range []string{"</app.css>; rel=preload", ""} {
+		var hints []string
+		trace := &httptrace.ClientTrace{
+			Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+				if code == http.StatusEarlyHints {
+					hints = append(hints, header.Get("Link"))
+				}
+				return nil
+			},
+		}
+		req, _ := http.NewRequest("GET", origin.URL+"/app.html", nil)
+		res, err := client.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		if got, want := string(body), "body"; got != want {
+			t.Errorf("unexpected body of request %d: got %q, want %q", i, got, want)
+		}
+		if got, want := res.Trailer.Get("Grpc-Status"), "0"; got != want {
+			t.Errorf("unexpected trailer of request %d: got %q, want %q", i, got, want)
+		}
+		if got := strings.Join(hints, ","); got != wantHints {
+			t.Errorf("unexpected early hints of request %d: got %q, want %q", i, got, wantHints)
+		}
+		if got := res.Header.Get("Link"); got != "" {
+			t.Errorf("unexpected Link header in the response of request %d: got %q, want none", i, got)
+		}
+		if got, want := res.Header.Get(httpcache.XFromCache), map[int]string{0: "", 1: "1"}[i]; got != want {
+			t.Errorf("unexpected %s of request %d: got %q, want %q", httpcache.XFromCache, i, got, want)
+		}
+	}
+}
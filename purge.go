@@ -0,0 +1,458 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+// storedHeader records when a response was stored in the cache so that
+// purges only apply to the responses stored before them. It is removed
+// from the responses served.
+const storedHeader = "X-Forwardcache-Stored"
+
+// softPurgedHeader marks the responses made stale by a soft purge,
+// holding their original Cache-Control.
+const softPurgedHeader = "X-Forwardcache-Soft-Purged"
+
+// DefaultPurgeRetention is how long the purges are applied by default,
+// see WithPurgeRetention.
+const DefaultPurgeRetention = 7 * 24 * time.Hour
+
+// purgeCache applies purges when the responses are looked up, like bans
+// in Varnish: a response matching a purge and stored before it is
+// deleted, or made stale by a soft purge, instead of being served, so
+// that purging doesn't iterate the cache. The purges are ordered by
+// time, so that only the ones made after a response was stored are
+// matched against it, and dropped after the retention.
+type purgeCache struct {
+	httpcache.Cache
+	retention time.Duration
+	mu        sync.RWMutex
+	purges    []timedPurge // the latest last
+}
+
+type purge struct {
+	pattern string // purges the keys matching pattern,
+	tag     string // or the responses carrying tag
+	soft    bool
+}
+
+type timedPurge struct {
+	purge
+	at time.Time
+}
+
+func newPurgeCache(cache httpcache.Cache, retention time.Duration) *purgeCache {
+	return &purgeCache{Cache: cache, retention: retention}
+}
+
+// cache returns the purgeCache, keeping the ability of the underlying
+// cache to open files.
+func (c *purgeCache) cache() httpcache.Cache {
+	if files, ok := c.Cache.(fileCache); ok {
+		return &purgeFileCache{c, files}
+	}
+	return c
+}
+
+func (c *purgeCache) Get(key string) ([]byte, bool) {
+	resp, ok := c.Cache.Get(key)
+	if !ok {
+		return nil, false
+	}
+	hard, soft := c.purged(key, storedAt(resp), func() http.Header { return dumpedHeader(resp) })
+	if !hard && soft {
+		// revalidated by the cache transport, which stores it back once valid
+		if resp, ok = markStale(resp); ok {
+			return resp, true
+		}
+	}
+	if hard || soft {
+		c.Cache.Delete(key)
+		return nil, false
+	}
+	return resp, true
+}
+
+func (c *purgeCache) Set(key string, resp []byte) {
+	c.Cache.Set(key, stamp(unmarkStale(resp), time.Now()))
+}
+
+// purge purges the responses whose keys match pattern or carrying tag,
+// see Peer.Purge and Peer.PurgeTag. A later purge of the same pattern
+// or tag replaces the earlier one. The purges older than the retention
+// are dropped.
+func (c *purgeCache) purge(p purge) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purges := c.purges[:0]
+	for _, tp := range c.purges {
+		if tp.purge != p && (c.retention <= 0 || now.Sub(tp.at) < c.retention) {
+			purges = append(purges, tp)
+		}
+	}
+	for i := len(purges); i < len(c.purges); i++ {
+		c.purges[i] = timedPurge{} // for the patterns to be collected
+	}
+	c.purges = append(purges, timedPurge{p, now})
+}
+
+// purged reports whether a response stored at stored was purged or
+// soft purged, header returning the header of the stored response.
+func (c *purgeCache) purged(key string, stored time.Time, header func() http.Header) (hard, soft bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// the keys of requests other than GET are prefixed with their method
+	if i := strings.IndexByte(key, ' '); i >= 0 {
+		key = key[i+1:]
+	}
+	var h http.Header
+	for i := len(c.purges) - 1; i >= 0 && !(hard && soft); i-- {
+		p := c.purges[i]
+		if !stored.Before(p.at) {
+			break // the earlier purges don't apply either
+		}
+		if p.soft && soft || !p.soft && hard {
+			continue
+		}
+		if p.tag != "" {
+			if h == nil {
+				h = header()
+			}
+			if !hasTag(h, p.tag) {
+				continue
+			}
+		} else if !matchKey(p.pattern, key) {
+			continue
+		}
+		if p.soft {
+			soft = true
+		} else {
+			hard = true
+		}
+	}
+	return hard, soft
+}
+
+// purgeFileCache is a purgeCache over a file cache.
+type purgeFileCache struct {
+	*purgeCache
+	files fileCache
+}
+
+// Open doesn't open the purged responses, letting the soft purged ones
+// be revalidated through Get.
+func (c *purgeFileCache) Open(key string) (*http.Response, error) {
+	res, err := c.files.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	hard, soft := c.purged(key, parseStored(res.Header.Get(storedHeader)), func() http.Header { return res.Header })
+	if hard || soft {
+		res.Body.Close()
+		if hard {
+			c.Cache.Delete(key)
+		}
+		return nil, errPurged
+	}
+	return res, nil
+}
+
+var errPurged = errors.New("purged")
+
+// markStale returns a copy of a dumped response that the cache
+// transport revalidates before serving it, by adding no-cache to its
+// Cache-Control.
+func markStale(resp []byte) ([]byte, bool) {
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(resp)), nil)
+	if err != nil {
+		return nil, false
+	}
+	defer res.Body.Close()
+
+	cc := res.Header.Get("Cache-Control")
+	res.Header.Set(softPurgedHeader, cc)
+	if cc != "" {
+		cc += ", "
+	}
+	res.Header.Set("Cache-Control", cc+"no-cache")
+
+	stale, err := httputil.DumpResponse(res, true)
+	return stale, err == nil
+}
+
+// unmarkStale reverts markStale on a dumped response.
+func unmarkStale(resp []byte) []byte {
+	header := resp
+	if end := bytes.Index(resp, []byte("\r\n\r\n")); end >= 0 {
+		header = resp[:end]
+	}
+	if !bytes.Contains(header, []byte("\r\n"+softPurgedHeader+":")) {
+		return resp
+	}
+
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(resp)), nil)
+	if err != nil {
+		return resp
+	}
+	defer res.Body.Close()
+	unmarkStaleHeader(res.Header)
+	if fresh, err := httputil.DumpResponse(res, true); err == nil {
+		return fresh
+	}
+	return resp
+}
+
+// unmarkStaleHeader restores the Cache-Control of a response marked by
+// markStale, unless the origin sent a new one while revalidating it.
+func unmarkStaleHeader(h http.Header) {
+	cc, ok := h[softPurgedHeader]
+	if !ok {
+		return
+	}
+	delete(h, softPurgedHeader)
+
+	original := ""
+	if len(cc) > 0 {
+		original = cc[0]
+	}
+	stale := "no-cache"
+	if original != "" {
+		stale = original + ", no-cache"
+	}
+	if h.Get("Cache-Control") != stale {
+		return
+	}
+	if original == "" {
+		h.Del("Cache-Control")
+	} else {
+		h.Set("Cache-Control", original)
+	}
+}
+
+// matchKey reports whether the url of a cache key matches a pattern,
+// patterns starting with a slash matching the path and query of the url.
+func matchKey(pattern, key string) bool {
+	if strings.HasPrefix(pattern, "/") {
+		u, err := url.Parse(key)
+		if err != nil {
+			return false
+		}
+		key = u.RequestURI()
+	}
+	return match(pattern, key)
+}
+
+// match reports whether s matches a pattern whose stars match any
+// sequence of characters.
+func match(pattern, s string) bool {
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 1 {
+		return pattern == s
+	}
+	if !strings.HasPrefix(s, parts[0]) {
+		return false
+	}
+	s = s[len(parts[0]):]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(s, part)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(part):]
+	}
+	return strings.HasSuffix(s, parts[len(parts)-1])
+}
+
+// stamp records in a dumped response when it is stored, replacing
+// a previous record.
+func stamp(resp []byte, now time.Time) []byte {
+	end := bytes.Index(resp, []byte("\r\n"))
+	if end < 0 {
+		return resp
+	}
+	line := "\r\n" + storedHeader + ": " + strconv.FormatInt(now.UnixNano(), 10)
+
+	stamped := make([]byte, 0, len(resp)+len(line))
+	stamped = append(stamped, resp[:end]...)
+	stamped = append(stamped, line...)
+	rest := resp[end:]
+	if i, j := storedLine(rest); i >= 0 {
+		stamped = append(stamped, rest[:i]...)
+		rest = rest[j:]
+	}
+	return append(stamped, rest...)
+}
+
+// dumpedHeader returns the header of a dumped response.
+func dumpedHeader(resp []byte) http.Header {
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(resp)), nil)
+	if err != nil {
+		return http.Header{}
+	}
+	res.Body.Close()
+	return res.Header
+}
+
+func parseStored(value string) time.Time {
+	nsec, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	return time.Unix(0, nsec)
+}
+
+// storedAt returns when a dumped response was stored, the zero time if
+// it wasn't stamped.
+func storedAt(resp []byte) time.Time {
+	i, j := storedLine(resp)
+	if i < 0 {
+		return time.Time{}
+	}
+	return parseStored(string(resp[i+len("\r\n"+storedHeader+": ") : j]))
+}
+
+// storedLine returns the bounds of the storedHeader line of a dumped
+// response, -1 if absent.
+func storedLine(resp []byte) (int, int) {
+	if end := bytes.Index(resp, []byte("\r\n\r\n")); end >= 0 {
+		resp = resp[:end+2]
+	}
+	i := bytes.Index(resp, []byte("\r\n"+storedHeader+": "))
+	if i < 0 {
+		return -1, -1
+	}
+	j := bytes.Index(resp[i+2:], []byte("\r\n"))
+	if j < 0 {
+		return -1, -1
+	}
+	return i, i + 2 + j
+}
+
+// Purge purges the responses cached by the peer whose URLs match
+// pattern, where a star matches any sequence of characters. Patterns
+// starting with a slash match the path and query of the URLs, like
+// "/api/catalog/*", the others the whole URLs. The purged responses
+// are deleted when they are next looked up, the responses stored after
+// the purge being served. The caches of the other peers of the pool
+// are left untouched.
+func (p *Peer) Purge(pattern string) {
+	p.purges.purge(purge{pattern: pattern})
+}
+
+// SoftPurge marks the responses matching pattern (see Purge) as stale
+// instead of deleting them: they are revalidated with the origin when
+// next requested, and still served if the origin fails and they allow
+// it with stale-if-error.
+func (p *Peer) SoftPurge(pattern string) {
+	p.purges.purge(purge{pattern: pattern, soft: true})
+}
+
+// Schedule tells when scheduled purges happen, see WithScheduledPurge.
+type Schedule interface {
+	// Next returns the first time of the schedule after t.
+	Next(t time.Time) time.Time
+}
+
+type daily struct {
+	hour, min int
+	loc       *time.Location
+}
+
+// DailyAt returns a Schedule of every day at hour:min in loc.
+func DailyAt(hour, min int, loc *time.Location) Schedule {
+	return daily{hour, min, loc}
+}
+
+func (d daily) Next(t time.Time) time.Time {
+	t = t.In(d.loc)
+	next := time.Date(t.Year(), t.Month(), t.Day(), d.hour, d.min, 0, 0, d.loc)
+	if !next.After(t) {
+		next = time.Date(t.Year(), t.Month(), t.Day()+1, d.hour, d.min, 0, 0, d.loc)
+	}
+	return next
+}
+
+type every time.Duration
+
+// Every returns a Schedule of every d.
+func Every(d time.Duration) Schedule {
+	return every(d)
+}
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+type scheduledPurge struct {
+	pattern  string
+	schedule Schedule
+}
+
+// WithScheduledPurge purges the responses matching pattern (see
+// Peer.Purge) on a schedule, for origins publishing at known times:
+//
+//	WithScheduledPurge("/api/catalog/*", DailyAt(2, 0, time.Local))
+//
+// The purges stop when the peer is closed.
+func WithScheduledPurge(pattern string, s Schedule) func(*Peer) {
+	return func(p *Peer) {
+		p.schedules = append(p.schedules, scheduledPurge{pattern, s})
+	}
+}
+
+// runSchedule purges on a schedule until the peer is closed.
+func (p *Peer) runSchedule(s scheduledPurge) {
+	for {
+		now := time.Now()
+		timer := time.NewTimer(s.schedule.Next(now).Sub(now))
+		select {
+		case <-timer.C:
+			p.Purge(s.pattern)
+		case <-p.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// WithPurgeRetention specifies how long the purges are applied (see
+// Peer.Purge), so that they don't accumulate. A response stored before
+// a purge dropped after the retention, and not looked up since, is
+// served again: the retention should exceed how long the purged
+// responses stay in the cache. Zero keeps the purges forever.
+// Defaults to DefaultPurgeRetention.
+func WithPurgeRetention(retention time.Duration) func(*Peer) {
+	return func(p *Peer) {
+		p.purgeTTL = retention
+	}
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestMatchKey(t *testing.T) {
+	testCases := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"http://cdn.com/jquery.js", "http://cdn.com/jquery.js", true},
+		{"http://cdn.com/jquery.js", "http://cdn.com/jquery.min.js", false},
+		{"http://cdn.com/*", "http://cdn.com/js/jquery.js", true},
+		{"http://cdn.com/*.css", "http://cdn.com/js/jquery.js", false},
+		{"*/jquery*.js", "http://cdn.com/js/jquery.min.js", true},
+		{"/api/catalog/*", "http://shop.com/api/catalog/1?page=2", true},
+		{"/api/catalog/*", "http://shop.com/api/cart/1", false},
+		{"/api/catalog/*", "http://shop.com/v2/api/catalog/1", false},
+		{"/a*b*c", "http://x.com/abc", true},
+		{"/a*b*c", "http://x.com/acb", false},
+	}
+	for _, tC := range testCases {
+		if got := matchKey(tC.pattern, tC.key); got != tC.want {
+			t.Errorf("unexpected match of %q by %q: got %t, want %t", tC.key, tC.pattern, got, tC.want)
+		}
+	}
+}
+
+func TestStamp(t *testing.T) {
+	resp := []byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nOK")
+	first, second := time.Unix(0, 1), time.Unix(0, 2)
+
+	stamped := stamp(stamp(resp, first), second)
+	want := "HTTP/1.1 200 OK\r\nX-Forwardcache-Stored: 2\r\nContent-Length: 2\r\n\r\nOK"
+	if string(stamped) != want {
+		t.Errorf("unexpected stamped response: got %q, want %q", stamped, want)
+	}
+	if got := parseStored(dumpedHeader(stamped).Get(storedHeader)); !got.Equal(second) {
+		t.Errorf("unexpected stored time: got %v, want %v", got, second)
+	}
+}
+
+func TestPurge(t *testing.T) {
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return okResponse(), nil
+	})
+	peer := NewPeer("http://self.com:3000", WithPeerTransport(origin))
+
+	get := func(u string) string {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/proxy?q="+url.QueryEscape(u), nil)
+		peer.Handler().ServeHTTP(rr, req)
+		if stored := rr.HeaderMap.Get(storedHeader); stored != "" {
+			t.Errorf("unexpected %q header: got %q, want none", storedHeader, stored)
+		}
+		return rr.HeaderMap.Get(httpcache.XFromCache)
+	}
+
+	testCases := []struct {
+		purge      string
+		url        string
+		xFromCache string
+	}{
+		{"", "http://cdn.com/js/jquery.js", ""},
+		{"", "http://cdn.com/css/bootstrap.css", ""},
+		{"", "http://cdn.com/js/jquery.js", "1"},
+		{"/js/*", "http://cdn.com/js/jquery.js", ""},
+		{"", "http://cdn.com/js/jquery.js", "1"},
+		{"", "http://cdn.com/css/bootstrap.css", "1"},
+		{"http://cdn.com/*", "http://cdn.com/css/bootstrap.css", ""},
+	}
+	for i, tC := range testCases {
+		if tC.purge != "" {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/purge?pattern="+url.QueryEscape(tC.purge), nil)
+			peer.AdminHandler().ServeHTTP(rr, req)
+			if rr.Code != http.StatusNoContent {
+				t.Fatalf("unexpected purge status: got %d, want %d", rr.Code, http.StatusNoContent)
+			}
+		}
+		if xFromCache := get(tC.url); xFromCache != tC.xFromCache {
+			t.Errorf("request %d: unexpected %q header: got %q, want %q", i, httpcache.XFromCache, xFromCache, tC.xFromCache)
+		}
+	}
+}
+
+func TestPurgeRetention(t *testing.T) {
+	c := newPurgeCache(httpcache.NewMemoryCache(), time.Hour)
+	resp := []byte("HTTP/1.1 200 OK\r\n\r\n")
+
+	c.Set("http://cdn.com/a.js", resp)
+	c.purge(purge{pattern: "http://cdn.com/a.js"})
+	c.purge(purge{pattern: "http://cdn.com/a.js"})
+	if got, want := len(c.purges), 1; got != want {
+		t.Errorf("unexpected purges after repeating one: got %d, want %d", got, want)
+	}
+	if _, ok := c.Get("http://cdn.com/a.js"); ok {
+		t.Errorf("expected key '%s' to be purged", "http://cdn.com/a.js")
+	}
+
+	// responses stored after the purges are not matched against them
+	c.Set("http://cdn.com/a.js", resp)
+	if _, ok := c.Get("http://cdn.com/a.js"); !ok {
+		t.Errorf("expected key '%s' to be served", "http://cdn.com/a.js")
+	}
+
+	c.purges[0].at = time.Now().Add(-2 * time.Hour)
+	c.purge(purge{pattern: "http://cdn.com/b.js"})
+	if len(c.purges) != 1 || c.purges[0].pattern != "http://cdn.com/b.js" {
+		t.Errorf("unexpected purges after the retention: got %+v", c.purges)
+	}
+}
+
+func TestStoredAt(t *testing.T) {
+	at := time.Unix(0, 42)
+	testCases := []struct {
+		resp []byte
+		want time.Time
+	}{
+		{stamp([]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nOK"), at), at},
+		{[]byte("HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nOK"), time.Time{}},
+		{[]byte("HTTP/1.1 200 OK\r\n\r\nX-Forwardcache-Stored: 42\r\n"), time.Time{}},
+	}
+	for _, tC := range testCases {
+		if got := storedAt(tC.resp); !got.Equal(tC.want) {
+			t.Errorf("unexpected stored time of %q: got %v, want %v", tC.resp, got, tC.want)
+		}
+	}
+}
+
+func TestScheduledPurge(t *testing.T) {
+	cache := httpcache.NewMemoryCache()
+	peer := NewPeer("http://self.com:3000", WithCache(cache), WithScheduledPurge("*", Every(time.Millisecond)))
+	defer peer.Close()
+
+	peer.purges.Set("http://cdn.com/jquery.js", []byte("HTTP/1.1 200 OK\r\n\r\n"))
+	time.Sleep(10 * time.Millisecond)
+	if _, ok := peer.purges.Get("http://cdn.com/jquery.js"); ok {
+		t.Errorf("expected key '%s' to be purged", "http://cdn.com/jquery.js")
+	}
+}
+
+func TestDailyAt(t *testing.T) {
+	loc := time.FixedZone("EST", -5*3600)
+	testCases := []struct {
+		now  time.Time
+		want time.Time
+	}{
+		{time.Date(2018, 3, 1, 1, 0, 0, 0, loc), time.Date(2018, 3, 1, 2, 0, 0, 0, loc)},
+		{time.Date(2018, 3, 1, 2, 0, 0, 0, loc), time.Date(2018, 3, 2, 2, 0, 0, 0, loc)},
+		{time.Date(2018, 2, 28, 23, 0, 0, 0, loc), time.Date(2018, 3, 1, 2, 0, 0, 0, loc)},
+		{time.Date(2018, 3, 1, 6, 0, 0, 0, time.UTC), time.Date(2018, 3, 1, 2, 0, 0, 0, loc)},
+	}
+	for _, tC := range testCases {
+		if got := DailyAt(2, 0, loc).Next(tC.now); !got.Equal(tC.want) {
+			t.Errorf("unexpected next purge after %v: got %v, want %v", tC.now, got, tC.want)
+		}
+	}
+}
+
+func TestSoftPurge(t *testing.T) {
+	var fetches, revalidations int
+	failing := false
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		fetches++
+		res := okResponse()
+		res.Header = http.Header{
+			"Date":          {time.Now().UTC().Format(http.TimeFormat)},
+			"Cache-Control": {"max-age=3600, stale-if-error=3600"},
+			"Etag":          {`"v1"`},
+		}
+		if failing {
+			res.StatusCode = http.StatusInternalServerError
+		} else if req.Header.Get("If-None-Match") == `"v1"` {
+			revalidations++
+			res.StatusCode = http.StatusNotModified
+			res.Body = http.NoBody
+			res.ContentLength = 0
+		}
+		return res, nil
+	})
+	peer := NewPeer("http://self.com:3000", WithPeerTransport(origin))
+
+	testCases := []struct {
+		purge         bool
+		failing       bool
+		fetches       int
+		revalidations int
+	}{
+		{false, false, 1, 0},
+		{false, false, 1, 0},
+		{true, false, 2, 1},
+		{false, false, 2, 1},
+		{true, true, 3, 1},
+		{false, false, 4, 2},
+	}
+	for i, tC := range testCases {
+		if tC.purge {
+			rr := httptest.NewRecorder()
+			req, _ := http.NewRequest("POST", "/purge?soft=1&pattern=*", nil)
+			peer.AdminHandler().ServeHTTP(rr, req)
+		}
+		failing = tC.failing
+
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/proxy?q="+url.QueryEscape("http://cdn.com/jquery.js"), nil)
+		peer.Handler().ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK || rr.Body.String() != "OK" {
+			t.Errorf("request %d: unexpected response: got %d %q, want %d %q", i, rr.Code, rr.Body.String(), http.StatusOK, "OK")
+		}
+		if cc := rr.HeaderMap.Get("Cache-Control"); cc != "max-age=3600, stale-if-error=3600" {
+			t.Errorf("request %d: unexpected Cache-Control: got %q, want %q", i, cc, "max-age=3600, stale-if-error=3600")
+		}
+		if marker := rr.HeaderMap.Get(softPurgedHeader); marker != "" {
+			t.Errorf("request %d: unexpected %q header: got %q, want none", i, softPurgedHeader, marker)
+		}
+		if fetches != tC.fetches || revalidations != tC.revalidations {
+			t.Errorf("request %d: unexpected origin requests: got %d (%d revalidations), want %d (%d revalidations)", i, fetches, revalidations, tC.fetches, tC.revalidations)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sync"
+
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+// Priority is the priority class of a request. Under load, peers serve
+// interactive requests before bulk ones, which are shed first.
+type Priority int
+
+const (
+	// PriorityInteractive is for requests a user is waiting for.
+	// It is the default.
+	PriorityInteractive Priority = iota
+
+	// PriorityBulk is for background requests, like prefetching.
+	PriorityBulk
+
+	numPriorities = iota
+)
+
+var priorityNames = [numPriorities]string{"interactive", "bulk"}
+
+func (p Priority) String() string {
+	if p < 0 || p >= numPriorities {
+		return "unknown"
+	}
+	return priorityNames[p]
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a copy of ctx carrying a priority class. Requests
+// made through a Client with the returned context are tagged with it.
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// PriorityFromContext returns the priority class carried by ctx.
+func PriorityFromContext(ctx context.Context) Priority {
+	p, _ := ctx.Value(priorityKey{}).(Priority)
+	return p
+}
+
+// requestPriority returns the priority class a client tagged a request with.
+func requestPriority(req *http.Request) Priority {
+	for p, name := range priorityNames {
+		if req.Header.Get(protocol.PriorityHeader) == name {
+			return Priority(p)
+		}
+	}
+	return PriorityInteractive
+}
+
+var errShed = errors.New("request shed")
+
+// scheduler limits the number of requests served concurrently. Waiting
+// requests are served by priority, and shed by reverse priority when
+// too many are waiting.
+type scheduler struct {
+	mu       sync.Mutex
+	free     int
+	maxQueue int
+	queues   [numPriorities][]*waiter
+	queued   int
+}
+
+type waiter struct {
+	granted chan bool // true if granted a slot, false if shed
+}
+
+func newScheduler(concurrency, maxQueue int) *scheduler {
+	return &scheduler{free: concurrency, maxQueue: maxQueue}
+}
+
+// acquire waits for a slot to serve a request. It fails if the request
+// is shed or if ctx is done first.
+func (s *scheduler) acquire(ctx context.Context, p Priority) error {
+	s.mu.Lock()
+	if s.free > 0 && s.queued == 0 {
+		s.free--
+		s.mu.Unlock()
+		return nil
+	}
+
+	if s.queued >= s.maxQueue && !s.shed(p) {
+		s.mu.Unlock()
+		return errShed
+	}
+
+	w := &waiter{make(chan bool, 1)}
+	s.queues[p] = append(s.queues[p], w)
+	s.queued++
+	s.mu.Unlock()
+
+	select {
+	case ok := <-w.granted:
+		if !ok {
+			return errShed
+		}
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		if s.remove(p, w) {
+			s.mu.Unlock()
+			return ctx.Err()
+		}
+		s.mu.Unlock()
+		// granted or shed in the meantime
+		if <-w.granted {
+			s.release()
+		}
+		return ctx.Err()
+	}
+}
+
+// release frees a slot, handing it to the next waiter if any.
+func (s *scheduler) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for p := range s.queues {
+		if len(s.queues[p]) > 0 {
+			w := s.queues[p][0]
+			s.queues[p] = s.queues[p][1:]
+			s.queued--
+			w.granted <- true
+			return
+		}
+	}
+	s.free++
+}
+
+// shed sheds the newest waiter of a lower priority than p
+// to make room for a request of priority p.
+func (s *scheduler) shed(p Priority) bool {
+	for lower := numPriorities - 1; lower > int(p); lower-- {
+		if n := len(s.queues[lower]); n > 0 {
+			w := s.queues[lower][n-1]
+			s.queues[lower] = s.queues[lower][:n-1]
+			s.queued--
+			w.granted <- false
+			return true
+		}
+	}
+	return false
+}
+
+func (s *scheduler) remove(p Priority, w *waiter) bool {
+	for i, x := range s.queues[p] {
+		if x == w {
+			s.queues[p] = append(s.queues[p][:i], s.queues[p][i+1:]...)
+			s.queued--
+			return true
+		}
+	}
+	return false
+}
+
+// WithMaxConcurrency limits the number of requests served concurrently
+// by the peer's handler to n. Up to maxQueue requests wait for a slot,
+// interactive ones being served first. When the queue is full, bulk
+// requests are shed to make room for interactive ones; shed requests
+// get a 503 Service Unavailable. Defaults to no limit.
+func WithMaxConcurrency(n, maxQueue int) func(*Peer) {
+	return func(p *Peer) {
+		p.scheduler = newScheduler(n, maxQueue)
+	}
+}
+
+// lane is a separate concurrency and rate budget for bulk requests.
+type lane struct {
+	scheduler *scheduler
+	limiter   *tokenBucket
+}
+
+// acquire waits for the lane's budget. The slot must be released.
+func (l *lane) acquire(ctx context.Context) error {
+	if err := l.scheduler.acquire(ctx, PriorityBulk); err != nil {
+		return err
+	}
+	if l.limiter != nil {
+		if _, err := l.limiter.wait(ctx); err != nil {
+			l.scheduler.release()
+			return err
+		}
+	}
+	return nil
+}
+
+// WithBulkLane serves bulk requests (like prefetching) with their own
+// budget of at most concurrency concurrent requests and rate requests
+// per second (with bursts of burst requests), so that they never take
+// the slots of interactive requests. A rate of 0 means no rate limit.
+// Defaults to serving bulk requests along interactive ones.
+func WithBulkLane(concurrency int, rate float64, burst int) func(*Peer) {
+	return func(p *Peer) {
+		p.bulk = &lane{scheduler: newScheduler(concurrency, math.MaxInt32)}
+		if rate > 0 {
+			p.bulk.limiter = newTokenBucket(rate, burst)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+func TestScheduler(t *testing.T) {
+	s := newScheduler(1, 2)
+	if err := s.acquire(context.Background(), PriorityBulk); err != nil {
+		t.Fatalf("unexpected error: got %q, want <nil>", err)
+	}
+
+	results := make(chan string, 10)
+	wait := func(name string, p Priority) {
+		go func() {
+			if err := s.acquire(context.Background(), p); err != nil {
+				results <- name + " shed"
+				return
+			}
+			results <- name
+		}()
+	}
+	queued := func(n int) {
+		for i := 0; i < 100; i++ {
+			s.mu.Lock()
+			q := s.queued
+			s.mu.Unlock()
+			if q == n {
+				return
+			}
+			time.Sleep(time.Millisecond)
+		}
+		t.Fatalf("timed out waiting for %d queued requests", n)
+	}
+
+	wait("bulk1", PriorityBulk)
+	queued(1)
+	wait("bulk2", PriorityBulk)
+	queued(2)
+	wait("interactive1", PriorityInteractive) // queue is full, sheds bulk2
+	if got, want := <-results, "bulk2 shed"; got != want {
+		t.Fatalf("unexpected result: got %q, want %q", got, want)
+	}
+	queued(2)
+
+	if err := s.acquire(context.Background(), PriorityBulk); err != errShed {
+		t.Fatalf("unexpected error: got %v, want %v", err, errShed)
+	}
+
+	for _, want := range []string{"interactive1", "bulk1"} {
+		s.release()
+		if got := <-results; got != want {
+			t.Fatalf("unexpected result: got %q, want %q", got, want)
+		}
+	}
+	s.release()
+
+	if s.free != 1 || s.queued != 0 {
+		t.Errorf("unexpected scheduler state: got free=%d queued=%d, want free=1 queued=0", s.free, s.queued)
+	}
+}
+
+func TestSchedulerCancel(t *testing.T) {
+	s := newScheduler(1, 1)
+	s.acquire(context.Background(), PriorityInteractive)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.acquire(ctx, PriorityInteractive); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	s.release()
+	if s.free != 1 || s.queued != 0 {
+		t.Errorf("unexpected scheduler state: got free=%d queued=%d, want free=1 queued=0", s.free, s.queued)
+	}
+}
+
+func TestClientPriority(t *testing.T) {
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("X-Priority", req.Header.Get(protocol.PriorityHeader))
+		return res, nil
+	})
+
+	client := NewClient(WithPool("http://a.com:3000"), WithClientTransport(transport))
+
+	testCases := []struct {
+		ctx  context.Context
+		want string
+	}{
+		{context.Background(), ""},
+		{WithPriority(context.Background(), PriorityInteractive), ""},
+		{WithPriority(context.Background(), PriorityBulk), "bulk"},
+	}
+	for _, tC := range testCases {
+		req, _ := http.NewRequest("GET", "http://cdn.com/jquery.js", nil)
+		res, _ := client.RoundTrip(req.WithContext(tC.ctx))
+		if got := res.Header.Get("X-Priority"); got != tC.want {
+			t.Errorf("unexpected priority header: got %q, want %q", got, tC.want)
+		}
+
+		if p := requestPriority(&http.Request{Header: http.Header{protocol.PriorityHeader: {tC.want}}}); p != PriorityFromContext(tC.ctx) {
+			t.Errorf("unexpected request priority: got %v, want %v", p, PriorityFromContext(tC.ctx))
+		}
+	}
+}
+
+func TestBulkLane(t *testing.T) {
+	block := make(chan struct{})
+	origin := newRoundTripperMock().
+		add("GET", "http://cdn.com/slow.js", func(*http.Request) (*http.Response, error) {
+			<-block
+			return okResponse(), nil
+		}).
+		add("GET", "http://cdn.com/prefetch.js", func(*http.Request) (*http.Response, error) {
+			return okResponse(), nil
+		})
+
+	peer := NewPeer("http://self.com:3000",
+		WithPeerTransport(origin),
+		WithMaxConcurrency(1, 0),
+		WithBulkLane(1, 0, 0),
+	)
+	defer close(block)
+
+	// takes the only interactive slot
+	go peer.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape("http://cdn.com/slow.js"), nil))
+	for i := 0; i < 100; i++ {
+		peer.scheduler.mu.Lock()
+		free := peer.scheduler.free
+		peer.scheduler.mu.Unlock()
+		if free == 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	testCases := []struct {
+		priority string
+		status   int
+	}{
+		{"", http.StatusServiceUnavailable},
+		{"interactive", http.StatusServiceUnavailable},
+		{"bulk", http.StatusOK},
+	}
+	for _, tC := range testCases {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape("http://cdn.com/prefetch.js"), nil)
+		req.Header.Set(protocol.PriorityHeader, tC.priority)
+		peer.Handler().ServeHTTP(rr, req)
+
+		if rr.Code != tC.status {
+			t.Errorf("proxy sent wrong status for %q priority: got %d, want %d", tC.priority, rr.Code, tC.status)
+		}
+	}
+}
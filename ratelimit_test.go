@@ -0,0 +1,68 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(10, 2)
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	testCases := []struct {
+		elapsed time.Duration
+		want    time.Duration
+	}{
+		{0, 0},
+		{0, 0},
+		{0, 100 * time.Millisecond},
+		{0, 200 * time.Millisecond},
+		{300 * time.Millisecond, 0},
+		{time.Second, 0},
+		{0, 0},
+		{0, 100 * time.Millisecond},
+	}
+	for i, tC := range testCases {
+		now = now.Add(tC.elapsed)
+		if got := b.reserve(); got != tC.want {
+			t.Errorf("unexpected wait for reservation %d: got %v, want %v", i, got, tC.want)
+		}
+	}
+}
+
+func TestTokenBucketWait(t *testing.T) {
+	b := newTokenBucket(1000, 1)
+	if d, err := b.wait(context.Background()); d != 0 || err != nil {
+		t.Fatalf("unexpected wait: got %v %v, want 0 <nil>", d, err)
+	}
+	if d, err := b.wait(context.Background()); d == 0 || err != nil {
+		t.Fatalf("unexpected wait: got %v %v, want >0 <nil>", d, err)
+	}
+
+	b = newTokenBucket(0.001, 1)
+	b.reserve()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := b.wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("unexpected error: got %v, want %v", err, context.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const defaultRecordedBody = 512
+
+// Exchange is an HTTP exchange recorded by a Recorder.
+type Exchange struct {
+	Time     time.Time     `json:"time"`
+	Hop      string        `json:"hop"` // "peer" (client to peer) or "origin" (peer to origin)
+	Method   string        `json:"method"`
+	URL      string        `json:"url"`
+	Request  http.Header   `json:"request"`
+	Status   int           `json:"status,omitempty"`
+	Response http.Header   `json:"response,omitempty"`
+	Duration time.Duration `json:"duration"` // time to get the response headers
+	Error    string        `json:"error,omitempty"`
+	Body     string        `json:"body,omitempty"` // the beginning of the response body
+}
+
+// Recorder records HTTP exchanges in a ring buffer to debug the traffic
+// of a peer, see WithRecorder. Only the beginning of the response bodies
+// is kept, non printable characters being replaced by dots. Secrets are
+// redacted, see Redactor.
+type Recorder struct {
+	mu        sync.Mutex // guards ring, next and the exchanges
+	ring      []*Exchange
+	next      int
+	bodyLimit int
+}
+
+// NewRecorder creates a Recorder keeping the last size exchanges.
+func NewRecorder(size int) *Recorder {
+	return &Recorder{
+		ring:      make([]*Exchange, size),
+		bodyLimit: defaultRecordedBody,
+	}
+}
+
+// Transport returns a RoundTripper recording the exchanges made through
+// rt, tagged with hop. Secrets are redacted as with NewRedactor.
+func (r *Recorder) Transport(hop string, rt http.RoundTripper) http.RoundTripper {
+	return r.transport(hop, rt, NewRedactor())
+}
+
+func (r *Recorder) transport(hop string, rt http.RoundTripper, redactor *Redactor) http.RoundTripper {
+	return &recordingTransport{r, hop, rt, redactor}
+}
+
+// Exchanges returns a copy of the recorded exchanges, oldest first.
+func (r *Recorder) Exchanges() []Exchange {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	exchanges := make([]Exchange, 0, len(r.ring))
+	for i := range r.ring {
+		if e := r.ring[(r.next+i)%len(r.ring)]; e != nil {
+			exchanges = append(exchanges, *e)
+		}
+	}
+	return exchanges
+}
+
+// ServeHTTP serves the recorded exchanges as JSON.
+func (r *Recorder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(r.Exchanges())
+}
+
+func (r *Recorder) record(e *Exchange) {
+	if len(r.ring) == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	r.ring[r.next] = e
+	r.next = (r.next + 1) % len(r.ring)
+	r.mu.Unlock()
+}
+
+type recordingTransport struct {
+	recorder  *Recorder
+	hop       string
+	transport http.RoundTripper
+	redactor  *Redactor
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	e := &Exchange{
+		Time:    time.Now(),
+		Hop:     t.hop,
+		Method:  req.Method,
+		URL:     t.redactor.URL(req.URL).String(),
+		Request: t.redactor.Header(req.Header),
+	}
+
+	res, err := t.transport.RoundTrip(req)
+	e.Duration = time.Since(e.Time)
+	if err != nil {
+		e.Error = t.redactor.Error(err)
+		t.recorder.record(e)
+		return nil, err
+	}
+
+	e.Status = res.StatusCode
+	e.Response = t.redactor.Header(res.Header)
+	t.recorder.record(e)
+
+	res.Body = &recordingBody{res.Body, t.recorder, e}
+	return res, nil
+}
+
+// recordingBody records the beginning of a body.
+type recordingBody struct {
+	io.ReadCloser
+	recorder *Recorder
+	exchange *Exchange
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	b.recorder.mu.Lock()
+	if left := b.recorder.bodyLimit - len(b.exchange.Body); left > 0 && n > 0 {
+		chunk := p[:n]
+		if len(chunk) > left {
+			chunk = chunk[:left]
+		}
+		b.exchange.Body += printable(chunk)
+	}
+	b.recorder.mu.Unlock()
+
+	return n, err
+}
+
+func printable(b []byte) string {
+	s := make([]byte, len(b))
+	for i, c := range b {
+		if c < 0x20 && c != '\n' && c != '\r' && c != '\t' || c > 0x7e {
+			c = '.'
+		}
+		s[i] = c
+	}
+	return string(s)
+}
+
+func cloneHeader(h http.Header) http.Header {
+	h2 := make(http.Header, len(h))
+	for k, v := range h {
+		h2[k] = append([]string(nil), v...)
+	}
+	return h2
+}
+
+// WithRecorder records the exchanges between the clients and the peers
+// (made through the peer's Client) and between the peer and the origins.
+// The recorded exchanges are served by the admin handler.
+// Defaults to not recording.
+func WithRecorder(r *Recorder) func(*Peer) {
+	return func(p *Peer) {
+		p.recorder = r
+	}
+}
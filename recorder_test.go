@@ -0,0 +1,83 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	hash := newHashMock().
+		with("http://self.com:3000", 0).
+		with("http://peer.com:3000", 1).
+		with("http://some.url/res-self.js", 0).
+		with("http://some.url/res-peer.js", 1)
+
+	origin := roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Body = ioutil.NopCloser(strings.NewReader("OK\x00\xff"))
+		return res, nil
+	})
+	down := roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return nil, errors.New("connection refused")
+	})
+
+	recorder := NewRecorder(2)
+	peer := NewPeer("http://self.com:3000",
+		WithPeerTransport(origin),
+		WithRecorder(recorder),
+		WithClient(NewClient(
+			WithPool("http://self.com:3000", "http://peer.com:3000"),
+			WithHashFn(hash.fn),
+			WithClientTransport(down),
+		)),
+	)
+
+	for _, u := range []string{"http://some.url/res-self.js", "http://some.url/res-peer.js"} {
+		req, _ := http.NewRequest("GET", u, nil)
+		if res, err := peer.RoundTrip(req); err == nil {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+		}
+	}
+
+	exchanges := recorder.Exchanges()
+	if len(exchanges) != 2 {
+		t.Fatalf("unexpected number of exchanges: got %d, want %d", len(exchanges), 2)
+	}
+
+	origins, peers := exchanges[0], exchanges[1]
+	if origins.Hop != "origin" || origins.URL != "http://some.url/res-self.js" || origins.Status != http.StatusOK || origins.Body != "OK.." {
+		t.Errorf("unexpected origin exchange: got %+v", origins)
+	}
+	if peers.Hop != "peer" || !strings.HasPrefix(peers.URL, "http://peer.com:3000/proxy?q=") || peers.Error != "connection refused" {
+		t.Errorf("unexpected peer exchange: got %+v", peers)
+	}
+
+	// the ring buffer wraps around
+	req, _ := http.NewRequest("GET", "http://some.url/res-self.js?v=2", nil)
+	peer.RoundTrip(req)
+	exchanges = recorder.Exchanges()
+	if len(exchanges) != 2 || exchanges[1].URL != "http://some.url/res-self.js?v=2" {
+		t.Errorf("unexpected exchanges after wrapping around: got %+v", exchanges)
+	}
+}
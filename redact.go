@@ -0,0 +1,182 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+// Redacted replaces the secrets removed by a Redactor.
+const Redacted = "REDACTED"
+
+// DefaultRedactedHeaders are the headers redacted by default.
+var DefaultRedactedHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	protocol.SignatureHeader,
+	protocol.APIKeyHeader,
+}
+
+// DefaultRedactedParams are the query parameters redacted by default,
+// carrying the tokens of signed URLs (S3, GCS, CloudFront, ...).
+var DefaultRedactedParams = []string{
+	"X-Amz-Signature",
+	"X-Amz-Credential",
+	"X-Amz-Security-Token",
+	"X-Goog-Signature",
+	"X-Goog-Credential",
+	"Signature",
+	"Key-Pair-Id",
+	"Policy",
+	"sig",
+	"signature",
+	"token",
+	"access_token",
+}
+
+// Redactor removes secrets from headers and URLs before they are
+// recorded or reported, see WithRedactor. Names are case insensitive.
+// A nil Redactor redacts nothing.
+type Redactor struct {
+	headers map[string]bool
+	params  map[string]bool
+}
+
+// NewRedactor creates a Redactor redacting DefaultRedactedHeaders
+// and DefaultRedactedParams.
+func NewRedactor() *Redactor {
+	return (&Redactor{
+		headers: make(map[string]bool),
+		params:  make(map[string]bool),
+	}).Headers(DefaultRedactedHeaders...).Params(DefaultRedactedParams...)
+}
+
+// Headers redacts the values of more headers.
+func (r *Redactor) Headers(names ...string) *Redactor {
+	for _, n := range names {
+		r.headers[http.CanonicalHeaderKey(n)] = true
+	}
+	return r
+}
+
+// Params redacts the values of more query parameters.
+func (r *Redactor) Params(names ...string) *Redactor {
+	for _, n := range names {
+		r.params[strings.ToLower(n)] = true
+	}
+	return r
+}
+
+// Header returns a copy of h with the secrets redacted.
+func (r *Redactor) Header(h http.Header) http.Header {
+	h2 := cloneHeader(h)
+	if r == nil {
+		return h2
+	}
+	for k, v := range h2 {
+		if r.headers[k] {
+			for i := range v {
+				v[i] = Redacted
+			}
+		}
+	}
+	return h2
+}
+
+// URL returns a copy of u with the secrets of its query and userinfo
+// redacted, including those of the origin URLs sent to peers.
+func (r *Redactor) URL(u *url.URL) *url.URL {
+	u2 := *u
+	if r == nil {
+		return &u2
+	}
+	if u2.User != nil {
+		u2.User = url.User(Redacted)
+	}
+	if u2.RawQuery == "" {
+		return &u2
+	}
+
+	// walk the raw parameters the way the peers parse them, see
+	// parseQuery, rather than with url.Query, which drops the ones
+	// separated by ';' and would leave them unredacted
+	var b strings.Builder
+	for query := u2.RawQuery; query != ""; {
+		var param, sep string
+		param, sep, query = cutParam(query)
+		b.WriteString(r.param(param))
+		b.WriteString(sep)
+	}
+	u2.RawQuery = b.String()
+	return &u2
+}
+
+// param returns a raw query parameter with its secrets redacted.
+func (r *Redactor) param(param string) string {
+	k, v, err := splitParam(param)
+	if err != nil {
+		return Redacted // its key can't be told apart from a secret's
+	}
+	prefix := param[:len(param)-len(v)]
+	switch {
+	case v == "":
+		return param
+	case r.params[strings.ToLower(k)]:
+		return prefix + Redacted
+	case k == protocol.QueryParam:
+		origin, err := url.QueryUnescape(v)
+		if err != nil {
+			return prefix + Redacted
+		}
+		u, err := url.Parse(origin)
+		if err != nil {
+			return prefix + Redacted
+		}
+		return prefix + url.QueryEscape(r.URL(u).String())
+	}
+	return param
+}
+
+// Error returns the message of err with the secrets of its URL redacted.
+func (r *Redactor) Error(err error) string {
+	if ue, ok := err.(*url.Error); ok {
+		if u, perr := url.Parse(ue.URL); perr == nil {
+			ue2 := *ue
+			ue2.URL = r.URL(u).String()
+			return ue2.Error()
+		}
+	}
+	return err.Error()
+}
+
+// WithRedactor sets the Redactor applied to the exchanges recorded
+// by the peer (see WithRecorder) and to the URLs of the origin alerts
+// (see WithOriginAlerts). Only the headers and URLs are redacted: the
+// bodies recorded are kept as is. A nil Redactor disables redaction.
+// Defaults to NewRedactor().
+func WithRedactor(r *Redactor) func(*Peer) {
+	return func(p *Peer) {
+		p.redactor = r
+	}
+}
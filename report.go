@@ -0,0 +1,233 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Report summarizes the usage of a peer over a period, for chargeback
+// and capacity reviews, see WithReports.
+type Report struct {
+	Start   time.Time                      `json:"start"`
+	End     time.Time                      `json:"end"`
+	Total   Usage                          `json:"total"`
+	Origins map[string]Usage               `json:"origins"`        // per origin host
+	Keys    map[string]KeyCountersSnapshot `json:"keys,omitempty"` // per authenticated client, see WithAPIKey
+	TopURLs []URLUsage                     `json:"topURLs"`        // the most requested first
+}
+
+// Usage is the usage of a peer, or of one of its origins, over the
+// period of a Report.
+type Usage struct {
+	Requests        int64   `json:"requests"`
+	Hits            int64   `json:"hits"`
+	OriginFetches   int64   `json:"originFetches"`
+	BytesFromOrigin int64   `json:"bytesFromOrigin"`
+	BytesServed     int64   `json:"bytesServed"`
+	BytesSaved      int64   `json:"bytesSaved"` // bytes served but not fetched from the origins
+	HitRatio        float64 `json:"hitRatio"`
+}
+
+// URLUsage is the usage of a URL over the period of a Report.
+type URLUsage struct {
+	URL      string `json:"url"`
+	Requests int64  `json:"requests"`
+	Hits     int64  `json:"hits"`
+}
+
+// WithReports emits a usage Report of the peer, listing its n most
+// requested URLs, on schedule s, for example with ReportJSON or
+// ReportWebhook:
+//
+//	WithReports(DailyAt(0, 0, time.UTC), 100, ReportJSON(os.Stdout))
+//
+// The URLs are redacted, see WithRedactor. The URLs are only counted
+// while they are among the ones most recently requested from the peer,
+// see Peer.Probe. The reports stop when the peer is closed.
+func WithReports(s Schedule, n int, emit func(Report)) func(*Peer) {
+	return func(p *Peer) {
+		p.reports = &reporter{schedule: s, n: n, emit: emit}
+	}
+}
+
+// ReportJSON returns a function writing the reports to w as JSON, one
+// per line.
+func ReportJSON(w io.Writer) func(Report) {
+	var mu sync.Mutex
+	return func(r Report) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := json.NewEncoder(w).Encode(r); err != nil {
+			log.Printf("forwardcache: writing report: %v", err)
+		}
+	}
+}
+
+// ReportWebhook returns a function posting the reports as JSON to url
+// with client, http.DefaultClient if nil.
+func ReportWebhook(url string, client *http.Client) func(Report) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func(r Report) {
+		body, err := json.Marshal(r)
+		if err == nil {
+			err = postReport(client, url, body)
+		}
+		if err != nil {
+			log.Printf("forwardcache: posting report: %v", err)
+		}
+	}
+}
+
+func postReport(client *http.Client, url string, body []byte) error {
+	res, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%s: %s", url, res.Status)
+	}
+	return nil
+}
+
+// reporter emits the usage reports of a peer, the differences between
+// the counters at the end and at the start of each period.
+type reporter struct {
+	schedule Schedule
+	n        int
+	emit     func(Report)
+	start    time.Time
+	total    persistedCounters
+	origins  map[string]persistedCounters
+	keys     map[string]KeyCountersSnapshot
+	urls     map[string]urlRecord
+}
+
+// mark records the counters at the start of a period.
+func (r *reporter) mark(stats *Stats, now time.Time) {
+	r.start = now
+	r.total = persistCounters(&stats.Counters)
+	r.origins = make(map[string]persistedCounters)
+	for host, c := range stats.Origins() {
+		r.origins[host] = persistCounters(c)
+	}
+	r.keys = make(map[string]KeyCountersSnapshot)
+	for name, c := range stats.Keys() {
+		r.keys[name] = c.Snapshot()
+	}
+	r.urls = make(map[string]urlRecord)
+	for _, u := range stats.urls.snapshot() {
+		r.urls[u.url] = u
+	}
+}
+
+// report returns the report of the period ending now and starts the
+// next one.
+func (r *reporter) report(stats *Stats, redactor *Redactor, now time.Time) Report {
+	prev := *r
+	r.mark(stats, now)
+
+	report := Report{
+		Start:   prev.start,
+		End:     now,
+		Total:   usage(r.total, prev.total),
+		Origins: make(map[string]Usage),
+	}
+	for host, c := range r.origins {
+		if u := usage(c, prev.origins[host]); u.Requests > 0 || u.OriginFetches > 0 {
+			report.Origins[host] = u
+		}
+	}
+	for name, c := range r.keys {
+		p := prev.keys[name]
+		if k := (KeyCountersSnapshot{delta(c.Requests, p.Requests), delta(c.RateLimited, p.RateLimited)}); k.Requests > 0 || k.RateLimited > 0 {
+			if report.Keys == nil {
+				report.Keys = make(map[string]KeyCountersSnapshot)
+			}
+			report.Keys[name] = k
+		}
+	}
+	for _, u := range r.urls {
+		p := prev.urls[u.url]
+		if requests := delta(u.requests, p.requests); requests > 0 {
+			report.TopURLs = append(report.TopURLs, URLUsage{URL: u.url, Requests: requests, Hits: delta(u.hits, p.hits)})
+		}
+	}
+	sort.Slice(report.TopURLs, func(i, j int) bool {
+		a, b := report.TopURLs[i], report.TopURLs[j]
+		return a.Requests > b.Requests || a.Requests == b.Requests && a.URL < b.URL
+	})
+	if len(report.TopURLs) > r.n {
+		report.TopURLs = report.TopURLs[:r.n]
+	}
+	for i, u := range report.TopURLs {
+		if parsed, err := url.Parse(u.URL); err == nil {
+			report.TopURLs[i].URL = redactor.URL(parsed).String()
+		}
+	}
+	return report
+}
+
+// usage returns the usage between two points in time, the counters
+// being reset in between counting from 0.
+func usage(cur, prev persistedCounters) Usage {
+	u := Usage{
+		Requests:        delta(cur.Requests, prev.Requests),
+		Hits:            delta(cur.Hits, prev.Hits),
+		OriginFetches:   delta(cur.OriginFetches, prev.OriginFetches),
+		BytesFromOrigin: delta(cur.BytesFromOrigin, prev.BytesFromOrigin),
+		BytesServed:     delta(cur.BytesServed, prev.BytesServed),
+	}
+	u.BytesSaved = delta(u.BytesServed, u.BytesFromOrigin)
+	u.HitRatio = ratio(u.Hits, u.Requests)
+	return u
+}
+
+// delta returns cur-prev, or cur if the counter was reset in between.
+func delta(cur, prev int64) int64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+// runReports emits the reports on schedule until the peer is closed.
+func (p *Peer) runReports() {
+	for {
+		now := time.Now()
+		timer := time.NewTimer(p.reports.schedule.Next(now).Sub(now))
+		select {
+		case <-timer.C:
+			p.reports.emit(p.reports.report(p.Stats(), p.redactor, time.Now()))
+		case <-p.done:
+			timer.Stop()
+			return
+		}
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	origin := roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return okResponse(), nil
+	})
+	peer := NewPeer("http://self.com:3000", WithPeerTransport(origin))
+	get := func(u string) {
+		req, _ := http.NewRequest("GET", "/proxy?q="+url.QueryEscape(u), nil)
+		peer.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	get("http://cdn.com/jquery.js")
+	r := &reporter{n: 2}
+	start := time.Now()
+	r.mark(peer.Stats(), start)
+
+	for _, u := range []string{
+		"http://cdn.com/jquery.js",
+		"http://cdn.com/jquery.js",
+		"http://cdn.com/bootstrap.js",
+		"http://other.com/app.js?token=secret",
+	} {
+		get(u)
+	}
+
+	end := start.Add(time.Hour)
+	report := r.report(peer.Stats(), NewRedactor(), end)
+	if !report.Start.Equal(start) || !report.End.Equal(end) {
+		t.Errorf("unexpected period: got %v to %v, want %v to %v", report.Start, report.End, start, end)
+	}
+	if want := (Usage{Requests: 4, Hits: 2, OriginFetches: 2, BytesFromOrigin: 4, BytesServed: 8, BytesSaved: 4, HitRatio: 0.5}); report.Total != want {
+		t.Errorf("unexpected total usage: got %+v, want %+v", report.Total, want)
+	}
+	if got, want := report.Origins["cdn.com"].Requests, int64(3); got != want {
+		t.Errorf("unexpected cdn.com requests: got %d, want %d", got, want)
+	}
+	want := []URLUsage{{"http://cdn.com/jquery.js", 2, 2}, {"http://cdn.com/bootstrap.js", 1, 0}}
+	if len(report.TopURLs) != len(want) {
+		t.Fatalf("unexpected top URLs: got %+v, want %+v", report.TopURLs, want)
+	}
+	for i := range want {
+		if report.TopURLs[i] != want[i] {
+			t.Errorf("unexpected top URL %d: got %+v, want %+v", i, report.TopURLs[i], want[i])
+		}
+	}
+
+	if next := r.report(peer.Stats(), NewRedactor(), end.Add(time.Hour)); next.Total.Requests != 0 || len(next.TopURLs) != 0 {
+		t.Errorf("unexpected usage of an idle period: got %+v", next)
+	}
+}
+
+func TestReportEmitters(t *testing.T) {
+	report := Report{Total: Usage{Requests: 1}}
+
+	var buf bytes.Buffer
+	ReportJSON(&buf)(report)
+
+	var posted []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+	ReportWebhook(server.URL, nil)(report)
+
+	for name, data := range map[string][]byte{"json": buf.Bytes(), "webhook": posted} {
+		var got Report
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Errorf("%s: invalid report %q: %v", name, data, err)
+		} else if got.Total != report.Total {
+			t.Errorf("%s: unexpected report: got %+v, want %+v", name, got.Total, report.Total)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// partialKey returns the key of the partial manifest of the response
+// stored at key, the manifest of a response whose chunks are being
+// stored.
+func partialKey(key string) string {
+	return key + "#partial"
+}
+
+// resume resumes an origin fetch interrupted while storing a response
+// in chunks, fetching with a range request only the chunks following
+// the ones stored, validated by the response's validator. It returns
+// nil if there is no fetch to resume, or if the origin doesn't resume
+// it, in which case the partial manifest is removed.
+func (t *cacheTransport) resume(req *http.Request, reqCC directives) (*http.Response, error) {
+	if t.chunks == nil || t.chunks.threshold <= 0 || req.Method != http.MethodGet || reqCC.has("no-store") {
+		return nil, nil
+	}
+	key := req.URL.String()
+	s := t.lookup(req, partialKey(key), time.Now())
+	if s == nil {
+		return nil, nil
+	}
+	s.res.Body.Close()
+	validator := strongValidator(s.res.Header)
+	if s.manifest == nil || validator == "" {
+		t.cache.Delete(partialKey(key))
+		return nil, nil
+	}
+	m := *s.manifest
+	var stored int64 // chunks
+	for ; stored < m.count()-1; stored++ {
+		if _, ok := t.cache.Get(m.key(key, stored)); !ok {
+			break
+		}
+	}
+	if stored == 0 {
+		return nil, nil
+	}
+
+	first, _ := m.bounds(stored)
+	out := req.Clone(req.Context())
+	for _, h := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since"} {
+		delete(out.Header, h)
+	}
+	out.Header.Set("Range", fmt.Sprintf("bytes=%d-", first))
+	out.Header.Set("If-Range", validator)
+	res, err := t.transport.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusPartialContent || res.Header.Get("Content-Range") != fmt.Sprintf("bytes %d-%d/%d", first, m.length-1, m.length) {
+		explain(req, "not resumed: the origin responded %d to the range request", res.StatusCode)
+		t.cache.Delete(partialKey(key))
+		if res.StatusCode == http.StatusOK {
+			return t.store(req, reqCC, res, false) // the response changed
+		}
+		res.Body.Close()
+		return nil, nil
+	}
+	explain(req, "resumed: %d chunks stored, fetching bytes %d-%d", stored, first, m.length-1)
+	if t.stats != nil {
+		t.stats.Resumed.Add(1)
+	}
+
+	header := s.res.Header
+	h := res.Header.Clone()
+	removeHopHeaders(h)
+	for _, name := range []string{"Content-Range", "Content-Length"} {
+		delete(h, name)
+	}
+	for k, v := range h {
+		header[k] = v
+	}
+	cpy := *s.res
+	cpy.Header = header.Clone()
+	cpy.Request = req
+	cpy.ContentLength = m.length
+	cpy.Header.Set("Content-Length", strconv.FormatInt(m.length, 10))
+
+	resumed := cpy
+	resumed.Header = cpy.Header.Clone()
+	resumed.Body = &resumedBody{
+		Reader: io.MultiReader(
+			&chunkedBody{t: t, req: req, header: cpy.Header, m: m, last: first - 1},
+			t.chunkingBody(req, res.Body, &cpy, s.varied, m, stored),
+		),
+		Closer: res.Body,
+	}
+	return &resumed, nil
+}
+
+// resumedBody is the body of a resumed response, whose stored chunks
+// are read before the rest of the response.
+type resumedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,100 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestResume(t *testing.T) {
+	testCases := []struct {
+		name    string
+		etag    string // of the response after the interruption
+		body    string // after the interruption
+		ranges  []string
+		resumed int64
+	}{
+		{"resumed", `"v1"`, "0123456789", []string{"", "bytes=4-"}, 1},
+		{"changed", `"v2"`, "abcdefghij", []string{"", "bytes=4-"}, 0},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			var ranges []string
+			etag, body := `"v1"`, "0123456789"
+			origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				ranges = append(ranges, req.Header.Get("Range"))
+				res := &http.Response{
+					StatusCode:    http.StatusOK,
+					Header:        http.Header{"Cache-Control": {"max-age=60"}, "Etag": {etag}, "Content-Length": {strconv.Itoa(len(body))}},
+					ContentLength: int64(len(body)),
+					Request:       req,
+				}
+				if len(ranges) == 1 {
+					res.Body = &truncatedBody{body: body[:5]} // the connection is lost
+					return res, nil
+				}
+				first, last, ok := parseRange(req.Header.Get("Range"), int64(len(body)))
+				if ok && req.Header.Get("If-Range") == etag {
+					res.StatusCode = http.StatusPartialContent
+					res.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", first, last, len(body)))
+					res.Header.Set("Content-Length", strconv.FormatInt(last-first+1, 10))
+					res.ContentLength = last - first + 1
+					res.Body = ioutil.NopCloser(strings.NewReader(body[first : last+1]))
+					return res, nil
+				}
+				res.Body = ioutil.NopCloser(strings.NewReader(body))
+				return res, nil
+			})
+			cache := &mapCache{items: make(map[string][]byte)}
+			peer := NewPeer("http://self.com:3000", WithCache(cache), WithPeerTransport(origin), WithChunkedStorage(5, 2))
+			target := "/proxy?q=" + url.QueryEscape("http://cdn.com/artifact.tar")
+
+			func() {
+				defer func() { recover() }() // the handler aborts the interrupted response
+				peer.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", target, nil))
+			}()
+			etag, body = tC.etag, tC.body
+
+			for i := 0; i < 2; i++ {
+				rr := httptest.NewRecorder()
+				peer.Handler().ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+				if got := rr.Body.String(); got != tC.body {
+					t.Errorf("unexpected body of request %d: got %q, want %q", i, got, tC.body)
+				}
+				if got, want := rr.Header().Get("Content-Length"), strconv.Itoa(len(tC.body)); got != want {
+					t.Errorf("unexpected Content-Length of request %d: got %q, want %q", i, got, want)
+				}
+			}
+			if got, want := strings.Join(ranges, ";"), strings.Join(tC.ranges, ";"); got != want {
+				t.Errorf("unexpected origin ranges: got %q, want %q", got, want)
+			}
+			if got := peer.handler.stats.Resumed.Get(); got != tC.resumed {
+				t.Errorf("unexpected resumed fetches: got %d, want %d", got, tC.resumed)
+			}
+			if _, ok := cache.Get(partialKey("http://cdn.com/artifact.tar")); ok {
+				t.Errorf("unexpected partial manifest left")
+			}
+		})
+	}
+}
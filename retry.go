@@ -0,0 +1,149 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRetryRatio  = 0.2
+	defaultMinRetries  = 10
+	defaultRetryWindow = 10 * time.Second
+	retryBudgetBuckets = 10
+	minBudgetBucket    = time.Millisecond // width of the buckets of the smallest windows
+)
+
+// retryBudget limits the retries to a ratio of the requests made over
+// a sliding window, plus a minimum number of retries per window, so
+// that retries cannot amplify an outage.
+type retryBudget struct {
+	mu      sync.Mutex
+	ratio   float64
+	min     int
+	width   time.Duration // 0 if the budget is disabled
+	buckets [retryBudgetBuckets]budgetBucket
+	now     func() time.Time
+}
+
+type budgetBucket struct {
+	slot     int64
+	requests int
+	retries  int
+}
+
+func newRetryBudget(ratio float64, min int, window time.Duration) *retryBudget {
+	var width time.Duration
+	if window > 0 {
+		width = window / retryBudgetBuckets
+		if width < minBudgetBucket {
+			width = minBudgetBucket
+		}
+	}
+	return &retryBudget{
+		ratio: ratio,
+		min:   min,
+		width: width,
+		now:   time.Now,
+	}
+}
+
+// bucket returns the current bucket, resetting it if it is stale.
+func (b *retryBudget) bucket() (*budgetBucket, int64) {
+	slot := b.now().UnixNano() / int64(b.width)
+	bucket := &b.buckets[slot%retryBudgetBuckets]
+	if bucket.slot != slot {
+		*bucket = budgetBucket{slot: slot}
+	}
+	return bucket, slot
+}
+
+// request records a request.
+func (b *retryBudget) request() {
+	if b.width == 0 {
+		return
+	}
+	b.mu.Lock()
+	bucket, _ := b.bucket()
+	bucket.requests++
+	b.mu.Unlock()
+}
+
+// allow reports whether a retry can be made, and records it if so.
+func (b *retryBudget) allow() bool {
+	if b.width == 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current, slot := b.bucket()
+	var requests, retries int
+	for _, bucket := range b.buckets {
+		if slot-bucket.slot < retryBudgetBuckets {
+			requests += bucket.requests
+			retries += bucket.retries
+		}
+	}
+
+	if float64(retries) >= b.ratio*float64(requests)+float64(b.min) {
+		return false
+	}
+	current.retries++
+	return true
+}
+
+// retryable reports whether a request can safely be sent again.
+func retryable(req *http.Request) bool {
+	return (req.Method == "GET" || req.Method == "HEAD" || req.Method == "") &&
+		(req.Body == nil || req.Body == http.NoBody)
+}
+
+// WithFailover makes the client retry a request on the next n peers of
+// the ring when the owner of a resource cannot be reached. Only GET and
+// HEAD requests without a body are retried, within the retry budget.
+// Defaults to 0 (no failover).
+func WithFailover(n int) func(*Client) {
+	return func(c *Client) {
+		c.failover = n
+	}
+}
+
+// WithSecondaryPool makes the client send a request through a secondary
+// pool, like a remote cluster, when the owner of the resource and the
+// peers it fails over to (see WithFailover) cannot be reached. The
+// secondary client has its own peers, strategy and retry budget. Only
+// GET and HEAD requests without a body are sent to it, the requests it
+// fails being failed. Defaults to nil (no secondary pool).
+func WithSecondaryPool(secondary *Client) func(*Client) {
+	return func(c *Client) {
+		c.secondary = secondary
+	}
+}
+
+// WithRetryBudget limits the retries made by the client to ratio of the
+// requests made over the last window, plus min retries per window.
+// The window is counted in 10 buckets of at least a millisecond, and
+// a window of 0 or less disables the budget, allowing every retry.
+// Defaults to 20% of the requests plus 10 retries per 10 seconds.
+func WithRetryBudget(ratio float64, min int, window time.Duration) func(*Client) {
+	return func(c *Client) {
+		c.budget = newRetryBudget(ratio, min, window)
+	}
+}
@@ -0,0 +1,224 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryBudget(t *testing.T) {
+	now := time.Unix(1000, 0)
+	b := newRetryBudget(0.5, 1, 10*time.Second)
+	b.now = func() time.Time { return now }
+
+	if !b.allow() {
+		t.Fatalf("the minimum retries should be allowed")
+	}
+	if b.allow() {
+		t.Fatalf("the retry should have been denied")
+	}
+
+	for i := 0; i < 4; i++ {
+		b.request()
+	}
+	for i := 0; i < 2; i++ {
+		if !b.allow() {
+			t.Fatalf("retry %d should have been allowed", i)
+		}
+	}
+	if b.allow() {
+		t.Fatalf("the retry should have been denied")
+	}
+
+	now = now.Add(5 * time.Second)
+	if b.allow() {
+		t.Fatalf("the retry should have been denied while the window slides")
+	}
+
+	now = now.Add(6 * time.Second)
+	if !b.allow() {
+		t.Fatalf("the retry should have been allowed once the window slid")
+	}
+}
+
+func TestRetryBudgetWindow(t *testing.T) {
+	testCases := []struct {
+		window time.Duration
+		width  time.Duration
+	}{
+		{0, 0},
+		{-time.Second, 0},
+		{5 * time.Nanosecond, time.Millisecond},
+		{time.Second, 100 * time.Millisecond},
+	}
+	for _, tC := range testCases {
+		b := newRetryBudget(0, 0, tC.window)
+		if b.width != tC.width {
+			t.Errorf("unexpected bucket width for a %v window: got %v, want %v", tC.window, b.width, tC.width)
+		}
+		b.request() // must not divide by zero
+		if got, want := b.allow(), tC.width == 0; got != want {
+			t.Errorf("unexpected retry allowed with a %v window: got %t, want %t", tC.window, got, want)
+		}
+	}
+}
+
+func TestFailover(t *testing.T) {
+	hash := newHashMock().
+		with("http://a.com:3000", 0).
+		with("http://b.com:3000", 1).
+		with("http://c.com:3000", 2).
+		with("http://some.url/res-a.js", 0)
+
+	var tried []string
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		tried = append(tried, req.URL.Host)
+		if req.URL.Host != "c.com:3000" {
+			return nil, errors.New("connection refused")
+		}
+		return okResponse(), nil
+	})
+
+	testCases := []struct {
+		name    string
+		method  string
+		options []func(*Client)
+		tried   string
+		err     bool
+	}{
+		{"no failover", "GET", nil, "a.com:3000", true},
+		{"failover", "GET", []func(*Client){WithFailover(2)}, "a.com:3000 b.com:3000 c.com:3000", false},
+		{"not enough failover", "GET", []func(*Client){WithFailover(1)}, "a.com:3000 b.com:3000", true},
+		{"not retryable", "POST", []func(*Client){WithFailover(2), WithMethods(RejectOtherMethods, "GET", "POST")}, "a.com:3000", true},
+		{"budget", "GET", []func(*Client){WithFailover(2), WithRetryBudget(0, 1, time.Minute)}, "a.com:3000 b.com:3000", true},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			tried = nil
+			client := NewClient(append([]func(*Client){
+				WithPool("http://a.com:3000", "http://b.com:3000", "http://c.com:3000"),
+				WithHashFn(hash.fn),
+				WithClientTransport(transport),
+			}, tC.options...)...)
+
+			req, _ := http.NewRequest(tC.method, "http://some.url/res-a.js", nil)
+			_, err := client.RoundTrip(req)
+
+			if (err != nil) != tC.err {
+				t.Errorf("unexpected error: got %v, want error %v", err, tC.err)
+			}
+			if got := strings.Join(tried, " "); got != tC.tried {
+				t.Errorf("unexpected peers tried: got %q, want %q", got, tC.tried)
+			}
+		})
+	}
+}
+
+func TestFailoverRingChange(t *testing.T) {
+	hash := newHashMock().
+		with("http://a.com:3000", 0).
+		with("http://b.com:3000", 1).
+		with("http://c.com:3000", 2).
+		with("http://d.com:3000", 3).
+		with("http://some.url/res-a.js", 0)
+
+	var client *Client
+	var tried []string
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		tried = append(tried, req.URL.Host)
+		if req.URL.Host == "a.com:3000" {
+			client.SetPool("http://a.com:3000", "http://c.com:3000", "http://d.com:3000")
+			return nil, errors.New("connection refused")
+		}
+		return okResponse(), nil
+	})
+	client = NewClient(
+		WithPool("http://a.com:3000", "http://b.com:3000", "http://c.com:3000"),
+		WithHashFn(hash.fn),
+		WithClientTransport(transport),
+		WithFailover(2),
+	)
+	epoch := client.ClientStats().RingEpoch.Get()
+
+	req, _ := http.NewRequest("GET", "http://some.url/res-a.js", nil)
+	if _, err := client.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := strings.Join(tried, " "), "a.com:3000 c.com:3000"; got != want {
+		t.Errorf("unexpected peers tried: got %q, want %q", got, want)
+	}
+	if got := client.ClientStats().RingEpoch.Get(); got != epoch+1 {
+		t.Errorf("unexpected ring epoch: got %d, want %d", got, epoch+1)
+	}
+	if got := client.ClientStats().Rerouted.Get(); got != 1 {
+		t.Errorf("unexpected rerouted requests: got %d, want %d", got, 1)
+	}
+}
+
+func TestSecondaryPool(t *testing.T) {
+	var tried []string
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		tried = append(tried, req.URL.Host)
+		if req.URL.Host == "a.com:3000" {
+			return nil, errors.New("connection refused")
+		}
+		return okResponse(), nil
+	})
+
+	testCases := []struct {
+		name      string
+		method    string
+		primary   string
+		tried     string
+		err       bool
+		secondary int64
+	}{
+		{"primary", "GET", "http://b.com:3000", "b.com:3000", false, 0},
+		{"secondary", "GET", "http://a.com:3000", "a.com:3000 x.com:3000", false, 1},
+		{"not retryable", "POST", "http://a.com:3000", "a.com:3000", true, 0},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			tried = nil
+			secondary := NewClient(WithPool("http://x.com:3000"), WithClientTransport(transport))
+			client := NewClient(
+				WithPool(tC.primary),
+				WithClientTransport(transport),
+				WithMethods(RejectOtherMethods, "GET", "POST"),
+				WithSecondaryPool(secondary),
+			)
+
+			req, _ := http.NewRequest(tC.method, "http://some.url/res-a.js", nil)
+			_, err := client.RoundTrip(req)
+
+			if (err != nil) != tC.err {
+				t.Errorf("unexpected error: got %v, want error %v", err, tC.err)
+			}
+			if got := strings.Join(tried, " "); got != tC.tried {
+				t.Errorf("unexpected peers tried: got %q, want %q", got, tC.tried)
+			}
+			if got := client.ClientStats().Secondary.Get(); got != tC.secondary {
+				t.Errorf("unexpected requests sent to the secondary pool: got %d, want %d", got, tC.secondary)
+			}
+		})
+	}
+}
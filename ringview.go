@@ -0,0 +1,171 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"sort"
+
+	"github.com/mikegleasonjr/forwardcache/consistenthash"
+)
+
+// RingLayout is the ownership of the keyspace by the peers of a pool,
+// see Client.RingLayout.
+type RingLayout struct {
+	Epoch     int64                  `json:"epoch"`     // see ClientStats.RingEpoch
+	Deviation float64                `json:"deviation"` // see consistenthash.Distribution.Deviation
+	Peers     []RingShare            `json:"peers"`     // sorted by peer
+	Points    []consistenthash.Point `json:"points"`    // sorted by hash
+}
+
+// RingShare is the share of the keyspace owned by a peer.
+type RingShare struct {
+	Peer   string  `json:"peer"`
+	Share  float64 `json:"share"`  // between 0 and 1
+	Points int     `json:"points"` // virtual nodes on the ring
+}
+
+// RingLayout returns the ownership of the keyspace by the peers of the
+// pool, to understand its skew before and after changes of the pool.
+// The shares and points are empty if the client's strategy isn't built
+// on a consistent hash.
+func (c *Client) RingLayout() RingLayout {
+	c.mu.RLock()
+	peers, strategy := c.peers, c.strategy
+	c.mu.RUnlock()
+
+	layout := RingLayout{Epoch: c.stats.RingEpoch.Get(), Peers: make([]RingShare, 0, len(peers))}
+	var shares consistenthash.Distribution
+	if r, ok := strategy.(ringStrategy); ok && r.ring() != nil {
+		snapshot := r.ring().Snapshot()
+		layout.Points = snapshot.Points
+		shares = r.ring().Distribution()
+		if len(shares) > 0 {
+			layout.Deviation = shares.Deviation()
+		}
+	}
+	points := make(map[string]int)
+	for _, p := range layout.Points {
+		points[p.Key]++
+	}
+	for _, peer := range peers {
+		layout.Peers = append(layout.Peers, RingShare{Peer: peer, Share: shares[peer], Points: points[peer]})
+	}
+	sort.Slice(layout.Peers, func(i, j int) bool { return layout.Peers[i].Peer < layout.Peers[j].Peer })
+	return layout
+}
+
+// ringColors are the colors of the peers on the rendered rings.
+var ringColors = []string{
+	"#4e79a7", "#f28e2b", "#e15759", "#76b7b2", "#59a14f",
+	"#edc948", "#b07aa1", "#ff9da7", "#9c755f", "#bab0ac",
+}
+
+// ringArc is an arc of the rendered ring, owned by a peer.
+type ringArc struct {
+	Path  string
+	Color string
+	Title string
+}
+
+// ringPage is the data of ringTemplate.
+type ringPage struct {
+	RingLayout
+	Arcs   []ringArc
+	Colors map[string]string
+}
+
+var ringTemplate = template.Must(template.New("ring").Funcs(template.FuncMap{
+	"percent": func(f float64) string { return fmt.Sprintf("%.1f%%", 100*f) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>forwardcache ring</title></head>
+<body style="font-family: sans-serif">
+<h1>Ring (epoch {{.Epoch}})</h1>
+<svg xmlns="http://www.w3.org/2000/svg" width="420" height="420" viewBox="-210 -210 420 420">
+<circle r="160" fill="none" stroke="#ddd" stroke-width="40"/>
+{{- range .Arcs}}
+<path d="{{.Path}}" fill="none" stroke="{{.Color}}" stroke-width="40"><title>{{.Title}}</title></path>
+{{- end}}
+</svg>
+<table>
+<tr><th></th><th>Peer</th><th>Share</th><th>Points</th></tr>
+{{- range .Peers}}
+<tr><td style="background: {{index $.Colors .Peer}}; width: 1em"></td><td>{{.Peer}}</td><td>{{percent .Share}}</td><td>{{.Points}}</td></tr>
+{{- end}}
+</table>
+<p>Deviation from a balanced ring: {{percent .Deviation}}</p>
+</body>
+</html>
+`))
+
+// serveRingHTML renders the layout of the ring as an HTML page with an
+// SVG drawing of the arcs owned by each peer, clockwise from the top.
+func serveRingHTML(w http.ResponseWriter, layout RingLayout) {
+	page := ringPage{RingLayout: layout, Colors: make(map[string]string)}
+	for i, share := range layout.Peers {
+		page.Colors[share.Peer] = ringColors[i%len(ringColors)]
+	}
+	for i, p := range layout.Points {
+		// a point owns the keys from the previous point, exclusive
+		var from float64
+		if i > 0 {
+			from = float64(layout.Points[i-1].Hash)
+		} else {
+			from = float64(layout.Points[len(layout.Points)-1].Hash) - (1 << 32)
+		}
+		page.Arcs = append(page.Arcs, ringArc{
+			Path:  arcPath(160, from/(1<<32), float64(p.Hash)/(1<<32)),
+			Color: page.Colors[p.Key],
+			Title: fmt.Sprintf("%s (%d)", p.Key, p.Hash),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	ringTemplate.Execute(w, page)
+}
+
+// arcPath returns the SVG path of the arc of a circle of radius r
+// centered on the origin between two fractions of a turn, clockwise
+// from the top.
+func arcPath(r, from, to float64) string {
+	point := func(turn float64) (float64, float64) {
+		a := 2 * math.Pi * turn
+		return coord(r * math.Sin(a)), coord(-r * math.Cos(a))
+	}
+	if to-from >= 1 { // a whole turn, drawn in two halves
+		return arcPath(r, from, from+0.5) + " " + arcPath(r, from+0.5, to)
+	}
+	x0, y0 := point(from)
+	x1, y1 := point(to)
+	large := 0
+	if to-from > 0.5 {
+		large = 1
+	}
+	return fmt.Sprintf("M %.2f %.2f A %.0f %.0f 0 %d 1 %.2f %.2f", x0, y0, r, r, large, x1, y1)
+}
+
+// coord rounds a coordinate to 2 decimals, without negative zeros.
+func coord(f float64) float64 {
+	if f = math.Round(f*100) / 100; f == 0 {
+		return 0
+	}
+	return f
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRingLayout(t *testing.T) {
+	client := NewClient(WithPool(strategyPool[2], strategyPool[0], strategyPool[1]))
+	layout := client.RingLayout()
+
+	if got := len(layout.Points); got != 150 {
+		t.Errorf("unexpected number of points: got %d, want %d", got, 150)
+	}
+	var total float64
+	for i, share := range layout.Peers {
+		if share.Peer != strategyPool[i] {
+			t.Errorf("unexpected peer %d: got %q, want %q", i, share.Peer, strategyPool[i])
+		}
+		if share.Points != 50 {
+			t.Errorf("unexpected points of %s: got %d, want %d", share.Peer, share.Points, 50)
+		}
+		total += share.Share
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Errorf("unexpected total share: got %v, want 1", total)
+	}
+
+	static := NewClient(WithPool(strategyPool...), WithStrategy(Static())).RingLayout()
+	if len(static.Peers) != 3 || len(static.Points) != 0 {
+		t.Errorf("unexpected layout of a static strategy: got %d peers and %d points, want 3 and 0", len(static.Peers), len(static.Points))
+	}
+}
+
+func TestRingHTML(t *testing.T) {
+	peer := NewPeer("http://a.com:3000", WithClient(NewClient(WithPool(strategyPool...), WithReplicas(2))))
+
+	rr := httptest.NewRecorder()
+	peer.AdminHandler().ServeHTTP(rr, httptest.NewRequest("GET", "/ring.html", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("unexpected status: got %d, want %d", rr.Code, http.StatusOK)
+	}
+	body := rr.Body.String()
+	if got := strings.Count(body, "<path "); got != 6 {
+		t.Errorf("unexpected number of arcs: got %d, want %d", got, 6)
+	}
+	for _, want := range append(strategyPool, ringColors[0], "%</td>", "</svg>") {
+		if !strings.Contains(body, want) {
+			t.Errorf("unexpected page: %q not found in %s", want, body)
+		}
+	}
+}
+
+func TestArcPath(t *testing.T) {
+	testCases := []struct {
+		from, to float64
+		want     string
+	}{
+		{0, 0.25, "M 0.00 -100.00 A 100 100 0 0 1 100.00 0.00"},
+		{0, 0.75, "M 0.00 -100.00 A 100 100 0 1 1 -100.00 0.00"},
+		{-0.5, 0.5, "M 0.00 100.00 A 100 100 0 0 1 0.00 -100.00 M 0.00 -100.00 A 100 100 0 0 1 0.00 100.00"},
+	}
+	for _, tC := range testCases {
+		if got := arcPath(100, tC.from, tC.to); got != tC.want {
+			t.Errorf("unexpected arc from %v to %v: got %q, want %q", tC.from, tC.to, got, tC.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// standbyConcurrency is the number of responses a standby peer
+// replicates at the same time.
+const standbyConcurrency = 4
+
+// standby replicates the responses of a primary peer until promoted.
+type standby struct {
+	primary  string
+	n        int
+	schedule Schedule
+	promoted chan struct{}
+	promote  sync.Once
+}
+
+// active reports whether s is a standby which is not promoted yet.
+func (s *standby) active() bool {
+	if s == nil {
+		return false
+	}
+	select {
+	case <-s.promoted:
+		return false
+	default:
+		return true
+	}
+}
+
+// WithStandby makes the peer a warm standby of primary, the base URL of
+// a peer, for the fast failover of single peer deployments. The standby
+// replicates the n responses primary served the most on schedule s,
+// fetching them from the cache of primary like Peer.Bootstrap, which
+// requires the same cluster secret, and refuses the client requests
+// with 503 Service Unavailable, like its health route, until it is
+// promoted with Peer.Promote or the admin API:
+//
+//	WithStandby("http://10.0.1.1:3000", 1000, Every(time.Minute))
+//
+// The replication stops once the peer is promoted or closed.
+func WithStandby(primary string, n int, s Schedule) func(*Peer) {
+	return func(p *Peer) {
+		p.standby = &standby{primary: primary, n: n, schedule: s, promoted: make(chan struct{})}
+	}
+}
+
+// Promote makes a standby peer take the client requests and stops its
+// replication, see WithStandby. It does nothing on other peers.
+func (p *Peer) Promote() {
+	if p.standby != nil {
+		p.standby.promote.Do(func() { close(p.standby.promoted) })
+	}
+}
+
+// Standby reports whether the peer is a standby which is not promoted
+// yet.
+func (p *Peer) Standby() bool {
+	return p.standby.active()
+}
+
+// runStandby replicates the responses of the primary on schedule until
+// the peer is promoted or closed.
+func (p *Peer) runStandby() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-p.standby.promoted:
+		case <-p.done:
+		}
+		cancel()
+	}()
+
+	for {
+		now := time.Now()
+		timer := time.NewTimer(p.standby.schedule.Next(now).Sub(now))
+		select {
+		case <-timer.C:
+			if err := p.replicate(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("forwardcache: replicating %s: %v", p.standby.primary, err)
+			}
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// replicate stores the responses the primary served the most.
+func (p *Peer) replicate(ctx context.Context) error {
+	ctx = WithPriority(ctx, PriorityBulk)
+	urls, err := p.hotURLs(ctx, p.standby.primary, p.standby.n)
+	if err != nil {
+		return err
+	}
+	cache := &sizeStatsCache{p.purges.cache(), p.handler.stats}
+	return p.fetchSibling(ctx, p.standby.primary, urls, cache, standbyConcurrency)
+}
+
+// refuseStandby refuses the client requests of a standby peer which is
+// not promoted yet.
+func (p *proxy) refuseStandby(w http.ResponseWriter) bool {
+	if !p.standby.active() {
+		return false
+	}
+	http.Error(w, "standby", http.StatusServiceUnavailable)
+	return true
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestStandby(t *testing.T) {
+	secret := WithClusterSecret([]byte("s3cr3t"))
+	primary := NewPeer("http://primary.invalid", secret, WithPeerTransport(roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return okResponse(), nil
+	})))
+	server := httptest.NewServer(primary.Handler())
+	defer server.Close()
+
+	get := func(peer *Peer) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/proxy?q="+url.QueryEscape("http://cdn.com/jquery.js"), nil)
+		peer.Handler().ServeHTTP(rr, req)
+		return rr
+	}
+	get(primary)
+
+	peer := NewPeer("http://standby.invalid", secret,
+		WithStandby(server.URL, 10, Every(time.Millisecond)),
+		WithPeerTransport(roundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("origin unreachable")
+		})))
+	defer peer.Close()
+
+	deadline := time.Now().Add(time.Second)
+	for probe, _ := peer.Probe("http://cdn.com/jquery.js"); !probe.Cached; probe, _ = peer.Probe("http://cdn.com/jquery.js") {
+		if time.Now().After(deadline) {
+			t.Fatal("the standby didn't replicate the primary")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if !peer.Standby() {
+		t.Errorf("unexpected standby: got false, want true")
+	}
+	if rr := get(peer); rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("unexpected status of a standby: got %d, want %d", rr.Code, http.StatusServiceUnavailable)
+	}
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/promote", nil)
+	peer.AdminHandler().ServeHTTP(rr, req)
+	if got, want := rr.Body.String(), "{\"standby\":false}\n"; got != want {
+		t.Errorf("unexpected promotion: got %q, want %q", got, want)
+	}
+
+	rr = get(peer)
+	if rr.Code != http.StatusOK || rr.HeaderMap.Get(httpcache.XFromCache) == "" {
+		t.Errorf("unexpected response of a promoted standby: got %d (from cache %q), want %d from cache", rr.Code, rr.HeaderMap.Get(httpcache.XFromCache), http.StatusOK)
+	}
+}
@@ -0,0 +1,614 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+// AtomicInt is an int64 to be accessed atomically.
+type AtomicInt int64
+
+// Add atomically adds n to i.
+func (i *AtomicInt) Add(n int64) {
+	atomic.AddInt64((*int64)(i), n)
+}
+
+// Set atomically sets i to n.
+func (i *AtomicInt) Set(n int64) {
+	atomic.StoreInt64((*int64)(i), n)
+}
+
+// Get atomically gets the value of i.
+func (i *AtomicInt) Get() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
+func (i *AtomicInt) String() string {
+	return strconv.FormatInt(i.Get(), 10)
+}
+
+// Counters are statistics about the requests served by a peer.
+type Counters struct {
+	Requests        AtomicInt // requests served by the peer
+	Hits            AtomicInt // requests served from the cache
+	OriginFetches   AtomicInt // requests made to origins
+	OriginErrors    AtomicInt // origin fetches that failed or returned a 5xx
+	BytesFromOrigin AtomicInt // bytes fetched from origins
+	BytesServed     AtomicInt // bytes served to clients
+	SlowOrigins     AtomicInt // origin fetches exceeding the latency threshold
+	LargeResponses  AtomicInt // origin responses exceeding the size threshold
+	Dials           AtomicInt // connections opened to origins
+	ReusedConns     AtomicInt // origin fetches on a connection used before
+	IdleConns       AtomicInt // connections to origins currently idle, see connStatsTransport
+	HandshakeTime   AtomicInt // nanoseconds spent opening the connections to origins
+}
+
+// HitRatio returns the ratio of requests served from the cache.
+func (c *Counters) HitRatio() float64 {
+	return ratio(c.Hits.Get(), c.Requests.Get())
+}
+
+// ErrorRatio returns the ratio of origin fetches that failed.
+func (c *Counters) ErrorRatio() float64 {
+	return ratio(c.OriginErrors.Get(), c.OriginFetches.Get())
+}
+
+// ReuseRatio returns the ratio of origin fetches made on a connection
+// used before.
+func (c *Counters) ReuseRatio() float64 {
+	reused := c.ReusedConns.Get()
+	return ratio(reused, reused+c.Dials.Get())
+}
+
+// HandshakeLatency returns the mean time to open a connection to an
+// origin, including the DNS lookup and the TLS handshake.
+func (c *Counters) HandshakeLatency() time.Duration {
+	dials := c.Dials.Get()
+	if dials == 0 {
+		return 0
+	}
+	return time.Duration(c.HandshakeTime.Get() / dials)
+}
+
+// CountersSnapshot is a point in time copy of Counters.
+type CountersSnapshot struct {
+	Requests         int64   `json:"requests"`
+	Hits             int64   `json:"hits"`
+	OriginFetches    int64   `json:"originFetches"`
+	OriginErrors     int64   `json:"originErrors"`
+	BytesFromOrigin  int64   `json:"bytesFromOrigin"`
+	BytesServed      int64   `json:"bytesServed"`
+	SlowOrigins      int64   `json:"slowOrigins"`
+	LargeResponses   int64   `json:"largeResponses"`
+	Dials            int64   `json:"dials"`
+	ReusedConns      int64   `json:"reusedConns"`
+	IdleConns        int64   `json:"idleConns"`
+	HitRatio         float64 `json:"hitRatio"`
+	ErrorRatio       float64 `json:"errorRatio"`
+	ReuseRatio       float64 `json:"reuseRatio"`
+	HandshakeLatency float64 `json:"handshakeLatency"` // mean, in seconds
+}
+
+// Snapshot returns a copy of the counters.
+func (c *Counters) Snapshot() CountersSnapshot {
+	return CountersSnapshot{
+		Requests:         c.Requests.Get(),
+		Hits:             c.Hits.Get(),
+		OriginFetches:    c.OriginFetches.Get(),
+		OriginErrors:     c.OriginErrors.Get(),
+		BytesFromOrigin:  c.BytesFromOrigin.Get(),
+		BytesServed:      c.BytesServed.Get(),
+		SlowOrigins:      c.SlowOrigins.Get(),
+		LargeResponses:   c.LargeResponses.Get(),
+		Dials:            c.Dials.Get(),
+		ReusedConns:      c.ReusedConns.Get(),
+		IdleConns:        c.IdleConns.Get(),
+		HitRatio:         c.HitRatio(),
+		ErrorRatio:       c.ErrorRatio(),
+		ReuseRatio:       c.ReuseRatio(),
+		HandshakeLatency: c.HandshakeLatency().Seconds(),
+	}
+}
+
+var (
+	// DefaultSizeBuckets are the upper bounds, in bytes,
+	// of the object size histogram.
+	DefaultSizeBuckets = []float64{1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20, 4 << 20, 16 << 20, 64 << 20}
+
+	// DefaultLatencyBuckets are the upper bounds, in seconds,
+	// of the latency histograms.
+	DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+// Histogram counts observations in buckets. It is safe for concurrent use.
+type Histogram struct {
+	bounds []float64
+	counts []AtomicInt // the last one counts observations above all bounds
+	count  AtomicInt
+	sum    uint64 // float64 bits
+}
+
+// NewHistogram creates a Histogram with buckets having the specified
+// upper bounds, which must be sorted.
+func NewHistogram(bounds ...float64) *Histogram {
+	return &Histogram{
+		bounds: bounds,
+		counts: make([]AtomicInt, len(bounds)+1),
+	}
+}
+
+// Observe adds an observation to the histogram.
+func (h *Histogram) Observe(v float64) {
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.count.Add(1)
+
+	for {
+		old := atomic.LoadUint64(&h.sum)
+		sum := math.Float64bits(math.Float64frombits(old) + v)
+		if atomic.CompareAndSwapUint64(&h.sum, old, sum) {
+			return
+		}
+	}
+}
+
+// Bucket is a histogram bucket.
+type Bucket struct {
+	UpperBound float64 `json:"le"`
+	Count      int64   `json:"count"` // cumulative
+}
+
+// HistogramSnapshot is a point in time copy of a Histogram.
+type HistogramSnapshot struct {
+	Buckets []Bucket `json:"buckets"`
+	Count   int64    `json:"count"`
+	Sum     float64  `json:"sum"`
+}
+
+// Snapshot returns a copy of the histogram. The count of observations
+// above the last bound is Count minus the last bucket's count.
+func (h *Histogram) Snapshot() HistogramSnapshot {
+	snap := HistogramSnapshot{
+		Buckets: make([]Bucket, len(h.bounds)),
+		Count:   h.count.Get(),
+		Sum:     math.Float64frombits(atomic.LoadUint64(&h.sum)),
+	}
+	var cumulative int64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i].Get()
+		snap.Buckets[i] = Bucket{UpperBound: bound, Count: cumulative}
+	}
+	return snap
+}
+
+// Stats are the statistics of a peer, in total and per origin host.
+// Stats implements expvar.Var and can be published using expvar.Publish.
+type Stats struct {
+	Counters
+	ObjectSize    *Histogram   // sizes of the objects stored in the cache
+	OriginLatency *Histogram   // time to get response headers from origins
+	PeerLatency   *Histogram   // time to get response headers from other peers
+	Handshakes    *Histogram   // time to open connections to origins
+	Shed          AtomicInt    // requests shed by the peer under load
+	OriginQueued  AtomicInt    // origin fetches queued, see WithMaxOriginConnections
+	Unauthorized  AtomicInt    // requests from unknown clients, see WithAPIKey
+	NotAdmitted   AtomicInt    // storable responses not stored, see WithAdmission
+	Truncated     AtomicInt    // responses not stored because their body was truncated
+	Compressed    AtomicInt    // responses gzipped for the clients, see WithCompression
+	OverBudget    AtomicInt    // responses not gzipped for lack of compression budget
+	Resumed       AtomicInt    // interrupted origin fetches resumed, see WithChunkedStorage
+	Mismatched    AtomicInt    // bodies not stored for not matching their digest, see WithChecksums
+	mu            sync.RWMutex // guards origins, retired and keys
+	origins       map[string]*trackedOrigin
+	retired       []*retiredOrigin // see Stats.Origin
+	aged          time.Time        // when the origins were last aged
+	now           func() time.Time
+	keys          map[string]*KeyCounters
+	urls          urlStats // see Peer.Probe
+}
+
+func newStats() *Stats {
+	return &Stats{
+		ObjectSize:    NewHistogram(DefaultSizeBuckets...),
+		OriginLatency: NewHistogram(DefaultLatencyBuckets...),
+		PeerLatency:   NewHistogram(DefaultLatencyBuckets...),
+		Handshakes:    NewHistogram(DefaultLatencyBuckets...),
+	}
+}
+
+// Key returns the counters of an authenticated client.
+func (s *Stats) Key(name string) *KeyCounters {
+	s.mu.RLock()
+	c, ok := s.keys[name]
+	s.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok = s.keys[name]; !ok {
+		if s.keys == nil {
+			s.keys = make(map[string]*KeyCounters)
+		}
+		c = new(KeyCounters)
+		s.keys[name] = c
+	}
+	return c
+}
+
+// Keys returns the counters of all the authenticated clients seen so far.
+func (s *Stats) Keys() map[string]*KeyCounters {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make(map[string]*KeyCounters, len(s.keys))
+	for name, c := range s.keys {
+		keys[name] = c
+	}
+	return keys
+}
+
+// StatsSnapshot is a point in time copy of Stats.
+type StatsSnapshot struct {
+	CountersSnapshot
+	ObjectSize    HistogramSnapshot              `json:"objectSize"`
+	OriginLatency HistogramSnapshot              `json:"originLatency"`
+	PeerLatency   HistogramSnapshot              `json:"peerLatency"`
+	Handshakes    HistogramSnapshot              `json:"handshakes"`
+	Shed          int64                          `json:"shed"`
+	OriginQueued  int64                          `json:"originQueued"`
+	Unauthorized  int64                          `json:"unauthorized"`
+	NotAdmitted   int64                          `json:"notAdmitted"`
+	Truncated     int64                          `json:"truncated"`
+	Compressed    int64                          `json:"compressed"`
+	OverBudget    int64                          `json:"overBudget"`
+	Resumed       int64                          `json:"resumed"`
+	Mismatched    int64                          `json:"mismatched"`
+	Origins       map[string]CountersSnapshot    `json:"origins"`
+	Keys          map[string]KeyCountersSnapshot `json:"keys,omitempty"`
+}
+
+// Snapshot returns a copy of the stats.
+func (s *Stats) Snapshot() StatsSnapshot {
+	snap := StatsSnapshot{
+		CountersSnapshot: s.Counters.Snapshot(),
+		ObjectSize:       s.ObjectSize.Snapshot(),
+		OriginLatency:    s.OriginLatency.Snapshot(),
+		PeerLatency:      s.PeerLatency.Snapshot(),
+		Handshakes:       s.Handshakes.Snapshot(),
+		Shed:             s.Shed.Get(),
+		OriginQueued:     s.OriginQueued.Get(),
+		Unauthorized:     s.Unauthorized.Get(),
+		NotAdmitted:      s.NotAdmitted.Get(),
+		Truncated:        s.Truncated.Get(),
+		Compressed:       s.Compressed.Get(),
+		OverBudget:       s.OverBudget.Get(),
+		Resumed:          s.Resumed.Get(),
+		Mismatched:       s.Mismatched.Get(),
+		Origins:          make(map[string]CountersSnapshot),
+	}
+	for host, c := range s.Origins() {
+		snap.Origins[host] = c.Snapshot()
+	}
+	if keys := s.Keys(); len(keys) > 0 {
+		snap.Keys = make(map[string]KeyCountersSnapshot, len(keys))
+		for name, c := range keys {
+			snap.Keys[name] = c.Snapshot()
+		}
+	}
+	return snap
+}
+
+// String returns the stats as JSON.
+func (s *Stats) String() string {
+	b, _ := json.Marshal(s.Snapshot())
+	return string(b)
+}
+
+// ClientStats are the statistics of the requests made by a Client to
+// the peers. ClientStats implements expvar.Var.
+type ClientStats struct {
+	Requests      AtomicInt    // requests made to peers
+	Retries       AtomicInt    // requests retried on another peer
+	RetriesDenied AtomicInt    // retries denied by the retry budget
+	RateLimited   AtomicInt    // requests delayed by a rate limit
+	RingEpoch     AtomicInt    // changes of the pool, see SetPool
+	Rerouted      AtomicInt    // requests whose peers were chosen again after a change of the pool
+	Bypassed      AtomicInt    // requests sent directly to the origins, see WithBypass
+	Secondary     AtomicInt    // requests sent to the secondary pool, see WithSecondaryPool
+	Delegated     AtomicInt    // requests routed through an origin pool, see WithOriginPool
+	LimiterWait   *Histogram   // time spent waiting for rate limits
+	PeerLatency   *Histogram   // time to get response headers from peers
+	mu            sync.RWMutex // guards peers
+	peers         map[string]*PeerCounters
+}
+
+// PeerCounters are statistics about the requests made by a Client to
+// one of the peers.
+type PeerCounters struct {
+	Requests AtomicInt // requests made to the peer
+	Errors   AtomicInt // requests that failed or got a 5xx from the peer
+}
+
+// ErrorRatio returns the ratio of the requests to the peer that failed.
+func (c *PeerCounters) ErrorRatio() float64 {
+	return ratio(c.Errors.Get(), c.Requests.Get())
+}
+
+// PeerCountersSnapshot is a point in time copy of PeerCounters.
+type PeerCountersSnapshot struct {
+	Requests   int64   `json:"requests"`
+	Errors     int64   `json:"errors"`
+	ErrorRatio float64 `json:"errorRatio"`
+}
+
+// Snapshot returns a copy of the counters.
+func (c *PeerCounters) Snapshot() PeerCountersSnapshot {
+	return PeerCountersSnapshot{
+		Requests:   c.Requests.Get(),
+		Errors:     c.Errors.Get(),
+		ErrorRatio: c.ErrorRatio(),
+	}
+}
+
+func newClientStats() *ClientStats {
+	return &ClientStats{
+		LimiterWait: NewHistogram(DefaultLatencyBuckets...),
+		PeerLatency: NewHistogram(DefaultLatencyBuckets...),
+	}
+}
+
+// ClientStatsSnapshot is a point in time copy of ClientStats.
+type ClientStatsSnapshot struct {
+	Requests      int64                           `json:"requests"`
+	Retries       int64                           `json:"retries"`
+	RetriesDenied int64                           `json:"retriesDenied"`
+	RateLimited   int64                           `json:"rateLimited"`
+	RingEpoch     int64                           `json:"ringEpoch"`
+	Rerouted      int64                           `json:"rerouted"`
+	Bypassed      int64                           `json:"bypassed"`
+	Secondary     int64                           `json:"secondary"`
+	Delegated     int64                           `json:"delegated"`
+	LimiterWait   HistogramSnapshot               `json:"limiterWait"`
+	PeerLatency   HistogramSnapshot               `json:"peerLatency"`
+	Peers         map[string]PeerCountersSnapshot `json:"peers,omitempty"`
+}
+
+// Peer returns the counters of the requests made to a peer.
+func (s *ClientStats) Peer(peer string) *PeerCounters {
+	s.mu.RLock()
+	c, ok := s.peers[peer]
+	s.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok = s.peers[peer]; !ok {
+		if s.peers == nil {
+			s.peers = make(map[string]*PeerCounters)
+		}
+		c = new(PeerCounters)
+		s.peers[peer] = c
+	}
+	return c
+}
+
+// forget drops the counters of the peers removed from the pool.
+func (s *ClientStats) forget(peers []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, peer := range peers {
+		delete(s.peers, peer)
+	}
+}
+
+// Peers returns the counters of the peers of the pool requested so far.
+func (s *ClientStats) Peers() map[string]*PeerCounters {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	peers := make(map[string]*PeerCounters, len(s.peers))
+	for peer, c := range s.peers {
+		peers[peer] = c
+	}
+	return peers
+}
+
+// Snapshot returns a copy of the stats.
+func (s *ClientStats) Snapshot() ClientStatsSnapshot {
+	snap := ClientStatsSnapshot{
+		Requests:      s.Requests.Get(),
+		Retries:       s.Retries.Get(),
+		RetriesDenied: s.RetriesDenied.Get(),
+		RateLimited:   s.RateLimited.Get(),
+		RingEpoch:     s.RingEpoch.Get(),
+		Rerouted:      s.Rerouted.Get(),
+		Bypassed:      s.Bypassed.Get(),
+		Secondary:     s.Secondary.Get(),
+		Delegated:     s.Delegated.Get(),
+		LimiterWait:   s.LimiterWait.Snapshot(),
+		PeerLatency:   s.PeerLatency.Snapshot(),
+	}
+	if peers := s.Peers(); len(peers) > 0 {
+		snap.Peers = make(map[string]PeerCountersSnapshot, len(peers))
+		for peer, c := range peers {
+			snap.Peers[peer] = c.Snapshot()
+		}
+	}
+	return snap
+}
+
+// String returns the stats as JSON.
+func (s *ClientStats) String() string {
+	b, _ := json.Marshal(s.Snapshot())
+	return string(b)
+}
+
+func ratio(n, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
+
+// statsTransport records the requests served by a peer. It sits in
+// front of the cache.
+type statsTransport struct {
+	stats     *Stats
+	transport http.RoundTripper
+}
+
+func (t *statsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	origin := t.stats.Origin(req.URL.Host)
+	t.stats.Requests.Add(1)
+	origin.Requests.Add(1)
+
+	res, err := t.transport.RoundTrip(req)
+	if err != nil {
+		t.stats.urls.request(req.URL.String(), false)
+		return nil, err
+	}
+
+	hit := res.Header.Get(httpcache.XFromCache) != ""
+	if hit {
+		t.stats.Hits.Add(1)
+		origin.Hits.Add(1)
+	}
+	t.stats.urls.request(req.URL.String(), hit)
+	if h, ok := clientHeader(res.Header); ok {
+		// the cacheTransport stores the response once its body is read
+		// to the end (see cachingBody), so its headers are copied rather
+		// than modified
+		cpy := *res
+		cpy.Header = h
+		res = &cpy
+	}
+
+	res.Body = newCountingBody(res.Body, &t.stats.BytesServed, &origin.BytesServed)
+	return res, nil
+}
+
+// originStatsTransport records the requests made to origins. It sits
+// behind the cache.
+type originStatsTransport struct {
+	stats      *Stats
+	thresholds *thresholds
+	transport  http.RoundTripper
+}
+
+func (t *originStatsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	origin := t.stats.Origin(req.URL.Host)
+	t.stats.OriginFetches.Add(1)
+	origin.OriginFetches.Add(1)
+
+	start := time.Now()
+	res, err := t.transport.RoundTrip(req)
+	latency := time.Since(start)
+	t.stats.OriginLatency.Observe(latency.Seconds())
+	if err != nil || res.StatusCode >= http.StatusInternalServerError {
+		t.stats.OriginErrors.Add(1)
+		origin.OriginErrors.Add(1)
+	}
+	if err != nil {
+		t.stats.urls.fetch(req.URL.String(), 0, err)
+		return nil, err
+	}
+	t.stats.urls.fetch(req.URL.String(), res.StatusCode, nil)
+
+	alert := OriginAlert{URL: req.URL, Latency: latency, Size: res.ContentLength}
+	alert.Slow = t.thresholds.latency > 0 && latency > t.thresholds.latency
+	alert.Large = t.thresholds.size > 0 && res.ContentLength > t.thresholds.size
+	if alert.Slow || alert.Large {
+		t.thresholds.raise(t.stats, origin, alert)
+	}
+
+	res.Body = newCountingBody(res.Body, &t.stats.BytesFromOrigin, &origin.BytesFromOrigin)
+	if t.thresholds.size > 0 && res.ContentLength < 0 {
+		res.Body = &largeBody{
+			ReadCloser: res.Body,
+			limit:      t.thresholds.size,
+			raise: func(size int64) {
+				t.thresholds.raise(t.stats, origin, OriginAlert{URL: req.URL, Latency: latency, Size: size, Large: true})
+			},
+		}
+	}
+	return res, nil
+}
+
+// sizeStatsCache records the sizes of the objects stored in a cache.
+type sizeStatsCache struct {
+	httpcache.Cache
+	stats *Stats
+}
+
+func (c *sizeStatsCache) Set(key string, resp []byte) {
+	c.stats.ObjectSize.Observe(float64(len(resp)))
+	c.Cache.Set(key, resp)
+}
+
+// countingBody counts the bytes read from a body.
+type countingBody struct {
+	io.ReadCloser
+	total, origin *AtomicInt
+}
+
+// newCountingBody wraps body, keeping its io.WriterTo implementation
+// if it has one.
+func newCountingBody(body io.ReadCloser, total, origin *AtomicInt) io.ReadCloser {
+	b := &countingBody{body, total, origin}
+	if _, ok := body.(io.WriterTo); ok {
+		return &countingWriterTo{b}
+	}
+	return b
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.total.Add(int64(n))
+	b.origin.Add(int64(n))
+	return n, err
+}
+
+// countingWriterTo counts the bytes written by a body implementing
+// io.WriterTo.
+type countingWriterTo struct {
+	*countingBody
+}
+
+func (b *countingWriterTo) WriteTo(w io.Writer) (int64, error) {
+	n, err := b.ReadCloser.(io.WriterTo).WriteTo(w)
+	b.total.Add(n)
+	b.origin.Add(n)
+	return n, err
+}
@@ -0,0 +1,249 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestStats(t *testing.T) {
+	origin := newRoundTripperMock().
+		add("GET", "http://cdn.com/jquery.js", func(*http.Request) (*http.Response, error) {
+			return okResponse(), nil
+		}).
+		add("GET", "http://other.com/down.js", func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("connection refused")
+		})
+
+	proxy := newProxy("/p", httpcache.NewMemoryCache(), origin, nil)
+
+	for _, u := range []string{
+		"http://cdn.com/jquery.js",
+		"http://cdn.com/jquery.js",
+		"http://cdn.com/jquery.js",
+		"http://cdn.com/bootstrap.js",
+		"http://other.com/down.js",
+	} {
+		req, _ := http.NewRequest("GET", "/p?q="+url.QueryEscape(u), nil)
+		proxy.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	testCases := []struct {
+		name string
+		got  CountersSnapshot
+		want CountersSnapshot
+	}{
+		{"total", proxy.stats.Counters.Snapshot(), CountersSnapshot{
+			Requests:        5,
+			Hits:            2,
+			OriginFetches:   3,
+			OriginErrors:    1,
+			BytesFromOrigin: 11,
+			BytesServed:     15,
+			HitRatio:        0.4,
+			ErrorRatio:      1.0 / 3,
+		}},
+		{"cdn.com", proxy.stats.Origin("cdn.com").Snapshot(), CountersSnapshot{
+			Requests:        4,
+			Hits:            2,
+			OriginFetches:   2,
+			BytesFromOrigin: 11,
+			BytesServed:     15,
+			HitRatio:        0.5,
+		}},
+		{"other.com", proxy.stats.Origin("other.com").Snapshot(), CountersSnapshot{
+			Requests:      1,
+			OriginFetches: 1,
+			OriginErrors:  1,
+			ErrorRatio:    1,
+		}},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			if tC.got != tC.want {
+				t.Errorf("unexpected stats: got %+v, want %+v", tC.got, tC.want)
+			}
+		})
+	}
+
+	if got, want := proxy.stats.ObjectSize.Snapshot().Count, int64(2); got != want {
+		t.Errorf("unexpected number of stored objects: got %d, want %d", got, want)
+	}
+	if got, want := proxy.stats.OriginLatency.Snapshot().Count, int64(3); got != want {
+		t.Errorf("unexpected number of origin latencies: got %d, want %d", got, want)
+	}
+
+	var snap StatsSnapshot
+	if err := json.Unmarshal([]byte(proxy.stats.String()), &snap); err != nil {
+		t.Fatalf("unexpected error: got %q, want <nil>", err)
+	}
+	if got, want := len(snap.Origins), 2; got != want {
+		t.Errorf("unexpected number of origins: got %d, want %d", got, want)
+	}
+}
+
+func TestOriginsBound(t *testing.T) {
+	stats := newStats()
+	now := time.Now()
+	stats.now = func() time.Time { return now }
+
+	busy := stats.Origin("busy.com")
+	busy.Requests.Add(10)
+	for i := 0; i < 2*maxOrigins; i++ {
+		stats.Origin(fmt.Sprintf("%d.com", i)).Requests.Add(1)
+	}
+
+	origins := stats.Origins()
+	if got, want := len(origins), maxOrigins; got != want {
+		t.Errorf("unexpected number of origins: got %d, want %d", got, want)
+	}
+	var requests int64
+	for _, c := range origins {
+		requests += c.Requests.Get()
+	}
+	if want := int64(10 + 2*maxOrigins); requests != want {
+		t.Errorf("unexpected requests: got %d, want %d", requests, want)
+	}
+	// the new hosts are counted under OtherOrigins while the others
+	// are in their grace period
+	if origins[OtherOrigins] == nil || stats.Origin("new.com") != origins[OtherOrigins] {
+		t.Errorf("expected the new origins under %q", OtherOrigins)
+	}
+
+	// then the least requested ones make room for them, and are given
+	// a grace period in turn
+	now = now.Add(originGrace)
+	for _, host := range []string{"new.com", "newer.com"} {
+		if stats.Origin(host) == stats.Origin(OtherOrigins) {
+			t.Errorf("expected %s to be counted separately", host)
+		}
+	}
+	if origins := stats.Origins(); origins["busy.com"] != busy || origins["new.com"] == nil || len(origins) != maxOrigins {
+		t.Errorf("expected the most requested and the new origins to be kept")
+	}
+}
+
+func TestOriginsFoldInFlight(t *testing.T) {
+	stats := newStats()
+	now := time.Now()
+	stats.now = func() time.Time { return now }
+
+	// a request for a host folded while in flight
+	inFlight := stats.Origin("inflight.com")
+	inFlight.Requests.Add(1)
+	inFlight.IdleConns.Add(1)
+	for i := 0; i < maxOrigins-2; i++ {
+		stats.Origin(fmt.Sprintf("%d.com", i)).Requests.Add(2)
+	}
+	now = now.Add(originGrace)
+	stats.Origin("new.com")
+	if _, ok := stats.Origins()["inflight.com"]; ok {
+		t.Fatal("expected the least requested origin to be folded")
+	}
+
+	inFlight.Hits.Add(1)
+	inFlight.BytesServed.Add(100)
+	inFlight.IdleConns.Add(-1)
+	other := stats.Origins()[OtherOrigins]
+	want := CountersSnapshot{Requests: 1, Hits: 1, BytesServed: 100, HitRatio: 1}
+	if got := other.Snapshot(); got != want {
+		t.Errorf("unexpected folded counters: got %+v, want %+v", got, want)
+	}
+
+	// the counters no longer used are forgotten after their grace period
+	now = now.Add(originGrace)
+	stats.Origins()
+	if got := len(stats.retired); got != 0 {
+		t.Errorf("unexpected retired counters: got %d, want %d", got, 0)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram(1, 5, 10)
+	for _, v := range []float64{0.5, 1, 2, 5, 7, 11, 100} {
+		h.Observe(v)
+	}
+
+	snap := h.Snapshot()
+	want := []Bucket{{1, 2}, {5, 4}, {10, 5}}
+	for i, b := range snap.Buckets {
+		if b != want[i] {
+			t.Errorf("unexpected bucket %d: got %+v, want %+v", i, b, want[i])
+		}
+	}
+	if snap.Count != 7 {
+		t.Errorf("unexpected count: got %d, want %d", snap.Count, 7)
+	}
+	if snap.Sum != 126.5 {
+		t.Errorf("unexpected sum: got %f, want %f", snap.Sum, 126.5)
+	}
+}
+
+func TestClientPeerStats(t *testing.T) {
+	hash := newHashMock().
+		with("http://a.com:3000", 0).
+		with("http://b.com:3000", 1).
+		with("http://some.url/res-a.js", 0).
+		with("http://some.url/res-b.js", 1)
+
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host == "b.com:3000" {
+			return &http.Response{StatusCode: http.StatusBadGateway, Header: make(http.Header), Body: http.NoBody}, nil
+		}
+		return okResponse(), nil
+	})
+
+	client := NewClient(
+		WithPool("http://a.com:3000", "http://b.com:3000"),
+		WithHashFn(hash.fn),
+		WithClientTransport(transport),
+	)
+	for _, u := range []string{"http://some.url/res-a.js", "http://some.url/res-a.js", "http://some.url/res-b.js"} {
+		req, _ := http.NewRequest("GET", u, nil)
+		if _, err := client.RoundTrip(req); err != nil {
+			t.Fatalf("unexpected error: got %q, want <nil>", err)
+		}
+	}
+
+	testCases := []struct {
+		peer   string
+		counts PeerCountersSnapshot
+	}{
+		{"http://a.com:3000", PeerCountersSnapshot{Requests: 2, Errors: 0, ErrorRatio: 0}},
+		{"http://b.com:3000", PeerCountersSnapshot{Requests: 1, Errors: 1, ErrorRatio: 1}},
+	}
+	peers := client.ClientStats().Snapshot().Peers
+	for _, tC := range testCases {
+		if got := peers[tC.peer]; got != tC.counts {
+			t.Errorf("unexpected counters of %q: got %+v, want %+v", tC.peer, got, tC.counts)
+		}
+	}
+
+	client.SetPool("http://a.com:3000")
+	if _, ok := client.ClientStats().Peers()["http://b.com:3000"]; ok {
+		t.Errorf("unexpected counters of a peer removed from the pool")
+	}
+}
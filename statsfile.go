@@ -0,0 +1,191 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// persistedCounters are the cumulative counters kept across restarts,
+// see WithStatsFile.
+type persistedCounters struct {
+	Requests        int64 `json:"requests"`
+	Hits            int64 `json:"hits"`
+	OriginFetches   int64 `json:"originFetches"`
+	OriginErrors    int64 `json:"originErrors"`
+	BytesFromOrigin int64 `json:"bytesFromOrigin"`
+	BytesServed     int64 `json:"bytesServed"`
+}
+
+// persistedStats is the content of a stats file.
+type persistedStats struct {
+	persistedCounters
+	Origins map[string]persistedCounters `json:"origins"`
+}
+
+func persistCounters(c *Counters) persistedCounters {
+	return persistedCounters{
+		Requests:        c.Requests.Get(),
+		Hits:            c.Hits.Get(),
+		OriginFetches:   c.OriginFetches.Get(),
+		OriginErrors:    c.OriginErrors.Get(),
+		BytesFromOrigin: c.BytesFromOrigin.Get(),
+		BytesServed:     c.BytesServed.Get(),
+	}
+}
+
+// restore adds the persisted counters to c.
+func (p persistedCounters) restore(c *Counters) {
+	c.Requests.Add(p.Requests)
+	c.Hits.Add(p.Hits)
+	c.OriginFetches.Add(p.OriginFetches)
+	c.OriginErrors.Add(p.OriginErrors)
+	c.BytesFromOrigin.Add(p.BytesFromOrigin)
+	c.BytesServed.Add(p.BytesServed)
+}
+
+// resetCounters zeroes the cumulative counters of c.
+func resetCounters(c *Counters) {
+	for _, i := range []*AtomicInt{
+		&c.Requests, &c.Hits, &c.OriginFetches, &c.OriginErrors, &c.BytesFromOrigin, &c.BytesServed,
+		&c.SlowOrigins, &c.LargeResponses, &c.Dials, &c.ReusedConns, &c.HandshakeTime,
+	} {
+		i.Set(0)
+	}
+}
+
+// Reset zeroes the counters of the stats, in total and per origin, for
+// example to start a new reporting period. The gauges, like IdleConns,
+// and the histograms are kept.
+func (s *Stats) Reset() {
+	resetCounters(&s.Counters)
+	for _, c := range s.Origins() {
+		resetCounters(c)
+	}
+	for _, i := range []*AtomicInt{&s.Shed, &s.OriginQueued, &s.Unauthorized, &s.NotAdmitted, &s.Truncated, &s.Compressed, &s.OverBudget, &s.Resumed, &s.Mismatched} {
+		i.Set(0)
+	}
+}
+
+// statsFile persists the cumulative counters of a peer.
+type statsFile struct {
+	path     string
+	schedule Schedule
+	mu       sync.Mutex // serializes the writes
+}
+
+// WithStatsFile persists the cumulative counters of the peer, the
+// requests, hits, origin fetches and errors and the bytes fetched from
+// the origins and served, in total and per origin, to the file at path
+// on schedule s and when the peer is closed. They are restored when the
+// peer is created, so that long term savings survive restarts:
+//
+//	WithStatsFile("/var/lib/forwardcache/stats.json", Every(time.Minute))
+//
+// The stats are reset, and the file rewritten, with the admin API.
+func WithStatsFile(path string, s Schedule) func(*Peer) {
+	return func(p *Peer) {
+		p.statsFile = &statsFile{path: path, schedule: s}
+	}
+}
+
+// load restores the counters persisted in the file into stats.
+func (f *statsFile) load(stats *Stats) error {
+	data, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var persisted persistedStats
+	if err := json.Unmarshal(data, &persisted); err != nil {
+		return err
+	}
+	persisted.restore(&stats.Counters)
+	for host, c := range persisted.Origins {
+		c.restore(stats.Origin(host))
+	}
+	return nil
+}
+
+// save persists the counters of stats in the file, replacing it
+// atomically.
+func (f *statsFile) save(stats *Stats) error {
+	persisted := persistedStats{
+		persistedCounters: persistCounters(&stats.Counters),
+		Origins:           make(map[string]persistedCounters),
+	}
+	for host, c := range stats.Origins() {
+		persisted.Origins[host] = persistCounters(c)
+	}
+	data, err := json.Marshal(persisted)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), f.path)
+}
+
+// runStatsFile persists the stats on schedule until the peer is closed.
+func (p *Peer) runStatsFile() {
+	for {
+		now := time.Now()
+		timer := time.NewTimer(p.statsFile.schedule.Next(now).Sub(now))
+		select {
+		case <-timer.C:
+			if err := p.statsFile.save(p.Stats()); err != nil {
+				log.Printf("forwardcache: saving stats: %v", err)
+			}
+		case <-p.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// ResetStats resets the stats of the peer (see Stats.Reset), rewriting
+// the stats file if it has one.
+func (p *Peer) ResetStats() error {
+	p.Stats().Reset()
+	if p.statsFile != nil {
+		return p.statsFile.save(p.Stats())
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "forwardcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "stats.json")
+
+	origin := roundTripperFunc(func(*http.Request) (*http.Response, error) {
+		return okResponse(), nil
+	})
+	newPeer := func() *Peer {
+		return NewPeer("http://self.com:3000", WithPeerTransport(origin), WithStatsFile(path, Every(time.Hour)))
+	}
+
+	peer := newPeer()
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("GET", "/proxy?q="+url.QueryEscape("http://cdn.com/jquery.js"), nil)
+		peer.Handler().ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if err := peer.Close(); err != nil {
+		t.Fatalf("unexpected error closing the peer: %v", err)
+	}
+
+	peer = newPeer()
+	defer peer.Close()
+	testCases := []struct {
+		name string
+		got  CountersSnapshot
+		want CountersSnapshot
+	}{
+		{"total", peer.Stats().Counters.Snapshot(), CountersSnapshot{Requests: 2, Hits: 1, OriginFetches: 1, BytesFromOrigin: 2, BytesServed: 4, HitRatio: 0.5}},
+		{"cdn.com", peer.Stats().Origin("cdn.com").Snapshot(), CountersSnapshot{Requests: 2, Hits: 1, OriginFetches: 1, BytesFromOrigin: 2, BytesServed: 4, HitRatio: 0.5}},
+	}
+	for _, tC := range testCases {
+		if tC.got != tC.want {
+			t.Errorf("unexpected restored %s stats: got %+v, want %+v", tC.name, tC.got, tC.want)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/stats/reset", nil)
+	peer.AdminHandler().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status resetting the stats: got %d, want %d", rr.Code, http.StatusNoContent)
+	}
+	if got := peer.Stats().Requests.Get(); got != 0 {
+		t.Errorf("unexpected requests after a reset: got %d, want 0", got)
+	}
+	restarted := newPeer()
+	defer restarted.Close()
+	if got := restarted.Stats().Requests.Get(); got != 0 {
+		t.Errorf("unexpected requests restored after a reset: got %d, want 0", got)
+	}
+}
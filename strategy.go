@@ -0,0 +1,416 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mikegleasonjr/forwardcache/consistenthash"
+)
+
+// Strategy chooses the peers the requests for a resource are sent to.
+// Strategies are immutable: a change of the pool makes a new one, the
+// requests being routed with the previous one until it is ready. All
+// the clients and peers of a pool must use the same strategy, so that
+// they agree on the owners of the resources.
+type Strategy interface {
+	// WithPool returns the strategy choosing among peers, the previous
+	// pool of the receiver changed by adding and removing peers. It
+	// must not modify the receiver.
+	WithPool(peers, added, removed []string) Strategy
+
+	// Peers appends to dst at most n distinct peers of the pool for
+	// the key of a resource, by preference: its owner first, then the
+	// peers to fail over to.
+	Peers(dst []string, key string, n int) []string
+}
+
+// ringStrategy is implemented by the strategies built on a consistent
+// hash, whose layout can be exported and restored, see Client.Ring.
+// ring returns nil if the strategy isn't built on one after all.
+type ringStrategy interface {
+	Strategy
+	ring() *consistenthash.Map
+	withRing(ring *consistenthash.Map, peers []string) Strategy
+}
+
+// clientStrategy is implemented by the strategies which depend on the
+// client using them, bound once it is configured.
+type clientStrategy interface {
+	bind(c *Client)
+}
+
+// ConsistentHash chooses the peers on a consistent hash with replicas
+// points per peer, using fn. Only the resources of the peers added or
+// removed change owners with the pool. It is the default strategy,
+// using the values of WithReplicas and WithHashFn.
+func ConsistentHash(replicas int, fn consistenthash.Hash) Strategy {
+	return &consistentHash{consistenthash.New(replicas, fn)}
+}
+
+type consistentHash struct {
+	hashMap *consistenthash.Map
+}
+
+func (s *consistentHash) WithPool(peers, added, removed []string) Strategy {
+	ring := s.hashMap.Clone()
+	ring.Remove(removed...)
+	ring.Add(added...)
+	return &consistentHash{ring}
+}
+
+func (s *consistentHash) Peers(dst []string, key string, n int) []string {
+	if n == 1 {
+		if peer := s.hashMap.Get(key); peer != "" {
+			dst = append(dst, peer)
+		}
+		return dst
+	}
+	return append(dst, s.hashMap.GetN(key, n)...)
+}
+
+func (s *consistentHash) ring() *consistenthash.Map {
+	return s.hashMap
+}
+
+func (s *consistentHash) withRing(ring *consistenthash.Map, peers []string) Strategy {
+	return &consistentHash{ring}
+}
+
+// RendezvousHash chooses the peers with the highest random weight for
+// the key of a resource, weights being computed with fn. Like a
+// consistent hash, only the resources of the peers added or removed
+// change owners with the pool, but the resources are spread evenly
+// without virtual nodes, at a cost linear in the size of the pool.
+func RendezvousHash(fn consistenthash.Hash) Strategy {
+	return &rendezvous{hash: fn}
+}
+
+type rendezvous struct {
+	hash   consistenthash.Hash
+	peers  []string
+	hashes []uint32 // of the peers
+}
+
+func (s *rendezvous) WithPool(peers, added, removed []string) Strategy {
+	r := &rendezvous{hash: s.hash, peers: append([]string(nil), peers...)}
+	r.hashes = make([]uint32, len(peers))
+	for i, peer := range peers {
+		r.hashes[i] = s.hash([]byte(peer))
+	}
+	return r
+}
+
+func (s *rendezvous) Peers(dst []string, key string, n int) []string {
+	if len(s.peers) == 0 || n < 1 {
+		return dst
+	}
+	h := s.hash([]byte(key))
+	if n == 1 {
+		best, max := 0, uint64(0)
+		for i := range s.peers {
+			if w := weight(h, s.hashes[i]); i == 0 || w > max {
+				best, max = i, w
+			}
+		}
+		return append(dst, s.peers[best])
+	}
+
+	weights := make([]uint64, len(s.peers))
+	order := make([]int, len(s.peers))
+	for i := range s.peers {
+		weights[i], order[i] = weight(h, s.hashes[i]), i
+	}
+	sort.Slice(order, func(i, j int) bool { return weights[order[i]] > weights[order[j]] })
+	for _, i := range order {
+		if n == 0 {
+			break
+		}
+		dst = append(dst, s.peers[i])
+		n--
+	}
+	return dst
+}
+
+// weight mixes the hashes of a key and of a peer (the finalizer of
+// SplitMix64), as hashes like crc32 don't mix concatenations well.
+func weight(key, peer uint32) uint64 {
+	z := uint64(key)<<32 | uint64(peer)
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// BoundedLoad chooses the peers on a consistent hash like
+// ConsistentHash, but skips the peers with more than factor times the
+// average number of requests in flight, so that a hot resource doesn't
+// overload its owner: its requests spill over to the next peers of the
+// ring. Requests are in flight until the client gets their response
+// headers. factor is at least 1, 1.25 being a common value. The loads
+// are the ones of the client, not of the whole pool.
+func BoundedLoad(replicas int, fn consistenthash.Hash, factor float64) Strategy {
+	if factor < 1 {
+		factor = 1
+	}
+	return &boundedLoad{
+		hashMap: consistenthash.New(replicas, fn),
+		factor:  factor,
+		loads:   &peerLoads{inFlight: make(map[string]int)},
+	}
+}
+
+type boundedLoad struct {
+	hashMap *consistenthash.Map
+	peers   int // in the ring
+	factor  float64
+	loads   *peerLoads // shared by the strategies of all the pools
+}
+
+func (s *boundedLoad) WithPool(peers, added, removed []string) Strategy {
+	ring := s.hashMap.Clone()
+	ring.Remove(removed...)
+	ring.Add(added...)
+	return s.withRing(ring, peers)
+}
+
+func (s *boundedLoad) Peers(dst []string, key string, n int) []string {
+	ordered := s.hashMap.GetN(key, s.peers)
+	if len(ordered) == 0 {
+		return dst
+	}
+
+	s.loads.mu.Lock()
+	total := 0
+	for _, peer := range ordered {
+		total += s.loads.inFlight[peer]
+	}
+	bound := int(math.Ceil(s.factor * float64(total+1) / float64(len(ordered))))
+	first := 0
+	for i, peer := range ordered {
+		if s.loads.inFlight[peer]+1 <= bound {
+			first = i
+			break
+		}
+	}
+	s.loads.mu.Unlock()
+
+	// the least loaded peer of the ring first, then the others in order
+	dst = append(dst, ordered[first])
+	for i, peer := range ordered {
+		if len(dst) >= n {
+			break
+		}
+		if i != first {
+			dst = append(dst, peer)
+		}
+	}
+	return dst
+}
+
+func (s *boundedLoad) bind(c *Client) {
+	c.loads = s.loads
+}
+
+func (s *boundedLoad) ring() *consistenthash.Map {
+	return s.hashMap
+}
+
+func (s *boundedLoad) withRing(ring *consistenthash.Map, peers []string) Strategy {
+	return &boundedLoad{hashMap: ring, peers: len(peers), factor: s.factor, loads: s.loads}
+}
+
+// peerLoads counts the requests in flight to each peer.
+type peerLoads struct {
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+// start counts a request to a peer, done its end.
+func (l *peerLoads) start(peer string) {
+	if l != nil {
+		l.mu.Lock()
+		l.inFlight[peer]++
+		l.mu.Unlock()
+	}
+}
+
+func (l *peerLoads) done(peer string) {
+	if l != nil {
+		l.mu.Lock()
+		if l.inFlight[peer]--; l.inFlight[peer] <= 0 {
+			delete(l.inFlight, peer)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// Static chooses the peers in the order of the pool: all the requests
+// are sent to its first peer, failing over to the next ones, for a
+// primary peer and its backups.
+func Static() Strategy {
+	return static(nil)
+}
+
+type static []string
+
+func (s static) WithPool(peers, added, removed []string) Strategy {
+	return static(append([]string(nil), peers...))
+}
+
+func (s static) Peers(dst []string, key string, n int) []string {
+	if n > len(s) {
+		n = len(s)
+	}
+	return append(dst, s[:n]...)
+}
+
+// StrategyFunc chooses the peers of a resource with a function of the
+// pool and of the key of the resource, for custom strategies like
+// pinning the resources of a tenant to its peers. The function is
+// called for every request and must not modify peers.
+type StrategyFunc func(peers []string, key string, n int) []string
+
+// WithPool returns the strategy choosing among peers.
+func (f StrategyFunc) WithPool(peers, added, removed []string) Strategy {
+	return &funcStrategy{f, append([]string(nil), peers...)}
+}
+
+// Peers returns f(nil, key, n), appended to dst: a StrategyFunc
+// without pool has no peers to choose among.
+func (f StrategyFunc) Peers(dst []string, key string, n int) []string {
+	return append(dst, f(nil, key, n)...)
+}
+
+type funcStrategy struct {
+	fn    StrategyFunc
+	peers []string
+}
+
+func (s *funcStrategy) WithPool(peers, added, removed []string) Strategy {
+	return s.fn.WithPool(peers, added, removed)
+}
+
+func (s *funcStrategy) Peers(dst []string, key string, n int) []string {
+	return append(dst, s.fn(s.peers, key, n)...)
+}
+
+// WithStrategy sets the strategy choosing the peers of the resources.
+// Defaults to ConsistentHash.
+func WithStrategy(s Strategy) func(*Client) {
+	return func(c *Client) {
+		c.strategy = s
+	}
+}
+
+// Rule pins the resources matching a pattern to a peer, see Rules.
+type Rule struct {
+	// Pattern matches the key of the resources (see WithKeyFn). Patterns
+	// without slash match the host, like "*.example.com", patterns
+	// starting with a slash match the path and query, like "/videos/*",
+	// and the other ones match the whole URL. Stars match any sequence
+	// of characters.
+	Pattern string
+
+	// Peer is the base URL of the peer, like "http://example.net:8000".
+	Peer string
+}
+
+// Rules chooses the peer of the first rule matching the resource, for
+// example to pin the large files of an origin to the peer with the
+// biggest disk, and fails over to the peers chosen by fallback. The
+// resources matching no rule, or rules whose peer isn't in the pool,
+// are routed by fallback only.
+func Rules(fallback Strategy, rules ...Rule) Strategy {
+	return &ruleStrategy{fallback: fallback, rules: rules}
+}
+
+type ruleStrategy struct {
+	fallback Strategy
+	rules    []Rule
+	pool     map[string]bool
+}
+
+func (s *ruleStrategy) WithPool(peers, added, removed []string) Strategy {
+	return s.with(s.fallback.WithPool(peers, added, removed), peers)
+}
+
+// with returns the strategy with a fallback for a pool.
+func (s *ruleStrategy) with(fallback Strategy, peers []string) Strategy {
+	pool := make(map[string]bool, len(peers))
+	for _, peer := range peers {
+		pool[peer] = true
+	}
+	return &ruleStrategy{fallback: fallback, rules: s.rules, pool: pool}
+}
+
+func (s *ruleStrategy) Peers(dst []string, key string, n int) []string {
+	pinned := s.pinned(key)
+	if pinned == "" || n < 1 {
+		return s.fallback.Peers(dst, key, n)
+	}
+
+	dst = append(dst, pinned)
+	start := len(dst)
+	dst = s.fallback.Peers(dst, key, n)
+	for i := start; i < len(dst); i++ {
+		if dst[i] == pinned {
+			return append(dst[:i], dst[i+1:]...)
+		}
+	}
+	if len(dst) > start+n-1 {
+		dst = dst[:start+n-1]
+	}
+	return dst
+}
+
+// pinned returns the peer of the first rule matching key, "" for none.
+func (s *ruleStrategy) pinned(key string) string {
+	var host string
+	if u, err := url.Parse(key); err == nil {
+		host = strings.ToLower(u.Hostname())
+	}
+	for _, rule := range s.rules {
+		if s.pool[rule.Peer] && matchURL(rule.Pattern, host, key) {
+			return rule.Peer
+		}
+	}
+	return ""
+}
+
+func (s *ruleStrategy) bind(c *Client) {
+	if b, ok := s.fallback.(clientStrategy); ok {
+		b.bind(c)
+	}
+}
+
+func (s *ruleStrategy) ring() *consistenthash.Map {
+	if r, ok := s.fallback.(ringStrategy); ok {
+		return r.ring()
+	}
+	return nil
+}
+
+func (s *ruleStrategy) withRing(ring *consistenthash.Map, peers []string) Strategy {
+	if r, ok := s.fallback.(ringStrategy); ok {
+		return s.with(r.withRing(ring, peers), peers)
+	}
+	return s.with(&consistentHash{ring}, peers)
+}
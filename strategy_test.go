@@ -0,0 +1,179 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+var strategyPool = []string{"http://a.com:3000", "http://b.com:3000", "http://c.com:3000"}
+
+// tenantStrategy pins the resources of tenant b to the second peer of
+// the pool, and the others to the first.
+var tenantStrategy = StrategyFunc(func(peers []string, key string, n int) []string {
+	if strings.Contains(key, "/tenant-b/") {
+		return peers[1:2]
+	}
+	return peers[:1]
+})
+
+func TestStrategies(t *testing.T) {
+	testCases := []struct {
+		name     string
+		strategy Strategy
+		stable   bool // only the resources of a removed peer change owners
+		spread   bool // all the peers own resources
+		failover int  // distinct peers chosen for the failover
+	}{
+		{"consistent hash", ConsistentHash(protocol.DefaultReplicas, protocol.Hash), true, true, 3},
+		{"rendezvous hash", RendezvousHash(protocol.Hash), true, true, 3},
+		{"bounded load", BoundedLoad(protocol.DefaultReplicas, protocol.Hash, 1.25), true, true, 3},
+		{"static", Static(), false, false, 3},
+		{"func", tenantStrategy, false, false, 1},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			client := NewClient(WithPool(strategyPool...), WithStrategy(tC.strategy))
+
+			owners := make(map[string]string)
+			owned := make(map[string]int)
+			for i := 0; i < 300; i++ {
+				u := fmt.Sprintf("http://some.url/res-%d.js", i)
+				owners[u] = client.Owner(u)
+				owned[owners[u]]++
+			}
+			if got := len(owned); tC.spread && got != len(strategyPool) {
+				t.Errorf("unexpected number of peers owning resources: got %d, want %d", got, len(strategyPool))
+			}
+
+			peers, _ := client.choosePeers(nil, "http://some.url/res-0.js", 3)
+			seen := make(map[string]bool)
+			for _, peer := range peers {
+				seen[peer] = true
+			}
+			if len(peers) != tC.failover || len(seen) != tC.failover {
+				t.Errorf("unexpected failover peers: got %q, want %d distinct peers", peers, tC.failover)
+			}
+
+			if !tC.stable {
+				return
+			}
+			client.SetPool(strategyPool[:2]...)
+			for u, before := range owners {
+				if got := client.Owner(u); before != strategyPool[2] && got != before {
+					t.Errorf("unexpected owner of %q: got %q, want %q", u, got, before)
+				}
+			}
+		})
+	}
+}
+
+func TestStrategyFunc(t *testing.T) {
+	client := NewClient(WithPool(strategyPool...), WithStrategy(tenantStrategy))
+
+	testCases := []struct {
+		url  string
+		want string
+	}{
+		{"http://some.url/tenant-a/res.js", "http://a.com:3000"},
+		{"http://some.url/tenant-b/res.js", "http://b.com:3000"},
+	}
+	for _, tC := range testCases {
+		if got := client.Owner(tC.url); got != tC.want {
+			t.Errorf("unexpected owner of %q: got %q, want %q", tC.url, got, tC.want)
+		}
+	}
+}
+
+func TestBoundedLoad(t *testing.T) {
+	client := NewClient(WithPool(strategyPool...), WithStrategy(BoundedLoad(protocol.DefaultReplicas, protocol.Hash, 1)))
+	u := "http://some.url/hot.js"
+	owner := client.Owner(u)
+
+	client.loads.start(owner)
+	if got := client.Owner(u); got == owner {
+		t.Errorf("unexpected owner of %q under load: got %q, want another peer", u, got)
+	}
+	client.loads.done(owner)
+	if got := client.Owner(u); got != owner {
+		t.Errorf("unexpected owner of %q: got %q, want %q", u, got, owner)
+	}
+
+	restored := NewClient(WithStrategy(BoundedLoad(protocol.DefaultReplicas, protocol.Hash, 1)))
+	restored.SetRing(client.Ring())
+	restored.loads.start(owner)
+	if got := restored.Owner(u); got == owner {
+		t.Errorf("unexpected owner of %q under load with a restored ring: got %q, want another peer", u, got)
+	}
+}
+
+func TestStrategyRing(t *testing.T) {
+	client := NewClient(WithPool(strategyPool...), WithStrategy(Static()))
+	if got := len(client.Ring().Points); got != 0 {
+		t.Errorf("unexpected ring points of a static strategy: got %d, want 0", got)
+	}
+
+	client.SetRing(NewClient(WithPool(strategyPool...)).Ring())
+	if _, ok := client.strategy.(*consistentHash); !ok {
+		t.Errorf("unexpected strategy with a restored ring: got %T, want a consistent hash", client.strategy)
+	}
+}
+
+func TestRules(t *testing.T) {
+	strategy := Rules(ConsistentHash(protocol.DefaultReplicas, protocol.Hash),
+		Rule{Pattern: "videos.example.com", Peer: "http://c.com:3000"},
+		Rule{Pattern: "/large/*", Peer: "http://b.com:3000"},
+		Rule{Pattern: "http://other.com/*", Peer: "http://d.com:3000"}, // not in the pool
+	)
+	client := NewClient(WithPool(strategyPool...), WithStrategy(strategy))
+	fallback := NewClient(WithPool(strategyPool...))
+
+	testCases := []struct {
+		url  string
+		want string
+	}{
+		{"http://videos.example.com/a.mp4", "http://c.com:3000"},
+		{"http://VIDEOS.example.com/b.mp4", "http://c.com:3000"},
+		{"http://cdn.com/large/c.iso", "http://b.com:3000"},
+		{"http://cdn.com/small/d.js", fallback.Owner("http://cdn.com/small/d.js")},
+		{"http://other.com/e.js", fallback.Owner("http://other.com/e.js")},
+	}
+	for _, tC := range testCases {
+		if got := client.Owner(tC.url); got != tC.want {
+			t.Errorf("unexpected owner of %q: got %q, want %q", tC.url, got, tC.want)
+		}
+
+		peers, _ := client.choosePeers(nil, tC.url, 3)
+		seen := make(map[string]bool)
+		for _, peer := range peers {
+			seen[peer] = true
+		}
+		if len(peers) != 3 || len(seen) != 3 || peers[0] != tC.want {
+			t.Errorf("unexpected failover peers of %q: got %q, want 3 distinct peers, %q first", tC.url, peers, tC.want)
+		}
+	}
+
+	restored := NewClient(WithStrategy(strategy))
+	restored.SetRing(client.Ring())
+	if got, want := restored.Owner("http://cdn.com/large/c.iso"), "http://b.com:3000"; got != want {
+		t.Errorf("unexpected owner with a restored ring: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"strings"
+)
+
+// targetedHeaders are the response headers controlling the caching of
+// surrogates like the peers, distinct from the Cache-Control meant for
+// browsers, by precedence.
+var targetedHeaders = []string{"Cdn-Cache-Control", "Surrogate-Control"}
+
+// browserCacheControlHeader holds the Cache-Control meant for browsers,
+// empty if none, while the cache uses a targeted header instead.
+const browserCacheControlHeader = "X-Forwardcache-Cache-Control"
+
+// surrogateTransport makes the cache honor the targeted headers of the
+// origin responses by using them as their Cache-Control. It sits behind
+// the cache.
+type surrogateTransport struct {
+	transport http.RoundTripper
+}
+
+func (t *surrogateTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range targetedHeaders {
+		if cc := res.Header.Get(name); cc != "" {
+			res.Header[browserCacheControlHeader] = []string{strings.Join(res.Header["Cache-Control"], ", ")}
+			res.Header.Set("Cache-Control", cc)
+			break
+		}
+	}
+	return res, nil
+}
+
+// cacheOnly replaces the Cache-Control of a response for the cache
+// only, the clients getting the previous one back (see clientHeader).
+func cacheOnly(h http.Header, cc string) {
+	if _, ok := h[browserCacheControlHeader]; !ok {
+		h[browserCacheControlHeader] = []string{strings.Join(h["Cache-Control"], ", ")}
+	}
+	h.Set("Cache-Control", cc)
+}
+
+// restoreCacheControl gives the Cache-Control meant for browsers back to
+// a response served to a client, and removes its targeted headers.
+func restoreCacheControl(h http.Header) {
+	if _, ok := h[browserCacheControlHeader]; ok {
+		if cc := h.Get(browserCacheControlHeader); cc != "" {
+			h.Set("Cache-Control", cc)
+		} else {
+			h.Del("Cache-Control")
+		}
+		delete(h, browserCacheControlHeader)
+	}
+	for _, name := range targetedHeaders {
+		h.Del(name)
+	}
+}
+
+// clientHeader returns a copy of the header of a response served to
+// a client without the headers used by the peer, if it has any.
+func clientHeader(h http.Header) (http.Header, bool) {
+	names := append([]string{storedHeader, softPurgedHeader, browserCacheControlHeader}, targetedHeaders...)
+	for _, name := range names {
+		if _, ok := h[name]; ok {
+			h = h.Clone()
+			delete(h, storedHeader)
+			unmarkStaleHeader(h)
+			restoreCacheControl(h)
+			return h, true
+		}
+	}
+	return h, false
+}
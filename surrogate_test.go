@@ -0,0 +1,71 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gregjones/httpcache"
+)
+
+func TestSurrogateControl(t *testing.T) {
+	testCases := []struct {
+		desc       string
+		header     http.Header
+		xFromCache string // of the second request
+	}{
+		{"surrogate cacheable", http.Header{"Cache-Control": {"no-cache"}, "Surrogate-Control": {"max-age=3600"}}, "1"},
+		{"cdn not cacheable", http.Header{"Cache-Control": {"max-age=3600"}, "Cdn-Cache-Control": {"no-store"}}, ""},
+		{"cdn precedence", http.Header{"Cdn-Cache-Control": {"no-store"}, "Surrogate-Control": {"max-age=3600"}}, ""},
+		{"browser only", http.Header{"Cache-Control": {"max-age=3600"}}, "1"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.desc, func(t *testing.T) {
+			origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				res := okResponse()
+				res.Header = http.Header{"Date": {time.Now().UTC().Format(http.TimeFormat)}}
+				for k, v := range tC.header {
+					res.Header[k] = v
+				}
+				return res, nil
+			})
+			proxy := newProxy("/p", httpcache.NewMemoryCache(), origin, DefaultBufferPool)
+
+			for i, want := range []string{"", tC.xFromCache} {
+				rr := httptest.NewRecorder()
+				req, _ := http.NewRequest("GET", "/p?q="+url.QueryEscape("http://cdn.com/jquery.js"), nil)
+				proxy.ServeHTTP(rr, req)
+
+				if xFromCache := rr.HeaderMap.Get(httpcache.XFromCache); xFromCache != want {
+					t.Errorf("request %d: unexpected %q header: got %q, want %q", i, httpcache.XFromCache, xFromCache, want)
+				}
+				if cc, want := rr.HeaderMap.Get("Cache-Control"), tC.header.Get("Cache-Control"); cc != want {
+					t.Errorf("request %d: unexpected Cache-Control: got %q, want %q", i, cc, want)
+				}
+				for _, name := range append(targetedHeaders, browserCacheControlHeader) {
+					if v := rr.HeaderMap.Get(name); v != "" {
+						t.Errorf("request %d: unexpected %q header: got %q, want none", i, name, v)
+					}
+				}
+			}
+		})
+	}
+}
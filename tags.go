@@ -0,0 +1,100 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+// hasTag reports whether a response carries tag in its Surrogate-Key
+// (space separated) or Cache-Tag (comma separated) header.
+func hasTag(h http.Header, tag string) bool {
+	for _, v := range h["Surrogate-Key"] {
+		for _, t := range strings.Fields(v) {
+			if t == tag {
+				return true
+			}
+		}
+	}
+	for _, v := range h["Cache-Tag"] {
+		for _, t := range strings.Split(v, ",") {
+			if strings.TrimSpace(t) == tag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// PurgeTag purges the responses carrying tag in their Surrogate-Key or
+// Cache-Tag header from every peer of the pool, like Peer.Purge, or
+// marks them stale if soft (see Peer.SoftPurge). Peers refuse tag purges
+// without a cluster secret, unless they are configured with
+// WithUnsignedTagPurges, and must accept the client's API key, if they
+// require one.
+func (c *Client) PurgeTag(ctx context.Context, tag string, soft bool) error {
+	c.mu.RLock()
+	peers := c.peers
+	c.mu.RUnlock()
+
+	for _, peer := range peers {
+		u, err := protocol.PurgeURL(peer, c.path, tag, soft)
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequest(protocol.PurgeMethod, u.String(), nil)
+		if err != nil {
+			return err
+		}
+		req = req.WithContext(ctx)
+		if c.apiKey != "" {
+			req.Header.Set(protocol.APIKeyHeader, c.apiKey)
+		}
+		if c.secret != nil {
+			protocol.SignRequest(req, c.secret, tag, time.Now())
+		}
+
+		res, err := c.transport.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusNoContent {
+			return fmt.Errorf("forwardcache: purging %q from %s: %s", tag, peer, res.Status)
+		}
+	}
+	return nil
+}
+
+// servePurge serves the tag purges of the pool, see Client.PurgeTag.
+// They must be signed with the cluster secret, unless the peer accepts
+// unsigned tag purges without one.
+func (p *proxy) servePurge(w http.ResponseWriter, req *http.Request, query peerQuery) {
+	tag := query.tag
+	if p.purges == nil || tag == "" {
+		http.Error(w, "cannot purge", http.StatusBadRequest)
+		return
+	}
+	p.purges.purge(purge{tag: tag, soft: query.soft == "1"})
+	w.WriteHeader(http.StatusNoContent)
+}
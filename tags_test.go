@@ -0,0 +1,123 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gregjones/httpcache"
+	"github.com/mikegleasonjr/forwardcache/protocol"
+)
+
+func TestHasTag(t *testing.T) {
+	testCases := []struct {
+		header http.Header
+		tag    string
+		want   bool
+	}{
+		{http.Header{"Surrogate-Key": {"product-1 catalog"}}, "catalog", true},
+		{http.Header{"Surrogate-Key": {"product-1 catalog"}}, "product", false},
+		{http.Header{"Cache-Tag": {"product-1, catalog"}}, "catalog", true},
+		{http.Header{"Cache-Tag": {"product-1,catalog"}}, "product-1", true},
+		{http.Header{}, "catalog", false},
+	}
+	for _, tC := range testCases {
+		if got := hasTag(tC.header, tC.tag); got != tC.want {
+			t.Errorf("unexpected tag %q in %v: got %t, want %t", tC.tag, tC.header, got, tC.want)
+		}
+	}
+}
+
+func TestPurgeTag(t *testing.T) {
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("Surrogate-Key", req.URL.Query().Get("tags"))
+		return res, nil
+	})
+
+	var servers []*httptest.Server
+	var peers []*Peer
+	for i := 0; i < 2; i++ {
+		peer := NewPeer("", WithPeerTransport(origin), WithClusterSecret([]byte("s3cr3t")))
+		server := httptest.NewServer(peer.Handler())
+		defer server.Close()
+		servers = append(servers, server)
+		peers = append(peers, peer)
+	}
+	for _, peer := range peers {
+		peer.SetPool(servers[0].URL, servers[1].URL)
+	}
+
+	get := func(peer *Peer, u string) string {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/proxy?q="+url.QueryEscape(u), nil)
+		peer.Handler().ServeHTTP(rr, req)
+		return rr.HeaderMap.Get(httpcache.XFromCache)
+	}
+	tagged, untagged := "http://shop.com/1?tags=product-1+catalog", "http://shop.com/2?tags=product-2"
+	for _, peer := range peers {
+		get(peer, tagged)
+		get(peer, untagged)
+	}
+
+	if err := peers[0].PurgeTag(context.Background(), "catalog", false); err != nil {
+		t.Fatal(err)
+	}
+	for i, peer := range peers {
+		if xFromCache := get(peer, tagged); xFromCache != "" {
+			t.Errorf("peer %d: expected %q to be purged", i, tagged)
+		}
+		if xFromCache := get(peer, untagged); xFromCache != "1" {
+			t.Errorf("peer %d: expected %q to be cached", i, untagged)
+		}
+	}
+
+	// unsigned purges are refused
+	req, _ := http.NewRequest(protocol.PurgeMethod, servers[1].URL+"/proxy?tag=product-2", nil)
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unexpected status: got %d, want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+}
+
+func TestPurgeTagWithoutSecret(t *testing.T) {
+	tests := []struct {
+		options []func(*Peer)
+		status  int
+	}{
+		{nil, http.StatusForbidden},
+		{[]func(*Peer){WithUnsignedTagPurges()}, http.StatusNoContent},
+	}
+
+	for i, tt := range tests {
+		peer := NewPeer("", tt.options...)
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest(protocol.PurgeMethod, "/proxy?tag=catalog", nil)
+		peer.Handler().ServeHTTP(rr, req)
+		if rr.Code != tt.status {
+			t.Errorf("%d: unexpected status: got %d, want %d", i, rr.Code, tt.status)
+		}
+	}
+}
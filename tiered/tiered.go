@@ -0,0 +1,207 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tiered provides a cache made of a fast tier, usually in
+// memory, in front of a larger and slower one, usually on disk.
+package tiered
+
+import (
+	"sync"
+
+	"github.com/gregjones/httpcache"
+)
+
+// Mode is how the responses stored are propagated to the back tier.
+type Mode int
+
+const (
+	// WriteThrough stores the responses in both tiers before Set returns.
+	WriteThrough Mode = iota
+	// WriteBack stores the responses in the front tier and in the back
+	// tier asynchronously. The responses not written back yet are lost
+	// if the process stops without Close.
+	WriteBack
+)
+
+// DefaultMaxPending is the default number of responses waiting to be
+// written back.
+const DefaultMaxPending = 1024
+
+// Cache is an httpcache.Cache looking up responses in its front tier,
+// then in its back tier, promoting them to the front tier.
+// It is safe for concurrent access.
+type Cache struct {
+	front, back httpcache.Cache
+	mode        Mode
+	maxPending  int
+
+	mu      sync.Mutex
+	pending map[string][]byte // responses to write back
+	flushed map[string][]byte // responses being written back
+	writing sync.Mutex        // held while writing back, orders the writes and deletes
+	signal  chan struct{}
+	done    chan struct{}
+	wg      sync.WaitGroup
+	close   sync.Once
+}
+
+// New creates a Cache with front and back tiers.
+func New(front, back httpcache.Cache, options ...func(*Cache)) *Cache {
+	c := &Cache{
+		front:      front,
+		back:       back,
+		maxPending: DefaultMaxPending,
+		pending:    make(map[string][]byte),
+		signal:     make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	if c.mode == WriteBack {
+		c.wg.Add(1)
+		go c.writeBack()
+	}
+	return c
+}
+
+// WithMode specifies how the responses are propagated to the back tier.
+// Defaults to WriteThrough.
+func WithMode(m Mode) func(*Cache) {
+	return func(c *Cache) {
+		c.mode = m
+	}
+}
+
+// WithMaxPending bounds the number of responses waiting to be written
+// back. Once reached, Set writes to the back tier synchronously.
+// Defaults to DefaultMaxPending.
+func WithMaxPending(n int) func(*Cache) {
+	return func(c *Cache) {
+		c.maxPending = n
+	}
+}
+
+// Get returns the response stored for key.
+func (c *Cache) Get(key string) (resp []byte, ok bool) {
+	if resp, ok = c.front.Get(key); ok {
+		return
+	}
+
+	c.mu.Lock()
+	if resp, ok = c.pending[key]; !ok {
+		resp, ok = c.flushed[key]
+	}
+	c.mu.Unlock()
+	if !ok {
+		resp, ok = c.back.Get(key)
+	}
+	if ok {
+		c.front.Set(key, resp)
+	}
+	return
+}
+
+// Set stores a response in the front tier and propagates it to the back
+// tier according to the mode of the cache.
+func (c *Cache) Set(key string, resp []byte) {
+	c.front.Set(key, resp)
+
+	if c.mode == WriteBack {
+		c.mu.Lock()
+		_, queued := c.pending[key]
+		if queued || len(c.pending) < c.maxPending {
+			c.pending[key] = resp
+			c.mu.Unlock()
+			c.wake()
+			return
+		}
+		c.mu.Unlock()
+	}
+
+	c.writing.Lock()
+	c.back.Set(key, resp)
+	c.writing.Unlock()
+}
+
+// Delete removes the response stored for key from both tiers.
+func (c *Cache) Delete(key string) {
+	c.front.Delete(key)
+
+	c.writing.Lock()
+	c.mu.Lock()
+	delete(c.pending, key)
+	c.mu.Unlock()
+	c.back.Delete(key)
+	c.writing.Unlock()
+}
+
+// Flush writes the pending responses to the back tier.
+func (c *Cache) Flush() {
+	c.writing.Lock()
+	defer c.writing.Unlock()
+
+	c.mu.Lock()
+	c.flushed, c.pending = c.pending, make(map[string][]byte)
+	c.mu.Unlock()
+
+	for key, resp := range c.flushed {
+		c.back.Set(key, resp)
+	}
+
+	c.mu.Lock()
+	c.flushed = nil
+	c.mu.Unlock()
+}
+
+// Pending returns the number of responses waiting to be written back.
+func (c *Cache) Pending() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.pending)
+}
+
+// Close stops writing back in the background and flushes the pending
+// responses. It should be called on shutdown in WriteBack mode.
+func (c *Cache) Close() error {
+	c.close.Do(func() { close(c.done) })
+	c.wg.Wait()
+	c.Flush()
+	return nil
+}
+
+func (c *Cache) wake() {
+	select {
+	case c.signal <- struct{}{}:
+	default:
+	}
+}
+
+// writeBack flushes the pending responses whenever some are stored.
+func (c *Cache) writeBack() {
+	defer c.wg.Done()
+	for {
+		select {
+		case <-c.signal:
+			c.Flush()
+		case <-c.done:
+			return
+		}
+	}
+}
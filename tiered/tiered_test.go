@@ -0,0 +1,108 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tiered
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/gregjones/httpcache"
+)
+
+// blockingCache is a cache whose Set blocks until released.
+type blockingCache struct {
+	*httpcache.MemoryCache
+	release chan struct{}
+}
+
+func (c *blockingCache) Set(key string, resp []byte) {
+	<-c.release
+	c.MemoryCache.Set(key, resp)
+}
+
+func TestWriteThrough(t *testing.T) {
+	front, back := httpcache.NewMemoryCache(), httpcache.NewMemoryCache()
+	cache := New(front, back)
+
+	cache.Set("key1", []byte("value1"))
+	for _, c := range []httpcache.Cache{front, back} {
+		if val, ok := c.Get("key1"); !ok || string(val) != "value1" {
+			t.Errorf("unexpected value: got %q, want %q", val, "value1")
+		}
+	}
+
+	// promoted to the front tier
+	back.Set("key2", []byte("value2"))
+	if val, ok := cache.Get("key2"); !ok || string(val) != "value2" {
+		t.Errorf("unexpected value: got %q, want %q", val, "value2")
+	}
+	if _, ok := front.Get("key2"); !ok {
+		t.Errorf("expected key '%s' to be promoted", "key2")
+	}
+
+	cache.Delete("key2")
+	for _, c := range []httpcache.Cache{front, back} {
+		if _, ok := c.Get("key2"); ok {
+			t.Errorf("unexpected key '%s' in cache", "key2")
+		}
+	}
+}
+
+func TestWriteBack(t *testing.T) {
+	front := httpcache.NewMemoryCache()
+	back := &blockingCache{httpcache.NewMemoryCache(), make(chan struct{})}
+	cache := New(front, back, WithMode(WriteBack))
+
+	// Set returns before the back tier is written
+	cache.Set("key1", []byte("value1"))
+	if _, ok := front.Get("key1"); !ok {
+		t.Errorf("expected key '%s' to be found in front tier", "key1")
+	}
+
+	// pending responses are served even if evicted from the front tier
+	front.Delete("key1")
+	if val, ok := cache.Get("key1"); !ok || string(val) != "value1" {
+		t.Errorf("unexpected value: got %q, want %q", val, "value1")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		cache.Close()
+	}()
+	close(back.release)
+	wg.Wait()
+
+	if val, ok := back.Get("key1"); !ok || string(val) != "value1" {
+		t.Errorf("unexpected value: got %q, want %q", val, "value1")
+	}
+	if n := cache.Pending(); n != 0 {
+		t.Errorf("unexpected pending responses: got %d, want %d", n, 0)
+	}
+}
+
+func TestMaxPending(t *testing.T) {
+	front, back := httpcache.NewMemoryCache(), httpcache.NewMemoryCache()
+	cache := New(front, back, WithMode(WriteBack), WithMaxPending(0))
+	defer cache.Close()
+
+	cache.Set("key1", []byte("value1"))
+	if _, ok := back.Get("key1"); !ok {
+		t.Errorf("expected key '%s' to be written through", "key1")
+	}
+}
@@ -0,0 +1,324 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tinylfu provides a concurrent in-memory cache whose admission
+// is decided by a frequency sketch, for peers doing too many operations
+// per second for httpcache.MemoryCache behind an lru.Cache.
+//
+// A response is only stored if its key was requested more often than
+// the keys of the responses it would evict, so that one-hit wonders and
+// scans don't flush the popular responses. fcsim simulates this package.
+package tinylfu
+
+import (
+	"container/list"
+	"hash/fnv"
+	"sync"
+)
+
+// DefaultShards is the default number of shards of a Cache.
+const DefaultShards = 16
+
+// sketchWidth is the number of counters per row of the sketch of a shard.
+const sketchWidth = 1 << 12
+
+// Cache is an httpcache.Cache admitting responses with TinyLFU and
+// evicting them in LRU order, the cost of a response being its length.
+// It is split in shards locked independently, each having an equal part
+// of the capacity: a response costing more than a shard's part is never
+// stored, even in an empty cache.
+// It is safe for concurrent access.
+type Cache struct {
+	shards []*shard
+	cost   func(resp []byte) int
+}
+
+// Stats are statistics about the cache.
+type Stats struct {
+	Hits      int64 // lookups of stored keys
+	Misses    int64 // lookups of unknown keys
+	Rejected  int64 // responses not admitted
+	Evictions int64 // responses evicted to admit new ones
+}
+
+type shard struct {
+	mu       sync.Mutex
+	capacity int
+	size     int
+	items    map[string]*list.Element
+	list     *list.List
+	sketch   *sketch
+	cost     func(resp []byte) int
+	stats    Stats
+}
+
+type entry struct {
+	key  string
+	resp []byte
+	cost int
+}
+
+// New creates a Cache with a capacity of cap bytes.
+func New(cap int, options ...func(*Cache)) *Cache {
+	c := &Cache{
+		shards: make([]*shard, DefaultShards),
+		cost:   func(resp []byte) int { return len(resp) },
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	for i := range c.shards {
+		c.shards[i] = &shard{
+			capacity: cap / len(c.shards),
+			items:    make(map[string]*list.Element),
+			list:     list.New(),
+			sketch:   newSketch(sketchWidth),
+			cost:     c.cost,
+		}
+	}
+	return c
+}
+
+// WithShards specifies the number of shards of the cache, each having
+// an equal part of its capacity. More shards mean less contention but
+// the responses costing more than cap/n are never stored.
+// Defaults to DefaultShards.
+func WithShards(n int) func(*Cache) {
+	return func(c *Cache) {
+		c.shards = make([]*shard, n)
+	}
+}
+
+// WithCost specifies the cost of the responses, counted against the
+// capacity, for example for responses standing for larger ones in
+// simulations. Defaults to their length.
+func WithCost(cost func(resp []byte) int) func(*Cache) {
+	return func(c *Cache) {
+		c.cost = cost
+	}
+}
+
+// Get returns the response stored for key.
+func (c *Cache) Get(key string) (resp []byte, ok bool) {
+	sum := hash(key)
+	s := c.shard(sum)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sketch.increment(sum)
+	e, ok := s.items[key]
+	if !ok {
+		s.stats.Misses++
+		return nil, false
+	}
+	s.stats.Hits++
+	s.list.MoveToFront(e)
+	return e.Value.(*entry).resp, true
+}
+
+// Set stores a response for key if it is worth the responses it would
+// evict. A response replacing another one is always stored.
+func (c *Cache) Set(key string, resp []byte) {
+	sum := hash(key)
+	s := c.shard(sum)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cost := s.cost(resp)
+	if cost > s.capacity {
+		s.stats.Rejected++
+		if e, ok := s.items[key]; ok {
+			s.remove(e)
+		}
+		return
+	}
+
+	if e, ok := s.items[key]; ok {
+		s.size += cost - e.Value.(*entry).cost
+		e.Value.(*entry).resp = resp
+		e.Value.(*entry).cost = cost
+		s.list.MoveToFront(e)
+		for s.size > s.capacity {
+			s.remove(s.list.Back())
+			s.stats.Evictions++
+		}
+		return
+	}
+
+	// find the victims and check that the candidate is worth them
+	freq := s.sketch.estimate(sum)
+	free := s.capacity - s.size
+	var victims []*list.Element
+	for e := s.list.Back(); free < cost; e = e.Prev() {
+		victim := e.Value.(*entry)
+		if s.sketch.estimate(hash(victim.key)) >= freq {
+			s.stats.Rejected++
+			return
+		}
+		victims = append(victims, e)
+		free += victim.cost
+	}
+
+	for _, e := range victims {
+		s.remove(e)
+		s.stats.Evictions++
+	}
+	s.items[key] = s.list.PushFront(&entry{key, resp, cost})
+	s.size += cost
+}
+
+// Delete removes the response stored for key.
+func (c *Cache) Delete(key string) {
+	s := c.shard(hash(key))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.items[key]; ok {
+		s.remove(e)
+	}
+}
+
+// Stats returns the statistics of the cache.
+func (c *Cache) Stats() Stats {
+	var stats Stats
+	for _, s := range c.shards {
+		s.mu.Lock()
+		stats.Hits += s.stats.Hits
+		stats.Misses += s.stats.Misses
+		stats.Rejected += s.stats.Rejected
+		stats.Evictions += s.stats.Evictions
+		s.mu.Unlock()
+	}
+	return stats
+}
+
+func (c *Cache) shard(sum uint64) *shard {
+	return c.shards[sum%uint64(len(c.shards))]
+}
+
+// remove forgets an entry, the shard being locked.
+func (s *shard) remove(e *list.Element) {
+	entry := s.list.Remove(e).(*entry)
+	delete(s.items, entry.key)
+	s.size -= entry.cost
+}
+
+func hash(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// Sketch estimates how often keys are seen, like the admission of a
+// Cache does, so that other admission policies can use their frequency.
+// It is safe for concurrent access.
+type Sketch struct {
+	mu     sync.Mutex
+	sketch *sketch
+}
+
+// NewSketch creates a Sketch with width counters per row, rounded up to
+// a power of two. Wider sketches estimate more keys accurately, the
+// counters being halved every 10*width increments.
+func NewSketch(width int) *Sketch {
+	w := 1
+	for w < width {
+		w <<= 1
+	}
+	return &Sketch{sketch: newSketch(w)}
+}
+
+// Increment counts key once more and returns its estimated frequency,
+// at most 15.
+func (s *Sketch) Increment(key string) int {
+	sum := hash(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sketch.increment(sum)
+	return int(s.sketch.estimate(sum))
+}
+
+// Estimate returns the estimated frequency of key, at most 15.
+func (s *Sketch) Estimate(key string) int {
+	sum := hash(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return int(s.sketch.estimate(sum))
+}
+
+// sketch is a count-min sketch of 4-bit counters with periodic aging,
+// as described in the TinyLFU paper.
+type sketch struct {
+	rows      [4][]uint8
+	mask      uint32
+	additions int
+	resetAt   int
+}
+
+func newSketch(width int) *sketch {
+	s := &sketch{mask: uint32(width - 1), resetAt: 10 * width}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *sketch) indexes(sum uint64) [4]uint32 {
+	// the shard was picked with the low bits, the rows use the high ones
+	lo, hi := uint32(sum>>16), uint32(sum>>32)
+
+	var idx [4]uint32
+	for i := range idx {
+		idx[i] = (lo + uint32(i)*hi) & s.mask
+	}
+	return idx
+}
+
+func (s *sketch) increment(sum uint64) {
+	for i, j := range s.indexes(sum) {
+		if s.rows[i][j] < 15 {
+			s.rows[i][j]++
+		}
+	}
+
+	if s.additions++; s.additions >= s.resetAt {
+		s.reset()
+	}
+}
+
+func (s *sketch) estimate(sum uint64) uint8 {
+	min := uint8(15)
+	for i, j := range s.indexes(sum) {
+		if s.rows[i][j] < min {
+			min = s.rows[i][j]
+		}
+	}
+	return min
+}
+
+// reset halves all the counters so that old popularity fades away.
+func (s *sketch) reset() {
+	for i := range s.rows {
+		for j := range s.rows[i] {
+			s.rows[i][j] >>= 1
+		}
+	}
+	s.additions /= 2
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tinylfu
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestCache(t *testing.T) {
+	cache := New(100, WithShards(1))
+
+	cache.Get("key1")
+	cache.Set("key1", make([]byte, 40))
+	cache.Get("key2")
+	cache.Set("key2", make([]byte, 40))
+	if val, ok := cache.Get("key1"); !ok || len(val) != 40 {
+		t.Errorf("unexpected value for '%s': got %d bytes, want %d", "key1", len(val), 40)
+	}
+
+	// key3 was requested less often than key2, the least recently used
+	cache.Get("key3")
+	cache.Set("key3", make([]byte, 40))
+	if _, ok := cache.Get("key3"); ok {
+		t.Errorf("unexpected key '%s' in cache", "key3")
+	}
+
+	// key4 was requested more often than key2 and evicts it
+	for i := 0; i < 3; i++ {
+		cache.Get("key4")
+	}
+	cache.Set("key4", make([]byte, 40))
+	for _, test := range []struct {
+		key  string
+		want bool
+	}{{"key1", true}, {"key2", false}, {"key4", true}} {
+		if _, ok := cache.Get(test.key); ok != test.want {
+			t.Errorf("unexpected presence of key '%s': got %t, want %t", test.key, ok, test.want)
+		}
+	}
+
+	// replacing a response always stores it
+	cache.Set("key1", make([]byte, 61))
+	if val, _ := cache.Get("key1"); len(val) != 61 {
+		t.Errorf("unexpected value for '%s': got %d bytes, want %d", "key1", len(val), 61)
+	}
+	if _, ok := cache.Get("key4"); ok {
+		t.Errorf("unexpected key '%s' in cache", "key4")
+	}
+
+	cache.Set("key5", make([]byte, 101))
+	cache.Delete("key1")
+	if _, ok := cache.Get("key1"); ok {
+		t.Errorf("unexpected key '%s' in cache", "key1")
+	}
+
+	want := Stats{Hits: 4, Misses: 10, Rejected: 2, Evictions: 2}
+	if got := cache.Stats(); got != want {
+		t.Errorf("unexpected stats: got %+v, want %+v", got, want)
+	}
+}
+
+func TestCost(t *testing.T) {
+	// the responses stand for 40 bytes each
+	cache := New(100, WithShards(2), WithCost(func(resp []byte) int { return 40 * len(resp) }))
+
+	cache.Set("key1", []byte{1})
+	if _, ok := cache.Get("key1"); !ok {
+		t.Errorf("expected '%s' to be stored", "key1")
+	}
+
+	// larger than a shard's part of the capacity, even in an empty cache
+	cache.Set("key2", []byte{1, 2})
+	if _, ok := cache.Get("key2"); ok {
+		t.Errorf("expected '%s' not to be stored", "key2")
+	}
+	if got, want := cache.Stats().Rejected, int64(1); got != want {
+		t.Errorf("unexpected rejections: got %d, want %d", got, want)
+	}
+}
+
+func TestScan(t *testing.T) {
+	cache := New(10*100, WithShards(1))
+	hot := make([]string, 10)
+	for i := range hot {
+		hot[i] = "hot" + strconv.Itoa(i)
+		for j := 0; j < 5; j++ {
+			if _, ok := cache.Get(hot[i]); !ok {
+				cache.Set(hot[i], make([]byte, 100))
+			}
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		key := "scan" + strconv.Itoa(i)
+		if _, ok := cache.Get(key); !ok {
+			cache.Set(key, make([]byte, 100))
+		}
+	}
+
+	for _, key := range hot {
+		if _, ok := cache.Get(key); !ok {
+			t.Errorf("expected key '%s' to survive the scan", key)
+		}
+	}
+}
+
+func TestRace(t *testing.T) {
+	cache := New(1000)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := strconv.Itoa((i * j) % 100)
+				if _, ok := cache.Get(key); !ok {
+					cache.Set(key, make([]byte, 10))
+				}
+				if j%10 == 0 {
+					cache.Delete(key)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func BenchmarkCache(b *testing.B) {
+	cache := New(1 << 20)
+	keys := make([]string, 1000)
+	for i := range keys {
+		keys[i] = "http://example.com/" + strconv.Itoa(i)
+	}
+	resp := make([]byte, 1000)
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			key := keys[i%len(keys)]
+			if _, ok := cache.Get(key); !ok {
+				cache.Set(key, resp)
+			}
+			i++
+		}
+	})
+}
+
+func TestSketch(t *testing.T) {
+	s := NewSketch(100)
+	if got, want := len(s.sketch.rows[0]), 128; got != want {
+		t.Errorf("unexpected width: got %d, want %d", got, want)
+	}
+
+	for i := 1; i <= 3; i++ {
+		if got := s.Increment("http://a.example.com/"); got != i {
+			t.Errorf("unexpected frequency after %d increments: got %d, want %d", i, got, i)
+		}
+	}
+	if got, want := s.Estimate("http://a.example.com/"), 3; got != want {
+		t.Errorf("unexpected estimate: got %d, want %d", got, want)
+	}
+	if got, want := s.Estimate("http://b.example.com/"), 0; got != want {
+		t.Errorf("unexpected estimate of an unseen key: got %d, want %d", got, want)
+	}
+}
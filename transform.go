@@ -0,0 +1,57 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import "net/http"
+
+// Transform transforms a response from an origin before the peer stores
+// it, like minifying or re-encoding its body, so that the transformation
+// is made once and the transformed response served from the cache. A
+// transform replacing the body must close the original one and update
+// the Content-Length header and the ContentLength field, -1 if unknown,
+// or the response isn't stored. A transform failing fails the request.
+type Transform func(res *http.Response) (*http.Response, error)
+
+// WithTransforms makes the peer transform the responses it stores, with
+// each transform in order. The responses which aren't stored are served
+// as is. Defaults to no transforms.
+func WithTransforms(transforms ...Transform) func(*Peer) {
+	return func(p *Peer) {
+		p.transforms = append(p.transforms, transforms...)
+	}
+}
+
+// transform applies the transforms to a response to be stored. The
+// response given to a failing transform is closed.
+func (t *cacheTransport) transform(req *http.Request, res *http.Response) (*http.Response, error) {
+	if t.transforms == nil {
+		return res, nil
+	}
+	for _, fn := range *t.transforms {
+		out, err := fn(res)
+		if err != nil {
+			res.Body.Close()
+			explain(req, "transform failed: %v", err)
+			return nil, err
+		}
+		res = out
+	}
+	if len(*t.transforms) > 0 {
+		explain(req, "transformed by %d transforms", len(*t.transforms))
+	}
+	return res, nil
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestTransforms(t *testing.T) {
+	lower := func(res *http.Response) (*http.Response, error) {
+		body, err := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		body = []byte(strings.ToLower(string(body)))
+		res.Body = ioutil.NopCloser(strings.NewReader(string(body)))
+		res.ContentLength = int64(len(body))
+		res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		return res, nil
+	}
+	suffix := func(res *http.Response) (*http.Response, error) {
+		res.Body = ioutil.NopCloser(io.MultiReader(res.Body, strings.NewReader("!")))
+		res.ContentLength = -1
+		res.Header.Del("Content-Length")
+		return res, nil
+	}
+	failing := func(res *http.Response) (*http.Response, error) {
+		return nil, errors.New("transform failed")
+	}
+
+	testCases := []struct {
+		name       string
+		transforms []Transform
+		noStore    bool
+		status     int
+		body       string
+		fetches    int
+	}{
+		{"none", nil, false, http.StatusOK, "OK", 1},
+		{"chained", []Transform{lower, suffix}, false, http.StatusOK, "ok!", 1},
+		{"not stored", []Transform{lower}, true, http.StatusOK, "OK", 2},
+		{"failing", []Transform{failing}, false, http.StatusBadGateway, "", 2},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			fetches := 0
+			origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				fetches++
+				res := okResponse()
+				if tC.noStore {
+					res.Header.Set("Cache-Control", "no-store")
+				}
+				return res, nil
+			})
+			peer := NewPeer("http://self.com:3000", WithPeerTransport(origin), WithTransforms(tC.transforms...))
+
+			for i := 0; i < 2; i++ {
+				rr := httptest.NewRecorder()
+				req := httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape("http://cdn.com/jquery.js"), nil)
+				peer.Handler().ServeHTTP(rr, req)
+
+				if rr.Code != tC.status {
+					t.Fatalf("unexpected status: got %d, want %d", rr.Code, tC.status)
+				}
+				if got := rr.Body.String(); tC.status == http.StatusOK && got != tC.body {
+					t.Errorf("unexpected body of request %d: got %q, want %q", i, got, tC.body)
+				}
+			}
+			if fetches != tC.fetches {
+				t.Errorf("unexpected origin fetches: got %d, want %d", fetches, tC.fetches)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import "net/http"
+
+// UserAgentMode is how a peer sets the User-Agent of origin requests.
+type UserAgentMode int
+
+const (
+	// UserAgentDefault sends the User-Agent only if the client sent none.
+	UserAgentDefault UserAgentMode = iota
+
+	// UserAgentOverride replaces the User-Agent of the client.
+	UserAgentOverride
+
+	// UserAgentAppend appends to the User-Agent of the client.
+	UserAgentAppend
+)
+
+// userAgentPolicy sets the User-Agent of origin requests.
+type userAgentPolicy struct {
+	agent  string // empty keeps the client's User-Agent
+	mode   UserAgentMode
+	header string // keeps the client's User-Agent under this header, if set
+}
+
+// enabled reports whether the policy modifies requests.
+func (u *userAgentPolicy) enabled() bool {
+	return u.agent != "" || u.header != ""
+}
+
+// apply sets the User-Agent of an outgoing request.
+func (u *userAgentPolicy) apply(h http.Header) {
+	if !u.enabled() {
+		return
+	}
+
+	client := h.Get("User-Agent")
+	if u.header != "" && client != "" {
+		h.Set(u.header, client)
+	}
+	if u.agent == "" {
+		return
+	}
+
+	switch {
+	case client == "" || u.mode == UserAgentOverride:
+		h.Set("User-Agent", u.agent)
+	case u.mode == UserAgentAppend:
+		h.Set("User-Agent", client+" "+u.agent)
+	}
+}
+
+// WithUserAgent sets the User-Agent used by the peer when fetching
+// from origins, like "forwardcache/1.0 (+pool-name)", so origins can
+// recognize the pool. The mode tells what to do with the User-Agent
+// sent by the client.
+func WithUserAgent(agent string, mode UserAgentMode) func(*Peer) {
+	return func(p *Peer) {
+		p.userAgent.agent = agent
+		p.userAgent.mode = mode
+	}
+}
+
+// WithClientUserAgentHeader keeps the User-Agent sent by the client
+// in the given header of origin requests (like "X-Client-User-Agent"),
+// to be used along with WithUserAgent.
+func WithClientUserAgentHeader(header string) func(*Peer) {
+	return func(p *Peer) {
+		p.userAgent.header = http.CanonicalHeaderKey(header)
+	}
+}
@@ -0,0 +1,65 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestUserAgent(t *testing.T) {
+	origin := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("X-Got-UA", req.Header.Get("User-Agent"))
+		res.Header.Set("X-Got-Client-UA", req.Header.Get("X-Client-User-Agent"))
+		return res, nil
+	})
+
+	testCases := []struct {
+		name       string
+		options    []func(*Peer)
+		ua         string
+		wantUA     string
+		wantClient string
+	}{
+		{"untouched", nil, "curl/7", "curl/7", ""},
+		{"default with client", []func(*Peer){WithUserAgent("fc/1", UserAgentDefault)}, "curl/7", "curl/7", ""},
+		{"default without client", []func(*Peer){WithUserAgent("fc/1", UserAgentDefault)}, "", "fc/1", ""},
+		{"override", []func(*Peer){WithUserAgent("fc/1", UserAgentOverride)}, "curl/7", "fc/1", ""},
+		{"append", []func(*Peer){WithUserAgent("fc/1", UserAgentAppend)}, "curl/7", "curl/7 fc/1", ""},
+		{"preserve", []func(*Peer){WithUserAgent("fc/1", UserAgentOverride), WithClientUserAgentHeader("x-client-user-agent")}, "curl/7", "fc/1", "curl/7"},
+	}
+	for _, tC := range testCases {
+		t.Run(tC.name, func(t *testing.T) {
+			peer := NewPeer("http://self.com:3000", append([]func(*Peer){WithPeerTransport(origin)}, tC.options...)...)
+
+			rr := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/proxy?q="+url.QueryEscape("http://cdn.com/jquery.js?"+tC.name), nil)
+			req.Header.Set("User-Agent", tC.ua)
+			peer.Handler().ServeHTTP(rr, req)
+
+			if got := rr.Header().Get("X-Got-UA"); got != tC.wantUA {
+				t.Errorf("unexpected User-Agent: got %q, want %q", got, tC.wantUA)
+			}
+			if got := rr.Header().Get("X-Got-Client-UA"); got != tC.wantClient {
+				t.Errorf("unexpected client User-Agent: got %q, want %q", got, tC.wantClient)
+			}
+		})
+	}
+}
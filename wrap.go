@@ -0,0 +1,88 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import "net/http"
+
+// Wrapper is an http.RoundTripper routing the cacheable requests through
+// the pool and the other ones through an existing transport. See Wrap.
+type Wrapper struct {
+	transport http.RoundTripper
+	client    *Client
+	cacheable func(*http.Request) bool
+}
+
+// Wrap wraps rt so that the cacheable requests go through the pool using
+// client, the other ones, like POSTs and websockets, still being made
+// with rt. It lets applications adopt the pool without changing how
+// they make their other requests, for example:
+//
+//	http.DefaultTransport = forwardcache.Wrap(http.DefaultTransport, client)
+//
+// A nil rt is http.DefaultTransport.
+func Wrap(rt http.RoundTripper, client *Client, options ...func(*Wrapper)) *Wrapper {
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	w := &Wrapper{
+		transport: rt,
+		client:    client,
+		cacheable: CacheableRequest,
+	}
+
+	for _, option := range options {
+		option(w)
+	}
+
+	return w
+}
+
+// WithCacheableFn specifies which requests go through the pool.
+// Defaults to CacheableRequest.
+func WithCacheableFn(fn func(*http.Request) bool) func(*Wrapper) {
+	return func(w *Wrapper) {
+		w.cacheable = fn
+	}
+}
+
+// RoundTrip makes the request through the pool if it is cacheable,
+// through the wrapped transport otherwise.
+func (w *Wrapper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if w.cacheable(req) {
+		return w.client.RoundTrip(req)
+	}
+	return w.transport.RoundTrip(req)
+}
+
+// CacheableRequest reports whether the response to a request can be
+// served by a shared cache: it is a GET or a HEAD without body, not
+// upgrading the connection, not asking for a range, not carrying
+// credentials and not forbidding the response from being stored.
+func CacheableRequest(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if req.Body != nil && req.Body != http.NoBody {
+		return false
+	}
+	for _, name := range []string{"Upgrade", "Range", "Authorization"} {
+		if _, ok := req.Header[name]; ok {
+			return false
+		}
+	}
+	return !requestDirectives(req.Header).has("no-store")
+}
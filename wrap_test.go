@@ -0,0 +1,74 @@
+/*
+Copyright 2018 Mike Gleason jr Couturier.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+     http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package forwardcache
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestWrap(t *testing.T) {
+	direct := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("X-Source", "direct")
+		return res, nil
+	})
+	pool := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		res := okResponse()
+		res.Header.Set("X-Source", "pool")
+		return res, nil
+	})
+
+	rt := Wrap(direct, NewClient(WithPool("http://a.com:3000"), WithClientTransport(pool)))
+
+	testCases := []struct {
+		method string
+		header string
+		body   string
+		want   string
+	}{
+		{"GET", "", "", "pool"},
+		{"HEAD", "", "", "pool"},
+		{"GET", "Cache-Control: max-age=0", "", "pool"},
+		{"POST", "", "a=1", "direct"},
+		{"DELETE", "", "", "direct"},
+		{"GET", "", "a=1", "direct"},
+		{"GET", "Upgrade: websocket", "", "direct"},
+		{"GET", "Range: bytes=0-10", "", "direct"},
+		{"GET", "Authorization: Bearer token", "", "direct"},
+		{"GET", "Cache-Control: no-store", "", "direct"},
+	}
+	for _, tC := range testCases {
+		req, _ := http.NewRequest(tC.method, "http://some.url/res.js", nil)
+		if tC.body != "" {
+			req, _ = http.NewRequest(tC.method, "http://some.url/res.js", strings.NewReader(tC.body))
+		}
+		if tC.header != "" {
+			kv := strings.SplitN(tC.header, ": ", 2)
+			req.Header.Set(kv[0], kv[1])
+		}
+
+		res, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("unexpected error: got %q, want <nil>", err)
+		}
+		if got := res.Header.Get("X-Source"); got != tC.want {
+			t.Errorf("unexpected transport of %s %q: got %q, want %q", tC.method, tC.header, got, tC.want)
+		}
+	}
+}